@@ -146,4 +146,73 @@ var (
 		Name: "is_test_net",
 		Usage: "start the test net",
 	}
+
+	KeyFileFlag = cli.StringFlag{
+		Name: "keyfile",
+		Usage: "hdkeystore key file of the wallet",
+	}
+
+	InputFileFlag = cli.StringFlag{
+		Name: "in",
+		Usage: "input file, read from stdin if empty",
+	}
+
+	OutputFileFlag = cli.StringFlag{
+		Name: "out",
+		Usage: "output file, write to stdout if empty",
+	}
+
+	ChunkSizeFlag = cli.IntFlag{
+		Name: "chunk",
+		Usage: "split output into chunks of the size, 0 is not split",
+	}
+
+	KeyDirFlag = cli.StringFlag{
+		Name: "keydir",
+		Usage: "hdkeystore key files directory",
+	}
+
+	AliasFlag = cli.StringFlag{
+		Name: "alias",
+		Usage: "alias of the wallet key",
+	}
+
+	MnemonicWordsFlag = cli.IntFlag{
+		Name: "words",
+		Usage: "number of mnemonic words: 12, 15, 18, 21, 24",
+		Value: 24,
+	}
+
+	MnemonicLangFlag = cli.StringFlag{
+		Name: "lang",
+		Usage: "mnemonic language: english, chinese_simplified, chinese_traditional, japanese, korean, spanish, french, italian",
+		Value: "english",
+	}
+
+	PassphraseFlag = cli.BoolFlag{
+		Name: "passphrase",
+		Usage: "use BIP39 passphrase to derive seed from mnemonic",
+	}
+
+	SeedMnemonicFlag = cli.BoolFlag{
+		Name: "seed-mnemonic",
+		Usage: "recover wallet key by the non-BIP39 seed mnemonic shown by wmd wallet mnemonic --keyfile",
+	}
+
+	SharesFlag = cli.BoolFlag{
+		Name: "shares",
+		Usage: "backup or restore wallet key by SLIP-39 shares",
+	}
+
+	GroupThresholdFlag = cli.IntFlag{
+		Name: "group-threshold",
+		Usage: "number of groups required to restore wallet key",
+		Value: 1,
+	}
+
+	GroupsFlag = cli.StringFlag{
+		Name: "groups",
+		Usage: "member threshold and count of each group, e.g. 2-of-3,3-of-5",
+		Value: "2-of-3",
+	}
 )
\ No newline at end of file
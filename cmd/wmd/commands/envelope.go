@@ -0,0 +1,263 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/cmd/utils"
+	"github.com/blocktree/openwallet/v2/console"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	// 离线签名命令
+	CmdEnvelope = cli.Command{
+		Name:      "envelope",
+		Usage:     "Sign transaction envelope offline",
+		ArgsUsage: "",
+		Category:  "Application COMMANDS",
+		Description: `
+You sign or inspect transaction envelope on a machine without node access
+
+`,
+
+		Subcommands: []cli.Command{
+			{
+				//签名信封
+				Name:     "sign",
+				Usage:    "sign a transaction envelope by hdkeystore key",
+				Action:   signEnvelope,
+				Category: "ENVELOPE COMMANDS",
+				Flags: []cli.Flag{
+					utils.KeyFileFlag,
+					utils.InputFileFlag,
+					utils.OutputFileFlag,
+					utils.ChunkSizeFlag,
+				},
+				Description: `
+	wmd envelope sign --keyfile <key file> --in <envelope file> --out <signed file> --chunk 300
+
+The envelope file contains a complete envelope, or all chunks separated by line.
+The transaction in envelope is shown and must be confirmed before signing.
+
+	`,
+			},
+			{
+				//查看信封内容
+				Name:     "show",
+				Usage:    "show the transaction in envelope",
+				Action:   showEnvelope,
+				Category: "ENVELOPE COMMANDS",
+				Flags: []cli.Flag{
+					utils.InputFileFlag,
+				},
+			},
+		},
+	}
+)
+
+//readEnvelope 读取信封或信封分段
+func readEnvelope(c *cli.Context) (*openwallet.SigningEnvelope, error) {
+
+	var (
+		content []byte
+		err     error
+	)
+
+	if in := c.String("in"); len(in) > 0 {
+		content, err = ioutil.ReadFile(in)
+	} else {
+		content, err = ioutil.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return openwallet.DecodeEnvelope(strings.Fields(string(content))...)
+}
+
+//signEnvelope 使用钱包密钥签名信封
+func signEnvelope(c *cli.Context) error {
+
+	keyFile := c.String("keyfile")
+	if len(keyFile) == 0 {
+		log.Error("Argument --keyfile <key file> is missing")
+		return nil
+	}
+
+	//标准输入用于确认签名，信封只能从文件读取
+	if len(c.String("in")) == 0 {
+		log.Error("Argument --in <envelope file> is missing")
+		return nil
+	}
+
+	env, err := readEnvelope(c)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	//签名前显示交易单内容，由用户确认
+	err = printEnvelope(env)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	confirm, err := console.Stdin.PromptConfirm("Sign this transaction?")
+	if err != nil {
+		return err
+	}
+	if !confirm {
+		log.Info("signing has been canceled")
+		return nil
+	}
+
+	keyjson, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	password, err := console.InputPassword(false, 8)
+	if err != nil {
+		return err
+	}
+
+	key, err := hdkeystore.DecryptHDKey(keyjson, password)
+	if err != nil {
+		log.Error("wallet password is incorrect")
+		return err
+	}
+
+	signed, err := env.Sign(key)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	chunks, err := env.Split(c.Int("chunk"))
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	output := strings.Join(chunks, "\n") + "\n"
+	if out := c.String("out"); len(out) > 0 {
+		err = ioutil.WriteFile(out, []byte(output), 0600)
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+	} else {
+		fmt.Print(output)
+	}
+
+	log.Infof("envelope has been signed, %d signatures added", signed)
+
+	return nil
+}
+
+//showEnvelope 显示信封中的交易单
+func showEnvelope(c *cli.Context) error {
+
+	env, err := readEnvelope(c)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	err = printEnvelope(env)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+//printEnvelope 解析并打印信封中交易单的摘要
+func printEnvelope(env *openwallet.SigningEnvelope) error {
+
+	fmt.Printf("Version: %d\n", env.Version)
+	fmt.Printf("Type: %s\n", env.Type)
+
+	switch env.Type {
+	case openwallet.EnvelopeTypeRawTransaction:
+		rawTx, err := env.RawTransaction()
+		if err != nil {
+			return err
+		}
+		printEnvelopeCoin(rawTx.Coin, rawTx.Account, rawTx.Sid)
+		for to, amount := range rawTx.To {
+			fmt.Printf("To: %s  Amount: %s\n", to, amount)
+		}
+		fmt.Printf("Fees: %s\n", rawTx.Fees)
+		fmt.Printf("FeeRate: %s\n", rawTx.FeeRate)
+		printEnvelopeSignatures(rawTx.Signatures)
+	case openwallet.EnvelopeTypeSmartContractRawTransaction:
+		rawTx, err := env.SmartContractRawTransaction()
+		if err != nil {
+			return err
+		}
+		printEnvelopeCoin(rawTx.Coin, rawTx.Account, rawTx.Sid)
+		fmt.Printf("From: %s\n", rawTx.TxFrom)
+		fmt.Printf("To: %s\n", rawTx.TxTo)
+		fmt.Printf("Value: %s\n", rawTx.Value)
+		fmt.Printf("ABIParam: %s\n", strings.Join(rawTx.ABIParam, ", "))
+		fmt.Printf("Fees: %s\n", rawTx.Fees)
+		printEnvelopeSignatures(rawTx.Signatures)
+	default:
+		return fmt.Errorf("envelope type: %s is not supported", env.Type)
+	}
+
+	return nil
+}
+
+//printEnvelopeCoin 打印交易单的币种和账户
+func printEnvelopeCoin(coin openwallet.Coin, account *openwallet.AssetsAccount, sid string) {
+	fmt.Printf("Symbol: %s\n", coin.Symbol)
+	if coin.IsContract {
+		fmt.Printf("Contract: %s (%s)\n", coin.Contract.Address, coin.Contract.Token)
+	}
+	if account != nil {
+		fmt.Printf("Account: %s\n", account.AccountID)
+	}
+	fmt.Printf("Sid: %s\n", sid)
+}
+
+//printEnvelopeSignatures 打印待签名的消息
+func printEnvelopeSignatures(signatures map[string][]*openwallet.KeySignature) {
+	for owner, keySignatures := range signatures {
+		for _, keySignature := range keySignatures {
+			if keySignature == nil {
+				continue
+			}
+			address := ""
+			if keySignature.Address != nil {
+				address = keySignature.Address.Address
+			}
+			fmt.Printf("Sign: %s  Address: %s  Message: %s\n", owner, address, keySignature.Message)
+		}
+	}
+}
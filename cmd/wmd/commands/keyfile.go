@@ -0,0 +1,56 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package commands
+
+import (
+	"path/filepath"
+
+	"github.com/blocktree/openwallet/v2/console"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+//migrateWalletKey 把旧版本的钱包密钥文件升级为当前版本
+func migrateWalletKey(c *cli.Context) error {
+
+	keyFile := c.String("keyfile")
+	if len(keyFile) == 0 {
+		log.Error("Argument --keyfile <key file> is missing")
+		return nil
+	}
+
+	password, err := console.InputPassword(false, 8)
+	if err != nil {
+		return err
+	}
+
+	ks := hdkeystore.NewHDKeystore(filepath.Dir(keyFile), hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	backup, err := ks.MigrateKey(filepath.Base(keyFile), password)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	if len(backup) == 0 {
+		log.Infof("wallet key file: %s is up to date", keyFile)
+		return nil
+	}
+
+	log.Infof("wallet key file: %s has been migrated, backup: %s", keyFile, backup)
+
+	return nil
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/blocktree/openwallet/v2/console"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+//inputPassphrase 输入BIP39密码，未开启--passphrase时为空
+func inputPassphrase(c *cli.Context) (string, error) {
+
+	if !c.Bool("passphrase") {
+		return "", nil
+	}
+
+	for {
+		passphrase, err := console.Stdin.PromptPassword("Enter BIP39 passphrase: ")
+		if err != nil {
+			log.Errorf("unexpected error: %v\n", err)
+			return "", err
+		}
+
+		confirm, err := console.Stdin.PromptPassword("Confirm BIP39 passphrase: ")
+		if err != nil {
+			log.Errorf("unexpected error: %v\n", err)
+			return "", err
+		}
+
+		if passphrase != confirm {
+			fmt.Printf("The two passphrase is not equal, please re-enter it.\n")
+			continue
+		}
+
+		return passphrase, nil
+	}
+}
+
+//walletMnemonic 显示钱包密钥的助记词，或通过新助记词创建钱包密钥
+func walletMnemonic(c *cli.Context) error {
+
+	lang := c.String("lang")
+
+	if keyFile := c.String("keyfile"); len(keyFile) > 0 {
+
+		keyjson, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+
+		password, err := console.InputPassword(false, 8)
+		if err != nil {
+			return err
+		}
+
+		key, err := hdkeystore.DecryptHDKey(keyjson, password)
+		if err != nil {
+			log.Error("wallet password is incorrect")
+			return err
+		}
+
+		mnemonic, err := key.Mnemonic(lang)
+		if err != nil {
+			log.Error("unexpected error: ", err)
+			return err
+		}
+
+		fmt.Printf("KeyID: %s\n", key.KeyID)
+		fmt.Printf("Seed Mnemonic: %s\n", mnemonic)
+		log.Warn("WARNING: this is NOT a BIP39 mnemonic, it encodes the key seed directly and can not be imported into other wallets")
+		log.Warn("Recover the wallet key by: wmd wallet recover --seed-mnemonic")
+
+		return nil
+	}
+
+	keyDir := c.String("keydir")
+	alias := c.String("alias")
+	if len(keyDir) == 0 || len(alias) == 0 {
+		log.Error("Argument --keyfile <key file> or --keydir <key dir> --alias <alias> is missing")
+		return nil
+	}
+
+	mnemonic, err := hdkeystore.GenerateMnemonic(c.Int("words"), lang)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	passphrase, err := inputPassphrase(c)
+	if err != nil {
+		return err
+	}
+
+	password, err := console.InputPassword(true, 8)
+	if err != nil {
+		return err
+	}
+
+	key, keyFile, err := hdkeystore.StoreHDKeyWithMnemonic(keyDir, alias, password, mnemonic, passphrase,
+		hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	fmt.Printf("Mnemonic: %s\n", mnemonic)
+	log.Infof("wallet key file: %s", keyFile)
+	log.Warn("Please write down the mnemonic and passphrase, both of them are required to recover the wallet key")
+
+	return nil
+}
+
+//recoverWalletKey 通过助记词恢复钱包密钥文件
+func recoverWalletKey(c *cli.Context) error {
+
+	keyDir := c.String("keydir")
+	alias := c.String("alias")
+	if len(keyDir) == 0 || len(alias) == 0 {
+		log.Error("Argument --keydir <key dir> --alias <alias> is missing")
+		return nil
+	}
+
+	//种子助记词没有BIP39密码
+	seedMnemonic := c.Bool("seed-mnemonic")
+	if seedMnemonic && c.Bool("passphrase") {
+		log.Error("Argument --passphrase can not be used with --seed-mnemonic")
+		return nil
+	}
+
+	mnemonic, err := console.InputText("Enter mnemonic: ", true)
+	if err != nil {
+		return err
+	}
+
+	passphrase, err := inputPassphrase(c)
+	if err != nil {
+		return err
+	}
+
+	password, err := console.InputPassword(true, 8)
+	if err != nil {
+		return err
+	}
+
+	var (
+		key     *hdkeystore.HDKey
+		keyFile string
+	)
+	if seedMnemonic {
+		key, keyFile, err = hdkeystore.StoreHDKeyWithEntropyMnemonic(keyDir, alias, password, mnemonic,
+			hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	} else {
+		key, keyFile, err = hdkeystore.StoreHDKeyWithMnemonic(keyDir, alias, password, mnemonic, passphrase,
+			hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	}
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	log.Infof("wallet key file: %s", keyFile)
+
+	return nil
+}
@@ -142,12 +142,40 @@ This command will transfer the coin.
 				Category: "WALLET COMMANDS",
 				Flags: []cli.Flag{
 					utils.SymbolFlag,
+					utils.SharesFlag,
+					utils.KeyFileFlag,
+					utils.GroupThresholdFlag,
+					utils.GroupsFlag,
+					utils.PassphraseFlag,
 				},
 				Description: `
 	wmd wallet backup -s ada
 
 This command will Backup wallet key in filePath: ./data/<symbol>/key/.
 
+	wmd wallet backup --shares --keyfile <key file> --group-threshold 2 --groups 2-of-3,3-of-5 [--passphrase]
+
+This command will split the wallet key into SLIP-39 shares, the key can be
+restored by the shares of group-threshold groups.
+
+	`,
+			},
+			{
+				//升级钱包密钥文件
+				Name:     "migrate",
+				Usage:    "Migrate wallet key file to the current version",
+				Action:   migrateWalletKey,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.KeyFileFlag,
+				},
+				Description: `
+	wmd wallet migrate --keyfile <key file>
+
+This command will re-encrypt the wallet key file of old version to the current
+version, the old key file is kept as a hidden backup file in the same directory.
+Reading a wallet key never modifies the key file.
+
 	`,
 			},
 			{
@@ -158,7 +186,75 @@ This command will Backup wallet key in filePath: ./data/<symbol>/key/.
 				Category: "WALLET COMMANDS",
 				Flags: []cli.Flag{
 					utils.SymbolFlag,
+					utils.SharesFlag,
+					utils.KeyDirFlag,
+					utils.AliasFlag,
+					utils.PassphraseFlag,
 				},
+				Description: `
+	wmd wallet restore -s ada
+
+This command will restore a wallet by backup data.
+
+	wmd wallet restore --shares --keydir <key dir> --alias <alias> [--passphrase]
+
+This command will restore the wallet key file by SLIP-39 shares.
+
+	`,
+			},
+			{
+				//助记词备份钱包密钥
+				Name:     "mnemonic",
+				Usage:    "Show mnemonic of wallet key, or create wallet key by new mnemonic",
+				Action:   walletMnemonic,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.KeyFileFlag,
+					utils.KeyDirFlag,
+					utils.AliasFlag,
+					utils.MnemonicWordsFlag,
+					utils.MnemonicLangFlag,
+					utils.PassphraseFlag,
+				},
+				Description: `
+	wmd wallet mnemonic --keyfile <key file> --lang english
+
+This command will show the seed mnemonic of the wallet key for paper backup.
+WARNING: the seed mnemonic encodes the key seed directly, it is NOT a BIP39
+mnemonic and can not be imported into other wallets, recover it by
+wmd wallet recover --seed-mnemonic.
+
+	wmd wallet mnemonic --keydir <key dir> --alias <alias> --words 24 --lang english [--passphrase]
+
+This command will create a wallet key by new BIP39 mnemonic, write down the
+mnemonic and the passphrase, both of them are required to recover the wallet key.
+
+	`,
+			},
+			{
+				//助记词恢复钱包密钥
+				Name:     "recover",
+				Usage:    "Recover wallet key file by mnemonic",
+				Action:   recoverWalletKey,
+				Category: "WALLET COMMANDS",
+				Flags: []cli.Flag{
+					utils.KeyDirFlag,
+					utils.AliasFlag,
+					utils.PassphraseFlag,
+					utils.SeedMnemonicFlag,
+				},
+				Description: `
+	wmd wallet recover --keydir <key dir> --alias <alias> [--passphrase]
+
+This command will recover the wallet key file by BIP39 mnemonic and passphrase,
+the key id is the same as the wallet key created by wmd wallet mnemonic.
+
+	wmd wallet recover --seed-mnemonic --keydir <key dir> --alias <alias>
+
+This command will recover the wallet key file by the seed mnemonic shown by
+wmd wallet mnemonic --keyfile, it is not a BIP39 mnemonic.
+
+	`,
 			},
 		},
 	}
@@ -250,6 +346,9 @@ func startSummary(c *cli.Context) error {
 
 //backupWalletKey 备份钱包
 func backupWalletKey(c *cli.Context) error {
+	if c.Bool("shares") {
+		return backupWalletShares(c)
+	}
 	symbol := c.String("symbol")
 	if len(symbol) == 0 {
 		log.Error("Argument -s <symbol> is missing")
@@ -307,6 +406,9 @@ func sendTransaction(c *cli.Context) error {
 
 //restoreWallet 恢复钱包
 func restoreWallet(c *cli.Context) error {
+	if c.Bool("shares") {
+		return restoreWalletShares(c)
+	}
 	symbol := c.String("symbol")
 	if len(symbol) == 0 {
 		log.Error("Argument -s <symbol> is missing")
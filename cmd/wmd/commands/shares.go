@@ -0,0 +1,155 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/console"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+//parseSLIP39Groups 解析分组配置，例如：2-of-3,3-of-5
+func parseSLIP39Groups(value string) ([]hdkeystore.SLIP39Group, error) {
+	groups := make([]hdkeystore.SLIP39Group, 0)
+	for _, g := range strings.Split(value, ",") {
+		var group hdkeystore.SLIP39Group
+		_, err := fmt.Sscanf(strings.TrimSpace(g), "%d-of-%d", &group.MemberThreshold, &group.MemberCount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group: %s, unexpected error: %v", g, err)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+//backupWalletShares 把钱包密钥拆分为SLIP-39分片
+func backupWalletShares(c *cli.Context) error {
+
+	keyFile := c.String("keyfile")
+	if len(keyFile) == 0 {
+		log.Error("Argument --keyfile <key file> is missing")
+		return nil
+	}
+
+	groups, err := parseSLIP39Groups(c.String("groups"))
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	keyjson, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	password, err := console.InputPassword(false, 8)
+	if err != nil {
+		return err
+	}
+
+	key, err := hdkeystore.DecryptHDKey(keyjson, password)
+	if err != nil {
+		log.Error("wallet password is incorrect")
+		return err
+	}
+
+	passphrase, err := inputPassphrase(c)
+	if err != nil {
+		return err
+	}
+
+	shares, err := key.SLIP39Shares(passphrase, c.Int("group-threshold"), groups)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	fmt.Printf("Group threshold: %d of %d\n", c.Int("group-threshold"), len(groups))
+	for gi, group := range shares {
+		fmt.Printf("Group %d: %d of %d\n", gi+1, groups[gi].MemberThreshold, groups[gi].MemberCount)
+		for mi, mnemonic := range group {
+			fmt.Printf("  Share %d: %s\n", mi+1, mnemonic)
+		}
+	}
+
+	return nil
+}
+
+//restoreWalletShares 通过SLIP-39分片恢复钱包密钥文件
+func restoreWalletShares(c *cli.Context) error {
+
+	keyDir := c.String("keydir")
+	alias := c.String("alias")
+	if len(keyDir) == 0 || len(alias) == 0 {
+		log.Error("Argument --keydir <key dir> --alias <alias> is missing")
+		return nil
+	}
+
+	mnemonics := make([]string, 0)
+	for {
+		mnemonic, err := console.InputText("Enter share mnemonic (empty to finish): ", false)
+		if err != nil {
+			return err
+		}
+		if len(mnemonic) == 0 {
+			break
+		}
+		if _, err := hdkeystore.DecodeSLIP39Share(mnemonic); err != nil {
+			log.Error("unexpected error: ", err)
+			continue
+		}
+		mnemonics = append(mnemonics, mnemonic)
+
+		//分片足够时结束输入
+		if hdkeystore.VerifySLIP39(mnemonics) == nil {
+			break
+		}
+	}
+
+	if err := hdkeystore.VerifySLIP39(mnemonics); err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	passphrase, err := inputPassphrase(c)
+	if err != nil {
+		return err
+	}
+
+	password, err := console.InputPassword(true, 8)
+	if err != nil {
+		return err
+	}
+
+	key, keyFile, err := hdkeystore.StoreHDKeyWithSLIP39(keyDir, alias, password, mnemonics, passphrase,
+		hdkeystore.StandardScryptN, hdkeystore.StandardScryptP)
+	if err != nil {
+		log.Error("unexpected error: ", err)
+		return err
+	}
+
+	fmt.Printf("KeyID: %s\n", key.KeyID)
+	log.Infof("wallet key file: %s", keyFile)
+
+	return nil
+}
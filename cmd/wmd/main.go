@@ -42,6 +42,7 @@ func init() {
 	app.Version = commands.Version
 	app.Commands = []cli.Command{
 		commands.CmdWallet,
+		commands.CmdEnvelope,
 		commands.CmdVersion,
 		//commands.CmdNode,
 		//commands.CmdConfig,
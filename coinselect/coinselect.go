@@ -0,0 +1,281 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+// Package coinselect UTXO模型的输入选择策略
+//
+// 输入为openw.WalletManager.GetTxUnspent返回的未花输出，按交易大小和费率计算手续费，
+// 找零低于粉尘阈值时不创建找零输出，差额作为手续费。
+//
+// 适配器需主动接入：构建交易单时通过WalletDAI断言UnspentSelector，由openw按交易单
+// ExtParam指定的策略选择输入并锁定到交易单的Sid；未接入的适配器仍使用自己的选择逻辑。
+//
+// Usage:
+//	if selector, ok := wrapper.(coinselect.UnspentSelector); ok {
+//		params, err := coinselect.NewParamsWithRawTransaction(rawTx)
+//		result, err := selector.SelectUnspent(rawTx, params)
+//	}
+package coinselect
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//选择策略名称
+const (
+	StrategyBranchAndBound = "bnb"      //分支定界，寻找无需找零的组合，找不到使用最大优先
+	StrategyLargestFirst   = "largest"  //最大优先，输入数量最少
+	StrategySmallestFirst  = "smallest" //最小优先，合并零碎的未花输出
+	StrategyRandom         = "random"   //随机选择，避免输入特征暴露钱包
+)
+
+const (
+	//ExtParamKey RawTransaction.ExtParam中指定选择策略的字段
+	ExtParamKey = "coinSelect"
+
+	//DefaultStrategy 默认选择策略
+	DefaultStrategy = StrategyBranchAndBound
+)
+
+//默认交易大小估算，单位：字节，参考比特币P2PKH
+const (
+	DefaultTxBaseSize = 10
+	DefaultInputSize  = 148
+	DefaultOutputSize = 34
+)
+
+//DefaultDustThreshold 默认找零粉尘阈值，参考比特币P2PKH输出的546聪
+var DefaultDustThreshold = decimal.New(546, -8)
+
+//UnspentSelector 未花输出选择，openw.WalletWrapper已实现，UTXO模型的适配器通过WalletDAI断言使用
+type UnspentSelector interface {
+
+	//SelectUnspent 按交易单指定的策略从账户未锁定的未花输出中选择输入，并锁定到交易单的Sid
+	SelectUnspent(rawTx *openwallet.RawTransaction, params *Params) (*Result, error)
+}
+
+//Params 选择参数
+type Params struct {
+	Target        decimal.Decimal //发送总额
+	FeeRate       decimal.Decimal //每KB的手续费
+	Outputs       int             //目标输出数量，不包括找零
+	TxBaseSize    int64           //交易基础大小
+	InputSize     int64           //每个输入大小
+	OutputSize    int64           //每个输出大小
+	DustThreshold decimal.Decimal //找零粉尘阈值，低于该值不创建找零
+	MaxInputs     int             //最大输入数量，小于等于0不限制
+}
+
+//NewParams 创建选择参数，交易大小和粉尘阈值使用默认值
+//@param target 发送总额
+//@param feeRate 每KB的手续费
+func NewParams(target, feeRate string) (*Params, error) {
+	t, err := decimal.NewFromString(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target amount: %s", target)
+	}
+	f, err := decimal.NewFromString(feeRate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fee rate: %s", feeRate)
+	}
+	return &Params{
+		Target:        t,
+		FeeRate:       f,
+		Outputs:       1,
+		TxBaseSize:    DefaultTxBaseSize,
+		InputSize:     DefaultInputSize,
+		OutputSize:    DefaultOutputSize,
+		DustThreshold: DefaultDustThreshold,
+	}, nil
+}
+
+//NewParamsWithRawTransaction 通过交易单创建选择参数，发送总额为To的合计，费率为FeeRate
+func NewParamsWithRawTransaction(rawTx *openwallet.RawTransaction) (*Params, error) {
+	if rawTx == nil {
+		return nil, fmt.Errorf("raw transaction is nil")
+	}
+	if len(rawTx.To) == 0 {
+		return nil, fmt.Errorf("receiver addresses is empty")
+	}
+
+	target := decimal.Zero
+	for addr, amount := range rawTx.To {
+		a, err := decimal.NewFromString(amount)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount of address: %s", addr)
+		}
+		target = target.Add(a)
+	}
+
+	feeRate := rawTx.FeeRate
+	if len(feeRate) == 0 {
+		feeRate = "0"
+	}
+
+	params, err := NewParams(target.String(), feeRate)
+	if err != nil {
+		return nil, err
+	}
+	params.Outputs = len(rawTx.To)
+	return params, nil
+}
+
+//StrategyOf 交易单ExtParam指定的选择策略，没有指定返回默认策略
+func StrategyOf(rawTx *openwallet.RawTransaction) string {
+	if rawTx == nil {
+		return DefaultStrategy
+	}
+	strategy := rawTx.GetExtParam().Get(ExtParamKey).String()
+	if len(strategy) == 0 {
+		return DefaultStrategy
+	}
+	return strategy
+}
+
+//fee 计算指定输入输出数量的交易手续费
+func (p *Params) fee(inputs, outputs int) decimal.Decimal {
+	size := p.TxBaseSize + int64(inputs)*p.InputSize + int64(outputs)*p.OutputSize
+	return p.FeeRate.Mul(decimal.New(size, 0)).Div(decimal.New(1000, 0))
+}
+
+//inputFee 单个输入的手续费
+func (p *Params) inputFee() decimal.Decimal {
+	return p.FeeRate.Mul(decimal.New(p.InputSize, 0)).Div(decimal.New(1000, 0))
+}
+
+//changeCost 创建找零输出增加的手续费
+func (p *Params) changeCost() decimal.Decimal {
+	return p.FeeRate.Mul(decimal.New(p.OutputSize, 0)).Div(decimal.New(1000, 0))
+}
+
+//Result 选择结果
+type Result struct {
+	Inputs      []*openwallet.TxOutPut //选中的未花输出
+	InputAmount decimal.Decimal        //输入总额
+	Fees        decimal.Decimal        //手续费，包括不找零时并入的差额
+	Change      decimal.Decimal        //找零金额，没有找零为0
+	HasChange   bool                   //是否需要找零输出
+}
+
+//Selector 选择策略
+type Selector interface {
+
+	//Select 从未花输出中选择满足发送总额和手续费的输入
+	Select(utxos []*openwallet.TxOutPut, params *Params) (*Result, error)
+}
+
+//NewSelector 根据策略名称创建选择器
+func NewSelector(strategy string) (Selector, error) {
+	switch strategy {
+	case StrategyBranchAndBound:
+		return &BranchAndBoundSelector{MaxTries: DefaultBnBMaxTries}, nil
+	case StrategyLargestFirst:
+		return &LargestFirstSelector{}, nil
+	case StrategySmallestFirst:
+		return &SmallestFirstSelector{}, nil
+	case StrategyRandom:
+		return &RandomSelector{}, nil
+	default:
+		return nil, fmt.Errorf("coin select strategy: %s is not support", strategy)
+	}
+}
+
+//Select 使用指定策略选择输入
+func Select(strategy string, utxos []*openwallet.TxOutPut, params *Params) (*Result, error) {
+	selector, err := NewSelector(strategy)
+	if err != nil {
+		return nil, err
+	}
+	return selector.Select(utxos, params)
+}
+
+//candidate 可用的未花输出
+type candidate struct {
+	utxo      *openwallet.TxOutPut
+	amount    decimal.Decimal
+	effective decimal.Decimal //扣除输入手续费后的有效金额
+}
+
+//candidates 解析未花输出，排除无效金额和有效金额不为正的输出
+func candidates(utxos []*openwallet.TxOutPut, params *Params) []*candidate {
+	list := make([]*candidate, 0, len(utxos))
+	inputFee := params.inputFee()
+	for _, u := range utxos {
+		if u == nil {
+			continue
+		}
+		amount, err := decimal.NewFromString(u.Amount)
+		if err != nil {
+			continue
+		}
+		effective := amount.Sub(inputFee)
+		if !effective.IsPositive() {
+			continue
+		}
+		list = append(list, &candidate{utxo: u, amount: amount, effective: effective})
+	}
+	return list
+}
+
+//accumulate 按顺序累加输入，直到满足发送总额和手续费
+func accumulate(list []*candidate, params *Params) (*Result, error) {
+	selected := make([]*candidate, 0)
+	total := decimal.Zero
+	for _, c := range list {
+		if params.MaxInputs > 0 && len(selected) >= params.MaxInputs {
+			break
+		}
+		selected = append(selected, c)
+		total = total.Add(c.amount)
+		if total.GreaterThanOrEqual(params.Target.Add(params.fee(len(selected), params.Outputs))) {
+			return newResult(selected, params), nil
+		}
+	}
+	return nil, insufficientError(total, params, len(selected))
+}
+
+//newResult 根据选中的输入计算手续费和找零
+func newResult(selected []*candidate, params *Params) *Result {
+	result := &Result{
+		Inputs:      make([]*openwallet.TxOutPut, 0, len(selected)),
+		InputAmount: decimal.Zero,
+		Change:      decimal.Zero,
+	}
+	for _, c := range selected {
+		result.Inputs = append(result.Inputs, c.utxo)
+		result.InputAmount = result.InputAmount.Add(c.amount)
+	}
+
+	//找零需要多一个输出的手续费，找零低于粉尘阈值时并入手续费
+	feeWithChange := params.fee(len(selected), params.Outputs+1)
+	change := result.InputAmount.Sub(params.Target).Sub(feeWithChange)
+	if change.IsPositive() && change.GreaterThanOrEqual(params.DustThreshold) {
+		result.HasChange = true
+		result.Change = change
+		result.Fees = feeWithChange
+	} else {
+		result.Fees = result.InputAmount.Sub(params.Target)
+	}
+	return result
+}
+
+//insufficientError 余额不足错误
+func insufficientError(total decimal.Decimal, params *Params, inputs int) error {
+	return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount,
+		"the available balance: %s is not enough, need: %s",
+		total.String(), params.Target.Add(params.fee(inputs, params.Outputs)).String())
+}
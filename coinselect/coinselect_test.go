@@ -0,0 +1,167 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package coinselect
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func testUnspent(amounts ...string) []*openwallet.TxOutPut {
+	list := make([]*openwallet.TxOutPut, 0, len(amounts))
+	for i, a := range amounts {
+		u := &openwallet.TxOutPut{}
+		u.Sid = openwallet.GenTxOutPutSID("tx", "BTC", "", uint64(i))
+		u.Amount = a
+		list = append(list, u)
+	}
+	return list
+}
+
+func testParams(t *testing.T, target string) *Params {
+	params, err := NewParams(target, "0.0001")
+	if err != nil {
+		t.Fatalf("NewParams unexpected error: %v", err)
+	}
+	params.DustThreshold = decimal.RequireFromString("0.00000546")
+	return params
+}
+
+func TestSelect_Strategies(t *testing.T) {
+
+	unspent := testUnspent("0.1", "0.5", "0.01", "0.02", "1")
+
+	tests := []struct {
+		strategy string
+		target   string
+		inputs   []string
+	}{
+		{StrategyLargestFirst, "0.6", []string{"1"}},
+		{StrategySmallestFirst, "0.1", []string{"0.01", "0.02", "0.1"}},
+		//0.1 + 0.02 的有效金额刚好覆盖发送额和手续费，无需找零
+		{StrategyBranchAndBound, "0.119966", []string{"0.1", "0.02"}},
+	}
+
+	for _, test := range tests {
+		result, err := Select(test.strategy, unspent, testParams(t, test.target))
+		if err != nil {
+			t.Errorf("%s Select unexpected error: %v", test.strategy, err)
+			continue
+		}
+		if len(result.Inputs) != len(test.inputs) {
+			t.Errorf("%s inputs = %d, want %d", test.strategy, len(result.Inputs), len(test.inputs))
+			continue
+		}
+		for i, u := range result.Inputs {
+			if u.Amount != test.inputs[i] {
+				t.Errorf("%s input[%d] = %s, want %s", test.strategy, i, u.Amount, test.inputs[i])
+			}
+		}
+
+		//输入总额 = 发送额 + 手续费 + 找零
+		sum := result.Fees.Add(result.Change).Add(decimal.RequireFromString(test.target))
+		if !sum.Equal(result.InputAmount) {
+			t.Errorf("%s input amount: %s != %s", test.strategy, result.InputAmount, sum)
+		}
+	}
+
+	//分支定界找到无需找零的组合
+	result, _ := Select(StrategyBranchAndBound, unspent, testParams(t, "0.119966"))
+	if result.HasChange {
+		t.Errorf("branch and bound should not have change: %s", result.Change)
+	}
+
+	result, err := Select(StrategyRandom, unspent, testParams(t, "1.5"))
+	if err != nil || result.InputAmount.LessThan(decimal.RequireFromString("1.5")) {
+		t.Errorf("random Select = %v, %v", result, err)
+	}
+}
+
+func TestSelect_DustChange(t *testing.T) {
+	unspent := testUnspent("0.001")
+
+	params := testParams(t, "0")
+	params.FeeRate = decimal.Zero
+	params.Target = decimal.RequireFromString("0.000996")
+
+	//找零0.000004低于粉尘阈值，并入手续费
+	result, err := Select(StrategyLargestFirst, unspent, params)
+	if err != nil {
+		t.Errorf("Select unexpected error: %v", err)
+		return
+	}
+	if result.HasChange || !result.Fees.Equal(decimal.RequireFromString("0.000004")) {
+		t.Errorf("change = %s, fees = %s", result.Change, result.Fees)
+	}
+
+	params.Target = decimal.RequireFromString("0.0009")
+	result, _ = Select(StrategyLargestFirst, unspent, params)
+	if !result.HasChange || !result.Change.Equal(decimal.RequireFromString("0.0001")) {
+		t.Errorf("change = %s, want 0.0001", result.Change)
+	}
+}
+
+func TestSelect_Insufficient(t *testing.T) {
+	unspent := testUnspent("0.1", "0.2", "0.00001")
+
+	_, err := Select(StrategyBranchAndBound, unspent, testParams(t, "0.3"))
+	owErr, ok := err.(*openwallet.Error)
+	if !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Errorf("Select error = %v, want insufficient balance", err)
+	}
+
+	params := testParams(t, "0.25")
+	params.MaxInputs = 1
+	if _, err = Select(StrategySmallestFirst, unspent, params); err == nil {
+		t.Errorf("max inputs should limit the selection")
+	}
+}
+
+func TestStrategyOf(t *testing.T) {
+	rawTx := &openwallet.RawTransaction{
+		To:      map[string]string{"a": "0.1", "b": "0.2"},
+		FeeRate: "0.0002",
+	}
+	if s := StrategyOf(rawTx); s != DefaultStrategy {
+		t.Errorf("StrategyOf = %s, want %s", s, DefaultStrategy)
+	}
+	rawTx.SetExtParam(ExtParamKey, StrategyRandom)
+	if s := StrategyOf(rawTx); s != StrategyRandom {
+		t.Errorf("StrategyOf = %s, want %s", s, StrategyRandom)
+	}
+
+	params, err := NewParamsWithRawTransaction(rawTx)
+	if err != nil || !params.Target.Equal(decimal.RequireFromString("0.3")) || params.Outputs != 2 {
+		t.Errorf("NewParamsWithRawTransaction = %v, %v", params, err)
+	}
+
+	if _, err = Select("unknown", nil, params); err == nil {
+		t.Errorf("unknown strategy should return error")
+	}
+}
+
+func TestNewParams_DustThreshold(t *testing.T) {
+	params, err := NewParams("0.1", "0.0001")
+	if err != nil {
+		t.Errorf("NewParams unexpected error: %v", err)
+		return
+	}
+	if !params.DustThreshold.Equal(decimal.RequireFromString("0.00000546")) {
+		t.Errorf("default dust threshold = %s, want 0.00000546", params.DustThreshold)
+	}
+}
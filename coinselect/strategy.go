@@ -0,0 +1,147 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package coinselect
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sort"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//DefaultBnBMaxTries 分支定界默认最大搜索次数
+const DefaultBnBMaxTries = 100000
+
+//LargestFirstSelector 最大优先，输入数量最少，手续费最低
+type LargestFirstSelector struct{}
+
+func (s *LargestFirstSelector) Select(utxos []*openwallet.TxOutPut, params *Params) (*Result, error) {
+	list := candidates(utxos, params)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].amount.GreaterThan(list[j].amount)
+	})
+	return accumulate(list, params)
+}
+
+//SmallestFirstSelector 最小优先，优先花费零碎的未花输出，适用于合并
+type SmallestFirstSelector struct{}
+
+func (s *SmallestFirstSelector) Select(utxos []*openwallet.TxOutPut, params *Params) (*Result, error) {
+	list := candidates(utxos, params)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].amount.LessThan(list[j].amount)
+	})
+	return accumulate(list, params)
+}
+
+//RandomSelector 随机选择，避免固定的选择规律暴露钱包的未花输出
+type RandomSelector struct{}
+
+func (s *RandomSelector) Select(utxos []*openwallet.TxOutPut, params *Params) (*Result, error) {
+	list := candidates(utxos, params)
+	for i := len(list) - 1; i > 0; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		j := int(n.Int64())
+		list[i], list[j] = list[j], list[i]
+	}
+	return accumulate(list, params)
+}
+
+//BranchAndBoundSelector 分支定界，寻找输入总额刚好满足发送总额和手续费的组合，
+//差额不足以创建找零输出，交易无需找零。找不到组合时使用最大优先选择。
+type BranchAndBoundSelector struct {
+	MaxTries int //最大搜索次数
+}
+
+func (s *BranchAndBoundSelector) Select(utxos []*openwallet.TxOutPut, params *Params) (*Result, error) {
+	list := candidates(utxos, params)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].effective.GreaterThan(list[j].effective)
+	})
+
+	if selected := s.search(list, params); selected != nil {
+		return newResult(selected, params), nil
+	}
+
+	return accumulate(list, params)
+}
+
+//search 深度优先搜索有效金额在[目标, 目标+找零成本)范围内，差额最小的组合
+func (s *BranchAndBoundSelector) search(list []*candidate, params *Params) []*candidate {
+
+	//有效金额已扣除输入手续费，目标只需加上基础大小和输出的手续费
+	target := params.Target.Add(params.fee(0, params.Outputs))
+	upper := target.Add(params.changeCost()).Add(params.DustThreshold)
+
+	//remaining[i]为第i个及之后的有效金额合计
+	remaining := make([]decimal.Decimal, len(list)+1)
+	remaining[len(list)] = decimal.Zero
+	for i := len(list) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1].Add(list[i].effective)
+	}
+
+	var (
+		tries    = 0
+		current  = make([]*candidate, 0)
+		best     []*candidate
+		bestDiff decimal.Decimal
+		dfs      func(i int, total decimal.Decimal) bool
+	)
+
+	dfs = func(i int, total decimal.Decimal) bool {
+		tries++
+		if s.MaxTries > 0 && tries > s.MaxTries {
+			return true
+		}
+		if total.GreaterThanOrEqual(upper) {
+			return false
+		}
+		if total.GreaterThanOrEqual(target) {
+			diff := total.Sub(target)
+			if best == nil || diff.LessThan(bestDiff) {
+				best = append([]*candidate{}, current...)
+				bestDiff = diff
+			}
+			//完全相等，停止搜索
+			return diff.IsZero()
+		}
+		if i >= len(list) || total.Add(remaining[i]).LessThan(target) {
+			return false
+		}
+		if params.MaxInputs > 0 && len(current) >= params.MaxInputs {
+			return false
+		}
+
+		//包含第i个输入
+		current = append(current, list[i])
+		if dfs(i+1, total.Add(list[i].effective)) {
+			return true
+		}
+		current = current[:len(current)-1]
+
+		//不包含第i个输入
+		return dfs(i+1, total)
+	}
+
+	dfs(0, decimal.Zero)
+
+	return best
+}
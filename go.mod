@@ -24,6 +24,7 @@ require (
 	github.com/gorilla/websocket v1.4.1
 	github.com/imroc/req v0.2.4
 	github.com/lib/pq v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mr-tron/base58 v1.1.3
 	github.com/pborman/uuid v1.2.0
 	github.com/peterh/liner v1.1.1-0.20190123174540-a2c9a5303de7
@@ -37,5 +38,6 @@ require (
 	github.com/tyler-smith/go-bip39 v1.0.2
 	go.etcd.io/bbolt v1.3.3
 	golang.org/x/crypto v0.0.0-20191227163750-53104e6ec876
+	golang.org/x/text v0.3.2
 	gopkg.in/urfave/cli.v1 v1.20.0
 )
@@ -28,6 +28,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 
@@ -35,6 +36,7 @@ import (
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/crypto"
 	"github.com/blocktree/openwallet/v2/crypto/sha3"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/scrypt"
 )
@@ -42,7 +44,9 @@ import (
 const (
 
 	// HDKey的规范版本号
-	version = 1
+	// 1: aes-128-ctr加密种子
+	// 2: aes-256-ctr加密种子，派生密钥64字节，支持argon2id
+	version = 2
 
 	// maxCoinType is the maximum allowed coin type used when structuring
 	// the BIP0044 multi-account hierarchy.  This value is based on the
@@ -249,12 +253,6 @@ func (k *HDKey) DerivedKeyWithPath(path string, curveType uint32) (*owkeychain.E
 //	return derivedKey, err
 //}
 
-//Mnemonic 密钥助记词
-//func (k *HDKey) Mnemonic() string {
-//	mnemonic, _ := bip39.NewMnemonic(k.seed)
-//	return mnemonic
-//}
-
 //FileName 文件名
 func (k *HDKey) FileName() string {
 	return KeyFileName(k.Alias, k.KeyID)
@@ -268,18 +266,21 @@ func (k *HDKey) Seed() []byte {
 // EncryptKey encrypts a key using the specified scrypt parameters into a json
 // blob that can be decrypted later on.
 func EncryptKey(hdkey *HDKey, auth string, scryptN, scryptP int) ([]byte, error) {
+	return EncryptKeyWithKDF(hdkey, auth, ScryptKDF(scryptN, scryptP))
+}
 
-	authArray := []byte(auth)
+// EncryptKeyWithKDF 使用指定的KDF加密HDKey，生成最新版本的密钥文件内容
+func EncryptKeyWithKDF(hdkey *HDKey, auth string, kdf KDFConfig) ([]byte, error) {
 
 	salt := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
 		panic("reading from crypto/rand failed: " + err.Error())
 	}
-	derivedKey, err := scrypt.Key(authArray, salt, scryptN, scryptR, scryptP, scryptDKLen)
+	derivedKey, kdfParamsJSON, err := kdf.deriveKey(auth, salt, keyDKLen)
 	if err != nil {
 		return nil, err
 	}
-	encryptKey := derivedKey[:16]
+	encryptKey := derivedKey[:32]
 
 	keyBytes := hdkey.seed
 
@@ -291,25 +292,18 @@ func EncryptKey(hdkey *HDKey, auth string, scryptN, scryptP int) ([]byte, error)
 	if err != nil {
 		return nil, err
 	}
-	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
-
-	scryptParamsJSON := make(map[string]interface{}, 5)
-	scryptParamsJSON["n"] = scryptN
-	scryptParamsJSON["r"] = scryptR
-	scryptParamsJSON["p"] = scryptP
-	scryptParamsJSON["dklen"] = scryptDKLen
-	scryptParamsJSON["salt"] = hex.EncodeToString(salt)
+	mac := crypto.Keccak256(derivedKey[32:64], cipherText)
 
 	cipherParamsJSON := cipherparamsJSON{
 		IV: hex.EncodeToString(iv),
 	}
 
 	cryptoStruct := cryptoJSON{
-		Cipher:       "aes-128-ctr",
+		Cipher:       "aes-256-ctr",
 		CipherText:   hex.EncodeToString(cipherText),
 		CipherParams: cipherParamsJSON,
-		KDF:          keyHeaderKDF,
-		KDFParams:    scryptParamsJSON,
+		KDF:          kdf.KDF,
+		KDFParams:    kdfParamsJSON,
 		MAC:          hex.EncodeToString(mac),
 	}
 
@@ -359,7 +353,18 @@ func DecryptHDKey(keyjson []byte, auth string) (*HDKey, error) {
 // decryptHDKey 解密HDKey的文件内容
 func decryptHDKey(keyProtected *encryptedHDKeyJSON, auth string) (keyBytes []byte, err error) {
 
-	if keyProtected.Crypto.Cipher != "aes-128-ctr" {
+	if keyProtected.Version > version {
+		return nil, fmt.Errorf("Key file version not supported: %d", keyProtected.Version)
+	}
+
+	//加密密钥长度，派生密钥的后半部分用于计算MAC
+	var keyLen int
+	switch keyProtected.Crypto.Cipher {
+	case "aes-128-ctr":
+		keyLen = 16
+	case "aes-256-ctr":
+		keyLen = 32
+	default:
 		return nil, fmt.Errorf("Cipher not supported: %v", keyProtected.Crypto.Cipher)
 	}
 
@@ -383,12 +388,16 @@ func decryptHDKey(keyProtected *encryptedHDKeyJSON, auth string) (keyBytes []byt
 		return nil, err
 	}
 
-	calculatedMAC := crypto.Keccak256(derivedKey[16:32], cipherText)
+	if len(derivedKey) < keyLen*2 {
+		return nil, fmt.Errorf("Derived key length is too short: %d", len(derivedKey))
+	}
+
+	calculatedMAC := crypto.Keccak256(derivedKey[keyLen:keyLen*2], cipherText)
 	if !bytes.Equal(calculatedMAC, mac) {
 		return nil, ErrDecrypt
 	}
 
-	plainText, err := aesCTRXOR(derivedKey[:16], cipherText, iv)
+	plainText, err := aesCTRXOR(derivedKey[:keyLen], cipherText, iv)
 	if err != nil {
 		return nil, err
 	}
@@ -411,6 +420,16 @@ func getKDFKey(cryptoJSON cryptoJSON, auth string) ([]byte, error) {
 		p := ensureInt(cryptoJSON.KDFParams["p"])
 		return scrypt.Key(authArray, salt, n, r, p, dkLen)
 
+	} else if cryptoJSON.KDF == KDFArgon2id {
+		t := ensureInt(cryptoJSON.KDFParams["t"])
+		m := ensureInt(cryptoJSON.KDFParams["m"])
+		p := ensureInt(cryptoJSON.KDFParams["p"])
+		//argon2对无效的参数会panic，密钥文件的参数需要先检查
+		if t < 1 || int64(t) > math.MaxUint32 || m < 1 || int64(m) > math.MaxUint32 || p < 1 || p > math.MaxUint8 || dkLen < 1 {
+			return nil, fmt.Errorf("invalid argon2id parameters: t = %d, m = %d, p = %d, dklen = %d", t, m, p, dkLen)
+		}
+		return argon2.IDKey(authArray, salt, uint32(t), uint32(m), uint8(p), uint32(dkLen)), nil
+
 	} else if cryptoJSON.KDF == "pbkdf2" {
 		c := ensureInt(cryptoJSON.KDFParams["c"])
 		prf := cryptoJSON.KDFParams["prf"].(string)
@@ -430,7 +449,9 @@ func getKDFKey(cryptoJSON cryptoJSON, auth string) ([]byte, error) {
 func ensureInt(x interface{}) int {
 	res, ok := x.(int)
 	if !ok {
-		res = int(x.(float64))
+		//缺少或不是数字的参数为0，由调用方检查
+		f, _ := x.(float64)
+		res = int(f)
 	}
 	return res
 }
@@ -17,12 +17,17 @@ package hdkeystore
 
 import (
 	"crypto/hmac"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/blocktree/openwallet/v2/crypto/sha3"
+	"github.com/blocktree/openwallet/v2/log"
 )
 
 const (
@@ -49,6 +54,9 @@ const (
 
 	//种子长度
 	SeedLen = 32
+
+	//派生密钥长度，前32字节加密种子，后32字节计算MAC
+	keyDKLen = 64
 )
 
 var (
@@ -64,15 +72,31 @@ type HDKeystore struct {
 	//MasterKey   string
 	scryptN int
 	scryptP int
+
+	//kdf 加密密钥文件的KDF，为空使用scrypt
+	kdf *KDFConfig
 }
 
 // NewHDKeystore 实例化HDKeystore
 func NewHDKeystore(keydir string, scryptN, scryptP int) *HDKeystore {
 	keydir, _ = filepath.Abs(keydir)
-	ks := &HDKeystore{keydir, scryptN, scryptP}
+	ks := &HDKeystore{keysDirPath: keydir, scryptN: scryptN, scryptP: scryptP}
 	return ks
 }
 
+//SetKDF 设置加密密钥文件的KDF，例如：Argon2idKDF
+func (ks *HDKeystore) SetKDF(kdf KDFConfig) {
+	ks.kdf = &kdf
+}
+
+//kdfConfig 加密密钥文件的KDF配置
+func (ks *HDKeystore) kdfConfig() KDFConfig {
+	if ks.kdf != nil {
+		return *ks.kdf
+	}
+	return ScryptKDF(ks.scryptN, ks.scryptP)
+}
+
 // StoreHDKey 创建HDKey
 func StoreHDKey(dir, alias, auth string, scryptN, scryptP int) (*HDKey, string, error) {
 
@@ -91,7 +115,7 @@ func StoreHDKey(dir, alias, auth string, scryptN, scryptP int) (*HDKey, string,
 
 // StoreHDKey 创建HDKey
 func StoreHDKeyWithSeed(dir, alias, auth string, seed []byte, scryptN, scryptP int) (*HDKey, string, error) {
-	key, filePath, err := storeNewKey(&HDKeystore{dir, scryptN, scryptP}, alias, auth, seed)
+	key, filePath, err := storeNewKey(&HDKeystore{keysDirPath: dir, scryptN: scryptN, scryptP: scryptP}, alias, auth, seed)
 	return key, filePath, err
 }
 
@@ -120,6 +144,8 @@ func (ks HDKeystore) GetKey(rootId, filename, auth string) (*HDKey, error) {
 		return nil, err
 	}
 
+	warnOldKeyFile(keyPath, keyjson)
+
 	if len(rootId) > 0 {
 		// Make sure we're really operating on the requested key (no swap attacks)
 		if key.KeyID != rootId {
@@ -132,13 +158,173 @@ func (ks HDKeystore) GetKey(rootId, filename, auth string) (*HDKey, error) {
 
 //StoreKey 把HDKey重写加密写入到文件中
 func (ks *HDKeystore) StoreKey(filename string, key *HDKey, auth string) error {
-	keyjson, err := EncryptKey(key, auth, ks.scryptN, ks.scryptP)
+	keyjson, err := EncryptKeyWithKDF(key, auth, ks.kdfConfig())
 	if err != nil {
 		return err
 	}
 	return writeKeyFile(filename, keyjson)
 }
 
+//ChangePassword 修改密钥文件的密码，使用keystore的KDF重新加密，返回旧文件的备份路径。
+//备份文件仍可用旧密码解密，修改密码是因为旧密码泄露时，确认新文件可用后需要通过RemoveKeyBackup删除备份
+func (ks *HDKeystore) ChangePassword(filename, auth, newAuth string) (string, error) {
+	return ks.Reencrypt(filename, auth, newAuth, ks.kdfConfig())
+}
+
+//Reencrypt 使用新密码和KDF重新加密密钥文件，例如从LightScryptN升级为StandardScryptN或Argon2id，
+//旧文件备份为同目录下的隐藏文件，新文件原子替换，返回旧文件的备份路径
+func (ks *HDKeystore) Reencrypt(filename, auth, newAuth string, kdf KDFConfig) (string, error) {
+
+	keyPath := ks.JoinPath(filename)
+	keyjson, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := DecryptHDKey(keyjson, auth)
+	if err != nil {
+		return "", err
+	}
+
+	newjson, err := EncryptKeyWithKDF(key, newAuth, kdf)
+	if err != nil {
+		return "", err
+	}
+
+	return replaceKeyFile(keyPath, keyjson, newjson)
+}
+
+//MigrateKey 把旧版本的密钥文件升级为当前版本，使用原来的KDF参数重新加密，
+//原来的KDF不支持时使用keystore的KDF。旧文件备份为同目录下的隐藏文件，返回备份路径，
+//已是当前版本的不修改文件，返回空的备份路径
+func (ks *HDKeystore) MigrateKey(filename, auth string) (string, error) {
+
+	keyPath := ks.JoinPath(filename)
+	keyjson, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := DecryptHDKey(keyjson, auth)
+	if err != nil {
+		return "", err
+	}
+
+	if keyFileVersion(keyjson) >= version {
+		return "", nil
+	}
+
+	return migrateKeyFile(keyPath, keyjson, key, auth, ks.kdfConfig())
+}
+
+//LoadHDKeyFile 读取并解密密钥文件，不修改文件，旧版本的密钥文件通过MigrateKey升级
+func LoadHDKeyFile(file, auth string) (*HDKey, error) {
+
+	keyjson, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DecryptHDKey(keyjson, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	warnOldKeyFile(file, keyjson)
+
+	return key, nil
+}
+
+//warnOldKeyFile 读取到旧版本的密钥文件时提示升级，读取时不修改文件
+func warnOldKeyFile(file string, keyjson []byte) {
+	if v := keyFileVersion(keyjson); v < version {
+		log.Warningf("key file: %s is version %d, run `wmd wallet migrate --keyfile %s` to upgrade it to version %d", file, v, file, version)
+	}
+}
+
+//keyFileVersion 密钥文件的版本号，没有版本号的为版本1
+func keyFileVersion(keyjson []byte) int {
+	k := new(encryptedHDKeyJSON)
+	if err := json.Unmarshal(keyjson, k); err != nil {
+		return 0
+	}
+	if k.Version == 0 {
+		return 1
+	}
+	return k.Version
+}
+
+//migrateKeyFile 旧版本的密钥文件使用原来的KDF参数重新加密为当前版本，
+//原来的KDF不支持时使用defaultKDF，返回旧文件的备份路径
+func migrateKeyFile(file string, keyjson []byte, key *HDKey, auth string, defaultKDF KDFConfig) (string, error) {
+
+	k := new(encryptedHDKeyJSON)
+	if err := json.Unmarshal(keyjson, k); err != nil {
+		return "", err
+	}
+
+	kdf, ok := kdfConfigOf(k.Crypto)
+	if !ok {
+		kdf = defaultKDF
+	}
+
+	newjson, err := EncryptKeyWithKDF(key, auth, kdf)
+	if err != nil {
+		return "", err
+	}
+
+	backup, err := replaceKeyFile(file, keyjson, newjson)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("key file: %s has been migrated from version %d to %d, backup: %s", file, keyFileVersion(keyjson), version, backup)
+
+	return backup, nil
+}
+
+//replaceKeyFile 备份旧的密钥文件后原子替换为新内容，返回备份文件路径
+func replaceKeyFile(file string, oldjson, newjson []byte) (string, error) {
+
+	//备份为隐藏文件，扫描钱包目录时会被忽略
+	backup := filepath.Join(filepath.Dir(file), fmt.Sprintf(".%s.%d.bak", filepath.Base(file), time.Now().UnixNano()))
+	if err := writeKeyFile(backup, oldjson); err != nil {
+		return "", err
+	}
+
+	if err := writeKeyFile(file, newjson); err != nil {
+		return "", err
+	}
+
+	return backup, nil
+}
+
+//RemoveKeyBackup 确认密钥文件可以用auth解密后，删除修改密码或升级时生成的备份文件，
+//备份文件仍可用旧密码解密，旧密码泄露时应在确认新文件可用后删除
+func (ks *HDKeystore) RemoveKeyBackup(filename, backup, auth string) error {
+
+	keyPath := ks.JoinPath(filename)
+	backupPath := ks.JoinPath(backup)
+
+	//只删除该密钥文件的备份
+	prefix := "." + filepath.Base(keyPath) + "."
+	name := filepath.Base(backupPath)
+	if filepath.Dir(backupPath) != filepath.Dir(keyPath) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+		return fmt.Errorf("%s is not the backup of key file: %s", backup, filename)
+	}
+
+	keyjson, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = DecryptHDKey(keyjson, auth); err != nil {
+		return err
+	}
+
+	return os.Remove(backupPath)
+}
+
 //JoinPath 文件路径组合
 func (ks *HDKeystore) JoinPath(filename string) string {
 	if filepath.IsAbs(filename) {
@@ -16,8 +16,19 @@
 package hdkeystore
 
 import (
-	"testing"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/crypto"
+	"golang.org/x/crypto/scrypt"
 )
 
 
@@ -33,7 +44,7 @@ func TestStoreHDKey(t *testing.T) {
 
 func TestGetKey(t *testing.T) {
 	path := filepath.Join(".", "keys")
-	ks := &HDKeystore{path, StandardScryptN, StandardScryptP}
+	ks := &HDKeystore{keysDirPath: path, scryptN: StandardScryptN, scryptP: StandardScryptP}
 
 	key, err := ks.GetKey("WAeAP5ggYYZ1euSJqURNEoGBRP6ucfPq2g",
 		"sogosdfo-WAeAP5ggYYZ1euSJqURNEoGBRP6ucfPq2g.key",
@@ -44,4 +55,202 @@ func TestGetKey(t *testing.T) {
 	} else {
 		t.Logf("GetKey root id = %s", key.KeyID)
 	}
-}
\ No newline at end of file
+}
+
+//encryptKeyV1 按版本1的格式加密HDKey，用于测试旧版本密钥文件的升级
+func encryptKeyV1(hdkey *HDKey, auth string, scryptN, scryptP int) []byte {
+	salt := make([]byte, 32)
+	io.ReadFull(rand.Reader, salt)
+	derivedKey, _ := scrypt.Key([]byte(auth), salt, scryptN, scryptR, scryptP, 32)
+	iv := make([]byte, 16)
+	io.ReadFull(rand.Reader, iv)
+	cipherText, _ := aesCTRXOR(derivedKey[:16], hdkey.seed, iv)
+	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
+
+	keyjson, _ := json.Marshal(encryptedHDKeyJSON{
+		Alias: hdkey.Alias,
+		KeyID: hdkey.KeyID,
+		Crypto: cryptoJSON{
+			Cipher:       "aes-128-ctr",
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: cipherparamsJSON{IV: hex.EncodeToString(iv)},
+			KDF:          keyHeaderKDF,
+			KDFParams: map[string]interface{}{
+				"n": scryptN, "r": scryptR, "p": scryptP, "dklen": 32, "salt": hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(mac),
+		},
+		RootPath: hdkey.RootPath,
+		Version:  1,
+	})
+	return keyjson
+}
+
+//keyBackups 密钥文件的备份
+func keyBackups(dir, filename string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "."+filename+".*.bak"))
+	return files
+}
+
+func TestHDKeystore_MigrateKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdkeystore")
+	if err != nil {
+		t.Fatalf("TempDir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	seed, _ := GenerateSeed(SeedLen)
+	key, _ := NewHDKey(seed, "hello", OpenwCoinTypePath)
+	filename := key.FileName() + ".key"
+	oldjson := encryptKeyV1(key, "12345678", LightScryptN, LightScryptP)
+	ioutil.WriteFile(filepath.Join(dir, filename), oldjson, 0600)
+
+	ks := NewHDKeystore(dir, LightScryptN, LightScryptP)
+	loaded, err := ks.GetKey(key.KeyID, filename, "12345678")
+	if err != nil {
+		t.Fatalf("GetKey failed unexpected error: %v", err)
+	}
+	if !bytes.Equal(loaded.Seed(), seed) {
+		t.Fatalf("loaded seed = %x, want %x", loaded.Seed(), seed)
+	}
+
+	//读取密钥不修改文件
+	if _, err = LoadHDKeyFile(filepath.Join(dir, filename), "12345678"); err != nil {
+		t.Fatalf("LoadHDKeyFile failed unexpected error: %v", err)
+	}
+	if current, _ := ioutil.ReadFile(filepath.Join(dir, filename)); !bytes.Equal(current, oldjson) || len(keyBackups(dir, filename)) != 0 {
+		t.Fatalf("key file should not be changed by reading")
+	}
+
+	//密码错误不升级
+	if _, err = ks.MigrateKey(filename, "wrong password"); err != ErrDecrypt {
+		t.Fatalf("MigrateKey with wrong password should be failed, unexpected error: %v", err)
+	}
+
+	backup, err := ks.MigrateKey(filename, "12345678")
+	if err != nil {
+		t.Fatalf("MigrateKey failed unexpected error: %v", err)
+	}
+
+	newjson, _ := ioutil.ReadFile(filepath.Join(dir, filename))
+	if v := keyFileVersion(newjson); v != version {
+		t.Fatalf("key file version = %d, want %d", v, version)
+	}
+	backups := keyBackups(dir, filename)
+	if len(backups) != 1 || backups[0] != backup {
+		t.Fatalf("backups = %v, want %s", backups, backup)
+	}
+	if backup, _ := ioutil.ReadFile(backups[0]); !bytes.Equal(backup, oldjson) {
+		t.Errorf("backup content is not the old key file")
+	}
+
+	//升级后的密钥文件可以正常读取，不再升级
+	loaded, err = LoadHDKeyFile(filepath.Join(dir, filename), "12345678")
+	if err != nil {
+		t.Fatalf("LoadHDKeyFile failed unexpected error: %v", err)
+	}
+	if backup, err = ks.MigrateKey(filename, "12345678"); err != nil || len(backup) > 0 {
+		t.Errorf("migrated key file should not be migrated again, backup: %s, err: %v", backup, err)
+	}
+	if loaded.KeyID != key.KeyID || len(keyBackups(dir, filename)) != 1 {
+		t.Errorf("migrated key file should not be migrated again")
+	}
+}
+
+func TestDecryptHDKey_InvalidArgon2id(t *testing.T) {
+	seed, _ := GenerateSeed(SeedLen)
+	key, _ := NewHDKey(seed, "hello", OpenwCoinTypePath)
+	keyjson, err := EncryptKeyWithKDF(key, "12345678", Argon2idKDF(LightArgon2idTime, LightArgon2idMemory, Argon2idThreads))
+	if err != nil {
+		t.Fatalf("EncryptKeyWithKDF failed unexpected error: %v", err)
+	}
+
+	//篡改的参数返回错误，不能panic
+	for _, param := range []string{"t", "m", "p"} {
+		k := new(encryptedHDKeyJSON)
+		json.Unmarshal(keyjson, k)
+		k.Crypto.KDFParams[param] = 0
+		invalid, _ := json.Marshal(k)
+		if _, err := DecryptHDKey(invalid, "12345678"); err == nil {
+			t.Errorf("argon2id parameter %s = 0 should be failed", param)
+		}
+
+		delete(k.Crypto.KDFParams, param)
+		invalid, _ = json.Marshal(k)
+		if _, err := DecryptHDKey(invalid, "12345678"); err == nil {
+			t.Errorf("missing argon2id parameter %s should be failed", param)
+		}
+	}
+}
+
+func TestHDKeystore_Reencrypt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdkeystore")
+	if err != nil {
+		t.Fatalf("TempDir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, file, err := StoreHDKey(dir, "hello", "12345678", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("StoreHDKey failed unexpected error: %v", err)
+	}
+	filename := filepath.Base(file)
+	ks := NewHDKeystore(dir, LightScryptN, LightScryptP)
+
+	//密码错误不修改密钥文件
+	origin, _ := ioutil.ReadFile(file)
+	if _, err := ks.ChangePassword(filename, "wrong password", "87654321"); err != ErrDecrypt {
+		t.Fatalf("ChangePassword with wrong password should be failed, unexpected error: %v", err)
+	}
+	if current, _ := ioutil.ReadFile(file); !bytes.Equal(current, origin) || len(keyBackups(dir, filename)) != 0 {
+		t.Fatalf("key file should not be changed")
+	}
+
+	backup, err := ks.ChangePassword(filename, "12345678", "87654321")
+	if err != nil {
+		t.Fatalf("ChangePassword failed unexpected error: %v", err)
+	}
+	if _, err := ks.GetKey(key.KeyID, filename, "12345678"); err != ErrDecrypt {
+		t.Errorf("old password should not decrypt the key file")
+	}
+	if _, err := DecryptHDKey(origin, "12345678"); err != nil {
+		t.Errorf("backup %s should be decrypted by old password", backup)
+	}
+
+	//备份仍可用旧密码解密，确认新密码可用后才删除
+	if err := ks.RemoveKeyBackup(filename, backup, "12345678"); err != ErrDecrypt {
+		t.Errorf("RemoveKeyBackup with old password should be failed, unexpected error: %v", err)
+	}
+	if err := ks.RemoveKeyBackup(filename, file, "87654321"); err == nil {
+		t.Errorf("RemoveKeyBackup should not remove the key file")
+	}
+	if n := len(keyBackups(dir, filename)); n != 1 {
+		t.Fatalf("backups = %d, want 1", n)
+	}
+	if err := ks.RemoveKeyBackup(filename, backup, "87654321"); err != nil {
+		t.Errorf("RemoveKeyBackup unexpected error: %v", err)
+	}
+	if n := len(keyBackups(dir, filename)); n != 0 {
+		t.Errorf("backups = %d, want 0", n)
+	}
+
+	//升级为argon2id
+	_, err = ks.Reencrypt(filename, "87654321", "87654321", Argon2idKDF(LightArgon2idTime, LightArgon2idMemory, Argon2idThreads))
+	if err != nil {
+		t.Fatalf("Reencrypt failed unexpected error: %v", err)
+	}
+	keyjson, _ := ioutil.ReadFile(file)
+	if !strings.Contains(string(keyjson), `"kdf": "argon2id"`) {
+		t.Fatalf("key file kdf is not argon2id: %s", keyjson)
+	}
+	loaded, err := ks.GetKey(key.KeyID, filename, "87654321")
+	if err != nil {
+		t.Fatalf("GetKey failed unexpected error: %v", err)
+	}
+	if !bytes.Equal(loaded.Seed(), key.Seed()) {
+		t.Errorf("loaded seed = %x, want %x", loaded.Seed(), key.Seed())
+	}
+	if n := len(keyBackups(dir, filename)); n != 1 {
+		t.Errorf("backups = %d, want 1", n)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	//KDFScrypt scrypt密钥派生
+	KDFScrypt = keyHeaderKDF
+	//KDFArgon2id argon2id密钥派生
+	KDFArgon2id = "argon2id"
+
+	// StandardArgon2idTime is the time parameter of Argon2id, using 256MB
+	// memory and taking approximately 1s CPU time on a modern processor.
+	StandardArgon2idTime = 3
+
+	// StandardArgon2idMemory is the memory parameter of Argon2id in KiB.
+	StandardArgon2idMemory = 256 * 1024
+
+	// LightArgon2idTime is the time parameter of Argon2id, using 4MB
+	// memory and taking approximately 10ms CPU time on a modern processor.
+	LightArgon2idTime = 1
+
+	// LightArgon2idMemory is the memory parameter of Argon2id in KiB.
+	LightArgon2idMemory = 4 * 1024
+
+	// Argon2idThreads is the parallelism parameter of Argon2id.
+	Argon2idThreads = 4
+)
+
+//KDFConfig 加密密钥文件的密钥派生配置
+type KDFConfig struct {
+	KDF string
+
+	//scrypt参数
+	ScryptN int
+	ScryptP int
+
+	//argon2id参数，Memory单位KiB
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+//ScryptKDF scrypt密钥派生配置
+func ScryptKDF(n, p int) KDFConfig {
+	return KDFConfig{KDF: KDFScrypt, ScryptN: n, ScryptP: p}
+}
+
+//Argon2idKDF argon2id密钥派生配置
+func Argon2idKDF(time, memory uint32, threads uint8) KDFConfig {
+	return KDFConfig{KDF: KDFArgon2id, Argon2Time: time, Argon2Memory: memory, Argon2Threads: threads}
+}
+
+//deriveKey 派生加密密钥，返回密钥文件中保存的KDF参数
+func (c KDFConfig) deriveKey(auth string, salt []byte, dkLen int) ([]byte, map[string]interface{}, error) {
+
+	params := make(map[string]interface{}, 5)
+	params["dklen"] = dkLen
+	params["salt"] = hex.EncodeToString(salt)
+
+	switch c.KDF {
+	case KDFScrypt:
+		key, err := scrypt.Key([]byte(auth), salt, c.ScryptN, scryptR, c.ScryptP, dkLen)
+		if err != nil {
+			return nil, nil, err
+		}
+		params["n"] = c.ScryptN
+		params["r"] = scryptR
+		params["p"] = c.ScryptP
+		return key, params, nil
+	case KDFArgon2id:
+		if c.Argon2Time == 0 || c.Argon2Memory == 0 || c.Argon2Threads == 0 {
+			return nil, nil, fmt.Errorf("argon2id parameters should not be zero")
+		}
+		key := argon2.IDKey([]byte(auth), salt, c.Argon2Time, c.Argon2Memory, c.Argon2Threads, uint32(dkLen))
+		params["t"] = c.Argon2Time
+		params["m"] = c.Argon2Memory
+		params["p"] = c.Argon2Threads
+		return key, params, nil
+	}
+
+	return nil, nil, fmt.Errorf("Unsupported KDF: %s", c.KDF)
+}
+
+//kdfConfigOf 密钥文件使用的KDF配置，不支持的KDF返回false
+func kdfConfigOf(cryptoJSON cryptoJSON) (KDFConfig, bool) {
+	switch cryptoJSON.KDF {
+	case KDFScrypt:
+		return ScryptKDF(ensureInt(cryptoJSON.KDFParams["n"]), ensureInt(cryptoJSON.KDFParams["p"])), true
+	case KDFArgon2id:
+		return Argon2idKDF(
+			uint32(ensureInt(cryptoJSON.KDFParams["t"])),
+			uint32(ensureInt(cryptoJSON.KDFParams["m"])),
+			uint8(ensureInt(cryptoJSON.KDFParams["p"])),
+		), true
+	}
+	return KDFConfig{}, false
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tyler-smith/go-bip39"
+	"github.com/tyler-smith/go-bip39/wordlists"
+	"golang.org/x/text/unicode/norm"
+)
+
+//助记词语言
+const (
+	MnemonicEnglish            = "english"
+	MnemonicChineseSimplified  = "chinese_simplified"
+	MnemonicChineseTraditional = "chinese_traditional"
+	MnemonicJapanese           = "japanese"
+	MnemonicKorean             = "korean"
+	MnemonicSpanish            = "spanish"
+	MnemonicFrench             = "french"
+	MnemonicItalian            = "italian"
+)
+
+var (
+	//MnemonicLanguages 支持的助记词语言，恢复时按此顺序识别
+	MnemonicLanguages = []string{
+		MnemonicEnglish,
+		MnemonicChineseSimplified,
+		MnemonicChineseTraditional,
+		MnemonicJapanese,
+		MnemonicKorean,
+		MnemonicSpanish,
+		MnemonicFrench,
+		MnemonicItalian,
+	}
+
+	mnemonicWordLists = map[string][]string{
+		MnemonicEnglish:            wordlists.English,
+		MnemonicChineseSimplified:  wordlists.ChineseSimplified,
+		MnemonicChineseTraditional: wordlists.ChineseTraditional,
+		MnemonicJapanese:           wordlists.Japanese,
+		MnemonicKorean:             wordlists.Korean,
+		MnemonicSpanish:            wordlists.Spanish,
+		MnemonicFrench:             wordlists.French,
+		MnemonicItalian:            wordlists.Italian,
+	}
+
+	//词库的反向索引，首次使用时创建。
+	//bip39的词库是包级别的全局变量，其他模块也在使用，这里不修改它，按语言自行编码
+	mnemonicWordIndexes     map[string]map[string]int
+	mnemonicWordIndexesOnce sync.Once
+
+	//ErrInvalidMnemonic 助记词无效
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+	//ErrInvalidMnemonicWords 助记词数量不支持
+	ErrInvalidMnemonicWords = errors.New("mnemonic words must be 12, 15, 18, 21 or 24")
+
+	//ErrMnemonicUnavailable 种子不能编码为助记词
+	ErrMnemonicUnavailable = errors.New("seed of key can not be encoded as mnemonic")
+
+	//ErrMnemonicChecksum 助记词校验和错误
+	ErrMnemonicChecksum = errors.New("mnemonic checksum is incorrect")
+)
+
+//wordList 获取语言的词库和反向索引，为空使用英文
+func wordList(language string) ([]string, map[string]int, error) {
+	if len(language) == 0 {
+		language = MnemonicEnglish
+	}
+	list, ok := mnemonicWordLists[language]
+	if !ok {
+		return nil, nil, fmt.Errorf("mnemonic language: %s is not supported", language)
+	}
+	mnemonicWordIndexesOnce.Do(func() {
+		mnemonicWordIndexes = make(map[string]map[string]int, len(mnemonicWordLists))
+		for lang, words := range mnemonicWordLists {
+			index := make(map[string]int, len(words))
+			for i, w := range words {
+				index[w] = i
+			}
+			mnemonicWordIndexes[lang] = index
+		}
+	})
+	return list, mnemonicWordIndexes[language], nil
+}
+
+//entropyToMnemonic 把熵编码为助记词，熵之后附加sha256的前len/32位作为校验和，每11位对应一个单词
+func entropyToMnemonic(entropy []byte, language string) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", bip39.ErrEntropyLengthInvalid
+	}
+	list, _, err := wordList(language)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := sha256.Sum256(entropy)
+	data := append(append([]byte{}, entropy...), checksum[0])
+	bits := len(entropy)*8 + len(entropy)/4
+
+	words := make([]string, bits/11)
+	for i := range words {
+		index := 0
+		for j := 0; j < 11; j++ {
+			pos := i*11 + j
+			bit := (data[pos/8] >> uint(7-pos%8)) & 1
+			index = index<<1 | int(bit)
+		}
+		words[i] = list[index]
+	}
+	return strings.Join(words, " "), nil
+}
+
+//entropyFromMnemonic 按语言解析助记词的熵并验证校验和
+func entropyFromMnemonic(words []string, language string) ([]byte, error) {
+	_, index, err := wordList(language)
+	if err != nil {
+		return nil, err
+	}
+
+	bits := len(words) * 11
+	data := make([]byte, (bits+7)/8)
+	for i, w := range words {
+		n, ok := index[w]
+		if !ok {
+			return nil, fmt.Errorf("word `%s` is not found in %s word list", w, language)
+		}
+		for j := 0; j < 11; j++ {
+			if n&(1<<uint(10-j)) != 0 {
+				pos := i*11 + j
+				data[pos/8] |= 1 << uint(7-pos%8)
+			}
+		}
+	}
+
+	checksumBits := bits / 33
+	entropy := data[:(bits-checksumBits)/8]
+	checksum := sha256.Sum256(entropy)
+	mask := byte(0xff) << uint(8-checksumBits)
+	if data[len(entropy)]&mask != checksum[0]&mask {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+//MnemonicToEntropy 解析助记词的熵，language为空时自动识别语言
+func MnemonicToEntropy(mnemonic, language string) ([]byte, error) {
+
+	words := strings.Fields(mnemonic)
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, ErrInvalidMnemonicWords
+	}
+
+	languages := MnemonicLanguages
+	if len(language) > 0 {
+		languages = []string{language}
+	}
+
+	for _, lang := range languages {
+		entropy, err := entropyFromMnemonic(words, lang)
+		if err == nil {
+			return entropy, nil
+		}
+		if len(language) > 0 {
+			return nil, fmt.Errorf("%v: %v", ErrInvalidMnemonic, err)
+		}
+	}
+
+	return nil, ErrInvalidMnemonic
+}
+
+//GenerateMnemonic 生成随机助记词
+//@param words 助记词数量：12，15，18，21，24
+//@param language 助记词语言，为空使用英文
+func GenerateMnemonic(words int, language string) (string, error) {
+	if words%3 != 0 || words < 12 || words > 24 {
+		return "", ErrInvalidMnemonicWords
+	}
+	entropy, err := bip39.NewEntropy(words / 3 * 32)
+	if err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy, language)
+}
+
+//MnemonicToSeed 按BIP39标准通过助记词和密码计算HDKey的种子，使用PBKDF2派生64字节的种子，
+//与其他BIP39钱包一致，密码可以为空，恢复时必须提供相同的密码
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicToEntropy(mnemonic, ""); err != nil {
+		return nil, err
+	}
+	sentence := norm.NFKD.String(strings.Join(strings.Fields(mnemonic), " "))
+	return bip39.NewSeed(sentence, norm.NFKD.String(passphrase)), nil
+}
+
+//Mnemonic 密钥种子的备份助记词，种子直接作为熵编码，只支持16到32字节的种子。
+//这不是BIP39的种子助记词，不能导入其他钱包，只能通过NewHDKeyWithEntropyMnemonic恢复
+func (k *HDKey) Mnemonic(language string) (string, error) {
+	if len(k.seed) < MinSeedBytes || len(k.seed) > 32 || len(k.seed)%4 != 0 {
+		return "", ErrMnemonicUnavailable
+	}
+	return entropyToMnemonic(k.seed, language)
+}
+
+//NewHDKeyWithMnemonic 通过BIP39助记词和密码创建HDKey，相同的助记词和密码得到相同的KeyID
+func NewHDKeyWithMnemonic(mnemonic, passphrase, alias, rootPath string) (*HDKey, error) {
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewHDKey(seed, alias, rootPath)
+}
+
+//NewHDKeyWithEntropyMnemonic 通过HDKey.Mnemonic导出的备份助记词恢复HDKey，助记词的熵就是种子
+func NewHDKeyWithEntropyMnemonic(mnemonic, alias, rootPath string) (*HDKey, error) {
+	seed, err := MnemonicToEntropy(mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+	return NewHDKey(seed, alias, rootPath)
+}
+
+//StoreHDKeyWithMnemonic 通过BIP39助记词和密码创建HDKey，并加密保存到密钥文件
+func StoreHDKeyWithMnemonic(dir, alias, auth, mnemonic, passphrase string, scryptN, scryptP int) (*HDKey, string, error) {
+	seed, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+	return StoreHDKeyWithSeed(dir, alias, auth, seed, scryptN, scryptP)
+}
+
+//StoreHDKeyWithEntropyMnemonic 通过HDKey.Mnemonic导出的备份助记词恢复HDKey，并加密保存到密钥文件
+func StoreHDKeyWithEntropyMnemonic(dir, alias, auth, mnemonic string, scryptN, scryptP int) (*HDKey, string, error) {
+	seed, err := MnemonicToEntropy(mnemonic, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return StoreHDKeyWithSeed(dir, alias, auth, seed, scryptN, scryptP)
+}
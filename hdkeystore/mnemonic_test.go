@@ -0,0 +1,174 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+func TestGenerateMnemonic(t *testing.T) {
+	for _, words := range []int{12, 18, 24} {
+		for _, lang := range MnemonicLanguages {
+			mnemonic, err := GenerateMnemonic(words, lang)
+			if err != nil {
+				t.Fatalf("GenerateMnemonic failed unexpected error: %v", err)
+			}
+			if n := len(strings.Fields(mnemonic)); n != words {
+				t.Fatalf("mnemonic words = %d, want %d", n, words)
+			}
+			if _, err := MnemonicToEntropy(mnemonic, ""); err != nil {
+				t.Fatalf("MnemonicToEntropy [%s] failed unexpected error: %v", lang, err)
+			}
+		}
+	}
+
+	if _, err := GenerateMnemonic(13, MnemonicEnglish); err != ErrInvalidMnemonicWords {
+		t.Errorf("13 words should not be supported")
+	}
+	if _, err := GenerateMnemonic(12, "latin"); err == nil {
+		t.Errorf("latin should not be supported")
+	}
+}
+
+func TestHDKey_Mnemonic(t *testing.T) {
+	seed, _ := hex.DecodeString("4b68b20a5d3ac671a61e6e94b4de309530a12439b7c3ee548d20966674696656")
+	key, _ := NewHDKey(seed, "hello", OpenwCoinTypePath)
+
+	for _, lang := range MnemonicLanguages {
+		mnemonic, err := key.Mnemonic(lang)
+		if err != nil {
+			t.Fatalf("Mnemonic failed unexpected error: %v", err)
+		}
+		restored, err := NewHDKeyWithEntropyMnemonic(mnemonic, "hello", OpenwCoinTypePath)
+		if err != nil {
+			t.Fatalf("NewHDKeyWithEntropyMnemonic failed unexpected error: %v", err)
+		}
+		if restored.KeyID != key.KeyID || !bytes.Equal(restored.Seed(), seed) {
+			t.Errorf("[%s] restored key = %s, want %s", lang, restored.KeyID, key.KeyID)
+		}
+	}
+
+	extSeed := append(seed, seed...)
+	key, _ = NewHDKey(extSeed, "hello", OpenwCoinTypePath)
+	if _, err := key.Mnemonic(MnemonicEnglish); err != ErrMnemonicUnavailable {
+		t.Errorf("64 bytes seed should not be encoded as mnemonic")
+	}
+}
+
+func TestMnemonicToSeed_Passphrase(t *testing.T) {
+	//BIP39 test vector
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	seed, err := MnemonicToSeed(mnemonic, "TREZOR")
+	if err != nil {
+		t.Fatalf("MnemonicToSeed failed unexpected error: %v", err)
+	}
+	want := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+	if hex.EncodeToString(seed) != want {
+		t.Errorf("seed = %s, want %s", hex.EncodeToString(seed), want)
+	}
+
+	//没有密码也按BIP39派生，与其他钱包一致
+	seed, err = MnemonicToSeed(mnemonic, "")
+	if err != nil {
+		t.Fatalf("MnemonicToSeed failed unexpected error: %v", err)
+	}
+	want = "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+	if hex.EncodeToString(seed) != want {
+		t.Errorf("seed = %s, want %s", hex.EncodeToString(seed), want)
+	}
+
+	if _, err := MnemonicToSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", ""); err == nil {
+		t.Errorf("checksum should be incorrect")
+	}
+}
+
+func TestMnemonic_Bip39WordList(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, lang := range MnemonicLanguages {
+				if _, err := GenerateMnemonic(12, lang); err != nil {
+					t.Errorf("GenerateMnemonic failed unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+
+	//其他模块直接使用bip39的全局词库，不受其他语言的影响
+	for i := 0; i < 100; i++ {
+		entropy, _ := bip39.NewEntropy(256)
+		want, err := bip39.NewMnemonic(entropy)
+		if err != nil {
+			t.Fatalf("NewMnemonic failed unexpected error: %v", err)
+		}
+		mnemonic, err := entropyToMnemonic(entropy, MnemonicEnglish)
+		if err != nil {
+			t.Fatalf("entropyToMnemonic failed unexpected error: %v", err)
+		}
+		if mnemonic != want {
+			t.Fatalf("mnemonic = %s, want %s", mnemonic, want)
+		}
+	}
+	wg.Wait()
+}
+
+func TestStoreHDKeyWithMnemonic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdkeystore")
+	if err != nil {
+		t.Fatalf("TempDir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, file, err := StoreHDKey(filepath.Join(dir, "origin"), "hello", "12345678", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("StoreHDKey failed unexpected error: %v", err)
+	}
+	mnemonic, err := key.Mnemonic(MnemonicChineseSimplified)
+	if err != nil {
+		t.Fatalf("Mnemonic failed unexpected error: %v", err)
+	}
+
+	restored, restoredFile, err := StoreHDKeyWithEntropyMnemonic(filepath.Join(dir, "restore"), "hello", "12345678", mnemonic, LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("StoreHDKeyWithEntropyMnemonic failed unexpected error: %v", err)
+	}
+	if restored.KeyID != key.KeyID || filepath.Base(restoredFile) != filepath.Base(file) {
+		t.Fatalf("restored key file = %s, want %s", restoredFile, file)
+	}
+
+	keyjson, err := ioutil.ReadFile(restoredFile)
+	if err != nil {
+		t.Fatalf("ReadFile failed unexpected error: %v", err)
+	}
+	decrypted, err := DecryptHDKey(keyjson, "12345678")
+	if err != nil {
+		t.Fatalf("DecryptHDKey failed unexpected error: %v", err)
+	}
+	if decrypted.KeyID != key.KeyID || !bytes.Equal(decrypted.Seed(), key.Seed()) {
+		t.Errorf("decrypted key = %s, want %s", decrypted.KeyID, key.KeyID)
+	}
+}
@@ -0,0 +1,577 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	slip39RadixBits              = 10
+	slip39IDLengthBits           = 15
+	slip39IterationExpLengthBits = 4
+	slip39ChecksumLengthWords    = 3
+	slip39DigestLengthBytes      = 4
+	slip39MetadataLengthWords    = 7
+	slip39MinMnemonicLengthWords = 20
+	slip39MaxShareCount          = 16
+	slip39BaseIterationCount     = 10000
+	slip39RoundCount             = 4
+	slip39SecretIndex            = 255
+	slip39DigestIndex            = 254
+
+	slip39Customization           = "shamir"
+	slip39CustomizationExtendable = "shamir_extendable"
+)
+
+var (
+	//SLIP39IterationExponent 拆分分片时加密主密钥的迭代指数，PBKDF2迭代次数为10000×2^e
+	SLIP39IterationExponent = 1
+
+	//ErrInvalidSLIP39Mnemonic SLIP-39助记词无效
+	ErrInvalidSLIP39Mnemonic = errors.New("invalid slip39 mnemonic")
+
+	//ErrInsufficientSLIP39Shares SLIP-39分片不足以恢复主密钥
+	ErrInsufficientSLIP39Shares = errors.New("insufficient slip39 shares")
+
+	slip39WordIndex = make(map[string]int, len(slip39WordList))
+
+	slip39Generator = [10]uint32{
+		0xE0E040, 0x1C1C080, 0x3838100, 0x7070200, 0xE0E0009,
+		0x1C0C2412, 0x38086C24, 0x3090FC48, 0x21B1F890, 0x3F3F120,
+	}
+
+	//GF(256)的指数表和对数表，约化多项式x^8+x^4+x^3+x+1，生成元3
+	gf256Exp [255]byte
+	gf256Log [256]byte
+)
+
+func init() {
+	for i, w := range slip39WordList {
+		slip39WordIndex[w] = i
+	}
+
+	poly := 1
+	for i := 0; i < 255; i++ {
+		gf256Exp[i] = byte(poly)
+		gf256Log[poly] = byte(i)
+		poly = (poly << 1) ^ poly
+		if poly&0x100 != 0 {
+			poly ^= 0x11B
+		}
+	}
+}
+
+//SLIP39Group 分组的成员门限和成员数量
+type SLIP39Group struct {
+	MemberThreshold int
+	MemberCount     int
+}
+
+//SLIP39Share SLIP-39的助记词分片
+type SLIP39Share struct {
+	Identifier        uint16
+	Extendable        bool
+	IterationExponent int
+	GroupIndex        int
+	GroupThreshold    int
+	GroupCount        int
+	MemberIndex       int
+	MemberThreshold   int
+	Value             []byte
+}
+
+//customization 校验和的自定义字符串
+func (s *SLIP39Share) customization() string {
+	if s.Extendable {
+		return slip39CustomizationExtendable
+	}
+	return slip39Customization
+}
+
+//Mnemonic 分片编码为助记词
+func (s *SLIP39Share) Mnemonic() string {
+
+	ext := 0
+	if s.Extendable {
+		ext = 1
+	}
+
+	idExp := int(s.Identifier)<<(slip39IterationExpLengthBits+1) | ext<<slip39IterationExpLengthBits | s.IterationExponent
+	params := s.GroupIndex<<16 | (s.GroupThreshold-1)<<12 | (s.GroupCount-1)<<8 | s.MemberIndex<<4 | (s.MemberThreshold - 1)
+
+	indices := []int{idExp >> 10, idExp & 1023, params >> 10, params & 1023}
+
+	valueWords := (len(s.Value)*8 + slip39RadixBits - 1) / slip39RadixBits
+	value := new(big.Int).SetBytes(s.Value)
+	valueIndices := make([]int, valueWords)
+	for i := valueWords - 1; i >= 0; i-- {
+		valueIndices[i] = int(new(big.Int).And(value, big.NewInt(1023)).Int64())
+		value.Rsh(value, slip39RadixBits)
+	}
+	indices = append(indices, valueIndices...)
+	indices = append(indices, slip39CreateChecksum(s.customization(), indices)...)
+
+	words := make([]string, len(indices))
+	for i, idx := range indices {
+		words[i] = slip39WordList[idx]
+	}
+	return strings.Join(words, " ")
+}
+
+//DecodeSLIP39Share 解析SLIP-39助记词分片，校验单词、校验和及填充位
+func DecodeSLIP39Share(mnemonic string) (*SLIP39Share, error) {
+
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < slip39MinMnemonicLengthWords {
+		return nil, fmt.Errorf("%v: mnemonic must be at least %d words", ErrInvalidSLIP39Mnemonic, slip39MinMnemonicLengthWords)
+	}
+
+	paddingLen := (slip39RadixBits * (len(words) - slip39MetadataLengthWords)) % 16
+	if paddingLen > 8 {
+		return nil, fmt.Errorf("%v: invalid mnemonic length", ErrInvalidSLIP39Mnemonic)
+	}
+
+	indices := make([]int, len(words))
+	for i, w := range words {
+		idx, ok := slip39WordIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("%v: word `%s` is not in wordlist", ErrInvalidSLIP39Mnemonic, w)
+		}
+		indices[i] = idx
+	}
+
+	idExp := indices[0]<<10 | indices[1]
+	s := &SLIP39Share{
+		Identifier:        uint16(idExp >> (slip39IterationExpLengthBits + 1)),
+		Extendable:        (idExp>>slip39IterationExpLengthBits)&1 == 1,
+		IterationExponent: idExp & (1<<slip39IterationExpLengthBits - 1),
+	}
+
+	if !slip39VerifyChecksum(s.customization(), indices) {
+		return nil, fmt.Errorf("%v: invalid checksum", ErrInvalidSLIP39Mnemonic)
+	}
+
+	params := indices[2]<<10 | indices[3]
+	s.GroupIndex = params >> 16
+	s.GroupThreshold = (params>>12)&15 + 1
+	s.GroupCount = (params>>8)&15 + 1
+	s.MemberIndex = (params >> 4) & 15
+	s.MemberThreshold = params&15 + 1
+
+	if s.GroupCount < s.GroupThreshold {
+		return nil, fmt.Errorf("%v: group threshold cannot be greater than group count", ErrInvalidSLIP39Mnemonic)
+	}
+
+	valueIndices := indices[4 : len(indices)-slip39ChecksumLengthWords]
+	valueLen := (slip39RadixBits*len(valueIndices) - paddingLen) / 8
+	value := new(big.Int)
+	for _, idx := range valueIndices {
+		value.Lsh(value, slip39RadixBits)
+		value.Or(value, big.NewInt(int64(idx)))
+	}
+	if value.BitLen() > valueLen*8 {
+		return nil, fmt.Errorf("%v: invalid mnemonic padding", ErrInvalidSLIP39Mnemonic)
+	}
+	s.Value = make([]byte, valueLen)
+	b := value.Bytes()
+	copy(s.Value[valueLen-len(b):], b)
+
+	return s, nil
+}
+
+//SplitSLIP39 按SLIP-39把主密钥拆分为分组的助记词分片，
+//恢复时需要groupThreshold个分组，每个分组需要MemberThreshold个分片
+func SplitSLIP39(masterSecret []byte, passphrase string, groupThreshold int, groups []SLIP39Group, extendable bool, iterationExponent int) ([][]string, error) {
+
+	if len(masterSecret) < MinSeedBytes || len(masterSecret)%2 != 0 {
+		return nil, fmt.Errorf("master secret must be at least %d bytes and an even number of bytes", MinSeedBytes)
+	}
+	if iterationExponent < 0 || iterationExponent >= 1<<slip39IterationExpLengthBits {
+		return nil, fmt.Errorf("iteration exponent must be between 0 and %d", 1<<slip39IterationExpLengthBits-1)
+	}
+	if groupThreshold < 1 || groupThreshold > len(groups) {
+		return nil, fmt.Errorf("group threshold must be between 1 and the number of groups")
+	}
+	if len(groups) > slip39MaxShareCount {
+		return nil, fmt.Errorf("the number of groups must not exceed %d", slip39MaxShareCount)
+	}
+	for _, g := range groups {
+		if g.MemberThreshold < 1 || g.MemberThreshold > g.MemberCount || g.MemberCount > slip39MaxShareCount {
+			return nil, fmt.Errorf("member threshold must be between 1 and the member count, and member count must not exceed %d", slip39MaxShareCount)
+		}
+		if g.MemberThreshold == 1 && g.MemberCount > 1 {
+			return nil, fmt.Errorf("creating multiple member shares with member threshold 1 is not allowed, use 1-of-1 member sharing instead")
+		}
+	}
+	if err := checkSLIP39Passphrase(passphrase); err != nil {
+		return nil, err
+	}
+
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	identifier := binary.BigEndian.Uint16(id[:]) & (1<<slip39IDLengthBits - 1)
+
+	ems := slip39Encrypt(masterSecret, passphrase, iterationExponent, identifier, extendable)
+
+	groupShares, err := slip39SplitSecret(groupThreshold, len(groups), ems)
+	if err != nil {
+		return nil, err
+	}
+
+	mnemonics := make([][]string, len(groups))
+	for gi, g := range groups {
+		memberShares, err := slip39SplitSecret(g.MemberThreshold, g.MemberCount, groupShares[gi].value)
+		if err != nil {
+			return nil, err
+		}
+		for _, ms := range memberShares {
+			share := &SLIP39Share{
+				Identifier:        identifier,
+				Extendable:        extendable,
+				IterationExponent: iterationExponent,
+				GroupIndex:        gi,
+				GroupThreshold:    groupThreshold,
+				GroupCount:        len(groups),
+				MemberIndex:       int(ms.x),
+				MemberThreshold:   g.MemberThreshold,
+				Value:             ms.value,
+			}
+			mnemonics[gi] = append(mnemonics[gi], share.Mnemonic())
+		}
+	}
+
+	return mnemonics, nil
+}
+
+//CombineSLIP39 通过SLIP-39助记词分片恢复主密钥
+func CombineSLIP39(mnemonics []string, passphrase string) ([]byte, error) {
+	if err := checkSLIP39Passphrase(passphrase); err != nil {
+		return nil, err
+	}
+	ems, share, err := recoverSLIP39EncryptedSecret(mnemonics)
+	if err != nil {
+		return nil, err
+	}
+	return slip39Decrypt(ems, passphrase, share.IterationExponent, share.Identifier, share.Extendable), nil
+}
+
+//VerifySLIP39 校验助记词分片有效并且足够恢复主密钥，不需要密码
+func VerifySLIP39(mnemonics []string) error {
+	_, _, err := recoverSLIP39EncryptedSecret(mnemonics)
+	return err
+}
+
+//SLIP39Shares 把HDKey的种子拆分为SLIP-39助记词分片
+func (k *HDKey) SLIP39Shares(passphrase string, groupThreshold int, groups []SLIP39Group) ([][]string, error) {
+	return SplitSLIP39(k.seed, passphrase, groupThreshold, groups, true, SLIP39IterationExponent)
+}
+
+//NewHDKeyWithSLIP39 通过SLIP-39助记词分片恢复HDKey
+func NewHDKeyWithSLIP39(mnemonics []string, passphrase, alias, rootPath string) (*HDKey, error) {
+	seed, err := CombineSLIP39(mnemonics, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewHDKey(seed, alias, rootPath)
+}
+
+//StoreHDKeyWithSLIP39 通过SLIP-39助记词分片恢复HDKey，并加密保存到密钥文件
+func StoreHDKeyWithSLIP39(dir, alias, auth string, mnemonics []string, passphrase string, scryptN, scryptP int) (*HDKey, string, error) {
+	seed, err := CombineSLIP39(mnemonics, passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+	return StoreHDKeyWithSeed(dir, alias, auth, seed, scryptN, scryptP)
+}
+
+//checkSLIP39Passphrase 密码只能是可打印的ASCII字符
+func checkSLIP39Passphrase(passphrase string) error {
+	for i := 0; i < len(passphrase); i++ {
+		if passphrase[i] < 32 || passphrase[i] > 126 {
+			return fmt.Errorf("passphrase must only consist of printable ASCII characters")
+		}
+	}
+	return nil
+}
+
+//recoverSLIP39EncryptedSecret 解析分片并恢复加密的主密钥
+func recoverSLIP39EncryptedSecret(mnemonics []string) ([]byte, *SLIP39Share, error) {
+
+	if len(mnemonics) == 0 {
+		return nil, nil, ErrInsufficientSLIP39Shares
+	}
+
+	var (
+		first  *SLIP39Share
+		groups = make(map[int][]*SLIP39Share)
+	)
+
+	for _, m := range mnemonics {
+		s, err := DecodeSLIP39Share(m)
+		if err != nil {
+			return nil, nil, err
+		}
+		if first == nil {
+			first = s
+		} else if s.Identifier != first.Identifier || s.Extendable != first.Extendable ||
+			s.IterationExponent != first.IterationExponent || s.GroupThreshold != first.GroupThreshold ||
+			s.GroupCount != first.GroupCount || len(s.Value) != len(first.Value) {
+			return nil, nil, fmt.Errorf("%v: all mnemonics must begin with the same words and have the same length", ErrInvalidSLIP39Mnemonic)
+		}
+
+		members := groups[s.GroupIndex]
+		for _, o := range members {
+			if o.MemberThreshold != s.MemberThreshold {
+				return nil, nil, fmt.Errorf("%v: mnemonics in a group must have the same member threshold", ErrInvalidSLIP39Mnemonic)
+			}
+			if o.MemberIndex == s.MemberIndex {
+				if bytes.Equal(o.Value, s.Value) {
+					s = nil
+					break
+				}
+				return nil, nil, fmt.Errorf("%v: member indices in each group must be unique", ErrInvalidSLIP39Mnemonic)
+			}
+		}
+		if s != nil {
+			groups[s.GroupIndex] = append(members, s)
+		}
+	}
+
+	groupShares := make([]slip39RawShare, 0, first.GroupThreshold)
+	for gi := 0; gi < first.GroupCount && len(groupShares) < first.GroupThreshold; gi++ {
+		members := groups[gi]
+		if len(members) == 0 || len(members) < members[0].MemberThreshold {
+			continue
+		}
+		memberShares := make([]slip39RawShare, members[0].MemberThreshold)
+		for i := range memberShares {
+			memberShares[i] = slip39RawShare{x: byte(members[i].MemberIndex), value: members[i].Value}
+		}
+		secret, err := slip39RecoverSecret(members[0].MemberThreshold, memberShares)
+		if err != nil {
+			return nil, nil, err
+		}
+		groupShares = append(groupShares, slip39RawShare{x: byte(gi), value: secret})
+	}
+
+	if len(groupShares) < first.GroupThreshold {
+		return nil, nil, ErrInsufficientSLIP39Shares
+	}
+
+	ems, err := slip39RecoverSecret(first.GroupThreshold, groupShares)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ems, first, nil
+}
+
+//slip39RawShare Shamir秘密共享的分片
+type slip39RawShare struct {
+	x     byte
+	value []byte
+}
+
+//slip39SplitSecret Shamir秘密共享拆分，门限大于1时x=254的分片保存秘密的摘要
+func slip39SplitSecret(threshold, count int, secret []byte) ([]slip39RawShare, error) {
+
+	shares := make([]slip39RawShare, 0, count)
+
+	if threshold == 1 {
+		for i := 0; i < count; i++ {
+			shares = append(shares, slip39RawShare{x: byte(i), value: secret})
+		}
+		return shares, nil
+	}
+
+	randomCount := threshold - 2
+	for i := 0; i < randomCount; i++ {
+		value := make([]byte, len(secret))
+		if _, err := rand.Read(value); err != nil {
+			return nil, err
+		}
+		shares = append(shares, slip39RawShare{x: byte(i), value: value})
+	}
+
+	randomPart := make([]byte, len(secret)-slip39DigestLengthBytes)
+	if _, err := rand.Read(randomPart); err != nil {
+		return nil, err
+	}
+	digest := slip39Digest(randomPart, secret)
+
+	base := append([]slip39RawShare{}, shares...)
+	base = append(base,
+		slip39RawShare{x: slip39DigestIndex, value: append(digest, randomPart...)},
+		slip39RawShare{x: slip39SecretIndex, value: secret},
+	)
+
+	for i := randomCount; i < count; i++ {
+		shares = append(shares, slip39RawShare{x: byte(i), value: slip39Interpolate(base, byte(i))})
+	}
+
+	return shares, nil
+}
+
+//slip39RecoverSecret Shamir秘密共享恢复，并校验秘密的摘要
+func slip39RecoverSecret(threshold int, shares []slip39RawShare) ([]byte, error) {
+
+	if threshold == 1 {
+		return shares[0].value, nil
+	}
+
+	secret := slip39Interpolate(shares, slip39SecretIndex)
+	digestShare := slip39Interpolate(shares, slip39DigestIndex)
+
+	if !hmac.Equal(digestShare[:slip39DigestLengthBytes], slip39Digest(digestShare[slip39DigestLengthBytes:], secret)) {
+		return nil, fmt.Errorf("%v: invalid digest of the shared secret", ErrInvalidSLIP39Mnemonic)
+	}
+
+	return secret, nil
+}
+
+func slip39Digest(randomPart, secret []byte) []byte {
+	mac := hmac.New(sha256.New, randomPart)
+	mac.Write(secret)
+	return mac.Sum(nil)[:slip39DigestLengthBytes]
+}
+
+//slip39Interpolate 在GF(256)上拉格朗日插值计算x处的值
+func slip39Interpolate(shares []slip39RawShare, x byte) []byte {
+
+	for _, s := range shares {
+		if s.x == x {
+			return s.value
+		}
+	}
+
+	logProd := 0
+	for _, s := range shares {
+		logProd += int(gf256Log[s.x^x])
+	}
+
+	result := make([]byte, len(shares[0].value))
+	for _, s := range shares {
+		logBasis := logProd - int(gf256Log[s.x^x])
+		for _, o := range shares {
+			logBasis -= int(gf256Log[s.x^o.x])
+		}
+		logBasis = ((logBasis % 255) + 255) % 255
+
+		for i, v := range s.value {
+			if v != 0 {
+				result[i] ^= gf256Exp[(int(gf256Log[v])+logBasis)%255]
+			}
+		}
+	}
+
+	return result
+}
+
+//slip39Encrypt 4轮Feistel网络加密主密钥
+func slip39Encrypt(masterSecret []byte, passphrase string, e int, identifier uint16, extendable bool) []byte {
+	half := len(masterSecret) / 2
+	l, r := masterSecret[:half], masterSecret[half:]
+	salt := slip39Salt(identifier, extendable)
+	for i := 0; i < slip39RoundCount; i++ {
+		l, r = r, slip39XOR(l, slip39RoundFunction(i, passphrase, e, salt, r))
+	}
+	return append(append([]byte{}, r...), l...)
+}
+
+//slip39Decrypt 4轮Feistel网络解密主密钥
+func slip39Decrypt(ems []byte, passphrase string, e int, identifier uint16, extendable bool) []byte {
+	half := len(ems) / 2
+	l, r := ems[:half], ems[half:]
+	salt := slip39Salt(identifier, extendable)
+	for i := slip39RoundCount - 1; i >= 0; i-- {
+		l, r = r, slip39XOR(l, slip39RoundFunction(i, passphrase, e, salt, r))
+	}
+	return append(append([]byte{}, r...), l...)
+}
+
+func slip39RoundFunction(i int, passphrase string, e int, salt, r []byte) []byte {
+	password := append([]byte{byte(i)}, passphrase...)
+	iterations := (slip39BaseIterationCount << uint(e)) / slip39RoundCount
+	return pbkdf2.Key(password, append(append([]byte{}, salt...), r...), iterations, len(r), sha256.New)
+}
+
+func slip39Salt(identifier uint16, extendable bool) []byte {
+	if extendable {
+		return nil
+	}
+	salt := []byte(slip39Customization)
+	return append(salt, byte(identifier>>8), byte(identifier))
+}
+
+func slip39XOR(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+//slip39Polymod RS1024校验和
+func slip39Polymod(values []int) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 20
+		chk = (chk&0xFFFFF)<<10 ^ uint32(v)
+		for i := 0; i < 10; i++ {
+			if (b>>uint(i))&1 == 1 {
+				chk ^= slip39Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func slip39CreateChecksum(customization string, data []int) []int {
+	values := make([]int, 0, len(customization)+len(data)+slip39ChecksumLengthWords)
+	for i := 0; i < len(customization); i++ {
+		values = append(values, int(customization[i]))
+	}
+	values = append(values, data...)
+	values = append(values, 0, 0, 0)
+	polymod := slip39Polymod(values) ^ 1
+	checksum := make([]int, slip39ChecksumLengthWords)
+	for i := range checksum {
+		checksum[i] = int(polymod>>uint(10*(2-i))) & 1023
+	}
+	return checksum
+}
+
+func slip39VerifyChecksum(customization string, data []int) bool {
+	values := make([]int, 0, len(customization)+len(data))
+	for i := 0; i < len(customization); i++ {
+		values = append(values, int(customization[i]))
+	}
+	values = append(values, data...)
+	return slip39Polymod(values) == 1
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package hdkeystore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//SLIP-39官方测试向量，密码为TREZOR
+var slip39Vectors = []struct {
+	name      string
+	mnemonics []string
+	secret    string
+}{
+	{
+		name: "valid mnemonic without sharing (128 bits)",
+		mnemonics: []string{
+			"duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision keyboard",
+		},
+		secret: "bb54aac4b89dc868ba37d9cc21b2cece",
+	},
+	{
+		name: "mnemonic with invalid checksum (128 bits)",
+		mnemonics: []string{
+			"duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision kidney",
+		},
+	},
+	{
+		name: "basic sharing 2-of-3 (128 bits)",
+		mnemonics: []string{
+			"shadow pistol academic always adequate wildlife fancy gross oasis cylinder mustang wrist rescue view short owner flip making coding armed",
+			"shadow pistol academic acid actress prayer class unknown daughter sweater depict flip twice unkind craft early superior advocate guest smoking",
+		},
+		secret: "b43ceb7e57a0ea8766221624d01b0864",
+	},
+	{
+		name: "basic sharing 2-of-3 with insufficient shares (128 bits)",
+		mnemonics: []string{
+			"shadow pistol academic always adequate wildlife fancy gross oasis cylinder mustang wrist rescue view short owner flip making coding armed",
+		},
+	},
+	{
+		name: "basic sharing with groups 2-of-4 (128 bits)",
+		mnemonics: []string{
+			"eraser senior decision roster beard treat identify grumpy salt index fake aviation theater cubic bike cause research dragon emphasis counter",
+			"eraser senior ceramic snake clay various huge numb argue hesitate auction category timber browser greatest hanger petition script leaf pickup",
+			"eraser senior ceramic shaft dynamic become junior wrist silver peasant force math alto coal amazing segment yelp velvet image paces",
+			"eraser senior ceramic round column hawk trust auction smug shame alive greatest sheriff living perfect corner chest sled fumes adequate",
+			"eraser senior decision smug corner ruin rescue cubic angel tackle skin skunk program roster trash rumor slush angel flea amazing",
+		},
+		secret: "7c3397a292a5941682d7a4ae2d898d11",
+	},
+	{
+		name: "valid mnemonic without sharing (256 bits)",
+		mnemonics: []string{
+			"theory painting academic academic armed sweater year military elder discuss acne wildlife boring employer fused large satoshi bundle carbon diagnose anatomy hamster leaves tracks paces beyond phantom capital marvel lips brave detect luck",
+		},
+		secret: "989baf9dcaad5b10ca33dfd8cc75e42477025dce88ae83e75a230086a0e00e92",
+	},
+	{
+		name: "valid extendable mnemonic without sharing (128 bits)",
+		mnemonics: []string{
+			"testify swimming academic academic column loyalty smear include exotic bedroom exotic wrist lobe cover grief golden smart junior estimate learn",
+		},
+		secret: "1679b4516e0ee5954351d288a838f45e",
+	},
+}
+
+func TestCombineSLIP39_Vectors(t *testing.T) {
+	for _, v := range slip39Vectors {
+		secret, err := CombineSLIP39(v.mnemonics, "TREZOR")
+		if len(v.secret) == 0 {
+			if err == nil {
+				t.Errorf("[%s] should be failed", v.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] CombineSLIP39 failed unexpected error: %v", v.name, err)
+			continue
+		}
+		if hex.EncodeToString(secret) != v.secret {
+			t.Errorf("[%s] secret = %x, want %s", v.name, secret, v.secret)
+		}
+
+		//解析后重新编码应得到相同的助记词
+		for _, m := range v.mnemonics {
+			share, _ := DecodeSLIP39Share(m)
+			if share.Mnemonic() != m {
+				t.Errorf("[%s] encoded mnemonic = %s, want %s", v.name, share.Mnemonic(), m)
+			}
+		}
+	}
+}
+
+func TestSplitSLIP39(t *testing.T) {
+	seed, _ := GenerateSeed(32)
+	groups := []SLIP39Group{{1, 1}, {2, 3}, {3, 5}}
+
+	for _, extendable := range []bool{false, true} {
+		shares, err := SplitSLIP39(seed, "TREZOR", 2, groups, extendable, 0)
+		if err != nil {
+			t.Fatalf("SplitSLIP39 failed unexpected error: %v", err)
+		}
+		if len(shares) != 3 || len(shares[1]) != 3 || len(shares[2]) != 5 {
+			t.Fatalf("wrong number of shares: %v", shares)
+		}
+
+		selected := [][]string{
+			{shares[0][0], shares[1][0], shares[1][2]},
+			{shares[1][1], shares[1][2], shares[2][4], shares[2][0], shares[2][3]},
+		}
+		for _, mnemonics := range selected {
+			if err := VerifySLIP39(mnemonics); err != nil {
+				t.Fatalf("VerifySLIP39 failed unexpected error: %v", err)
+			}
+			secret, err := CombineSLIP39(mnemonics, "TREZOR")
+			if err != nil {
+				t.Fatalf("CombineSLIP39 failed unexpected error: %v", err)
+			}
+			if !bytes.Equal(secret, seed) {
+				t.Errorf("secret = %x, want %x", secret, seed)
+			}
+			if secret, _ := CombineSLIP39(mnemonics, ""); bytes.Equal(secret, seed) {
+				t.Errorf("secret should not be recovered without passphrase")
+			}
+		}
+
+		if err := VerifySLIP39([]string{shares[0][0], shares[2][1], shares[2][2]}); err != ErrInsufficientSLIP39Shares {
+			t.Errorf("shares should be insufficient, unexpected error: %v", err)
+		}
+	}
+
+	if _, err := SplitSLIP39(seed, "", 1, []SLIP39Group{{1, 3}}, true, 0); err == nil {
+		t.Errorf("1-of-3 member sharing should not be allowed")
+	}
+	if _, err := SplitSLIP39(seed, "", 3, groups[:2], true, 0); err == nil {
+		t.Errorf("group threshold should not be greater than groups")
+	}
+	if _, err := SplitSLIP39(seed[:15], "", 1, groups[:1], true, 0); err == nil {
+		t.Errorf("odd length secret should not be allowed")
+	}
+}
+
+func TestStoreHDKeyWithSLIP39(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hdkeystore")
+	if err != nil {
+		t.Fatalf("TempDir failed unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	seed, _ := GenerateSeed(SeedLen)
+	key, _ := NewHDKey(seed, "treasury", OpenwCoinTypePath)
+
+	shares, err := key.SLIP39Shares("", 1, []SLIP39Group{{2, 3}})
+	if err != nil {
+		t.Fatalf("SLIP39Shares failed unexpected error: %v", err)
+	}
+
+	restored, file, err := StoreHDKeyWithSLIP39(dir, "treasury", "12345678", shares[0][1:], "", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("StoreHDKeyWithSLIP39 failed unexpected error: %v", err)
+	}
+	if restored.KeyID != key.KeyID {
+		t.Errorf("restored key = %s, want %s", restored.KeyID, key.KeyID)
+	}
+
+	keyjson, _ := ioutil.ReadFile(file)
+	decrypted, err := DecryptHDKey(keyjson, "12345678")
+	if err != nil {
+		t.Fatalf("DecryptHDKey failed unexpected error: %v", err)
+	}
+	if !bytes.Equal(decrypted.Seed(), seed) {
+		t.Errorf("decrypted seed = %x, want %x", decrypted.Seed(), seed)
+	}
+}
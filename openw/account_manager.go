@@ -16,6 +16,7 @@
 package openw
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
@@ -24,6 +25,11 @@ import (
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
 
+const (
+	//DefaultGapLimit 地址发现默认的间隔限制，与BIP44建议的一致
+	DefaultGapLimit = 20
+)
+
 // CreateAssetsAccount
 func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, account *openwallet.AssetsAccount, otherOwnerKeys []string) (*openwallet.AssetsAccount, *openwallet.Address, error) {
 
@@ -82,13 +88,34 @@ func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, a
 		wallet.AccountIndex = newAccIndex
 	} else {
 
+		//导入BIP32扩展公钥（xpub/ypub/zpub等），转换为OW编码公钥，前缀约定的地址脚本类型记录到账户扩展参数
+		if len(account.PublicKey) > 0 && !strings.HasPrefix(account.PublicKey, "owpub") {
+			pubkey, err := openwallet.ParseExtendedPublicKey(account.PublicKey, symbolInfo.CurveType())
+			if err != nil {
+				return nil, nil, err
+			}
+			if scriptType := openwallet.ExtendedPublicKeyScriptType(account.PublicKey); len(scriptType) > 0 {
+				err = account.SetExtParam(openwallet.ExtParamKeyScriptType, scriptType)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			account.PublicKey = pubkey.OWEncode()
+		}
+
+		//没有衍生路径时，地址的衍生路径相对于导入的账户公钥，例如：m/0/1
+		if len(account.HDPath) == 0 {
+			account.HDPath = "m"
+		}
+
 		if wallet == nil {
 
-			//非托管的，创建资产账户的钱包
+			//非托管的，创建资产账户的观察钱包，没有私钥文件
 			wallet, _, err = wm.CreateWallet(appID, &openwallet.Wallet{
-				Alias:    "imported",
-				WalletID: walletID,
-				IsTrust:  false,
+				Alias:     "imported",
+				WalletID:  walletID,
+				IsTrust:   false,
+				WatchOnly: true,
 			})
 			if err != nil {
 				return nil, nil, err
@@ -100,19 +127,28 @@ func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, a
 
 	account.AddressIndex = -1
 
-	//组合拥有者
-	account.OwnerKeys = []string{
-		account.PublicKey,
+	if len(account.PublicKey) == 0 {
+		return nil, nil, fmt.Errorf("account publicKey is empty")
 	}
 
-	for _, otherKey := range otherOwnerKeys {
-		if len(otherKey) > 0 {
-			account.OwnerKeys = append(account.OwnerKeys, otherKey)
-		}
+	//非托管账户使用导入的公钥计算AccountID
+	account.AccountID = account.GetAccountID()
+
+	//组合拥有者
+	err = account.SetOwnerKeys(otherOwnerKeys...)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if len(account.PublicKey) == 0 {
-		return nil, nil, fmt.Errorf("account publicKey is empty")
+	//多重签名账户，通过拥有者公钥生成多签合约地址
+	if account.IsMultiSig() {
+		if len(account.GetScriptType()) > 0 {
+			return nil, nil, fmt.Errorf("multisig account does not support script type: %s", account.GetScriptType())
+		}
+		err = wm.setupMultiSigAccount(account)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	//保存钱包到本地应用数据库
@@ -160,6 +196,78 @@ func (wm *WalletManager) CreateAssetsAccount(appID, walletID, password string, a
 	return account, addr, nil
 }
 
+//CreateSignerAssetsAccount 使用钱包外部签名器的公钥创建资产账户，用于不支持BIP32衍生的签名器，例如PKCS#11硬件安全模块。
+//账户公钥为签名器在account.HDPath的hex编码公钥，地址的密钥逐个由签名器提供，不支持多重签名
+func (wm *WalletManager) CreateSignerAssetsAccount(appID, walletID string, account *openwallet.AssetsAccount) (*openwallet.AssetsAccount, *openwallet.Address, error) {
+
+	if len(account.Alias) == 0 {
+		return nil, nil, fmt.Errorf("account alias is empty")
+	}
+
+	if len(account.Symbol) == 0 {
+		return nil, nil, fmt.Errorf("account symbol is empty")
+	}
+
+	if len(account.HDPath) == 0 {
+		return nil, nil, fmt.Errorf("account hdPath is empty")
+	}
+
+	signer := wm.WalletSigner(walletID)
+	if signer == nil {
+		return nil, nil, fmt.Errorf("wallet: %s has no external signer", walletID)
+	}
+
+	symbolInfo, err := GetSymbolInfo(account.Symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapper, err := wm.NewWalletWrapper(appID, walletID)
+	if err != nil {
+		return nil, nil, err
+	}
+	wallet := wrapper.GetWallet()
+
+	pub, err := openwallet.SignerPublicKey(signer, account.HDPath, symbolInfo.CurveType())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	account.WalletID = wallet.WalletID
+	account.PublicKey = hex.EncodeToString(pub)
+	account.AccountID = ""
+	account.AccountID = account.GetAccountID()
+	account.Required = 1
+	account.AddressIndex = -1
+	account.SetOwnerKeys()
+
+	//保存账户到本地应用数据库
+	db, err := wm.OpenDB(appID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = db.Save(account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Debug("new signer account create success:", account.AccountID)
+
+	addresses, err := wm.CreateAddress(appID, walletID, account.AccountID, 1)
+	if err != nil {
+		log.Debug("new address create failed, unexpected error:", err)
+	}
+
+	var addr *openwallet.Address
+	if len(addresses) > 0 {
+		addr = addresses[0]
+		account.AddressIndex++
+	}
+
+	return account, addr, nil
+}
+
 // GetAssetsAccountInfo
 func (wm *WalletManager) GetAssetsAccountInfo(appID, walletID, accountID string) (*openwallet.AssetsAccount, error) {
 
@@ -207,7 +315,7 @@ func (wm *WalletManager) GetAssetsAccountInfo(appID, walletID, accountID string)
 //	balanceDel := decimal.New(0, 0)
 //
 //	//打开数据库
-//	db, err := wrapper.OpenStormDB()
+//	db, err := wrapper.OpenDB()
 //	if err != nil {
 //		return err
 //	}
@@ -282,13 +390,18 @@ func (wm *WalletManager) CreateAddress(appID, walletID string, accountID string,
 		return nil, err
 	}
 
-	addrs, err := openwallet.BatchCreateAddressByAccount(account, assetsMgr, int64(count), 20)
+	var addrs []*openwallet.Address
+	if account.IsSignerAccount() {
+		addrs, err = wm.createSignerAddress(account, assetsMgr, count)
+	} else {
+		addrs, err = openwallet.BatchCreateAddressByAccount(account, assetsMgr, int64(count), 20)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -346,6 +459,153 @@ func (wm *WalletManager) CreateAddress(appID, walletID string, accountID string,
 	return addrs, nil
 }
 
+//createSignerAddress 由钱包外部签名器提供公钥，按顺序创建签名器账户的收款地址
+func (wm *WalletManager) createSignerAddress(account *openwallet.AssetsAccount, assetsMgr openwallet.AssetsAdapter, count uint64) ([]*openwallet.Address, error) {
+
+	signer := wm.WalletSigner(account.WalletID)
+	if signer == nil {
+		return nil, fmt.Errorf("wallet: %s has no external signer", account.WalletID)
+	}
+
+	createdAt := time.Now().Unix()
+	addrs := make([]*openwallet.Address, 0, count)
+	for i := 0; i < int(count); i++ {
+		result := openwallet.CreateAddressBySigner(account, assetsMgr, signer, account.AddressIndex+1+i, 0)
+		if !result.Success {
+			return nil, result.Err
+		}
+		result.Address.CreatedTime = createdAt
+		addrs = append(addrs, result.Address)
+	}
+	return addrs, nil
+}
+
+//DiscoverAddresses 按BIP44的间隔限制发现资产账户已使用的地址，从账户公钥衍生收款和找零地址，
+//连续gapLimit个地址没有交易记录时停止，新发现的地址保存到账户并加入区块扫描，不需要私钥；
+//区块扫描器需要支持按地址查询交易记录
+func (wm *WalletManager) DiscoverAddresses(appID, accountID string, gapLimit int) ([]*openwallet.Address, error) {
+
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := wrapper.GetAssetsAccountInfo(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	assetsMgr, err := GetAssetsAdapter(account.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	//签名器账户的地址不能通过账户公钥衍生
+	if account.IsSignerAccount() {
+		return nil, fmt.Errorf("signer account: %s can not discover addresses", accountID)
+	}
+
+	scanner := assetsMgr.GetBlockScanner()
+	if scanner == nil {
+		return nil, fmt.Errorf("[%s] is not support block scan", account.Symbol)
+	}
+
+	//自定义创建地址的适配器没有找零地址
+	chains := []int64{0, 1}
+	if decoder := assetsMgr.GetAddressDecoderV2(); decoder != nil && decoder.SupportCustomCreateAddressFunction() {
+		chains = chains[:1]
+	}
+
+	used := make([]*openwallet.Address, 0)
+	lastIndex := account.AddressIndex
+	for _, change := range chains {
+		for index, gap := 0, 0; gap < gapLimit; index++ {
+			result := openwallet.CreateAddressByAccountWithIndex(account, assetsMgr, index, change)
+			if !result.Success {
+				return nil, result.Err
+			}
+
+			isUsed, err := isAddressUsed(scanner, account.Symbol, result.Address.Address)
+			if err != nil {
+				return nil, err
+			}
+			if !isUsed {
+				gap++
+				continue
+			}
+
+			gap = 0
+			result.Address.CreatedTime = time.Now().Unix()
+			used = append(used, result.Address)
+			if index > lastIndex {
+				lastIndex = index
+			}
+		}
+	}
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	discovered := make([]*openwallet.Address, 0)
+	for _, addr := range used {
+		var existing openwallet.Address
+		if tx.One("Address", addr.Address, &existing) == nil {
+			continue
+		}
+		err = tx.Save(addr)
+		if err != nil {
+			return nil, err
+		}
+		discovered = append(discovered, addr)
+	}
+
+	//后续创建的地址从最后使用的索引之后开始
+	account.AddressIndex = lastIndex
+	err = tx.Save(account)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range discovered {
+		key := wm.encodeSourceKey(appID, address.AccountID)
+		wm.AddAddressForBlockScan(address.Address, key)
+	}
+
+	log.Debugf("account: %s discovered %d used addresses", accountID, len(discovered))
+
+	return discovered, nil
+}
+
+//isAddressUsed 地址是否有交易记录，扫描器不能查询交易记录时返回错误，
+//余额为零的地址也可能已使用，不能代替交易记录判断
+func isAddressUsed(scanner openwallet.BlockScanner, symbol, address string) (bool, error) {
+	txs, err := scanner.GetTransactionsByAddress(0, 1, openwallet.Coin{Symbol: symbol}, address)
+	if err != nil {
+		return false, fmt.Errorf("query address: %s transactions failed, unexpected error: %v", address, err)
+	}
+	return len(txs) > 0, nil
+}
+
 // GetAddressList
 func (wm *WalletManager) GetAddressList(appID, walletID, accountID string, offset, limit int, watchOnly bool) ([]*openwallet.Address, error) {
 
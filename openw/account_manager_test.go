@@ -0,0 +1,206 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const testXpubSymbol = "XPUBT"
+
+//testXpubAdapter 测试用的secp256k1资产适配器，用于导入扩展公钥
+type testXpubAdapter struct {
+	openwallet.AssetsAdapterBase
+	scanner *testUsedAddressScanner
+}
+
+func (a *testXpubAdapter) Symbol() string {
+	return testXpubSymbol
+}
+
+func (a *testXpubAdapter) CurveType() uint32 {
+	return owcrypt.ECC_CURVE_SECP256K1
+}
+
+func (a *testXpubAdapter) GetAddressDecoderV2() openwallet.AddressDecoderV2 {
+	return &testAddressDecoder{}
+}
+
+func (a *testXpubAdapter) GetBlockScanner() openwallet.BlockScanner {
+	return a.scanner
+}
+
+//testUsedAddressScanner 测试用的区块扫描器，used中的地址有交易记录
+type testUsedAddressScanner struct {
+	*openwallet.BlockScannerBase
+	used map[string]bool
+}
+
+func (bs *testUsedAddressScanner) GetTransactionsByAddress(offset, limit int, coin openwallet.Coin, address ...string) ([]*openwallet.TxExtractData, error) {
+	txs := make([]*openwallet.TxExtractData, 0)
+	for _, a := range address {
+		if bs.used[a] {
+			txs = append(txs, &openwallet.TxExtractData{})
+		}
+	}
+	return txs, nil
+}
+
+var testXpubScanner = &testUsedAddressScanner{BlockScannerBase: openwallet.NewBlockScannerBase(), used: make(map[string]bool)}
+
+func init() {
+	RegAssets(testXpubSymbol, &testXpubAdapter{scanner: testXpubScanner})
+}
+
+func TestWalletManager_DiscoverAddresses(t *testing.T) {
+
+	//BIP32测试向量1的m/0'账户公钥
+	xpub := "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+	accountKey, err := openwallet.ParseExtendedPublicKey(xpub, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("ParseExtendedPublicKey unexpected error: %v", err)
+	}
+
+	deriveAddress := func(change, index uint32) string {
+		chain, _ := accountKey.GenPublicChild(change)
+		child, _ := chain.GenPublicChild(index)
+		return hex.EncodeToString(child.GetPublicKeyBytes())
+	}
+
+	//收款地址2、5和找零地址1已使用，收款地址9超出间隔限制
+	testXpubScanner.used[deriveAddress(0, 2)] = true
+	testXpubScanner.used[deriveAddress(0, 5)] = true
+	testXpubScanner.used[deriveAddress(0, 9)] = true
+	testXpubScanner.used[deriveAddress(1, 1)] = true
+
+	tm := testInitMemoryWalletManager()
+
+	account, addr, err := tm.CreateAssetsAccount(testApp, "xpubwallet", "", &openwallet.AssetsAccount{
+		Alias:     "xpub",
+		Symbol:    testXpubSymbol,
+		PublicKey: xpub,
+	}, nil)
+	if err != nil {
+		t.Fatalf("CreateAssetsAccount unexpected error: %v", err)
+	}
+
+	if account.PublicKey != accountKey.OWEncode() {
+		t.Errorf("account public key = %s, want %s", account.PublicKey, accountKey.OWEncode())
+	}
+	if addr == nil || addr.Address != deriveAddress(0, 0) || addr.HDPath != "m/0/0" {
+		t.Fatalf("first address = %+v, want %s", addr, deriveAddress(0, 0))
+	}
+
+	wallet, err := tm.GetWalletInfo(testApp, "xpubwallet")
+	if err != nil || !wallet.WatchOnly {
+		t.Errorf("imported wallet should be watch only, err = %v", err)
+	}
+
+	discovered, err := tm.DiscoverAddresses(testApp, account.AccountID, 3)
+	if err != nil {
+		t.Fatalf("DiscoverAddresses unexpected error: %v", err)
+	}
+	if len(discovered) != 3 || discovered[0].Address != deriveAddress(0, 2) || discovered[1].Address != deriveAddress(0, 5) || discovered[2].Address != deriveAddress(1, 1) {
+		t.Fatalf("discovered addresses = %d, want receive 2, 5 and change 1", len(discovered))
+	}
+	if discovered[1].HDPath != "m/0/5" || !discovered[2].IsChange {
+		t.Errorf("discovered address path = %s, isChange = %v", discovered[1].HDPath, discovered[2].IsChange)
+	}
+	if !tm.IsExistAddressForBlockScan(deriveAddress(0, 5)) {
+		t.Errorf("discovered address should be added for block scan")
+	}
+
+	//新地址从最后使用的索引之后开始
+	addresses, err := tm.CreateAddress(testApp, "xpubwallet", account.AccountID, 1)
+	if err != nil || len(addresses) != 1 || addresses[0].Address != deriveAddress(0, 6) {
+		t.Errorf("next address should be receive index 6, err = %v", err)
+	}
+
+	//重复发现不会保存已有的地址
+	discovered, err = tm.DiscoverAddresses(testApp, account.AccountID, 3)
+	if err != nil || len(discovered) != 0 {
+		t.Errorf("rediscover addresses = %d, err = %v", len(discovered), err)
+	}
+
+	//曲线不支持的扩展公钥
+	_, _, err = tm.CreateAssetsAccount(testApp, "xpubwallet", "", &openwallet.AssetsAccount{
+		Alias:     "xpub",
+		Symbol:    testAssetsSymbol,
+		PublicKey: xpub,
+	}, nil)
+	if err == nil {
+		t.Errorf("xpub of unsupported curve should return error")
+	}
+}
+
+func TestWalletManager_ImportScriptTypeExtendedKey(t *testing.T) {
+
+	//BIP32测试向量1的m/0'账户公钥，按BIP84的zpub前缀重新编码
+	xpub := "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+	zpub := "zpub6mwJaQaUE3oZ763dJZKRbNUxW1znc5f4uqty7hKaAS5RKNscWpZrkohNNhd7BNxD8Hj5NceNPbujdF3935mRkSHHcS6yZLnpsUkrK1XoMLr"
+	accountKey, err := openwallet.ParseExtendedPublicKey(xpub, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("ParseExtendedPublicKey unexpected error: %v", err)
+	}
+
+	tm := testInitMemoryWalletManager()
+
+	account, addr, err := tm.CreateAssetsAccount(testApp, "zpubwallet", "", &openwallet.AssetsAccount{
+		Alias:     "zpub",
+		Symbol:    testXpubSymbol,
+		PublicKey: zpub,
+	}, nil)
+	if err != nil {
+		t.Fatalf("CreateAssetsAccount unexpected error: %v", err)
+	}
+
+	if account.PublicKey != accountKey.OWEncode() || account.GetScriptType() != openwallet.ScriptTypeP2WPKH {
+		t.Errorf("account public key = %s, script type = %s", account.PublicKey, account.GetScriptType())
+	}
+
+	//地址解析器按账户记录的脚本类型生成地址
+	chain, _ := accountKey.GenPublicChild(0)
+	child, _ := chain.GenPublicChild(0)
+	want := string(openwallet.ScriptTypeP2WPKH) + ":" + hex.EncodeToString(child.GetPublicKeyBytes())
+	if addr == nil || addr.Address != want {
+		t.Fatalf("first address = %+v, want %s", addr, want)
+	}
+
+	saved, err := tm.GetAssetsAccountInfo(testApp, "zpubwallet", account.AccountID)
+	if err != nil || saved.GetScriptType() != openwallet.ScriptTypeP2WPKH {
+		t.Errorf("saved account should keep script type, err = %v", err)
+	}
+}
+
+func TestIsAddressUsed(t *testing.T) {
+
+	//扫描器不能查询交易记录时返回错误，不按余额判断
+	if _, err := isAddressUsed(openwallet.NewBlockScannerBase(), testXpubSymbol, "addr"); err == nil {
+		t.Errorf("scanner without transaction history should return error")
+	}
+
+	scanner := &testUsedAddressScanner{BlockScannerBase: openwallet.NewBlockScannerBase(), used: map[string]bool{"used": true}}
+	if used, err := isAddressUsed(scanner, testXpubSymbol, "used"); err != nil || !used {
+		t.Errorf("isAddressUsed(used) = %v, err = %v", used, err)
+	}
+	if used, err := isAddressUsed(scanner, testXpubSymbol, "unused"); err != nil || used {
+		t.Errorf("isAddressUsed(unused) = %v, err = %v", used, err)
+	}
+}
@@ -15,7 +15,10 @@
 
 package openw
 
-import "path/filepath"
+import (
+	"path/filepath"
+	"time"
+)
 
 var (
 	defaultDataDir = filepath.Join(".", "openw_data")
@@ -28,6 +31,13 @@ type Config struct {
 	SupportAssets   []string //支持的资产类型
 	EnableBlockScan bool
 	ConfigDir       string
+	ConfirmDepth    map[string]uint64 //各币种的最终确认数，达到后推送最终确认通知
+	Store           Store             //存储后端，为空使用DBPath下的storm数据库文件，多进程共享可使用NewSQLStore
+	UnspentLockTTL  time.Duration     //交易单锁定未花输出的时长，过期自动释放
+	EnableTxTracker bool              //开启已广播交易的跟踪
+	TxTrackPeriod   time.Duration     //交易跟踪的周期
+	TxDropTimeout   time.Duration     //交易不在内存池也未被打包超过该时长，重新广播
+	MaxRebroadcast  int               //最大重新广播次数
 }
 
 func NewConfig() *Config {
@@ -44,6 +54,14 @@ func NewConfig() *Config {
 	c.SupportAssets = []string{"BTC", "ETH", "QTUM", "NAS", "TRX"}
 	//开启区块扫描
 	c.EnableBlockScan = true
+	//最终确认数
+	c.ConfirmDepth = make(map[string]uint64)
+	//未花输出锁定时长
+	c.UnspentLockTTL = DefaultUnspentLockTTL
+	//交易跟踪
+	c.TxTrackPeriod = DefaultTxTrackPeriod
+	c.TxDropTimeout = DefaultTxDropTimeout
+	c.MaxRebroadcast = DefaultTxMaxRebroadcast
 
 	return &c
 }
@@ -167,7 +167,7 @@ func (wm *WalletManager) SubmitSmartContractTransaction(appID, walletID, account
 	log.Debug("smart contract transaction has been submitted successfully")
 
 	//log.Info("Save new transaction data successfully")
-	//db, err := wrapper.OpenStormDB()
+	//db, err := wrapper.OpenDB()
 	//if err != nil {
 	//	return tx, nil
 	//}
@@ -196,6 +196,21 @@ func (wm *WalletManager) SignSmartContractTransaction(appID, walletID, accountID
 		return nil, openwallet.ConvertError(err)
 	}
 
+	if signer := wm.WalletSigner(account.WalletID); signer != nil {
+		err = checkWalletSigner(signer, password)
+		if err != nil {
+			return nil, openwallet.ConvertError(err)
+		}
+
+		//外部签名器签名，私钥不进入进程内存
+		_, err = openwallet.SignRequiredKeySignaturesWithSigner(signer, rawTx.Signatures, true)
+		if err != nil {
+			return nil, openwallet.ConvertError(err)
+		}
+		log.Debug("transaction has been signed successfully")
+		return rawTx, nil
+	}
+
 	//解锁钱包
 	err = wrapper.UnlockWallet(password, 5*time.Second)
 	if err != nil {
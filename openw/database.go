@@ -16,8 +16,19 @@
 package openw
 
 import (
-	"github.com/asdine/storm"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/common/file"
+	"github.com/blocktree/openwallet/v2/log"
+	bolt "go.etcd.io/bbolt"
 )
 
 type StormDB struct {
@@ -55,3 +66,151 @@ func (db *StormDB) Close() error {
 	return nil
 }
 
+//Find 按成对的字段名和值查询全部相等的记录
+func (db *StormDB) Find(to interface{}, offset, limit int, cols ...interface{}) error {
+	return stormFind(db.DB, to, offset, limit, cols...)
+}
+
+//Begin 开启事务
+func (db *StormDB) Begin(writable bool) (StoreTx, error) {
+	node, err := db.DB.Begin(writable)
+	if err != nil {
+		return nil, err
+	}
+	return &stormTx{Node: node}, nil
+}
+
+//stormTx storm数据库事务
+type stormTx struct {
+	storm.Node
+}
+
+//Find 按成对的字段名和值查询全部相等的记录
+func (tx *stormTx) Find(to interface{}, offset, limit int, cols ...interface{}) error {
+	return stormFind(tx.Node, to, offset, limit, cols...)
+}
+
+//stormFind 把成对的字段名和值转换为storm的查询条件
+func stormFind(node storm.Node, to interface{}, offset, limit int, cols ...interface{}) error {
+
+	query := make([]q.Matcher, 0)
+
+	if len(cols)%2 != 0 {
+		return fmt.Errorf("condition param is not pair")
+	}
+
+	for i := 0; i < len(cols); i = i + 2 {
+		field := common.NewString(cols[i])
+		val := cols[i+1]
+		query = append(query, q.Eq(field.String(), val))
+	}
+
+	if limit > 0 {
+		return node.Select(q.And(
+			query...,
+		)).Limit(limit).Skip(offset).Find(to)
+	}
+
+	return node.Select(q.And(
+		query...,
+	)).Skip(offset).Find(to)
+}
+
+//StormStore 基于storm的存储后端，每个应用一个bolt数据库文件
+type StormStore struct {
+	dir   string
+	mu    sync.RWMutex
+	appDB map[string]*StormDB
+}
+
+//NewStormStore 创建storm存储后端
+//@param dir 数据库文件目录
+func NewStormStore(dir string) *StormStore {
+	file.MkdirAll(dir)
+	return &StormStore{
+		dir:   dir,
+		appDB: make(map[string]*StormDB),
+	}
+}
+
+//Source 应用数据库文件
+func (s *StormStore) Source(appID string) string {
+	return filepath.Join(s.dir, appID+".db")
+}
+
+//OpenAppDB 打开应用数据库文件
+func (s *StormStore) OpenAppDB(appID string) (StoreDB, error) {
+
+	//数据库文件
+	s.mu.RLock()
+	db, ok := s.appDB[appID]
+	s.mu.RUnlock()
+
+	if ok && db.Opened {
+		return db, nil
+	}
+
+	//保证数据库文件并发下不被同时打开
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//解锁进入后，再次确认是否已经存在
+	db, ok = s.appDB[appID]
+	if ok && db.Opened {
+		return db, nil
+	}
+
+	db, err := OpenStormDB(
+		s.Source(appID),
+		storm.Batch(),
+		storm.BoltOptions(0600, &bolt.Options{Timeout: 3 * time.Second}),
+	)
+	log.Debug("open storm db appID:", appID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.appDB[appID] = db
+
+	return db, nil
+}
+
+//CloseAppDB 关闭应用数据库文件
+func (s *StormStore) CloseAppDB(appID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, ok := s.appDB[appID]
+	if ok && db.Opened {
+		return db.Close()
+	}
+
+	return nil
+}
+
+//AppIDs 扫描数据库目录，加载全部应用ID
+func (s *StormStore) AppIDs() ([]string, error) {
+
+	apps := make([]string, 0)
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range files {
+		// Skip any non-key files from the folder
+		if !file.IsUserFile(fi) {
+			continue
+		}
+		if fi.IsDir() {
+			continue
+		}
+
+		appID := strings.TrimSuffix(fi.Name(), ".db")
+		apps = append(apps, appID)
+
+	}
+
+	return apps, nil
+}
@@ -17,19 +17,16 @@ package openw
 
 import (
 	"fmt"
-	"io/ioutil"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/asdine/storm"
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/common/file"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/blocktree/openwallet/v2/timer"
-	bolt "go.etcd.io/bbolt"
 )
 
 var (
@@ -49,13 +46,17 @@ type NotificationObject interface {
 
 //WalletManager OpenWallet钱包管理器
 type WalletManager struct {
-	appDB             map[string]*StormDB
+	store             Store //存储后端
 	cfg               *Config
 	initialized       bool
 	mu                sync.RWMutex
 	observers         map[NotificationObject]bool //观察者
 	importAddressTask *timer.TaskTimer
+	sidLocks          sidLocker         //业务订单号锁
+	txTrackTask       *timer.TaskTimer  //交易跟踪定时任务
 	AddressInScanning map[string]string //加入扫描的地址
+
+	signers map[string]openwallet.Signer //钱包的外部签名器
 }
 
 // NewWalletManager
@@ -78,11 +79,15 @@ func (wm *WalletManager) Init() {
 	log.Info("openwallet Manager is initializing ...")
 
 	//新建文件目录
-	file.MkdirAll(wm.cfg.DBPath)
 	file.MkdirAll(wm.cfg.KeyDir)
 
+	//存储后端，默认使用storm数据库文件
+	wm.store = wm.cfg.Store
+	if wm.store == nil {
+		wm.store = NewStormStore(wm.cfg.DBPath)
+	}
+
 	wm.observers = make(map[NotificationObject]bool)
-	wm.appDB = make(map[string]*StormDB)
 	wm.AddressInScanning = make(map[string]string)
 
 	wm.initialized = true
@@ -91,6 +96,11 @@ func (wm *WalletManager) Init() {
 
 	wm.initSupportAssetsAdapter()
 
+	//启动已广播交易的跟踪
+	if wm.cfg.EnableTxTracker {
+		wm.startTxTracker()
+	}
+
 	//启动定时导入地址到核心钱包
 	//task := timer.NewTask(PeriodOfTask, wm.importNewAddressToCoreWallet)
 	//wm.importAddressTask = task
@@ -124,95 +134,24 @@ func (wm *WalletManager) RemoveObserver(obj NotificationObject) {
 	delete(wm.observers, obj)
 }
 
-//DBFile 应用数据库文件
+//DBFile 应用数据库的数据源，文件存储为数据库文件路径
 func (wm *WalletManager) DBFile(appID string) string {
-	return filepath.Join(wm.cfg.DBPath, appID+".db")
+	return wm.store.Source(appID)
 }
 
-//OpenDB 打开应用数据库文件
-func (wm *WalletManager) OpenDB(appID string) (*StormDB, error) {
-
-	var (
-		db  *StormDB
-		err error
-		ok  bool
-	)
-
-	//数据库文件
-	db, ok = wm.appDB[appID]
-
-	if ok && db.Opened {
-		return db, nil
-	}
-
-	//保证数据库文件并发下不被同时打开
-	wm.mu.Lock()
-	defer wm.mu.Unlock()
-
-	//解锁进入后，再次确认是否已经存在
-	db, ok = wm.appDB[appID]
-	if ok && db.Opened {
-		return db, nil
-
-	}
-
-	db, err = OpenStormDB(
-		wm.DBFile(appID),
-		storm.Batch(),
-		storm.BoltOptions(0600, &bolt.Options{Timeout: 3 * time.Second}),
-	)
-	log.Debug("open storm db appID:", appID)
-	if err != nil {
-		return nil, err
-	}
-
-	//return opendb, nil
-	wm.appDB[appID] = db
-
-	return db, nil
+//OpenDB 打开应用数据库
+func (wm *WalletManager) OpenDB(appID string) (StoreDB, error) {
+	return wm.store.OpenAppDB(appID)
 }
 
-//CloseDB 关闭应用数据库文件
+//CloseDB 关闭应用数据库
 func (wm *WalletManager) CloseDB(appID string) error {
-
-	//数据库文件
-	db, ok := wm.appDB[appID]
-	if ok {
-		db.Close()
-	}
-
-	return nil
+	return wm.store.CloseAppDB(appID)
 }
 
 //loadAllAppIDs 加载全部应用ID
 func (wm *WalletManager) loadAllAppIDs() ([]string, error) {
-
-	var (
-		apps = make([]string, 0)
-		dir  = wm.cfg.DBPath
-	)
-
-	//扫描key目录的所有钱包
-	files, err := ioutil.ReadDir(dir)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, fi := range files {
-		// Skip any non-key files from the folder
-		if !file.IsUserFile(fi) {
-			continue
-		}
-		if fi.IsDir() {
-			continue
-		}
-
-		appID := strings.TrimSuffix(fi.Name(), ".db")
-		apps = append(apps, appID)
-
-	}
-
-	return apps, nil
+	return wm.store.AppIDs()
 }
 
 // initBlockScanner 初始化区块链扫描器
@@ -281,6 +220,13 @@ func (wm *WalletManager) initSupportAssetsAdapter() error {
 		//添加观测者到区块扫描器
 		scanner.AddObserver(wm)
 
+		//设置最终确认数
+		if depth, ok := wm.cfg.ConfirmDepth[symbol]; ok {
+			if confirmation, ok := scanner.(openwallet.BlockScanConfirmation); ok {
+				confirmation.SetConfirmDepth(symbol, depth)
+			}
+		}
+
 		//设置查找地址算法
 		scanner.SetBlockScanAddressFunc(wm.GetSourceKeyByAddressForBlockScan)
 
@@ -319,6 +265,8 @@ func (wm *WalletManager) NewWalletWrapper(appID, walletID string) (*WalletWrappe
 		walletWrapper = NewWalletWrapper(wrapper)
 	}
 
+	walletWrapper.unspentLockTTL = wm.cfg.UnspentLockTTL
+
 	return walletWrapper, nil
 }
 
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//setupMultiSigAccount 检查必要签名数，通过拥有者公钥生成多签合约地址
+func (wm *WalletManager) setupMultiSigAccount(account *openwallet.AssetsAccount) error {
+
+	if account.Required == 0 || account.Required > uint64(len(account.OwnerKeys)) {
+		return fmt.Errorf("required signatures: %d is invalid for %d owners", account.Required, len(account.OwnerKeys))
+	}
+
+	//已指定合约地址，例如账户模型链上已部署的多签合约
+	if len(account.ContractAddress) > 0 {
+		return nil
+	}
+
+	assetsMgr, err := GetAssetsAdapter(account.Symbol)
+	if err != nil {
+		return err
+	}
+
+	var decoder openwallet.AddressDecoder
+	if decoderV2 := assetsMgr.GetAddressDecoderV2(); decoderV2 != nil {
+		decoder = decoderV2
+	} else {
+		decoder = assetsMgr.GetAddressDecode()
+	}
+	if decoder == nil {
+		return fmt.Errorf("[%s] is not support AddressDecoder interface", account.Symbol)
+	}
+
+	pubs, err := account.GetOwnerPublicKeys()
+	if err != nil {
+		return err
+	}
+
+	address, err := decoder.RedeemScriptToAddress(pubs, account.Required, false)
+	if err != nil {
+		return fmt.Errorf("create multisig address failed, unexpected error: %v", err)
+	}
+	account.ContractAddress = address
+
+	return nil
+}
+
+//ownerSignaturePublicKey 按签名地址的衍生路径由拥有者的账户公钥推导签名公钥，
+//地址没有衍生路径时使用账户公钥
+func ownerSignaturePublicKey(account *openwallet.AssetsAccount, ownerKey, hdPath string) (string, error) {
+
+	pubkey, err := owkeychain.OWDecode(ownerKey)
+	if err != nil {
+		return "", err
+	}
+
+	relative := ""
+	if len(hdPath) > 0 && hdPath != account.HDPath {
+		if !strings.HasPrefix(hdPath, account.HDPath+"/") {
+			return "", fmt.Errorf("hdPath: %s is not belong to account: %s", hdPath, account.AccountID)
+		}
+		relative = strings.TrimPrefix(hdPath, account.HDPath+"/")
+	}
+
+	if len(relative) > 0 {
+		for _, path := range strings.Split(relative, "/") {
+			index, err := strconv.ParseUint(path, 10, 31)
+			if err != nil {
+				return "", fmt.Errorf("hdPath: %s can not derive public key", hdPath)
+			}
+			pubkey, err = pubkey.GenPublicChild(uint32(index))
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(pubkey.GetPublicKeyBytes()), nil
+}
+
+//bindOwnerSignatures 检查交易单的签名都属于多签账户的拥有者，并以拥有者账户公钥推导的公钥
+//作为签名信息的地址公钥，交易单已有的签名需要通过验证
+func bindOwnerSignatures(account *openwallet.AssetsAccount, rawTx *openwallet.RawTransaction) error {
+
+	owners := make(map[string]string)
+	for _, owner := range account.GetOwners() {
+		owners[owner.GetAccountID()] = owner.GetPublicKey()
+	}
+
+	for owner, keySignatures := range rawTx.Signatures {
+		ownerKey, ok := owners[owner]
+		if !ok {
+			return fmt.Errorf("signature owner: %s is not belong to account: %s", owner, account.AccountID)
+		}
+
+		for i, keySignature := range keySignatures {
+			if keySignature == nil {
+				return fmt.Errorf("owner: %s signature: %d is empty", owner, i)
+			}
+
+			address := openwallet.Address{}
+			if keySignature.Address != nil {
+				address = *keySignature.Address
+			}
+
+			pub, err := ownerSignaturePublicKey(account, ownerKey, address.HDPath)
+			if err != nil {
+				return fmt.Errorf("owner: %s %v", owner, err)
+			}
+
+			if len(address.PublicKey) > 0 && !strings.EqualFold(strings.TrimPrefix(address.PublicKey, "0x"), pub) {
+				return fmt.Errorf("owner: %s signature public key is not match", owner)
+			}
+			address.PublicKey = pub
+			keySignature.Address = &address
+
+			if len(keySignature.Signature) > 0 {
+				if err = keySignature.VerifySignature(); err != nil {
+					return fmt.Errorf("owner: %s %v", owner, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+//mergeRecordSignatures 合并提现记录中已收集的签名和其他拥有者的签名，并保存合并后的交易单。
+//提现记录中本地构建的交易单存在时，签名公钥和被签消息以提现记录为准
+func (wrapper *WalletWrapper) mergeRecordSignatures(account *openwallet.AssetsAccount, rawTx *openwallet.RawTransaction, signedTxs ...*openwallet.RawTransaction) error {
+
+	//必要签名数以账户为准
+	rawTx.Required = account.Required
+
+	base := rawTx
+	if len(rawTx.Sid) > 0 {
+		withdrawal, err := wrapper.GetWithdrawal(rawTx.Sid)
+		if err == nil && withdrawal.RawTx != nil && withdrawal.RawTx.RawHex == rawTx.RawHex {
+			base = withdrawal.RawTx
+			base.Account = account
+			base.Required = account.Required
+			signedTxs = append([]*openwallet.RawTransaction{rawTx}, signedTxs...)
+		}
+	}
+
+	err := bindOwnerSignatures(account, base)
+	if err != nil {
+		return err
+	}
+
+	err = base.MergeSignatures(signedTxs...)
+	if err != nil {
+		return err
+	}
+
+	rawTx.Signatures = base.Signatures
+	rawTx.UpdateCompleted()
+
+	return wrapper.updateWithdrawal(rawTx.Sid, openwallet.WithdrawalStatusSigned, rawTx, nil, nil)
+}
+
+//MergeTransactionSignatures 合并多个拥有者签名后的交易单，返回的交易单IsCompleted为true时可以广播，
+//导入的交易单只采用签名值，签名公钥和被签消息以本地交易单为准
+//@param rawTx 待合并的交易单
+//@param signedTxs 各拥有者签名后导出的交易单
+func (wm *WalletManager) MergeTransactionSignatures(appID, accountID string, rawTx *openwallet.RawTransaction, signedTxs ...*openwallet.RawTransaction) (*openwallet.RawTransaction, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := wrapper.GetAssetsAccountInfo(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !account.IsMultiSig() {
+		return nil, fmt.Errorf("account: %s is not multisig account", accountID)
+	}
+
+	err = wrapper.mergeRecordSignatures(account, rawTx, signedTxs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rawTx, nil
+}
+
+//GetPartiallySignedTransaction 获取业务订单号已收集签名的交易单，导出给其他拥有者签名
+func (wm *WalletManager) GetPartiallySignedTransaction(appID, sid string) (*openwallet.RawTransaction, error) {
+
+	withdrawal, err := wm.GetWithdrawal(appID, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	if withdrawal.RawTx == nil {
+		return nil, fmt.Errorf("withdrawal: %s has not raw transaction", sid)
+	}
+
+	withdrawal.RawTx.UpdateCompleted()
+
+	return withdrawal.RawTx, nil
+}
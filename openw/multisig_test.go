@@ -0,0 +1,233 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testAddressDecoder 测试用的地址解析器，多签地址由必要签名数和首个公钥组成
+type testAddressDecoder struct {
+	openwallet.AddressDecoderV2Base
+}
+
+func (dec *testAddressDecoder) RedeemScriptToAddress(pubs [][]byte, required uint64, isTestnet bool) (string, error) {
+	return fmt.Sprintf("ms%dof%d_%s", required, len(pubs), hex.EncodeToString(pubs[0][:4])), nil
+}
+
+//AddressEncode 地址为公钥的hex编码，指定地址脚本类型时加上类型前缀
+func (dec *testAddressDecoder) AddressEncode(pub []byte, opts ...interface{}) (string, error) {
+	for _, opt := range opts {
+		if scriptType, ok := opt.(openwallet.AddressScriptType); ok {
+			return string(scriptType) + ":" + hex.EncodeToString(pub), nil
+		}
+	}
+	return hex.EncodeToString(pub), nil
+}
+
+func (a *testAssetsAdapter) GetAddressDecoderV2() openwallet.AddressDecoderV2 {
+	return &testAddressDecoder{}
+}
+
+func testOwnerKey(t *testing.T, seed string) *owkeychain.ExtendedKey {
+	key, err := owkeychain.DerivedPrivateKeyWithPath([]byte(seed), "m/44'/88'/0'", owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("DerivedPrivateKeyWithPath unexpected error: %v", err)
+	}
+	return key
+}
+
+//testKeySignature 拥有者对消息的签名信息，signed为false时只有待签名的消息
+func testKeySignature(t *testing.T, key *owkeychain.ExtendedKey, msg []byte, signed bool) *openwallet.KeySignature {
+	keySignature := &openwallet.KeySignature{
+		EccType: owcrypt.ECC_CURVE_SECP256K1,
+		Address: &openwallet.Address{PublicKey: hex.EncodeToString(key.GetPublicKeyBytes())},
+		Message: hex.EncodeToString(msg),
+	}
+	if signed {
+		prv, _ := key.GetPrivateKeyBytes()
+		signature, _, ret := owcrypt.Signature(prv, nil, msg, owcrypt.ECC_CURVE_SECP256K1)
+		if ret != owcrypt.SUCCESS {
+			t.Fatalf("Signature failed")
+		}
+		keySignature.Signature = hex.EncodeToString(signature)
+	}
+	return keySignature
+}
+
+//testMultiSigSignatures 构建多签交易单时每个拥有者待签名的消息，消息为订单号的哈希
+func testMultiSigSignatures(rawTx *openwallet.RawTransaction) map[string][]*openwallet.KeySignature {
+	msg := owcrypt.Hash([]byte(rawTx.Sid), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+	sigs := make(map[string][]*openwallet.KeySignature)
+	for _, owner := range rawTx.Account.GetOwners() {
+		sigs[owner.GetAccountID()] = []*openwallet.KeySignature{{
+			EccType: owcrypt.ECC_CURVE_SECP256K1,
+			Address: &openwallet.Address{},
+			Message: hex.EncodeToString(msg),
+		}}
+	}
+	return sigs
+}
+
+func TestWalletManager_MultiSigTransaction(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	pubs := make([]string, 0, 3)
+	keys := make(map[string]*owkeychain.ExtendedKey)
+	for _, seed := range []string{"owner_a", "owner_b", "owner_c"} {
+		key := testOwnerKey(t, seed+"_seed_0123456789abcdef")
+		pub := key.GetPublicKey().OWEncode()
+		keys[pub] = key
+		pubs = append(pubs, pub)
+	}
+	pubA, pubB, pubC := pubs[0], pubs[1], pubs[2]
+
+	account, _, err := tm.CreateAssetsAccount(testApp, "msw", "", &openwallet.AssetsAccount{
+		Alias:     "multisig",
+		Symbol:    testAssetsSymbol,
+		PublicKey: pubA,
+		HDPath:    "m/44'/88'/0'",
+		Required:  2,
+	}, []string{pubB, pubC, pubB})
+	if err != nil {
+		t.Errorf("CreateAssetsAccount unexpected error: %v", err)
+		return
+	}
+
+	if !account.IsMultiSig() || len(account.GetOwners()) != 3 || len(account.ContractAddress) == 0 {
+		t.Errorf("multisig account = %+v", account)
+		return
+	}
+
+	if _, _, err = tm.CreateAssetsAccount(testApp, "msw", "", &openwallet.AssetsAccount{
+		Alias:     "invalid",
+		Symbol:    testAssetsSymbol,
+		PublicKey: pubB,
+		Required:  3,
+	}, []string{pubC}); err == nil {
+		t.Errorf("required signatures more than owners should return error")
+	}
+
+	rawTx, err := tm.CreateBatchTransactionWithSid(testApp, "", account.AccountID, "ms_order", "", "", map[string]string{"addr": "1"}, nil, nil)
+	if err != nil {
+		t.Errorf("CreateBatchTransactionWithSid unexpected error: %v", err)
+		return
+	}
+
+	//交易单构建时每个拥有者都有待签名的消息
+	msg := owcrypt.Hash([]byte("ms_order"), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+	newSignatures := func(signer string) map[string][]*openwallet.KeySignature {
+		sigs := make(map[string][]*openwallet.KeySignature)
+		for _, owner := range account.GetOwners() {
+			key := keys[owner.GetPublicKey()]
+			sigs[owner.GetAccountID()] = []*openwallet.KeySignature{testKeySignature(t, key, msg, owner.GetAccountID() == signer)}
+		}
+		return sigs
+	}
+	owners := account.GetOwners()
+	if rawTx.SignatureCount() != 0 || len(rawTx.Signatures) != len(owners) {
+		t.Errorf("multisig transaction signatures = %+v", rawTx.Signatures)
+		return
+	}
+
+	signedA := *rawTx
+	signedA.Signatures = newSignatures(owners[0].GetAccountID())
+	_, err = tm.MergeTransactionSignatures(testApp, account.AccountID, rawTx, &signedA)
+	if err != nil || rawTx.SignatureCount() != 1 || rawTx.IsCompleted {
+		t.Errorf("merge first signature, count = %d, err = %v", rawTx.SignatureCount(), err)
+		return
+	}
+
+	if _, err = tm.SubmitTransaction(testApp, "", account.AccountID, rawTx); err == nil {
+		t.Errorf("incomplete multisig transaction should not be submitted")
+		return
+	}
+
+	//其他拥有者从提现记录导出交易单签名
+	exported, err := tm.GetPartiallySignedTransaction(testApp, "ms_order")
+	if err != nil || exported.SignatureCount() != 1 {
+		t.Errorf("GetPartiallySignedTransaction = %v, %v", exported, err)
+		return
+	}
+
+	forged := *exported
+	forged.Signatures = newSignatures(owners[1].GetAccountID())
+	forged.Signatures[owners[1].GetAccountID()][0].Signature = forged.Signatures[owners[1].GetAccountID()][0].Signature[:126] + "00"
+	if _, err = tm.MergeTransactionSignatures(testApp, account.AccountID, exported, &forged); err == nil {
+		t.Errorf("invalid signature should return error")
+	}
+
+	//其他拥有者的密钥签名
+	stolen := *exported
+	stolen.Signatures = newSignatures("")
+	stolen.Signatures[owners[1].GetAccountID()][0] = testKeySignature(t, keys[owners[0].GetPublicKey()], msg, true)
+	if _, err = tm.MergeTransactionSignatures(testApp, account.AccountID, exported, &stolen); err == nil {
+		t.Errorf("signature of other public key should return error")
+	}
+
+	//导入交易单的公钥和被签消息不被采用，签名需要通过本地交易单的消息验证
+	otherMsg := owcrypt.Hash([]byte("other_order"), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+	replaced := *exported
+	replaced.Signatures = newSignatures("")
+	replaced.Signatures[owners[1].GetAccountID()][0] = testKeySignature(t, keys[owners[1].GetPublicKey()], otherMsg, true)
+	if _, err = tm.MergeTransactionSignatures(testApp, account.AccountID, exported, &replaced); err == nil {
+		t.Errorf("signature of other message should return error")
+	}
+
+	//多签账户的非拥有者公钥和签名
+	outsider := testOwnerKey(t, "outsider_seed_0123456789abcdef")
+	injected := *exported
+	injected.Signatures = newSignatures("")
+	injected.Signatures[owners[1].GetAccountID()][0] = testKeySignature(t, outsider, msg, true)
+	if _, err = tm.MergeTransactionSignatures(testApp, account.AccountID, exported, &injected); err == nil {
+		t.Errorf("signature of outsider public key should return error")
+	}
+
+	//本地交易单没有的签名信息
+	appended := *exported
+	appended.Signatures = newSignatures(owners[1].GetAccountID())
+	appended.Signatures[owners[1].GetAccountID()] = append(appended.Signatures[owners[1].GetAccountID()], testKeySignature(t, keys[owners[1].GetPublicKey()], otherMsg, true))
+	if _, err = tm.MergeTransactionSignatures(testApp, account.AccountID, exported, &appended); err == nil {
+		t.Errorf("signature not in local transaction should return error")
+	}
+
+	signedB := *exported
+	signedB.Signatures = newSignatures(owners[1].GetAccountID())
+	signedB.Signatures["unknown"] = []*openwallet.KeySignature{testKeySignature(t, keys[owners[1].GetPublicKey()], msg, true)}
+	if _, err = tm.MergeTransactionSignatures(testApp, account.AccountID, rawTx, &signedB); err == nil {
+		t.Errorf("signature of unknown owner should return error")
+	}
+	delete(signedB.Signatures, "unknown")
+
+	//签名公钥由拥有者账户公钥推导，导入的签名信息不需要提供公钥
+	signedB.Signatures[owners[1].GetAccountID()][0].Address = nil
+
+	_, err = tm.MergeTransactionSignatures(testApp, account.AccountID, rawTx, &signedB)
+	if err != nil || rawTx.SignatureCount() != 2 || !rawTx.IsCompleted {
+		t.Errorf("merge second signature, count = %d, err = %v", rawTx.SignatureCount(), err)
+		return
+	}
+
+	if _, err = tm.SubmitTransaction(testApp, "", account.AccountID, rawTx); err != nil {
+		t.Errorf("SubmitTransaction unexpected error: %v", err)
+	}
+}
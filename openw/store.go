@@ -0,0 +1,79 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"github.com/asdine/storm"
+)
+
+//ErrRecordNotFound 记录不存在，各存储后端查询不到记录时返回
+var ErrRecordNotFound = storm.ErrNotFound
+
+//Store 钱包管理器的存储后端，每个应用拥有独立的数据库
+type Store interface {
+
+	//OpenAppDB 打开应用数据库，不存在则创建
+	OpenAppDB(appID string) (StoreDB, error)
+
+	//CloseAppDB 关闭应用数据库
+	CloseAppDB(appID string) error
+
+	//AppIDs 已存在的全部应用ID
+	AppIDs() ([]string, error)
+
+	//Source 应用数据库的数据源描述，文件存储为数据库文件路径
+	Source(appID string) string
+}
+
+//StoreNode 数据库的读写操作，对象以结构体storm:"id"标签的字段为主键
+type StoreNode interface {
+
+	//One 查询字段值相等的一条记录
+	One(fieldName string, value interface{}, to interface{}) error
+
+	//Find 按成对的字段名和值查询全部相等的记录，没有记录返回ErrRecordNotFound
+	//@param to 结构体指针切片的指针
+	//@param limit 小于等于0不限制数量
+	Find(to interface{}, offset, limit int, cols ...interface{}) error
+
+	//Save 保存记录，主键相同则替换
+	Save(data interface{}) error
+
+	//DeleteStruct 删除记录
+	DeleteStruct(data interface{}) error
+}
+
+//StoreDB 应用数据库
+type StoreDB interface {
+	StoreNode
+
+	//Begin 开启事务
+	Begin(writable bool) (StoreTx, error)
+
+	//Close 关闭数据库
+	Close() error
+}
+
+//StoreTx 数据库事务
+type StoreTx interface {
+	StoreNode
+
+	//Commit 提交事务
+	Commit() error
+
+	//Rollback 回滚事务，已提交的事务调用无影响
+	Rollback() error
+}
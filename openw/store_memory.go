@@ -0,0 +1,378 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/common"
+)
+
+//MemoryStore 内存存储后端，数据不持久化，用于测试
+type MemoryStore struct {
+	mu    sync.Mutex
+	appDB map[string]*MemoryDB
+}
+
+//NewMemoryStore 创建内存存储后端
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		appDB: make(map[string]*MemoryDB),
+	}
+}
+
+//OpenAppDB 打开应用数据库，不存在则创建
+func (s *MemoryStore) OpenAppDB(appID string) (StoreDB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db, ok := s.appDB[appID]
+	if !ok {
+		db = &MemoryDB{buckets: make(memoryBuckets)}
+		s.appDB[appID] = db
+	}
+	return db, nil
+}
+
+//CloseAppDB 内存数据库无需关闭
+func (s *MemoryStore) CloseAppDB(appID string) error {
+	return nil
+}
+
+//AppIDs 已存在的全部应用ID
+func (s *MemoryStore) AppIDs() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	apps := make([]string, 0, len(s.appDB))
+	for appID := range s.appDB {
+		apps = append(apps, appID)
+	}
+	sort.Strings(apps)
+	return apps, nil
+}
+
+//Source 数据源描述
+func (s *MemoryStore) Source(appID string) string {
+	return "memory://" + appID
+}
+
+//memoryBuckets 结构体类型名: 主键: json数据
+type memoryBuckets map[string]map[string][]byte
+
+//clone 复制数据，json数据不会被修改，只复制索引
+func (b memoryBuckets) clone() memoryBuckets {
+	c := make(memoryBuckets, len(b))
+	for name, bucket := range b {
+		nb := make(map[string][]byte, len(bucket))
+		for id, data := range bucket {
+			nb[id] = data
+		}
+		c[name] = nb
+	}
+	return c
+}
+
+//one 查询字段值相等的一条记录
+func (b memoryBuckets) one(fieldName string, value interface{}, to interface{}) error {
+	ref := reflect.ValueOf(to)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("provided target must be a pointer to struct")
+	}
+
+	for _, id := range b.sortedIDs(ref.Elem().Type().Name()) {
+		obj := reflect.New(ref.Elem().Type())
+		err := json.Unmarshal(b[ref.Elem().Type().Name()][id], obj.Interface())
+		if err != nil {
+			return err
+		}
+		if memoryMatch(obj, fieldName, value) {
+			ref.Elem().Set(obj.Elem())
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+//find 按成对的字段名和值查询全部相等的记录
+func (b memoryBuckets) find(to interface{}, offset, limit int, cols ...interface{}) error {
+
+	if len(cols)%2 != 0 {
+		return fmt.Errorf("condition param is not pair")
+	}
+
+	ref := reflect.ValueOf(to)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("provided target must be a pointer to slice")
+	}
+
+	sliceValue := ref.Elem()
+	elemType := sliceValue.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	results := reflect.MakeSlice(sliceValue.Type(), 0, 0)
+	skipped := 0
+
+	for _, id := range b.sortedIDs(elemType.Name()) {
+		obj := reflect.New(elemType)
+		err := json.Unmarshal(b[elemType.Name()][id], obj.Interface())
+		if err != nil {
+			return err
+		}
+
+		matched := true
+		for i := 0; i < len(cols); i = i + 2 {
+			if !memoryMatch(obj, common.NewString(cols[i]).String(), cols[i+1]) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if limit > 0 && results.Len() >= limit {
+			break
+		}
+
+		if isPtr {
+			results = reflect.Append(results, obj)
+		} else {
+			results = reflect.Append(results, obj.Elem())
+		}
+	}
+
+	if results.Len() == 0 {
+		return ErrRecordNotFound
+	}
+
+	sliceValue.Set(results)
+	return nil
+}
+
+//save 保存记录，主键相同则替换
+func (b memoryBuckets) save(data interface{}) error {
+	name, id, err := memoryKey(data)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := b[name]; !ok {
+		b[name] = make(map[string][]byte)
+	}
+	b[name][id] = raw
+	return nil
+}
+
+//deleteStruct 删除记录
+func (b memoryBuckets) deleteStruct(data interface{}) error {
+	name, id, err := memoryKey(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := b[name][id]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(b[name], id)
+	return nil
+}
+
+//sortedIDs 按主键排序，保证分页结果稳定
+func (b memoryBuckets) sortedIDs(name string) []string {
+	ids := make([]string, 0, len(b[name]))
+	for id := range b[name] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+//memoryKey 获取结构体类型名和storm:"id"标签的主键值
+func memoryKey(data interface{}) (string, string, error) {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Struct {
+		return "", "", fmt.Errorf("provided data must be a struct or a pointer to struct")
+	}
+	id, ok := memoryID(v)
+	if !ok {
+		return "", "", fmt.Errorf("id field not found in %s", v.Type().Name())
+	}
+	return v.Type().Name(), fmt.Sprintf("%v", id.Interface()), nil
+}
+
+//memoryID 查找storm:"id"标签的字段，包括内嵌结构体，没有标签使用ID字段
+func memoryID(v reflect.Value) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if memoryHasTag(f.Tag.Get("storm"), "id") && !f.Anonymous {
+			return v.Field(i), true
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if id, ok := memoryID(v.Field(i)); ok {
+				return id, true
+			}
+		}
+	}
+	if id := v.FieldByName("ID"); id.IsValid() {
+		return id, true
+	}
+	return reflect.Value{}, false
+}
+
+//memoryHasTag storm标签是否包含指定项
+func memoryHasTag(tag, name string) bool {
+	for _, t := range strings.Split(tag, ",") {
+		if strings.TrimSpace(t) == name {
+			return true
+		}
+	}
+	return false
+}
+
+//memoryMatch 对象字段值是否相等
+func memoryMatch(obj reflect.Value, fieldName string, value interface{}) bool {
+	f := reflect.Indirect(obj).FieldByName(fieldName)
+	if !f.IsValid() {
+		return false
+	}
+	want := reflect.ValueOf(value)
+	if !want.IsValid() || !want.Type().ConvertibleTo(f.Type()) {
+		return false
+	}
+	return reflect.DeepEqual(f.Interface(), want.Convert(f.Type()).Interface())
+}
+
+//MemoryDB 内存应用数据库
+type MemoryDB struct {
+	mu      sync.RWMutex //数据读写锁
+	txMu    sync.Mutex   //写事务锁，同时只能有一个写操作
+	buckets memoryBuckets
+}
+
+func (db *MemoryDB) One(fieldName string, value interface{}, to interface{}) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.buckets.one(fieldName, value, to)
+}
+
+func (db *MemoryDB) Find(to interface{}, offset, limit int, cols ...interface{}) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.buckets.find(to, offset, limit, cols...)
+}
+
+func (db *MemoryDB) Save(data interface{}) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.buckets.save(data)
+}
+
+func (db *MemoryDB) DeleteStruct(data interface{}) error {
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.buckets.deleteStruct(data)
+}
+
+//Begin 开启事务，事务内修改在提交前对外不可见
+func (db *MemoryDB) Begin(writable bool) (StoreTx, error) {
+	if writable {
+		db.txMu.Lock()
+	}
+	db.mu.RLock()
+	buckets := db.buckets.clone()
+	db.mu.RUnlock()
+	return &memoryTx{db: db, buckets: buckets, writable: writable}, nil
+}
+
+func (db *MemoryDB) Close() error {
+	return nil
+}
+
+//memoryTx 内存数据库事务
+type memoryTx struct {
+	db       *MemoryDB
+	buckets  memoryBuckets
+	writable bool
+	done     bool
+}
+
+func (tx *memoryTx) One(fieldName string, value interface{}, to interface{}) error {
+	return tx.buckets.one(fieldName, value, to)
+}
+
+func (tx *memoryTx) Find(to interface{}, offset, limit int, cols ...interface{}) error {
+	return tx.buckets.find(to, offset, limit, cols...)
+}
+
+func (tx *memoryTx) Save(data interface{}) error {
+	if !tx.writable {
+		return fmt.Errorf("transaction is not writable")
+	}
+	return tx.buckets.save(data)
+}
+
+func (tx *memoryTx) DeleteStruct(data interface{}) error {
+	if !tx.writable {
+		return fmt.Errorf("transaction is not writable")
+	}
+	return tx.buckets.deleteStruct(data)
+}
+
+func (tx *memoryTx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("transaction has been closed")
+	}
+	tx.done = true
+	if !tx.writable {
+		return nil
+	}
+	tx.db.mu.Lock()
+	tx.db.buckets = tx.buckets
+	tx.db.mu.Unlock()
+	tx.db.txMu.Unlock()
+	return nil
+}
+
+func (tx *memoryTx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	if tx.writable {
+		tx.db.txMu.Unlock()
+	}
+	return nil
+}
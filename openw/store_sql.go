@@ -0,0 +1,273 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/blocktree/openwallet/v2/sqlstore"
+)
+
+//SQLStore 基于关系型数据库的存储后端，多个进程可共享同一数据库。
+//钱包、账户、地址和交易记录保存在sqlstore的数据表，与sqlstore.WalletStore共用。
+//其他对象按结构体类型名分组以json保存在ow_object表，storm:"index"标签的字段建立索引，
+//按主键或索引字段查询时直接在数据库查询，其他字段的条件在加载后过滤。
+type SQLStore struct {
+	db *sqlstore.DB
+}
+
+//NewSQLStore 创建关系型数据库存储后端，db需已执行Migrate
+func NewSQLStore(db *sqlstore.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+//OpenAppDB 打开应用数据库
+func (s *SQLStore) OpenAppDB(appID string) (StoreDB, error) {
+	return &SQLDB{sqlNode: sqlNode{objects: s.db.Objects(appID)}}, nil
+}
+
+//CloseAppDB 数据库连接由调用方管理，无需关闭
+func (s *SQLStore) CloseAppDB(appID string) error {
+	return nil
+}
+
+//AppIDs 已保存数据的全部应用ID
+func (s *SQLStore) AppIDs() ([]string, error) {
+	return s.db.ObjectAppIDs()
+}
+
+//Source 数据源描述
+func (s *SQLStore) Source(appID string) string {
+	return s.db.Driver() + "://" + appID
+}
+
+//sqlNode 关系型数据库的读写操作，数据库和事务共用
+type sqlNode struct {
+	objects *sqlstore.ObjectBucket
+}
+
+//load 按主键或索引字段加载结构体类型的对象，复用内存存储的查询逻辑过滤其他条件
+func (n *sqlNode) load(t reflect.Type, cols ...interface{}) (memoryBuckets, error) {
+
+	name := t.Name()
+	idField, indexFields := sqlFields(t)
+	indexes := make(map[string]string)
+
+	for i := 0; i+1 < len(cols); i = i + 2 {
+		field := fmt.Sprintf("%v", cols[i])
+		value, ok := sqlIndexValue(t, field, cols[i+1])
+		if !ok {
+			//类型不一致不会有相等的记录
+			return memoryBuckets{}, nil
+		}
+		if field == idField {
+			raw, err := n.objects.Get(name, value)
+			if err != nil {
+				return nil, err
+			}
+			b := memoryBuckets{name: make(map[string][]byte)}
+			if raw != nil {
+				b[name][value] = raw
+			}
+			return b, nil
+		}
+		if indexFields[field] {
+			indexes[field] = value
+		}
+	}
+
+	ids, data, err := n.objects.Load(name, indexes)
+	if err != nil {
+		return nil, err
+	}
+	b := memoryBuckets{name: make(map[string][]byte, len(ids))}
+	for i, id := range ids {
+		b[name][id] = data[i]
+	}
+	return b, nil
+}
+
+func (n *sqlNode) One(fieldName string, value interface{}, to interface{}) error {
+	ref := reflect.ValueOf(to)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("provided target must be a pointer to struct")
+	}
+	t := ref.Elem().Type()
+	if n.objects.HasTable(t.Name()) {
+		list := reflect.New(reflect.SliceOf(t))
+		err := n.objects.FindRecords(t.Name(), list.Interface(), 0, 1, fieldName, value)
+		if err != nil {
+			return err
+		}
+		if list.Elem().Len() == 0 {
+			return ErrRecordNotFound
+		}
+		ref.Elem().Set(list.Elem().Index(0))
+		return nil
+	}
+	b, err := n.load(t, fieldName, value)
+	if err != nil {
+		return err
+	}
+	return b.one(fieldName, value, to)
+}
+
+func (n *sqlNode) Find(to interface{}, offset, limit int, cols ...interface{}) error {
+	ref := reflect.ValueOf(to)
+	if ref.Kind() != reflect.Ptr || ref.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("provided target must be a pointer to slice")
+	}
+	if len(cols)%2 != 0 {
+		return fmt.Errorf("condition param is not pair")
+	}
+	elemType := ref.Elem().Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if n.objects.HasTable(elemType.Name()) {
+		list := reflect.New(ref.Elem().Type())
+		err := n.objects.FindRecords(elemType.Name(), list.Interface(), offset, limit, cols...)
+		if err != nil {
+			return err
+		}
+		if list.Elem().Len() == 0 {
+			return ErrRecordNotFound
+		}
+		ref.Elem().Set(list.Elem())
+		return nil
+	}
+	b, err := n.load(elemType, cols...)
+	if err != nil {
+		return err
+	}
+	return b.find(to, offset, limit, cols...)
+}
+
+func (n *sqlNode) Save(data interface{}) error {
+	name, id, err := memoryKey(data)
+	if err != nil {
+		return err
+	}
+	if n.objects.HasTable(name) {
+		return n.objects.SaveRecord(data)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	v := reflect.Indirect(reflect.ValueOf(data))
+	_, indexFields := sqlFields(v.Type())
+	indexes := make(map[string]string, len(indexFields))
+	for field := range indexFields {
+		indexes[field] = fmt.Sprintf("%v", v.FieldByName(field).Interface())
+	}
+	return n.objects.Save(name, id, raw, indexes)
+}
+
+func (n *sqlNode) DeleteStruct(data interface{}) error {
+	name, id, err := memoryKey(data)
+	if err != nil {
+		return err
+	}
+	var exist bool
+	if n.objects.HasTable(name) {
+		exist, err = n.objects.DeleteRecord(name, id)
+	} else {
+		exist, err = n.objects.Delete(name, id)
+	}
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+//sqlFields 结构体storm:"id"标签的主键字段名，以及storm:"index"和storm:"unique"标签的索引字段名，包括内嵌结构体
+func sqlFields(t reflect.Type) (string, map[string]bool) {
+	id := ""
+	indexes := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("storm")
+		switch {
+		case f.Anonymous && f.Type.Kind() == reflect.Struct:
+			embeddedID, embeddedIndexes := sqlFields(f.Type)
+			if len(id) == 0 {
+				id = embeddedID
+			}
+			for field := range embeddedIndexes {
+				indexes[field] = true
+			}
+		case memoryHasTag(tag, "id"):
+			id = f.Name
+		case memoryHasTag(tag, "index"), memoryHasTag(tag, "unique"):
+			indexes[f.Name] = true
+		}
+	}
+	if _, ok := t.FieldByName("ID"); len(id) == 0 && ok {
+		id = "ID"
+	}
+	return id, indexes
+}
+
+//sqlIndexValue 查询值转换为字段类型后的字符串，与保存索引时的格式一致
+func sqlIndexValue(t reflect.Type, fieldName string, value interface{}) (string, bool) {
+	f, ok := t.FieldByName(fieldName)
+	if !ok {
+		return "", false
+	}
+	want := reflect.ValueOf(value)
+	if !want.IsValid() || !want.Type().ConvertibleTo(f.Type) {
+		return "", false
+	}
+	return fmt.Sprintf("%v", want.Convert(f.Type).Interface()), true
+}
+
+//SQLDB 关系型数据库的应用数据库
+type SQLDB struct {
+	sqlNode
+}
+
+//Begin 开启数据库事务
+func (db *SQLDB) Begin(writable bool) (StoreTx, error) {
+	objects, err := db.objects.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{sqlNode: sqlNode{objects: objects}}, nil
+}
+
+//Close 数据库连接由调用方管理，无需关闭
+func (db *SQLDB) Close() error {
+	return nil
+}
+
+//sqlTx 关系型数据库事务
+type sqlTx struct {
+	sqlNode
+}
+
+func (tx *sqlTx) Commit() error {
+	return tx.objects.Commit()
+}
+
+func (tx *sqlTx) Rollback() error {
+	return tx.objects.Rollback()
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/openwallet/v2/sqlstore"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMemoryDB_Begin(t *testing.T) {
+	db, _ := NewMemoryStore().OpenAppDB("app")
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Errorf("Begin unexpected error: %v", err)
+		return
+	}
+	tx.Save(&openwallet.Wallet{WalletID: "w1"})
+	tx.Rollback()
+
+	var w openwallet.Wallet
+	if err = db.One("WalletID", "w1", &w); err != ErrRecordNotFound {
+		t.Errorf("rollback data should not be saved, err: %v", err)
+		return
+	}
+
+	tx, _ = db.Begin(true)
+	tx.Save(&openwallet.Wallet{WalletID: "w1"})
+	tx.Save(&openwallet.Wallet{WalletID: "w2"})
+	tx.Commit()
+
+	var list []*openwallet.Wallet
+	err = db.Find(&list, 1, 10)
+	if err != nil || len(list) != 1 || list[0].WalletID != "w2" {
+		t.Errorf("Find = %v, %v", list, err)
+	}
+}
+
+func TestWalletManager_MemoryStore(t *testing.T) {
+
+	tc := NewConfig()
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.Store = NewMemoryStore()
+	tm := NewWalletManager(tc)
+
+	_, _, err := tm.CreateWallet(testApp, &openwallet.Wallet{WalletID: "w1", Alias: "memory"})
+	if err != nil {
+		t.Errorf("CreateWallet unexpected error: %v", err)
+		return
+	}
+
+	wallets, err := tm.GetWalletList(testApp, 0, -1)
+	if err != nil || len(wallets) != 1 || wallets[0].DBFile != "memory://"+testApp {
+		t.Errorf("GetWalletList = %v, %v", wallets, err)
+		return
+	}
+
+	wrapper, err := tm.NewWalletWrapper(testApp, "w1")
+	if err != nil {
+		t.Errorf("NewWalletWrapper unexpected error: %v", err)
+		return
+	}
+	txWrapper := NewTransactionWrapper(wrapper)
+
+	db, _ := tm.OpenDB(testApp)
+	db.Save(&openwallet.Address{AccountID: "acc1", Address: "addr1"})
+
+	data := openwallet.NewBlockExtractData()
+	output := &openwallet.TxOutPut{}
+	output.Sid = "sid1"
+	output.TxID = "tx1"
+	output.Address = "addr1"
+	output.BlockHeight = 100
+	data.TxOutputs = append(data.TxOutputs, output)
+	data.Transaction = &openwallet.Transaction{TxID: "tx1", WxID: "wx1", BlockHeight: 100}
+
+	err = txWrapper.SaveBlockExtractData("acc1", data)
+	if err != nil {
+		t.Errorf("SaveBlockExtractData unexpected error: %v", err)
+		return
+	}
+
+	outputs, err := wrapper.GetTxOutputs(0, -1, "TxID", "tx1")
+	if err != nil || len(outputs) != 1 {
+		t.Errorf("GetTxOutputs = %v, %v", outputs, err)
+		return
+	}
+
+	err = txWrapper.DeleteBlockDataByHeight(100)
+	if err != nil {
+		t.Errorf("DeleteBlockDataByHeight unexpected error: %v", err)
+		return
+	}
+
+	if _, err = wrapper.GetTransactions(0, -1); err == nil {
+		t.Errorf("transactions should be deleted")
+	}
+}
+
+func TestWalletManager_SQLStore(t *testing.T) {
+
+	db, err := sqlstore.Open(sqlstore.DriverSQLite, ":memory:")
+	if err != nil {
+		t.Errorf("sqlstore.Open unexpected error: %v", err)
+		return
+	}
+	defer db.Close()
+	if err = db.Migrate(); err != nil {
+		t.Errorf("Migrate unexpected error: %v", err)
+		return
+	}
+
+	tc := NewConfig()
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.Store = NewSQLStore(db)
+	tm := NewWalletManager(tc)
+
+	_, _, err = tm.CreateWallet(testApp, &openwallet.Wallet{WalletID: "w1", Alias: "sql"})
+	if err != nil {
+		t.Errorf("CreateWallet unexpected error: %v", err)
+		return
+	}
+
+	wallets, err := tm.GetWalletList(testApp, 0, -1)
+	if err != nil || len(wallets) != 1 || wallets[0].DBFile != "sqlite3://"+testApp {
+		t.Errorf("GetWalletList = %v, %v", wallets, err)
+		return
+	}
+
+	apps, err := tc.Store.AppIDs()
+	if err != nil || len(apps) != 1 || apps[0] != testApp {
+		t.Errorf("AppIDs = %v, %v", apps, err)
+		return
+	}
+
+	appDB, _ := tm.OpenDB(testApp)
+	tx, _ := appDB.Begin(true)
+	tx.Save(&openwallet.Address{AccountID: "acc1", Address: "addr1"})
+	tx.Save(&openwallet.Address{AccountID: "acc1", Address: "addr2"})
+	tx.Rollback()
+
+	var list []*openwallet.Address
+	if err = appDB.Find(&list, 0, -1, "AccountID", "acc1"); err != ErrRecordNotFound {
+		t.Errorf("rollback data should not be saved, err: %v", err)
+		return
+	}
+
+	tx, _ = appDB.Begin(true)
+	tx.Save(&openwallet.Address{AccountID: "acc1", Address: "addr1"})
+	tx.Save(&openwallet.Address{AccountID: "acc1", Address: "addr2"})
+	tx.Commit()
+
+	err = appDB.Find(&list, 1, 10, "AccountID", "acc1")
+	if err != nil || len(list) != 1 || list[0].Address != "addr2" {
+		t.Errorf("Find = %v, %v", list, err)
+		return
+	}
+
+	if err = appDB.DeleteStruct(list[0]); err != nil {
+		t.Errorf("DeleteStruct unexpected error: %v", err)
+		return
+	}
+	if err = appDB.DeleteStruct(list[0]); err != ErrRecordNotFound {
+		t.Errorf("DeleteStruct deleted record err = %v, want ErrRecordNotFound", err)
+	}
+
+	//地址保存在sqlstore的地址表，与WalletStore共用
+	address, err := sqlstore.NewWalletStore(db, testApp, nil, "").GetAddress("addr1")
+	if err != nil || address.AccountID != "acc1" {
+		t.Errorf("WalletStore.GetAddress = %v, %v", address, err)
+		return
+	}
+	var objects int
+	db.QueryRow("SELECT COUNT(*) FROM ow_object WHERE bucket IN ('Wallet', 'Address')").Scan(&objects)
+	if objects != 0 {
+		t.Errorf("wallets and addresses should not be saved in ow_object, count = %d", objects)
+	}
+
+	//其他对象按索引字段查询
+	appDB.Save(&openwallet.Withdrawal{Sid: "s1", AccountID: "acc1", TxID: "tx1"})
+	appDB.Save(&openwallet.Withdrawal{Sid: "s2", AccountID: "acc2", TxID: "tx2"})
+	appDB.Save(&openwallet.Withdrawal{Sid: "s1", AccountID: "acc1", TxID: "tx3"})
+
+	var withdrawal openwallet.Withdrawal
+	if err = appDB.One("TxID", "tx1", &withdrawal); err != ErrRecordNotFound {
+		t.Errorf("replaced index should not be found, err = %v", err)
+	}
+	if err = appDB.One("TxID", "tx3", &withdrawal); err != nil || withdrawal.Sid != "s1" {
+		t.Errorf("One by index = %v, %v", withdrawal, err)
+	}
+	if err = appDB.One("Sid", "s2", &withdrawal); err != nil || withdrawal.AccountID != "acc2" {
+		t.Errorf("One by id = %v, %v", withdrawal, err)
+	}
+	var withdrawals []openwallet.Withdrawal
+	err = appDB.Find(&withdrawals, 0, -1, "AccountID", "acc2", "Status", "")
+	if err != nil || len(withdrawals) != 1 || withdrawals[0].Sid != "s2" {
+		t.Errorf("Find by index = %v, %v", withdrawals, err)
+	}
+
+	appDB.DeleteStruct(&withdrawals[0])
+	var indexes int
+	db.QueryRow("SELECT COUNT(*) FROM ow_object_index WHERE id = 's2'").Scan(&indexes)
+	if indexes != 0 {
+		t.Errorf("deleted object indexes = %d, want 0", indexes)
+	}
+}
@@ -74,11 +74,24 @@ func (wm *WalletManager) BlockExtractDataNotify(sourceKey string, data *openwall
 	}
 
 	txWrapper := NewTransactionWrapper(wrapper)
-	err = txWrapper.SaveBlockExtractData(accountID, data)
+	if data.NotifyType == openwallet.TxExtractNotifyTypeNew {
+		err = txWrapper.SaveBlockExtractData(accountID, data)
+	} else {
+		//确认数增长，只更新已保存记录的确认数
+		err = txWrapper.UpdateBlockExtractDataConfirm(data)
+	}
 	if err != nil {
 		return err
 	}
 
+	//提现交易已被打包
+	if data.NotifyType == openwallet.TxExtractNotifyTypeNew && data.Transaction != nil {
+		err = wrapper.confirmWithdrawal(data.Transaction.TxID)
+		if err != nil {
+			log.Error("confirm withdrawal failed, unexpected error:", err)
+		}
+	}
+
 	//更新账户余额
 	//err = wm.RefreshAssetsAccountBalance(appID, accountID)
 	//if err != nil {
@@ -22,6 +22,7 @@ import (
 
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/pborman/uuid"
 	"github.com/shopspring/decimal"
 )
 
@@ -135,6 +136,13 @@ func (wm *WalletManager) CreateTransaction(appID, walletID, accountID, amount, a
 
 // CreateBatchTransaction
 func (wm *WalletManager) CreateBatchTransaction(appID, walletID, accountID, feeRate, memo string, to map[string]string, contract *openwallet.SmartContract, extParam map[string]interface{},) (*openwallet.RawTransaction, error) {
+	return wm.CreateBatchTransactionWithSid(appID, walletID, accountID, "", feeRate, memo, to, contract, extParam)
+}
+
+//CreateBatchTransactionWithSid 以业务订单号创建交易单，订单号已有提现记录时返回原交易单，不重复构建。
+//订单号的互斥锁只在进程内有效，多个进程共享存储时，同一订单号需路由到同一进程处理。
+//@param sid 业务订单号，为空时自动生成
+func (wm *WalletManager) CreateBatchTransactionWithSid(appID, walletID, accountID, sid, feeRate, memo string, to map[string]string, contract *openwallet.SmartContract, extParam map[string]interface{}) (*openwallet.RawTransaction, error) {
 
 	var (
 		coin openwallet.Coin
@@ -145,6 +153,34 @@ func (wm *WalletManager) CreateBatchTransaction(appID, walletID, accountID, feeR
 		return nil, err
 	}
 
+	if len(sid) > 0 {
+		//同一订单号不能并发构建
+		unlock := wm.sidLocks.lock(appID + "_" + sid)
+		defer unlock()
+
+		withdrawal, findErr := wrapper.GetWithdrawal(sid)
+		if findErr == nil {
+			if withdrawal.AccountID != accountID {
+				return nil, fmt.Errorf("transaction sid: %s has been used by account: %s", sid, withdrawal.AccountID)
+			}
+
+			//广播结果未知，确认原交易不在链上才能重新构建
+			if withdrawal.Status == openwallet.WithdrawalStatusUnknown {
+				err = wm.resolveUnknownWithdrawal(wrapper, withdrawal)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if !withdrawal.CanRebuild() {
+				log.Debug("transaction sid:", sid, "has been created, status:", withdrawal.Status)
+				return withdrawal.RawTx, nil
+			}
+		}
+	} else {
+		sid = uuid.New()
+	}
+
 	account, err := wrapper.GetAssetsAccountInfo(accountID)
 	if err != nil {
 		return nil, err
@@ -172,12 +208,18 @@ func (wm *WalletManager) CreateBatchTransaction(appID, walletID, accountID, feeR
 
 	rawTx := openwallet.RawTransaction{
 		Coin:     coin,
+		Sid:      sid,
 		Account:  account,
 		FeeRate:  feeRate,
 		To:       to,
 		Required: 1,
 	}
 
+	//多签账户需要收集必要签名数
+	if account.IsMultiSig() {
+		rawTx.Required = account.Required
+	}
+
 	if extParam != nil {
 		extString, _ := json.Marshal(extParam)
 		rawTx.ExtParam = string(extString)
@@ -194,11 +236,25 @@ func (wm *WalletManager) CreateBatchTransaction(appID, walletID, accountID, feeR
 
 	err = txdecoder.CreateRawTransaction(wrapper, &rawTx)
 	if err != nil {
+		//释放构建过程中锁定的未花输出
+		wrapper.UnlockUnspent(rawTx.Sid)
 		return nil, err
 	}
 
 	log.Debug("transaction has been created successfully")
 
+	//记录提现，用于订单号的重复检查
+	err = wrapper.SaveWithdrawal(&openwallet.Withdrawal{
+		Sid:       rawTx.Sid,
+		AccountID: accountID,
+		Status:    openwallet.WithdrawalStatusBuilt,
+		RawTx:     &rawTx,
+	})
+	if err != nil {
+		wrapper.UnlockUnspent(rawTx.Sid)
+		return nil, err
+	}
+
 	return &rawTx, nil
 }
 
@@ -215,6 +271,18 @@ func (wm *WalletManager) SignTransaction(appID, walletID, accountID, password st
 		return nil, err
 	}
 
+	//已广播的交易单不再签名，返回记录中的交易单，替换原交易的加速交易单除外
+	replacing := false
+	if len(rawTx.Sid) > 0 {
+		withdrawal, findErr := wrapper.GetWithdrawal(rawTx.Sid)
+		if findErr == nil && withdrawal.IsFinal() {
+			if !isReplacementOf(rawTx, withdrawal.TxID) {
+				return withdrawal.RawTx, nil
+			}
+			replacing = true
+		}
+	}
+
 	assetsMgr, err := GetAssetsAdapter(account.Symbol)
 	if err != nil {
 		return nil, err
@@ -225,19 +293,53 @@ func (wm *WalletManager) SignTransaction(appID, walletID, accountID, password st
 		return nil, fmt.Errorf("[%s] is not support transaction. ", account.Symbol)
 	}
 
-	//解锁钱包
-	err = wrapper.UnlockWallet(password, 5*time.Second)
-	if err != nil {
-		return nil, err
+	if signer := wm.WalletSigner(account.WalletID); signer != nil {
+		err = checkWalletSigner(signer, password)
+		if err != nil {
+			return nil, err
+		}
+
+		//外部签名器按签名信息的消息哈希签名，适配器自定义的交易签署器不参与，
+		//私钥不进入进程内存，多签账户只要求本拥有者的签名完整
+		_, err = openwallet.SignRequiredKeySignaturesWithSigner(signer, rawTx.Signatures, !account.IsMultiSig())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		//解锁钱包
+		err = wrapper.UnlockWallet(password, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+
+		err = txdecoder.SignRawTransaction(wrapper, rawTx)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = txdecoder.SignRawTransaction(wrapper, rawTx)
+	log.Debug("transaction has been signed successfully")
+
+	//多签账户合并其他拥有者已提供的签名
+	if account.IsMultiSig() {
+		err = wrapper.mergeRecordSignatures(account, rawTx)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("transaction has collected %d of %d signatures", rawTx.SignatureCount(), rawTx.RequiredSignatures())
+		return rawTx, nil
+	}
+
+	//原交易仍在链上等待打包，广播替换交易前提现保持已广播状态
+	if replacing {
+		return rawTx, nil
+	}
+
+	err = wrapper.updateWithdrawal(rawTx.Sid, openwallet.WithdrawalStatusSigned, rawTx, nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debug("transaction has been signed successfully")
-
 	return rawTx, nil
 }
 
@@ -297,15 +399,68 @@ func (wm *WalletManager) SubmitTransaction(appID, walletID, accountID string, ra
 		return nil, fmt.Errorf("[%s] is not support transaction. ", account.Symbol)
 	}
 
+	if len(rawTx.Sid) > 0 {
+		//同一订单号不能并发广播
+		unlock := wm.sidLocks.lock(appID + "_" + rawTx.Sid)
+		defer unlock()
+
+		//已广播的订单返回原交易记录，不重复广播，替换原交易的加速交易单除外
+		withdrawal, findErr := wrapper.GetWithdrawal(rawTx.Sid)
+		if findErr == nil {
+			if withdrawal.AccountID != accountID {
+				return nil, fmt.Errorf("transaction sid: %s has been used by account: %s", rawTx.Sid, withdrawal.AccountID)
+			}
+			if withdrawal.IsFinal() && withdrawal.Tx != nil && !isReplacementOf(rawTx, withdrawal.TxID) {
+				log.Debug("transaction sid:", rawTx.Sid, "has been submitted, txid:", withdrawal.TxID)
+				return withdrawal.Tx, nil
+			}
+		}
+	}
+
+	//多签账户收集足够的签名后才能广播
+	if account.IsMultiSig() {
+		err = wrapper.mergeRecordSignatures(account, rawTx)
+		if err != nil {
+			return nil, err
+		}
+		if !rawTx.IsCompleted {
+			return nil, fmt.Errorf("transaction has collected %d of %d signatures, can not submit", rawTx.SignatureCount(), rawTx.RequiredSignatures())
+		}
+	}
+
 	tx, err := txdecoder.SubmitRawTransaction(wrapper, rawTx)
 	if err != nil {
+		if len(rawTx.Sid) > 0 {
+			if openwallet.IsSubmitRejected(err) {
+				//节点明确拒绝，释放交易单锁定的未花输出，允许重新构建
+				wrapper.UnlockUnspent(rawTx.Sid)
+				wrapper.updateWithdrawal(rawTx.Sid, openwallet.WithdrawalStatusFailed, rawTx, nil, err)
+			} else {
+				//超时等错误时节点可能已接收交易，保留锁定，可重新广播同一交易单
+				wrapper.updateWithdrawal(rawTx.Sid, openwallet.WithdrawalStatusUnknown, rawTx, nil, err)
+			}
+		}
 		return nil, err
 	}
 
 	log.Debug("transaction has been submitted successfully")
 
+	//已广播的输入在确认前仍可能被区块浏览器等索引作为未花输出返回，
+	//保留锁定直到交易跟踪确认或锁定过期，加速交易沿用同一Sid继续占用这些输入
+
+	err = wrapper.updateWithdrawal(rawTx.Sid, openwallet.WithdrawalStatusSubmitted, rawTx, tx, nil)
+	if err != nil {
+		log.Error("update withdrawal failed, unexpected error:", err)
+	}
+
+	//跟踪交易的链上状态
+	err = wm.trackSubmittedTransaction(appID, wrapper, rawTx, tx)
+	if err != nil {
+		log.Error("track transaction failed, unexpected error:", err)
+	}
+
 	log.Info("Save new transaction data successfully")
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return tx, nil
 	}
@@ -321,6 +476,52 @@ func (wm *WalletManager) SubmitTransaction(appID, walletID, accountID string, ra
 	//return perfectTx, nil
 }
 
+//resolveUnknownWithdrawal 查询广播结果未知的原交易，已在内存池或区块中则更新为已广播，
+//确认不存在则更新为失败并释放锁定的未花输出，无法查询时返回错误
+func (wm *WalletManager) resolveUnknownWithdrawal(wrapper *WalletWrapper, withdrawal *openwallet.Withdrawal) error {
+
+	unknownErr := fmt.Errorf("transaction sid: %s submit result is unknown, can not rebuild before the original transaction is confirmed absent", withdrawal.Sid)
+
+	rawTx := withdrawal.RawTx
+	if rawTx == nil || len(rawTx.TxID) == 0 {
+		return unknownErr
+	}
+
+	assetsMgr, err := GetAssetsAdapter(rawTx.Coin.Symbol)
+	if err != nil {
+		return err
+	}
+
+	querier, ok := assetsMgr.GetTransactionDecoder().(openwallet.TransactionStatusQuerier)
+	if !ok {
+		return unknownErr
+	}
+
+	status, err := querier.GetTransactionStatus(wrapper, rawTx.TxID)
+	if err != nil {
+		return fmt.Errorf("transaction sid: %s query original transaction failed, unexpected error: %v", withdrawal.Sid, err)
+	}
+
+	if status.InMempool || status.BlockHeight > 0 {
+		withdrawal.Status = openwallet.WithdrawalStatusSubmitted
+		withdrawal.TxID = rawTx.TxID
+		withdrawal.Tx = &openwallet.Transaction{TxID: rawTx.TxID, Coin: rawTx.Coin}
+		withdrawal.Tx.WxID = openwallet.GenTransactionWxID(withdrawal.Tx)
+		return wrapper.SaveWithdrawal(withdrawal)
+	}
+
+	wrapper.UnlockUnspent(withdrawal.Sid)
+	withdrawal.Status = openwallet.WithdrawalStatusFailed
+	return wrapper.SaveWithdrawal(withdrawal)
+}
+
+//isReplacementOf 交易单是否替换指定交易的加速交易单
+func isReplacementOf(rawTx *openwallet.RawTransaction, txid string) bool {
+	ext := rawTx.GetExtParam()
+	return ext.Get(openwallet.ExtParamKeyBumpType).String() == openwallet.FeeBumpTypeReplace &&
+		ext.Get(openwallet.ExtParamKeyBumpTxID).String() == txid
+}
+
 //GetAssetsAccountBalance 获取账户余额
 func (wm *WalletManager) GetAssetsAccountBalance(appID, walletID, accountID string) (*openwallet.Balance, error) {
 
@@ -504,7 +705,7 @@ func (wm *WalletManager) GetTransactionByWxID(appID, wxID string) (*openwallet.T
 	return trx[0], nil
 }
 
-//GetTxUnspent
+//GetTxUnspent 查询未被交易单锁定的未花输出
 func (wm *WalletManager) GetTxUnspent(appID string, offset, limit int, cols ...interface{}) ([]*openwallet.TxOutPut, error) {
 
 	wrapper, err := wm.NewWalletWrapper(appID, "")
@@ -512,8 +713,7 @@ func (wm *WalletManager) GetTxUnspent(appID string, offset, limit int, cols ...i
 		return nil, err
 	}
 
-	txWrapper := NewTransactionWrapper(wrapper)
-	trx, err := txWrapper.GetTxOutputs(offset, limit, cols...)
+	trx, err := wrapper.GetAvailableUnspent(offset, limit, cols...)
 	if err != nil {
 		return nil, err
 	}
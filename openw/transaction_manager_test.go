@@ -16,10 +16,15 @@
 package openw
 
 import (
+	"encoding/hex"
+	"fmt"
 	"github.com/astaxie/beego/config"
 	"path/filepath"
 	"testing"
 
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/crypto"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
@@ -401,3 +406,241 @@ func TestGetAddressBalance(t *testing.T) {
 		log.Infof("ConfirmBalance[%s] = %s", b.Address, b.ConfirmBalance)
 	}
 }
+
+//testPasswordSigner 测试用的外部签名器，签名前验证密码
+type testPasswordSigner struct {
+	*openwallet.SoftwareSigner
+	password string
+}
+
+func (s *testPasswordSigner) VerifyPassword(password string) error {
+	if password != s.password {
+		return fmt.Errorf("wallet password is incorrect")
+	}
+	return nil
+}
+
+//testHashSignerDecoder 实现了自定义交易签署器的交易单解析器
+type testHashSignerDecoder struct {
+	testTransactionDecoder
+}
+
+func (decoder *testHashSignerDecoder) SignTransactionHash(msg []byte, privateKey []byte, eccType uint32) ([]byte, error) {
+	return nil, fmt.Errorf("custom signer")
+}
+
+const testHashSignerSymbol = "OWTESTHASH"
+
+type testHashSignerAdapter struct {
+	testAssetsAdapter
+}
+
+func (a *testHashSignerAdapter) Symbol() string {
+	return testHashSignerSymbol
+}
+
+func (a *testHashSignerAdapter) GetTransactionDecoder() openwallet.TransactionDecoder {
+	return &testHashSignerDecoder{}
+}
+
+const testSignerSymbol = "OWTESTHSM"
+
+//testSignerAdapter 测试签名器账户的secp256k1资产适配器
+type testSignerAdapter struct {
+	testXpubAdapter
+}
+
+func (a *testSignerAdapter) Symbol() string {
+	return testSignerSymbol
+}
+
+func (a *testSignerAdapter) GetTransactionDecoder() openwallet.TransactionDecoder {
+	return &testTransactionDecoder{}
+}
+
+func init() {
+	RegAssets(testHashSignerSymbol, &testHashSignerAdapter{})
+	RegAssets(testSignerSymbol, &testSignerAdapter{})
+}
+
+//testSignerKeySignature 签名器密钥对应的待签名信息
+func testSignerKeySignature(t *testing.T, key *hdkeystore.HDKey, hdPath string) *openwallet.KeySignature {
+	childKey, err := key.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("DerivedKeyWithPath unexpected error: %v", err)
+	}
+	return &openwallet.KeySignature{
+		EccType: owcrypt.ECC_CURVE_SECP256K1,
+		Address: &openwallet.Address{Address: hdPath, HDPath: hdPath, PublicKey: hex.EncodeToString(childKey.GetPublicKeyBytes())},
+		Message: hex.EncodeToString(crypto.SHA256([]byte(hdPath))),
+	}
+}
+
+func TestWalletManager_SignTransactionWithSigner(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	db, _ := tm.OpenDB(testApp)
+	db.Save(&openwallet.Wallet{WalletID: "w1"})
+	db.Save(&openwallet.Wallet{WalletID: "w3"})
+	db.Save(&openwallet.AssetsAccount{AccountID: "acc3", WalletID: "w3", Symbol: testHashSignerSymbol})
+
+	key, _ := hdkeystore.NewHDKey([]byte("wallet_signer_test_seed_0123456789"), "signer", hdkeystore.OpenwCoinTypePath)
+	other, _ := hdkeystore.NewHDKey([]byte("wallet_signer_test_seed_9876543210"), "other", hdkeystore.OpenwCoinTypePath)
+	signer := &testPasswordSigner{SoftwareSigner: openwallet.NewSoftwareSigner(key, nil), password: "12345678"}
+	tm.SetWalletSigner("w1", signer)
+	tm.SetWalletSigner("w3", signer)
+
+	newRawTx := func(keys ...*hdkeystore.HDKey) *openwallet.RawTransaction {
+		keySignatures := make([]*openwallet.KeySignature, 0)
+		for i, k := range keys {
+			keySignatures = append(keySignatures, testSignerKeySignature(t, k, fmt.Sprintf("m/44'/88'/0'/0/%d", i)))
+		}
+		return &openwallet.RawTransaction{Signatures: map[string][]*openwallet.KeySignature{"acc1": keySignatures}}
+	}
+
+	if _, err := tm.SignTransaction(testApp, "", "acc1", "wrong password", newRawTx(key)); err == nil {
+		t.Errorf("wrong password should not sign transaction")
+	}
+
+	//签名器不持有全部密钥时不能返回成功
+	if _, err := tm.SignTransaction(testApp, "", "acc1", "12345678", newRawTx(key, other)); err == nil {
+		t.Errorf("incomplete signatures should return error")
+	}
+	if _, err := tm.SignTransaction(testApp, "", "acc1", "12345678", newRawTx(other)); err == nil {
+		t.Errorf("no signature should return error")
+	}
+
+	rawTx, err := tm.SignTransaction(testApp, "", "acc1", "12345678", newRawTx(key, key))
+	if err != nil {
+		t.Errorf("SignTransaction unexpected error: %v", err)
+		return
+	}
+	for _, keySignature := range rawTx.Signatures["acc1"] {
+		if err := keySignature.VerifySignature(); err != nil {
+			t.Errorf("VerifySignature unexpected error: %v", err)
+		}
+	}
+
+	//适配器有自定义的交易签署器时由外部签名器签名
+	rawTx = newRawTx(key)
+	rawTx.Signatures = map[string][]*openwallet.KeySignature{"acc3": rawTx.Signatures["acc1"]}
+	rawTx, err = tm.SignTransaction(testApp, "", "acc3", "12345678", rawTx)
+	if err != nil {
+		t.Fatalf("SignTransaction with custom transaction signer adapter unexpected error: %v", err)
+	}
+	if err := rawTx.Signatures["acc3"][0].VerifySignature(); err != nil {
+		t.Errorf("VerifySignature unexpected error: %v", err)
+	}
+
+	//签名器不能验证密码时不能签名
+	tm.SetWalletSigner("w1", openwallet.NewSoftwareSigner(key, nil))
+	if _, err := tm.SignTransaction(testApp, "", "acc1", "12345678", newRawTx(key)); err == nil {
+		t.Errorf("signer without password verifier should not sign transaction")
+	}
+}
+
+//testHSMSigner 测试用的硬件安全模块签名器，每个HDPath独立生成密钥，不支持BIP32衍生
+type testHSMSigner struct {
+	keys     map[string][]byte
+	password string
+}
+
+func (s *testHSMSigner) GenerateKey(hdPath string, eccType uint32) ([]byte, error) {
+	if _, exist := s.keys[hdPath]; exist {
+		return nil, fmt.Errorf("key: %s already exists", hdPath)
+	}
+	s.keys[hdPath] = crypto.SHA256([]byte("hsm_signer_test_" + hdPath))
+	return s.PublicKey(hdPath, eccType)
+}
+
+func (s *testHSMSigner) PublicKey(hdPath string, eccType uint32) ([]byte, error) {
+	key, exist := s.keys[hdPath]
+	if !exist {
+		return nil, fmt.Errorf("key: %s not found", hdPath)
+	}
+	pub, ret := owcrypt.GenPubkey(key, eccType)
+	if ret != owcrypt.SUCCESS {
+		return nil, fmt.Errorf("generate public key failed")
+	}
+	return owcrypt.PointCompress(pub, eccType), nil
+}
+
+func (s *testHSMSigner) Sign(hdPath string, eccType uint32, msg []byte) ([]byte, byte, error) {
+	key, exist := s.keys[hdPath]
+	if !exist {
+		return nil, 0, fmt.Errorf("key: %s not found", hdPath)
+	}
+	signature, v, ret := owcrypt.Signature(key, nil, msg, eccType)
+	if ret != owcrypt.SUCCESS {
+		return nil, 0, fmt.Errorf("sign failed")
+	}
+	return signature, v, nil
+}
+
+func (s *testHSMSigner) VerifyPassword(password string) error {
+	if password != s.password {
+		return fmt.Errorf("wallet password is incorrect")
+	}
+	return nil
+}
+
+func TestWalletManager_CreateSignerAssetsAccount(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	db, _ := tm.OpenDB(testApp)
+	db.Save(&openwallet.Wallet{WalletID: "hsm"})
+
+	newAccount := func() *openwallet.AssetsAccount {
+		return &openwallet.AssetsAccount{Alias: "hsm", Symbol: testSignerSymbol, HDPath: "m/44'/88'/0'"}
+	}
+
+	if _, _, err := tm.CreateSignerAssetsAccount(testApp, "hsm", newAccount()); err == nil {
+		t.Errorf("wallet without signer should not create signer account")
+	}
+
+	hsm := &testHSMSigner{keys: make(map[string][]byte), password: "1234"}
+	tm.SetWalletSigner("hsm", hsm)
+
+	account, addr, err := tm.CreateSignerAssetsAccount(testApp, "hsm", newAccount())
+	if err != nil {
+		t.Fatalf("CreateSignerAssetsAccount unexpected error: %v", err)
+	}
+	accountKey, _ := hsm.PublicKey("m/44'/88'/0'", owcrypt.ECC_CURVE_SECP256K1)
+	if !account.IsSignerAccount() || account.PublicKey != hex.EncodeToString(accountKey) || account.AccountID != openwallet.GenAccountIDByHex(account.PublicKey) {
+		t.Errorf("signer account public key = %s, accountID = %s", account.PublicKey, account.AccountID)
+	}
+	if addr == nil || addr.HDPath != "m/44'/88'/0'/0/0" {
+		t.Fatalf("first address = %+v, want path m/44'/88'/0'/0/0", addr)
+	}
+
+	addresses, err := tm.CreateAddress(testApp, "hsm", account.AccountID, 2)
+	if err != nil || len(addresses) != 2 || addresses[1].HDPath != "m/44'/88'/0'/0/2" {
+		t.Fatalf("CreateAddress should create signer addresses 1 and 2, err = %v", err)
+	}
+	for _, a := range append(addresses, addr) {
+		pub, _ := hsm.PublicKey(a.HDPath, owcrypt.ECC_CURVE_SECP256K1)
+		if a.PublicKey != hex.EncodeToString(pub) || a.Address != a.PublicKey {
+			t.Errorf("address: %s public key is not the signer key", a.HDPath)
+		}
+	}
+
+	if _, err := tm.DiscoverAddresses(testApp, account.AccountID, 3); err == nil {
+		t.Errorf("signer account should not discover addresses")
+	}
+
+	//签名器按地址公钥匹配设备中的密钥签名
+	rawTx := &openwallet.RawTransaction{Signatures: map[string][]*openwallet.KeySignature{
+		account.AccountID: {{
+			EccType: owcrypt.ECC_CURVE_SECP256K1,
+			Address: addresses[0],
+			Message: hex.EncodeToString(crypto.SHA256([]byte("hsm"))),
+		}},
+	}}
+	rawTx, err = tm.SignTransaction(testApp, "hsm", account.AccountID, "1234", rawTx)
+	if err != nil {
+		t.Fatalf("SignTransaction unexpected error: %v", err)
+	}
+	if err := rawTx.Signatures[account.AccountID][0].VerifySignature(); err != nil {
+		t.Errorf("VerifySignature unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/blocktree/openwallet/v2/timer"
+	"github.com/pborman/uuid"
+)
+
+//交易跟踪的默认配置
+const (
+	DefaultTxTrackPeriod    = 30 * time.Second
+	DefaultTxDropTimeout    = 10 * time.Minute
+	DefaultTxMaxRebroadcast = 5
+)
+
+//TxStateNotificationObject 已广播交易的状态变化通知，通过AddObserver添加的观察者可选实现
+type TxStateNotificationObject interface {
+
+	//TransactionStateNotify 交易跟踪状态变化通知
+	TransactionStateNotify(appID string, track *openwallet.TransactionTrack) error
+}
+
+//GetTransactionTrack 通过交易单ID获取跟踪记录
+func (wrapper *WalletWrapper) GetTransactionTrack(txid string) (*openwallet.TransactionTrack, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var track openwallet.TransactionTrack
+	err = db.One("TxID", txid, &track)
+	if err != nil {
+		return nil, err
+	}
+
+	return &track, nil
+}
+
+//GetTransactionTrackList 查询跟踪记录
+func (wrapper *WalletWrapper) GetTransactionTrackList(offset, limit int, cols ...interface{}) ([]*openwallet.TransactionTrack, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var list []*openwallet.TransactionTrack
+	err = db.Find(&list, offset, limit, cols...)
+	if err != nil {
+		return nil, fmt.Errorf("can not find transaction tracks")
+	}
+
+	return list, nil
+}
+
+//SaveTransactionTrack 保存跟踪记录
+func (wrapper *WalletWrapper) SaveTransactionTrack(track *openwallet.TransactionTrack) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	track.UpdateAt = time.Now().Unix()
+
+	err = db.Save(track)
+	if err != nil {
+		return fmt.Errorf("wallet save TransactionTrack failed, unexpected error: %v", err)
+	}
+
+	return nil
+}
+
+//trackSubmittedTransaction 记录广播成功的交易，替换交易时结束原交易的跟踪
+func (wm *WalletManager) trackSubmittedTransaction(appID string, wrapper *WalletWrapper, rawTx *openwallet.RawTransaction, tx *openwallet.Transaction) error {
+
+	now := time.Now().Unix()
+	track := &openwallet.TransactionTrack{
+		WxID:     tx.WxID,
+		TxID:     tx.TxID,
+		Sid:      rawTx.Sid,
+		Coin:     rawTx.Coin,
+		State:    openwallet.TxTrackStateMempool,
+		RawTx:    rawTx,
+		SubmitAt: now,
+		SeenAt:   now,
+	}
+	if len(track.WxID) == 0 {
+		track.WxID = openwallet.GenTransactionWxID2(tx.TxID, rawTx.Coin.Symbol, rawTx.Coin.ContractID)
+	}
+	if rawTx.Account != nil {
+		track.AccountID = rawTx.Account.AccountID
+	}
+
+	err := wrapper.SaveTransactionTrack(track)
+	if err != nil {
+		return err
+	}
+	wm.notifyTransactionState(appID, track)
+
+	ext := rawTx.GetExtParam()
+	bumpTxID := ext.Get(openwallet.ExtParamKeyBumpTxID).String()
+	if len(bumpTxID) == 0 || ext.Get(openwallet.ExtParamKeyBumpType).String() != openwallet.FeeBumpTypeReplace {
+		return nil
+	}
+
+	replaced, err := wrapper.GetTransactionTrack(bumpTxID)
+	if err != nil {
+		return nil
+	}
+	replaced.State = openwallet.TxTrackStateReplaced
+	replaced.ReplacedBy = tx.TxID
+	err = wrapper.SaveTransactionTrack(replaced)
+	if err != nil {
+		return err
+	}
+	wm.notifyTransactionState(appID, replaced)
+
+	return nil
+}
+
+//notifyTransactionState 推送交易跟踪状态变化
+func (wm *WalletManager) notifyTransactionState(appID string, track *openwallet.TransactionTrack) {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	for o, _ := range wm.observers {
+		if obj, ok := o.(TxStateNotificationObject); ok {
+			obj.TransactionStateNotify(appID, track)
+		}
+	}
+}
+
+//startTxTracker 启动定时跟踪已广播的交易
+func (wm *WalletManager) startTxTracker() {
+	period := wm.cfg.TxTrackPeriod
+	if period <= 0 {
+		period = DefaultTxTrackPeriod
+	}
+	wm.txTrackTask = timer.NewTask(period, func() {
+		err := wm.TrackTransactions()
+		if err != nil {
+			log.Error("track transactions failed, unexpected error:", err)
+		}
+	})
+	wm.txTrackTask.Start()
+}
+
+//StopTxTracker 停止定时跟踪已广播的交易
+func (wm *WalletManager) StopTxTracker() {
+	if wm.txTrackTask != nil {
+		wm.txTrackTask.Stop()
+	}
+}
+
+//TrackTransactions 跟踪全部应用未结束的交易，更新状态并重新广播已丢失的交易
+func (wm *WalletManager) TrackTransactions() error {
+
+	appIDs, err := wm.loadAllAppIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, appID := range appIDs {
+		wrapper, err := wm.NewWalletWrapper(appID, "")
+		if err != nil {
+			log.Errorf("track transactions of app: %s failed, unexpected error: %v", appID, err)
+			continue
+		}
+
+		for _, track := range wrapper.getTrackingTransactions() {
+			err = wm.trackTransaction(appID, wrapper, track)
+			if err != nil {
+				log.Errorf("track transaction: %s failed, unexpected error: %v", track.TxID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+//getTrackingTransactions 按状态查询需要跟踪的交易，
+//已被替换的交易在替换链上的交易确认前仍需跟踪，原交易可能在替换后被打包
+func (wrapper *WalletWrapper) getTrackingTransactions() []*openwallet.TransactionTrack {
+
+	states := []string{
+		openwallet.TxTrackStateMempool,
+		openwallet.TxTrackStateInBlock,
+		openwallet.TxTrackStateDropped,
+		openwallet.TxTrackStateReplaced,
+	}
+
+	tracks := make([]*openwallet.TransactionTrack, 0)
+	for _, state := range states {
+		list, err := wrapper.GetTransactionTrackList(0, -1, "State", state)
+		if err != nil {
+			continue
+		}
+		tracks = append(tracks, list...)
+	}
+
+	return tracks
+}
+
+//isReplacementConfirmed 替换链上是否已有交易达到确认数
+func (wrapper *WalletWrapper) isReplacementConfirmed(track *openwallet.TransactionTrack) bool {
+	visited := map[string]bool{track.TxID: true}
+	next := track.ReplacedBy
+	for len(next) > 0 && !visited[next] {
+		visited[next] = true
+		replacement, err := wrapper.GetTransactionTrack(next)
+		if err != nil {
+			return false
+		}
+		if replacement.State == openwallet.TxTrackStateConfirmed {
+			return true
+		}
+		next = replacement.ReplacedBy
+	}
+	return false
+}
+
+//trackTransaction 查询单个交易的链上状态
+func (wm *WalletManager) trackTransaction(appID string, wrapper *WalletWrapper, track *openwallet.TransactionTrack) error {
+
+	//被替换的交易只关注是否被打包，替换交易确认后结束跟踪
+	replaced := track.State == openwallet.TxTrackStateReplaced
+	if replaced && wrapper.isReplacementConfirmed(track) {
+		return nil
+	}
+
+	assetsMgr, err := GetAssetsAdapter(track.Coin.Symbol)
+	if err != nil {
+		return err
+	}
+
+	txdecoder := assetsMgr.GetTransactionDecoder()
+	querier, ok := txdecoder.(openwallet.TransactionStatusQuerier)
+	if !ok {
+		return nil
+	}
+
+	status, err := querier.GetTransactionStatus(wrapper, track.TxID)
+	if err != nil {
+		return err
+	}
+
+	if replaced && status.BlockHeight == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	state := track.State
+
+	switch {
+	case status.BlockHeight > 0:
+		track.SeenAt = now.Unix()
+		track.BlockHeight = status.BlockHeight
+		track.BlockHash = status.BlockHash
+		track.Confirm = status.Confirmations
+		state = openwallet.TxTrackStateInBlock
+		if track.Confirm >= wm.confirmDepth(track.Coin.Symbol) {
+			state = openwallet.TxTrackStateConfirmed
+		}
+	case status.InMempool:
+		track.SeenAt = now.Unix()
+		track.BlockHeight = 0
+		track.BlockHash = ""
+		track.Confirm = 0
+		state = openwallet.TxTrackStateMempool
+	default:
+		//不在内存池也未被打包，超时后重新广播
+		dropTimeout := wm.cfg.TxDropTimeout
+		if dropTimeout <= 0 {
+			dropTimeout = DefaultTxDropTimeout
+		}
+		if now.Sub(time.Unix(track.SeenAt, 0)) < dropTimeout {
+			return nil
+		}
+		state = openwallet.TxTrackStateDropped
+		maxRebroadcast := wm.cfg.MaxRebroadcast
+		if maxRebroadcast <= 0 {
+			maxRebroadcast = DefaultTxMaxRebroadcast
+		}
+		if track.RawTx != nil && track.Rebroadcast < maxRebroadcast {
+			track.Rebroadcast++
+			_, err = txdecoder.SubmitRawTransaction(wrapper, track.RawTx)
+			if err != nil {
+				log.Errorf("rebroadcast transaction: %s failed, unexpected error: %v", track.TxID, err)
+			} else {
+				log.Infof("rebroadcast transaction: %s successfully", track.TxID)
+				track.SeenAt = now.Unix()
+				state = openwallet.TxTrackStateMempool
+			}
+		}
+	}
+
+	changed := state != track.State
+	track.State = state
+
+	replacedBy := track.ReplacedBy
+	if replaced {
+		track.ReplacedBy = ""
+	}
+
+	err = wrapper.SaveTransactionTrack(track)
+	if err != nil {
+		return err
+	}
+
+	if replaced {
+		//原交易在替换后被打包，替换链上的交易已失效
+		err = wm.invalidateReplacements(appID, wrapper, track, replacedBy)
+		if err != nil {
+			return err
+		}
+	}
+
+	if state == openwallet.TxTrackStateConfirmed {
+		wrapper.confirmWithdrawal(track.TxID)
+
+		//交易已确认，输入不会再被返回为未花输出，释放交易单锁定的输出
+		if len(track.Sid) > 0 {
+			wrapper.UnlockUnspent(track.Sid)
+		}
+	}
+
+	if changed {
+		wm.notifyTransactionState(appID, track)
+	}
+
+	return nil
+}
+
+//invalidateReplacements 原交易被打包后，标记替换链上的交易被原交易替换，并更新提现记录的交易单ID
+func (wm *WalletManager) invalidateReplacements(appID string, wrapper *WalletWrapper, track *openwallet.TransactionTrack, replacedBy string) error {
+
+	visited := map[string]bool{track.TxID: true}
+	next := replacedBy
+	for len(next) > 0 && !visited[next] {
+		visited[next] = true
+		replacement, err := wrapper.GetTransactionTrack(next)
+		if err != nil {
+			break
+		}
+		next = replacement.ReplacedBy
+		replacement.State = openwallet.TxTrackStateReplaced
+		replacement.ReplacedBy = track.TxID
+		err = wrapper.SaveTransactionTrack(replacement)
+		if err != nil {
+			return err
+		}
+		wm.notifyTransactionState(appID, replacement)
+	}
+
+	if len(track.Sid) == 0 {
+		return nil
+	}
+
+	withdrawal, err := wrapper.GetWithdrawal(track.Sid)
+	if err != nil || withdrawal.Status != openwallet.WithdrawalStatusSubmitted || withdrawal.TxID == track.TxID {
+		return nil
+	}
+	withdrawal.TxID = track.TxID
+	withdrawal.RawTx = track.RawTx
+	withdrawal.Tx = &openwallet.Transaction{TxID: track.TxID, WxID: track.WxID, Coin: track.Coin}
+	return wrapper.SaveWithdrawal(withdrawal)
+}
+
+//confirmDepth 币种的最终确认数，没有配置为1
+func (wm *WalletManager) confirmDepth(symbol string) uint64 {
+	if depth := wm.cfg.ConfirmDepth[symbol]; depth > 0 {
+		return depth
+	}
+	return 1
+}
+
+//BumpTransactionFee 以新的费率构建加速交易单，需要交易单解析器实现openwallet.TransactionFeeBumper，
+//返回的交易单需要重新签名和广播，替换方式沿用原交易的业务订单号
+func (wm *WalletManager) BumpTransactionFee(appID, txid, feeRate string) (*openwallet.RawTransaction, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := wrapper.GetTransactionTrack(txid)
+	if err != nil {
+		return nil, fmt.Errorf("transaction: %s is not tracked", txid)
+	}
+
+	if track.IsFinal() {
+		return nil, fmt.Errorf("transaction: %s has been %s", txid, track.State)
+	}
+
+	if track.RawTx == nil {
+		return nil, fmt.Errorf("transaction: %s has not raw transaction", txid)
+	}
+
+	assetsMgr, err := GetAssetsAdapter(track.Coin.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	bumper, ok := assetsMgr.GetTransactionDecoder().(openwallet.TransactionFeeBumper)
+	if !ok {
+		return nil, fmt.Errorf("[%s] is not support fee bumping", track.Coin.Symbol)
+	}
+
+	rawTx, bumpType, err := bumper.BumpRawTransactionFee(wrapper, track.RawTx, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	if bumpType == openwallet.FeeBumpTypeReplace {
+		rawTx.Sid = track.Sid
+	} else {
+		rawTx.Sid = uuid.New()
+	}
+	rawTx.SetExtParam(openwallet.ExtParamKeyBumpTxID, txid)
+	rawTx.SetExtParam(openwallet.ExtParamKeyBumpType, bumpType)
+
+	return rawTx, nil
+}
+
+//GetTransactionTrack 通过交易单ID获取跟踪记录
+func (wm *WalletManager) GetTransactionTrack(appID, txid string) (*openwallet.TransactionTrack, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper.GetTransactionTrack(txid)
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/crypto"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func (decoder *testTransactionDecoder) GetTransactionStatus(wrapper openwallet.WalletDAI, txid string) (*openwallet.TransactionStatus, error) {
+	decoder.mu.Lock()
+	defer decoder.mu.Unlock()
+	if status, ok := decoder.status[txid]; ok {
+		return status, nil
+	}
+	return &openwallet.TransactionStatus{TxID: txid}, nil
+}
+
+func (decoder *testTransactionDecoder) BumpRawTransactionFee(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, feeRate string) (*openwallet.RawTransaction, string, error) {
+	bump := *rawTx
+	bump.RawHex = rawTx.RawHex + "_bump"
+	bump.FeeRate = feeRate
+	//替换交易的待签名信息与原交易不同，需要重新签名
+	bump.Signatures = make(map[string][]*openwallet.KeySignature)
+	for accountID, keySignatures := range rawTx.Signatures {
+		for _, keySignature := range keySignatures {
+			unsigned := *keySignature
+			unsigned.Message = hex.EncodeToString(crypto.SHA256([]byte(keySignature.Message + feeRate)))
+			unsigned.Signature = ""
+			bump.Signatures[accountID] = append(bump.Signatures[accountID], &unsigned)
+		}
+	}
+	return &bump, openwallet.FeeBumpTypeReplace, nil
+}
+
+type testTxStateObserver struct {
+	states []string
+}
+
+func (o *testTxStateObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	return nil
+}
+
+func (o *testTxStateObserver) BlockTxExtractDataNotify(account *openwallet.AssetsAccount, data *openwallet.TxExtractData) error {
+	return nil
+}
+
+func (o *testTxStateObserver) TransactionStateNotify(appID string, track *openwallet.TransactionTrack) error {
+	o.states = append(o.states, track.TxID+":"+track.State)
+	return nil
+}
+
+func TestWalletManager_TrackTransactions(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	tm.cfg.ConfirmDepth[testAssetsSymbol] = 3
+	tm.cfg.TxDropTimeout = time.Nanosecond
+	observer := &testTxStateObserver{}
+	tm.AddObserver(observer)
+
+	testDecoder.status = make(map[string]*openwallet.TransactionStatus)
+
+	//外部签名器签名，不需要钱包密钥文件
+	key, _ := hdkeystore.NewHDKey([]byte("tracker_signer_test_seed_0123456789"), "signer", hdkeystore.OpenwCoinTypePath)
+	db, _ := tm.OpenDB(testApp)
+	db.Save(&openwallet.Wallet{WalletID: "w1"})
+	tm.SetWalletSigner("w1", &testPasswordSigner{SoftwareSigner: openwallet.NewSoftwareSigner(key, nil), password: "12345678"})
+
+	rawTx, _ := tm.CreateBatchTransactionWithSid(testApp, "", "acc1", "order_track", "", "", map[string]string{"addr": "1"}, nil, nil)
+	rawTx.Signatures = map[string][]*openwallet.KeySignature{"acc1": {testSignerKeySignature(t, key, "m/44'/88'/0'/0/0")}}
+	rawTx, err := tm.SignTransaction(testApp, "", "acc1", "12345678", rawTx)
+	if err != nil {
+		t.Errorf("SignTransaction unexpected error: %v", err)
+		return
+	}
+	signature := rawTx.Signatures["acc1"][0].Signature
+	tx, err := tm.SubmitTransaction(testApp, "", "acc1", rawTx)
+	if err != nil {
+		t.Errorf("SubmitTransaction unexpected error: %v", err)
+		return
+	}
+
+	//交易丢失，重新广播
+	submitted := testDecoder.submitted
+	time.Sleep(time.Millisecond)
+	tm.TrackTransactions()
+	track, _ := tm.GetTransactionTrack(testApp, tx.TxID)
+	if track.Rebroadcast != 1 || testDecoder.submitted != submitted+1 {
+		t.Errorf("dropped transaction should be rebroadcast, rebroadcast = %d", track.Rebroadcast)
+	}
+
+	testDecoder.status[tx.TxID] = &openwallet.TransactionStatus{TxID: tx.TxID, BlockHeight: 100, Confirmations: 1}
+	tm.TrackTransactions()
+
+	//提高手续费替换原交易
+	bump, err := tm.BumpTransactionFee(testApp, tx.TxID, "0.001")
+	if err != nil || bump.Sid != rawTx.Sid {
+		t.Errorf("BumpTransactionFee = %v, %v", bump, err)
+		return
+	}
+
+	//同一订单号的替换交易需要重新签名，不能返回已广播的原交易单
+	bump, err = tm.SignTransaction(testApp, "", "acc1", "12345678", bump)
+	if err != nil {
+		t.Errorf("SignTransaction bump unexpected error: %v", err)
+		return
+	}
+	bumpSignature := bump.Signatures["acc1"][0]
+	if bump.RawHex == rawTx.RawHex || bumpSignature.Signature == signature || bumpSignature.VerifySignature() != nil {
+		t.Errorf("bump should be signed again, signature = %s, original = %s", bumpSignature.Signature, signature)
+		return
+	}
+	if withdrawal, _ := tm.GetWithdrawal(testApp, "order_track"); withdrawal.Status != openwallet.WithdrawalStatusSubmitted {
+		t.Errorf("withdrawal status after signing bump = %s, want submitted", withdrawal.Status)
+	}
+
+	bumpTx, err := tm.SubmitTransaction(testApp, "", "acc1", bump)
+	if err != nil || bumpTx.TxID == tx.TxID {
+		t.Errorf("replacement should be submitted, err: %v", err)
+		return
+	}
+
+	withdrawal, _ := tm.GetWithdrawal(testApp, "order_track")
+	if withdrawal.TxID != bumpTx.TxID {
+		t.Errorf("withdrawal txid = %s, want %s", withdrawal.TxID, bumpTx.TxID)
+	}
+
+	testDecoder.status[bumpTx.TxID] = &openwallet.TransactionStatus{TxID: bumpTx.TxID, BlockHeight: 101, Confirmations: 3}
+	tm.TrackTransactions()
+
+	want := []string{
+		tx.TxID + ":" + openwallet.TxTrackStateMempool,
+		tx.TxID + ":" + openwallet.TxTrackStateInBlock,
+		bumpTx.TxID + ":" + openwallet.TxTrackStateMempool,
+		tx.TxID + ":" + openwallet.TxTrackStateReplaced,
+		bumpTx.TxID + ":" + openwallet.TxTrackStateConfirmed,
+	}
+	if len(observer.states) != len(want) {
+		t.Errorf("states = %v, want %v", observer.states, want)
+		return
+	}
+	for i := range want {
+		if observer.states[i] != want[i] {
+			t.Errorf("states = %v, want %v", observer.states, want)
+			return
+		}
+	}
+
+	withdrawal, _ = tm.GetWithdrawal(testApp, "order_track")
+	if withdrawal.Status != openwallet.WithdrawalStatusConfirmed {
+		t.Errorf("withdrawal status = %s, want confirmed", withdrawal.Status)
+	}
+}
+
+func TestWalletManager_TrackReplacedTransaction(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	tm.cfg.ConfirmDepth[testAssetsSymbol] = 3
+	observer := &testTxStateObserver{}
+	tm.AddObserver(observer)
+
+	testDecoder.status = make(map[string]*openwallet.TransactionStatus)
+
+	rawTx, _ := tm.CreateBatchTransactionWithSid(testApp, "", "acc1", "order_replaced", "", "", map[string]string{"addr": "1"}, nil, nil)
+	tx, err := tm.SubmitTransaction(testApp, "", "acc1", rawTx)
+	if err != nil {
+		t.Errorf("SubmitTransaction unexpected error: %v", err)
+		return
+	}
+
+	bump, err := tm.BumpTransactionFee(testApp, tx.TxID, "0.001")
+	if err != nil {
+		t.Errorf("BumpTransactionFee unexpected error: %v", err)
+		return
+	}
+	bumpTx, err := tm.SubmitTransaction(testApp, "", "acc1", bump)
+	if err != nil {
+		t.Errorf("SubmitTransaction unexpected error: %v", err)
+		return
+	}
+
+	//原交易在替换后被打包
+	testDecoder.status[tx.TxID] = &openwallet.TransactionStatus{TxID: tx.TxID, BlockHeight: 100, Confirmations: 3}
+	testDecoder.status[bumpTx.TxID] = &openwallet.TransactionStatus{TxID: bumpTx.TxID, InMempool: true}
+	tm.TrackTransactions()
+
+	track, _ := tm.GetTransactionTrack(testApp, tx.TxID)
+	if track.State != openwallet.TxTrackStateConfirmed || len(track.ReplacedBy) > 0 {
+		t.Errorf("original transaction state = %s, want confirmed", track.State)
+	}
+
+	bumpTrack, _ := tm.GetTransactionTrack(testApp, bumpTx.TxID)
+	if bumpTrack.State != openwallet.TxTrackStateReplaced || bumpTrack.ReplacedBy != tx.TxID {
+		t.Errorf("replacement state = %s, replacedBy = %s", bumpTrack.State, bumpTrack.ReplacedBy)
+	}
+
+	withdrawal, _ := tm.GetWithdrawal(testApp, "order_replaced")
+	if withdrawal.Status != openwallet.WithdrawalStatusConfirmed || withdrawal.TxID != tx.TxID {
+		t.Errorf("withdrawal status = %s, txid = %s", withdrawal.Status, withdrawal.TxID)
+	}
+
+	//已结束的跟踪不再通知
+	notified := len(observer.states)
+	tm.TrackTransactions()
+	if len(observer.states) != notified {
+		t.Errorf("finished tracks should not be notified again, states = %v", observer.states)
+	}
+}
@@ -17,8 +17,6 @@ package openw
 
 import (
 	"fmt"
-	"github.com/asdine/storm/q"
-	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/shopspring/decimal"
 )
@@ -48,7 +46,7 @@ func NewTransactionWrapper(args ...interface{}) *TransactionWrapper {
 func (wrapper *WalletWrapper) GetTxInputs(offset, limit int, cols ...interface{}) ([]*openwallet.TxInput, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -56,31 +54,7 @@ func (wrapper *WalletWrapper) GetTxInputs(offset, limit int, cols ...interface{}
 
 	var txs []*openwallet.TxInput
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
-	}
-
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&txs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&txs)
-
-	}
+	err = db.Find(&txs, offset, limit, cols...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find txInputs")
@@ -93,7 +67,7 @@ func (wrapper *WalletWrapper) GetTxInputs(offset, limit int, cols ...interface{}
 func (wrapper *WalletWrapper) GetTxOutputs(offset, limit int, cols ...interface{}) ([]*openwallet.TxOutPut, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -101,31 +75,7 @@ func (wrapper *WalletWrapper) GetTxOutputs(offset, limit int, cols ...interface{
 
 	var txs []*openwallet.TxOutPut
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
-	}
-
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&txs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&txs)
-
-	}
+	err = db.Find(&txs, offset, limit, cols...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find txoutputs")
@@ -138,7 +88,7 @@ func (wrapper *WalletWrapper) GetTxOutputs(offset, limit int, cols ...interface{
 func (wrapper *WalletWrapper) GetTransactions(offset, limit int, cols ...interface{}) ([]*openwallet.Transaction, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -146,31 +96,7 @@ func (wrapper *WalletWrapper) GetTransactions(offset, limit int, cols ...interfa
 
 	var txs []*openwallet.Transaction
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
-	}
-
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&txs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&txs)
-
-	}
+	err = db.Find(&txs, offset, limit, cols...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find transactions")
@@ -188,7 +114,7 @@ func (wrapper *TransactionWrapper) SaveBlockExtractData(accountID string, data *
 	)
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return err
 	}
@@ -258,11 +184,81 @@ func (wrapper *TransactionWrapper) SaveBlockExtractData(accountID string, data *
 	return nil
 }
 
+//UpdateBlockExtractDataConfirm 更新已保存的区块提取数据的确认数
+func (wrapper *TransactionWrapper) UpdateBlockExtractDataConfirm(data *openwallet.TxExtractData) error {
+
+	if data.Transaction == nil {
+		return fmt.Errorf("the transaction of extract data is nil")
+	}
+
+	confirm := data.Transaction.Confirm
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	//更新出账记录
+	for _, input := range data.TxInputs {
+		var obj openwallet.TxInput
+		err = tx.One("Sid", input.Sid, &obj)
+		if err != nil {
+			continue
+		}
+		obj.Confirm = confirm
+		err = tx.Save(&obj)
+		if err != nil {
+			return fmt.Errorf("wallet update TxInputs failed, unexpected error: %v", err)
+		}
+	}
+
+	//更新入账记录
+	for _, output := range data.TxOutputs {
+		var obj openwallet.TxOutPut
+		err = tx.One("Sid", output.Sid, &obj)
+		if err != nil {
+			continue
+		}
+		obj.Confirm = confirm
+		err = tx.Save(&obj)
+		if err != nil {
+			return fmt.Errorf("wallet update TxOutputs failed, unexpected error: %v", err)
+		}
+	}
+
+	//更新账户相关的记录
+	var trx openwallet.Transaction
+	err = tx.One("WxID", data.Transaction.WxID, &trx)
+	if err == nil {
+		trx.Confirm = confirm
+		err = tx.Save(&trx)
+		if err != nil {
+			return fmt.Errorf("wallet update Transactions failed, unexpected error: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("wallet update TxExtractData confirm failed, unexpected error: %v", err)
+	}
+
+	return nil
+}
+
 //DeleteBlockDataByHeight 删除钱包中指定区块高度相关的交易记录
 func (wrapper *TransactionWrapper) DeleteBlockDataByHeight(height uint64) error {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return err
 	}
@@ -275,37 +271,41 @@ func (wrapper *TransactionWrapper) DeleteBlockDataByHeight(height uint64) error
 
 	defer tx.Rollback()
 
-	trxs, err := wrapper.GetTransactions(0, -1, "BlockHeight", height)
-	if err != nil {
+	//事务内查询和删除，避免事务外的写操作等待事务锁
+	var trxs []*openwallet.Transaction
+	err = tx.Find(&trxs, 0, -1, "BlockHeight", height)
+	if err != nil && err != ErrRecordNotFound {
 		return err
 	}
 
 	for _, obj := range trxs {
-		err = db.DeleteStruct(&obj)
+		err = tx.DeleteStruct(obj)
 		if err != nil {
 			return err
 		}
 	}
 
-	inputs, err := wrapper.GetTxInputs(0, -1, "BlockHeight", height)
-	if err != nil {
+	var inputs []*openwallet.TxInput
+	err = tx.Find(&inputs, 0, -1, "BlockHeight", height)
+	if err != nil && err != ErrRecordNotFound {
 		return err
 	}
 
 	for _, obj := range inputs {
-		err = db.DeleteStruct(&obj)
+		err = tx.DeleteStruct(obj)
 		if err != nil {
 			return err
 		}
 	}
 
-	outputs, err := wrapper.GetTxOutputs(0, -1, "BlockHeight", height)
-	if err != nil {
+	var outputs []*openwallet.TxOutPut
+	err = tx.Find(&outputs, 0, -1, "BlockHeight", height)
+	if err != nil && err != ErrRecordNotFound {
 		return err
 	}
 
 	for _, obj := range outputs {
-		err = db.DeleteStruct(&obj)
+		err = tx.DeleteStruct(obj)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,254 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/coinselect"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//DefaultUnspentLockTTL 未花输出默认锁定时长
+const DefaultUnspentLockTTL = 10 * time.Minute
+
+//GetAvailableUnspent 查询未被锁定的未花输出，过期的锁定视为已释放
+func (wrapper *WalletWrapper) GetAvailableUnspent(offset, limit int, cols ...interface{}) ([]*openwallet.TxOutPut, error) {
+
+	outputs, err := wrapper.GetTxOutputs(0, -1, cols...)
+	if err != nil {
+		return nil, err
+	}
+
+	locks, err := wrapper.GetLockedUnspent(0, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	locked := make(map[string]bool)
+	for _, lock := range locks {
+		locked[lock.Sid] = true
+	}
+
+	available := make([]*openwallet.TxOutPut, 0)
+	skipped := 0
+	for _, output := range outputs {
+		if locked[output.Sid] {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if limit > 0 && len(available) >= limit {
+			break
+		}
+		available = append(available, output)
+	}
+
+	return available, nil
+}
+
+//LockUnspent 把选中的未花输出锁定到交易单的Sid
+func (wrapper *WalletWrapper) LockUnspent(rawTx *openwallet.RawTransaction, outputs ...*openwallet.TxOutPut) error {
+
+	if rawTx == nil || len(rawTx.Sid) == 0 {
+		return fmt.Errorf("raw transaction sid is empty")
+	}
+
+	accountID := ""
+	if rawTx.Account != nil {
+		accountID = rawTx.Account.AccountID
+	}
+
+	ttl := wrapper.unspentLockTTL
+	if ttl <= 0 {
+		ttl = DefaultUnspentLockTTL
+	}
+
+	now := time.Now()
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, output := range outputs {
+		var lock openwallet.UnspentLock
+		err = tx.One("Sid", output.Sid, &lock)
+		if err == nil && lock.RawTxSid != rawTx.Sid && !lock.IsExpired() {
+			return fmt.Errorf("unspent: %s has been locked by transaction: %s", output.Sid, lock.RawTxSid)
+		}
+
+		lock = openwallet.UnspentLock{
+			Sid:       output.Sid,
+			TxID:      output.TxID,
+			Index:     output.Index,
+			AccountID: accountID,
+			RawTxSid:  rawTx.Sid,
+			CreateAt:  now.Unix(),
+			ExpireAt:  now.Add(ttl).Unix(),
+		}
+		err = tx.Save(&lock)
+		if err != nil {
+			return fmt.Errorf("wallet save UnspentLock failed, unexpected error: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+//SelectUnspent 按交易单ExtParam指定的策略从账户未锁定的未花输出中选择输入，并锁定到交易单的Sid
+func (wrapper *WalletWrapper) SelectUnspent(rawTx *openwallet.RawTransaction, params *coinselect.Params) (*coinselect.Result, error) {
+
+	if rawTx == nil || rawTx.Account == nil {
+		return nil, fmt.Errorf("raw transaction account is empty")
+	}
+
+	if params == nil {
+		p, err := coinselect.NewParamsWithRawTransaction(rawTx)
+		if err != nil {
+			return nil, err
+		}
+		params = p
+	}
+
+	unspent, err := wrapper.GetAvailableUnspent(0, -1, "AccountID", rawTx.Account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := coinselect.Select(coinselect.StrategyOf(rawTx), unspent, params)
+	if err != nil {
+		return nil, err
+	}
+
+	//锁定失败说明已被并发构建的交易单选中
+	err = wrapper.LockUnspent(rawTx, result.Inputs...)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//GetLockedUnspent 查询锁定中的未花输出，不包括已过期的锁定
+func (wrapper *WalletWrapper) GetLockedUnspent(offset, limit int, cols ...interface{}) ([]*openwallet.UnspentLock, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var locks []*openwallet.UnspentLock
+	err = db.Find(&locks, 0, -1, cols...)
+	if err != nil && err != ErrRecordNotFound {
+		return nil, err
+	}
+
+	actives := make([]*openwallet.UnspentLock, 0)
+	skipped := 0
+	for _, lock := range locks {
+		if lock.IsExpired() {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if limit > 0 && len(actives) >= limit {
+			break
+		}
+		actives = append(actives, lock)
+	}
+
+	return actives, nil
+}
+
+//UnlockUnspent 释放交易单锁定的未花输出，同时清理已过期的锁定
+func (wrapper *WalletWrapper) UnlockUnspent(rawTxSid string) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var locks []*openwallet.UnspentLock
+	err = tx.Find(&locks, 0, -1)
+	if err != nil && err != ErrRecordNotFound {
+		return err
+	}
+
+	for _, lock := range locks {
+		if lock.RawTxSid != rawTxSid && !lock.IsExpired() {
+			continue
+		}
+		err = tx.DeleteStruct(lock)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//ListLockedUnspent 查询资产账户锁定中的未花输出，accountID为空查询应用全部
+func (wm *WalletManager) ListLockedUnspent(appID, accountID string) ([]*openwallet.UnspentLock, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(accountID) > 0 {
+		return wrapper.GetLockedUnspent(0, -1, "AccountID", accountID)
+	}
+
+	return wrapper.GetLockedUnspent(0, -1)
+}
+
+//UnlockUnspent 释放交易单锁定的未花输出
+//@param sid 交易单的Sid
+func (wm *WalletManager) UnlockUnspent(appID, sid string) error {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return err
+	}
+
+	return wrapper.UnlockUnspent(sid)
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/coinselect"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestWalletWrapper_LockUnspent(t *testing.T) {
+
+	tc := NewConfig()
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.Store = NewMemoryStore()
+	tm := NewWalletManager(tc)
+
+	db, _ := tm.OpenDB(testApp)
+	for _, sid := range []string{"u1", "u2", "u3"} {
+		output := &openwallet.TxOutPut{}
+		output.Sid = sid
+		output.AccountID = "acc1"
+		db.Save(output)
+	}
+
+	wrapper, _ := tm.NewWalletWrapper(testApp, "")
+	var locker openwallet.UnspentLocker = wrapper
+
+	unspent, _ := locker.GetAvailableUnspent(0, -1, "AccountID", "acc1")
+	rawTx1 := &openwallet.RawTransaction{Sid: "raw1", Account: &openwallet.AssetsAccount{AccountID: "acc1"}}
+	err := locker.LockUnspent(rawTx1, unspent[0], unspent[1])
+	if err != nil {
+		t.Errorf("LockUnspent unexpected error: %v", err)
+		return
+	}
+
+	//已被其他交易单锁定
+	rawTx2 := &openwallet.RawTransaction{Sid: "raw2", Account: &openwallet.AssetsAccount{AccountID: "acc1"}}
+	if err = locker.LockUnspent(rawTx2, unspent[1]); err == nil {
+		t.Errorf("locked unspent should not be locked by another transaction")
+	}
+
+	available, _ := locker.GetAvailableUnspent(0, -1, "AccountID", "acc1")
+	if len(available) != 1 || available[0].Sid != "u3" {
+		t.Errorf("GetAvailableUnspent = %d, want 1", len(available))
+	}
+
+	locks, _ := tm.ListLockedUnspent(testApp, "acc1")
+	if len(locks) != 2 {
+		t.Errorf("ListLockedUnspent = %d, want 2", len(locks))
+	}
+
+	err = tm.UnlockUnspent(testApp, "raw1")
+	if err != nil {
+		t.Errorf("UnlockUnspent unexpected error: %v", err)
+		return
+	}
+	locks, _ = tm.ListLockedUnspent(testApp, "")
+	if len(locks) != 0 {
+		t.Errorf("ListLockedUnspent after unlock = %d, want 0", len(locks))
+	}
+
+	//过期的锁定视为已释放
+	db.Save(&openwallet.UnspentLock{Sid: unspent[0].Sid, RawTxSid: "raw1", ExpireAt: time.Now().Unix() - 1})
+	if err = locker.LockUnspent(rawTx2, unspent[0]); err != nil {
+		t.Errorf("expired lock should be released, err: %v", err)
+	}
+}
+
+func TestWalletWrapper_SelectUnspent(t *testing.T) {
+
+	tc := NewConfig()
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.Store = NewMemoryStore()
+	tm := NewWalletManager(tc)
+
+	db, _ := tm.OpenDB(testApp)
+	for i, amount := range []string{"0.1", "0.5", "1"} {
+		output := &openwallet.TxOutPut{}
+		output.Sid = fmt.Sprintf("u%d", i)
+		output.AccountID = "acc1"
+		output.Amount = amount
+		db.Save(output)
+	}
+
+	wrapper, _ := tm.NewWalletWrapper(testApp, "")
+	var selector coinselect.UnspentSelector = wrapper
+
+	rawTx1 := &openwallet.RawTransaction{
+		Sid:      "raw1",
+		Account:  &openwallet.AssetsAccount{AccountID: "acc1"},
+		To:       map[string]string{"addr": "0.6"},
+		FeeRate:  "0.0001",
+		ExtParam: `{"coinSelect":"largest"}`,
+	}
+	result, err := selector.SelectUnspent(rawTx1, nil)
+	if err != nil {
+		t.Errorf("SelectUnspent unexpected error: %v", err)
+		return
+	}
+	if len(result.Inputs) != 1 || result.Inputs[0].Amount != "1" {
+		t.Errorf("SelectUnspent inputs = %v, want [1]", result.Inputs)
+		return
+	}
+
+	//已选中的输出被锁定，其他交易单不能再选择
+	rawTx2 := &openwallet.RawTransaction{
+		Sid:      "raw2",
+		Account:  &openwallet.AssetsAccount{AccountID: "acc1"},
+		To:       map[string]string{"addr": "0.6"},
+		FeeRate:  "0.0001",
+		ExtParam: `{"coinSelect":"largest"}`,
+	}
+	if _, err = selector.SelectUnspent(rawTx2, nil); err == nil {
+		t.Errorf("locked unspent should not be selected by another transaction")
+	}
+
+	locks, _ := tm.ListLockedUnspent(testApp, "acc1")
+	if len(locks) != 1 || locks[0].RawTxSid != "raw1" {
+		t.Errorf("ListLockedUnspent = %v, want locked by raw1", locks)
+	}
+}
+
+func TestWalletManager_CreateTransactionLockUnspent(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	db, _ := tm.OpenDB(testApp)
+	for i, amount := range []string{"0.5", "1"} {
+		output := &openwallet.TxOutPut{}
+		output.Sid = fmt.Sprintf("u%d", i)
+		output.AccountID = "acc1"
+		output.Amount = amount
+		db.Save(output)
+	}
+
+	testDecoder.useSelect = true
+	defer func() { testDecoder.useSelect = false }()
+
+	extParam := map[string]interface{}{coinselect.ExtParamKey: coinselect.StrategyLargestFirst}
+	rawTx, err := tm.CreateTransaction(testApp, "", "acc1", "0.6", "addr", "0.0001", "", nil, extParam)
+	if err != nil {
+		t.Errorf("CreateTransaction unexpected error: %v", err)
+		return
+	}
+
+	//构建中的交易单已选的输出不再作为未花输出返回
+	unspent, err := tm.GetTxUnspent(testApp, 0, -1, "AccountID", "acc1")
+	if err != nil || len(unspent) != 1 || unspent[0].Sid != "u0" {
+		t.Errorf("GetTxUnspent = %v, %v", unspent, err)
+		return
+	}
+
+	//广播后确认前保留锁定，索引可能仍返回已花费的输出
+	tx, err := tm.SubmitTransaction(testApp, "", "acc1", rawTx)
+	if err != nil {
+		t.Errorf("SubmitTransaction unexpected error: %v", err)
+		return
+	}
+	locks, _ := tm.ListLockedUnspent(testApp, "acc1")
+	if len(locks) != 1 || locks[0].Sid != "u1" {
+		t.Errorf("ListLockedUnspent after submit = %v, want u1 locked", locks)
+		return
+	}
+
+	testDecoder.status = map[string]*openwallet.TransactionStatus{
+		tx.TxID: {TxID: tx.TxID, InMempool: true},
+	}
+	tm.TrackTransactions()
+	if locks, _ = tm.ListLockedUnspent(testApp, "acc1"); len(locks) != 1 {
+		t.Errorf("ListLockedUnspent in mempool = %d, want 1", len(locks))
+	}
+
+	//交易确认后释放锁定
+	testDecoder.status[tx.TxID] = &openwallet.TransactionStatus{TxID: tx.TxID, BlockHeight: 100, Confirmations: 1}
+	tm.TrackTransactions()
+	if locks, _ = tm.ListLockedUnspent(testApp, "acc1"); len(locks) != 0 {
+		t.Errorf("ListLockedUnspent after confirmed = %d, want 0", len(locks))
+	}
+}
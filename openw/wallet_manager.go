@@ -59,7 +59,7 @@ func (wm *WalletManager) CreateWallet(appID string, wallet *openwallet.Wallet) (
 	}
 
 	//数据路径
-	wallet.DBFile = db.FileName
+	wallet.DBFile = wm.DBFile(appID)
 
 	//保存钱包到本地应用数据库
 	err = db.Save(wallet)
@@ -105,3 +105,38 @@ func (wm *WalletManager) GetWalletList(appID string, offset, limit int) ([]*open
 	//
 	//return wallets, nil
 }
+
+//SetWalletSigner 设置钱包的外部签名器，例如PKCS#11硬件安全模块，设置后签名交易不再解锁钱包密钥文件，
+//signer为nil时移除签名器。签名器必须实现openwallet.PasswordVerifier验证签名时提供的钱包密码；
+//不支持BIP32衍生的签名器通过CreateSignerAssetsAccount创建账户和地址
+func (wm *WalletManager) SetWalletSigner(walletID string, signer openwallet.Signer) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if signer == nil {
+		delete(wm.signers, walletID)
+		return
+	}
+
+	if wm.signers == nil {
+		wm.signers = make(map[string]openwallet.Signer)
+	}
+	wm.signers[walletID] = signer
+}
+
+//WalletSigner 获取钱包的外部签名器，没有设置返回nil
+func (wm *WalletManager) WalletSigner(walletID string) openwallet.Signer {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.signers[walletID]
+}
+
+//checkWalletSigner 外部签名器签名前由签名器验证密码，签名器没有实现PasswordVerifier时拒绝签名，
+//不会回退到解密钱包密钥文件
+func checkWalletSigner(signer openwallet.Signer, password string) error {
+	verifier, ok := signer.(openwallet.PasswordVerifier)
+	if !ok {
+		return fmt.Errorf("external signer can not verify wallet password")
+	}
+	return verifier.VerifyPassword(password)
+}
@@ -21,11 +21,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"io/ioutil"
 	"strings"
 	"time"
 
-	"github.com/asdine/storm/q"
 	"github.com/blocktree/go-owcdrivers/owkeychain"
 	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/hdkeystore"
@@ -43,6 +41,8 @@ type WalletWrapper struct {
 	wallet  *openwallet.Wallet //需要包装的钱包
 	keyFile string             //钱包密钥文件路径
 	key     *hdkeystore.HDKey
+
+	unspentLockTTL time.Duration //未花输出锁定时长
 }
 
 func NewWalletWrapper(args ...interface{}) *WalletWrapper {
@@ -77,7 +77,7 @@ func (wrapper *WalletWrapper) GetWallet() *openwallet.Wallet {
 func (wrapper *WalletWrapper) GetWalletByID(walletID string) (*openwallet.Wallet, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -96,7 +96,7 @@ func (wrapper *WalletWrapper) GetWalletByID(walletID string) (*openwallet.Wallet
 func (wrapper *WalletWrapper) GetAssetsAccountInfo(accountID string) (*openwallet.AssetsAccount, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -115,7 +115,7 @@ func (wrapper *WalletWrapper) GetAssetsAccountInfo(accountID string) (*openwalle
 func (wrapper *WalletWrapper) GetAssetsAccountList(offset, limit int, cols ...interface{}) ([]*openwallet.AssetsAccount, error) {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -123,35 +123,11 @@ func (wrapper *WalletWrapper) GetAssetsAccountList(offset, limit int, cols ...in
 
 	var accounts []*openwallet.AssetsAccount
 
-	query := make([]q.Matcher, 0)
-
 	if wrapper.wallet != nil {
-		query = append(query, q.Eq("WalletID", wrapper.wallet.WalletID))
-	}
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
+		cols = append([]interface{}{"WalletID", wrapper.wallet.WalletID}, cols...)
 	}
 
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&accounts)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&accounts)
-
-	}
+	err = db.Find(&accounts, offset, limit, cols...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find accounts")
@@ -162,7 +138,7 @@ func (wrapper *WalletWrapper) GetAssetsAccountList(offset, limit int, cols ...in
 
 //GetAssetsAccountByAddress 通过地址获取资产账户对象
 func (wrapper *WalletWrapper) GetAssetsAccountByAddress(address string) (*openwallet.AssetsAccount, error) {
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -186,7 +162,7 @@ func (wrapper *WalletWrapper) GetAssetsAccountByAddress(address string) (*openwa
 
 //GetAddress 通过地址字符串获取地址对象
 func (wrapper *WalletWrapper) GetAddress(address string) (*openwallet.Address, error) {
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +181,7 @@ func (wrapper *WalletWrapper) GetAddress(address string) (*openwallet.Address, e
 // GetAddresses 获取资产账户地址列表
 func (wrapper *WalletWrapper) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -213,31 +189,7 @@ func (wrapper *WalletWrapper) GetAddressList(offset, limit int, cols ...interfac
 
 	var addrs []*openwallet.Address
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
-	}
-
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&addrs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&addrs)
-
-	}
+	err = db.Find(&addrs, offset, limit, cols...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find addresses")
@@ -249,7 +201,7 @@ func (wrapper *WalletWrapper) GetAddressList(offset, limit int, cols ...interfac
 // GetImportAddressList 获取待导入
 func (wrapper *WalletWrapper) GetImportAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.ImportAddress, error) {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -257,31 +209,7 @@ func (wrapper *WalletWrapper) GetImportAddressList(offset, limit int, cols ...in
 
 	var addrs []*openwallet.ImportAddress
 
-	query := make([]q.Matcher, 0)
-
-	if len(cols)%2 != 0 {
-		return nil, fmt.Errorf("condition param is not pair")
-	}
-
-	for i := 0; i < len(cols); i = i + 2 {
-		field := common.NewString(cols[i])
-		val := cols[i+1]
-		query = append(query, q.Eq(field.String(), val))
-	}
-
-	if limit > 0 {
-
-		err = db.Select(q.And(
-			query...,
-		)).Limit(limit).Skip(offset).Find(&addrs)
-
-	} else {
-
-		err = db.Select(q.And(
-			query...,
-		)).Skip(offset).Find(&addrs)
-
-	}
+	err = db.Find(&addrs, offset, limit, cols...)
 
 	if err != nil {
 		return nil, fmt.Errorf("can not find addresses")
@@ -315,7 +243,7 @@ func (wrapper *WalletWrapper) CreateAddress(accountID string, count uint64, deco
 	}
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -355,7 +283,13 @@ func (wrapper *WalletWrapper) CreateAddress(accountID string, count uint64, deco
 			}
 
 			start, err := pubkey.GenPublicChild(changeIndex)
+			if err != nil {
+				return nil, err
+			}
 			newKey, err := start.GenPublicChild(uint32(newIndex))
+			if err != nil {
+				return nil, err
+			}
 			newKeys = append(newKeys, newKey.GetPublicKeyBytes())
 
 		}
@@ -425,7 +359,7 @@ func (wrapper *WalletWrapper) CreateAddress(accountID string, count uint64, deco
 func (wrapper *WalletWrapper) ImportWatchOnlyAddress(address ...*openwallet.Address) error {
 
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return err
 	}
@@ -462,7 +396,7 @@ func (wrapper *WalletWrapper) ImportWatchOnlyAddress(address ...*openwallet.Addr
 //SaveAssetsAccount 更新账户信息
 func (wrapper *WalletWrapper) SaveAssetsAccount(account *openwallet.AssetsAccount) error {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return err
 	}
@@ -503,11 +437,7 @@ func (wrapper *WalletWrapper) HDKey(password ...string) (*hdkeystore.HDKey, erro
 		return nil, errors.New("Wallet key is not exist!")
 	}
 
-	keyjson, err := ioutil.ReadFile(wrapper.keyFile)
-	if err != nil {
-		return nil, err
-	}
-	key, err := hdkeystore.DecryptHDKey(keyjson, pw)
+	key, err := hdkeystore.LoadHDKeyFile(wrapper.keyFile, pw)
 	if err != nil {
 		return nil, err
 	}
@@ -517,7 +447,7 @@ func (wrapper *WalletWrapper) HDKey(password ...string) (*hdkeystore.HDKey, erro
 //设置地址的扩展字段
 func (wrapper *WalletWrapper) SetAddressExtParam(address string, key string, val interface{}) error {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return err
 	}
@@ -553,7 +483,7 @@ func (wrapper *WalletWrapper) SetAddressExtParam(address string, key string, val
 //获取地址的扩展字段
 func (wrapper *WalletWrapper) GetAddressExtParam(address string, key string) (interface{}, error) {
 	//打开数据库
-	db, err := wrapper.OpenStormDB()
+	db, err := wrapper.OpenDB()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//GetWithdrawal 通过业务订单号获取提现记录
+func (wrapper *WalletWrapper) GetWithdrawal(sid string) (*openwallet.Withdrawal, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var withdrawal openwallet.Withdrawal
+	err = db.One("Sid", sid, &withdrawal)
+	if err != nil {
+		return nil, err
+	}
+
+	return &withdrawal, nil
+}
+
+//GetWithdrawalList 查询提现记录
+func (wrapper *WalletWrapper) GetWithdrawalList(offset, limit int, cols ...interface{}) ([]*openwallet.Withdrawal, error) {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	defer wrapper.CloseDB()
+
+	var list []*openwallet.Withdrawal
+	err = db.Find(&list, offset, limit, cols...)
+	if err != nil {
+		return nil, fmt.Errorf("can not find withdrawals")
+	}
+
+	return list, nil
+}
+
+//SaveWithdrawal 保存提现记录
+func (wrapper *WalletWrapper) SaveWithdrawal(withdrawal *openwallet.Withdrawal) error {
+
+	//打开数据库
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return err
+	}
+	defer wrapper.CloseDB()
+
+	now := time.Now().Unix()
+	if withdrawal.CreateAt == 0 {
+		withdrawal.CreateAt = now
+	}
+	withdrawal.UpdateAt = now
+
+	err = db.Save(withdrawal)
+	if err != nil {
+		return fmt.Errorf("wallet save Withdrawal failed, unexpected error: %v", err)
+	}
+
+	return nil
+}
+
+//updateWithdrawal 更新交易单对应的提现记录，没有记录不处理
+func (wrapper *WalletWrapper) updateWithdrawal(sid, status string, rawTx *openwallet.RawTransaction, tx *openwallet.Transaction, reason error) error {
+
+	if len(sid) == 0 {
+		return nil
+	}
+
+	withdrawal, err := wrapper.GetWithdrawal(sid)
+	if err != nil {
+		return nil
+	}
+
+	withdrawal.Status = status
+	if rawTx != nil {
+		withdrawal.RawTx = rawTx
+	}
+	if tx != nil {
+		withdrawal.Tx = tx
+		withdrawal.TxID = tx.TxID
+	}
+	if reason != nil {
+		withdrawal.Error = reason.Error()
+	}
+
+	return wrapper.SaveWithdrawal(withdrawal)
+}
+
+//confirmWithdrawal 广播的交易被区块打包，更新提现记录为已确认
+func (wrapper *WalletWrapper) confirmWithdrawal(txid string) error {
+
+	if len(txid) == 0 {
+		return nil
+	}
+
+	list, err := wrapper.GetWithdrawalList(0, -1, "TxID", txid)
+	if err != nil {
+		return nil
+	}
+
+	for _, withdrawal := range list {
+		if withdrawal.Status != openwallet.WithdrawalStatusSubmitted {
+			continue
+		}
+		withdrawal.Status = openwallet.WithdrawalStatusConfirmed
+		err = wrapper.SaveWithdrawal(withdrawal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//GetWithdrawal 通过业务订单号获取提现记录
+func (wm *WalletManager) GetWithdrawal(appID, sid string) (*openwallet.Withdrawal, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper.GetWithdrawal(sid)
+}
+
+//GetWithdrawalList 查询提现记录
+func (wm *WalletManager) GetWithdrawalList(appID string, offset, limit int, cols ...interface{}) ([]*openwallet.Withdrawal, error) {
+
+	wrapper, err := wm.NewWalletWrapper(appID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper.GetWithdrawalList(offset, limit, cols...)
+}
+
+//sidLocker 业务订单号的互斥锁，保证同一订单号不会并发构建或广播。
+//锁只在进程内有效，多个进程共享存储时不能保证订单号的唯一性。
+type sidLocker struct {
+	mu    sync.Mutex
+	locks map[string]*sidLock
+}
+
+type sidLock struct {
+	sync.Mutex
+	ref int
+}
+
+//lock 锁定订单号，返回解锁函数
+func (l *sidLocker) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sidLock)
+	}
+	m, ok := l.locks[key]
+	if !ok {
+		m = &sidLock{}
+		l.locks[key] = m
+	}
+	m.ref++
+	l.mu.Unlock()
+
+	m.Lock()
+
+	return func() {
+		m.Unlock()
+		l.mu.Lock()
+		m.ref--
+		if m.ref == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openw
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/coinselect"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const testAssetsSymbol = "OWTEST"
+
+//testAssetsAdapter 测试用的资产适配器，记录交易单的构建和广播次数
+type testAssetsAdapter struct {
+	openwallet.AssetsAdapterBase
+	decoder *testTransactionDecoder
+}
+
+func (a *testAssetsAdapter) Symbol() string {
+	return testAssetsSymbol
+}
+
+func (a *testAssetsAdapter) GetTransactionDecoder() openwallet.TransactionDecoder {
+	return a.decoder
+}
+
+type testTransactionDecoder struct {
+	openwallet.TransactionDecoderBase
+	mu         sync.Mutex
+	built      int
+	submitted  int
+	submitFail bool //节点拒绝交易
+	submitLost bool //节点已接收交易，但响应超时
+	useSelect  bool                                     //通过WalletWrapper选择并锁定未花输出
+	status     map[string]*openwallet.TransactionStatus //交易的链上状态
+}
+
+func (decoder *testTransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+	decoder.mu.Lock()
+	defer decoder.mu.Unlock()
+	decoder.built++
+	if decoder.useSelect {
+		selector, ok := wrapper.(coinselect.UnspentSelector)
+		if !ok {
+			return fmt.Errorf("wallet dai is not unspent selector")
+		}
+		if _, err := selector.SelectUnspent(rawTx, nil); err != nil {
+			return err
+		}
+	}
+	rawTx.RawHex = fmt.Sprintf("raw_%d", decoder.built)
+	rawTx.IsBuilt = true
+	if rawTx.Account != nil && rawTx.Account.IsMultiSig() {
+		rawTx.Signatures = testMultiSigSignatures(rawTx)
+	}
+	return nil
+}
+
+func (decoder *testTransactionDecoder) SubmitRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*openwallet.Transaction, error) {
+	decoder.mu.Lock()
+	defer decoder.mu.Unlock()
+	if decoder.submitFail {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "submit failed")
+	}
+	if decoder.submitLost {
+		rawTx.TxID = "tx_" + rawTx.RawHex
+		return nil, fmt.Errorf("request timeout")
+	}
+	decoder.submitted++
+	tx := &openwallet.Transaction{TxID: "tx_" + rawTx.RawHex, Coin: rawTx.Coin}
+	tx.WxID = openwallet.GenTransactionWxID(tx)
+	return tx, nil
+}
+
+var testDecoder = &testTransactionDecoder{}
+
+func init() {
+	RegAssets(testAssetsSymbol, &testAssetsAdapter{decoder: testDecoder})
+}
+
+func testInitMemoryWalletManager() *WalletManager {
+	tc := NewConfig()
+	tc.EnableBlockScan = false
+	tc.SupportAssets = []string{}
+	tc.Store = NewMemoryStore()
+	tm := NewWalletManager(tc)
+
+	db, _ := tm.OpenDB(testApp)
+	db.Save(&openwallet.AssetsAccount{AccountID: "acc1", WalletID: "w1", Symbol: testAssetsSymbol})
+	return tm
+}
+
+func TestWalletManager_CreateBatchTransactionWithSid(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	to := map[string]string{"addr": "1"}
+
+	var wg sync.WaitGroup
+	rawTxs := make([]*openwallet.RawTransaction, 5)
+	for i := 0; i < len(rawTxs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rawTxs[i], _ = tm.CreateBatchTransactionWithSid(testApp, "", "acc1", "order1", "", "", to, nil, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, rawTx := range rawTxs {
+		if rawTx == nil || rawTx.RawHex != rawTxs[0].RawHex {
+			t.Errorf("same sid should return the original raw transaction")
+			return
+		}
+	}
+
+	if _, err := tm.CreateBatchTransactionWithSid(testApp, "", "acc2", "order1", "", "", to, nil, nil); err == nil {
+		t.Errorf("sid used by another account should return error")
+	}
+
+	//广播失败后允许重新构建
+	testDecoder.submitFail = true
+	if _, err := tm.SubmitTransaction(testApp, "", "acc1", rawTxs[0]); err == nil {
+		t.Errorf("SubmitTransaction should fail")
+		return
+	}
+	testDecoder.submitFail = false
+
+	withdrawal, _ := tm.GetWithdrawal(testApp, "order1")
+	if withdrawal.Status != openwallet.WithdrawalStatusFailed || withdrawal.Error != "[2003]submit failed" {
+		t.Errorf("withdrawal status = %s, error = %s", withdrawal.Status, withdrawal.Error)
+	}
+
+	rawTx, err := tm.CreateBatchTransactionWithSid(testApp, "", "acc1", "order1", "", "", to, nil, nil)
+	if err != nil || rawTx.RawHex == rawTxs[0].RawHex {
+		t.Errorf("failed withdrawal should be rebuilt, err: %v", err)
+		return
+	}
+
+	tx, err := tm.SubmitTransaction(testApp, "", "acc1", rawTx)
+	if err != nil {
+		t.Errorf("SubmitTransaction unexpected error: %v", err)
+		return
+	}
+
+	//重复广播返回原交易记录
+	submitted := testDecoder.submitted
+	tx2, err := tm.SubmitTransaction(testApp, "", "acc1", rawTx)
+	if err != nil || tx2.TxID != tx.TxID || testDecoder.submitted != submitted {
+		t.Errorf("same sid should not be submitted twice")
+	}
+
+	//交易被打包后为已确认
+	data := openwallet.NewBlockExtractData()
+	data.Transaction = tx
+	tm.BlockExtractDataNotify(tm.encodeSourceKey(testApp, "acc1"), data)
+
+	list, err := tm.GetWithdrawalList(testApp, 0, -1, "Status", openwallet.WithdrawalStatusConfirmed)
+	if err != nil || len(list) != 1 || list[0].TxID != tx.TxID {
+		t.Errorf("GetWithdrawalList = %v, %v", list, err)
+	}
+}
+
+func TestWalletManager_SubmitTransactionUnknown(t *testing.T) {
+
+	tm := testInitMemoryWalletManager()
+	to := map[string]string{"addr": "1"}
+
+	rawTx, err := tm.CreateBatchTransactionWithSid(testApp, "", "acc1", "order2", "", "", to, nil, nil)
+	if err != nil {
+		t.Errorf("CreateBatchTransactionWithSid unexpected error: %v", err)
+		return
+	}
+
+	if _, err = tm.SubmitTransaction(testApp, "", "acc2", rawTx); err == nil {
+		t.Errorf("sid submitted by another account should return error")
+		return
+	}
+
+	//广播超时，结果未知
+	testDecoder.submitLost = true
+	if _, err = tm.SubmitTransaction(testApp, "", "acc1", rawTx); err == nil {
+		t.Errorf("SubmitTransaction should fail")
+		return
+	}
+	testDecoder.submitLost = false
+
+	withdrawal, _ := tm.GetWithdrawal(testApp, "order2")
+	if withdrawal.Status != openwallet.WithdrawalStatusUnknown {
+		t.Errorf("withdrawal status = %s, want unknown", withdrawal.Status)
+		return
+	}
+
+	//原交易已在内存池，不重新构建
+	testDecoder.mu.Lock()
+	testDecoder.status = map[string]*openwallet.TransactionStatus{
+		rawTx.TxID: {TxID: rawTx.TxID, InMempool: true},
+	}
+	testDecoder.mu.Unlock()
+	defer func() {
+		testDecoder.mu.Lock()
+		testDecoder.status = nil
+		testDecoder.mu.Unlock()
+	}()
+
+	built := testDecoder.built
+	rebuilt, err := tm.CreateBatchTransactionWithSid(testApp, "", "acc1", "order2", "", "", to, nil, nil)
+	if err != nil || rebuilt.RawHex != rawTx.RawHex || testDecoder.built != built {
+		t.Errorf("submitted withdrawal should not be rebuilt, err: %v", err)
+		return
+	}
+
+	withdrawal, _ = tm.GetWithdrawal(testApp, "order2")
+	if withdrawal.Status != openwallet.WithdrawalStatusSubmitted || withdrawal.TxID != rawTx.TxID {
+		t.Errorf("withdrawal status = %s, txid = %s, want submitted", withdrawal.Status, withdrawal.TxID)
+	}
+}
@@ -16,6 +16,7 @@
 package openw
 
 import (
+	"fmt"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"sync"
 	"time"
@@ -29,7 +30,7 @@ type WrapperSourceFile string
 // Wrapper 基于OpenWallet钱包体系模型，专门处理钱包的持久化问题，关系数据查询
 type Wrapper struct {
 	openwallet.WalletDAIBase
-	sourceDB     *StormDB     //存储钱包相关数据的数据库，目前使用boltdb作为持久方案
+	sourceDB     StoreDB      //存储钱包相关数据的数据库，默认使用boltdb作为持久方案
 	mu           sync.RWMutex //锁
 	isExternalDB bool         //是否外部加载的数据库，非内部打开，内部打开需要关闭
 	sourceFile   string       //钱包数据库文件路径，用于内部打开
@@ -41,8 +42,8 @@ func NewWrapper(args ...interface{}) *Wrapper {
 
 	for _, arg := range args {
 		switch obj := arg.(type) {
-		case *StormDB:
-			if obj != nil {
+		case StoreDB:
+			if obj != nil && !isNilStormDB(obj) {
 				//if !obj.Opened {
 				//	return nil, fmt.Errorf("wallet db is close")
 				//}
@@ -58,16 +59,11 @@ func NewWrapper(args ...interface{}) *Wrapper {
 	return &wrapper
 }
 
-//OpenStormDB 打开数据库
-func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
-
-	var (
-		db  *StormDB
-		err error
-	)
+//OpenDB 打开数据库，没有外部数据库时打开钱包数据库文件
+func (wrapper *Wrapper) OpenDB() (StoreDB, error) {
 
-	if wrapper.sourceDB != nil && wrapper.sourceDB.Opened {
-		return wrapper.sourceDB, nil
+	if db := wrapper.openedDB(); db != nil {
+		return db, nil
 	}
 
 	//保证数据库文件并发下不被同时打开
@@ -75,12 +71,12 @@ func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
 	defer wrapper.mu.Unlock()
 
 	//解锁进入后，再次确认是否已经存在
-	if wrapper.sourceDB != nil && wrapper.sourceDB.Opened {
-		return wrapper.sourceDB, nil
+	if db := wrapper.openedDB(); db != nil {
+		return db, nil
 	}
 
 	//log.Debugf("sourceFile :%v", wrapper.sourceFile)
-	db, err = OpenStormDB(
+	db, err := OpenStormDB(
 		wrapper.sourceFile,
 		storm.BoltOptions(0600, &bolt.Options{Timeout: 3 * time.Second}),
 	)
@@ -95,8 +91,33 @@ func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
 	return db, nil
 }
 
-//SetStormDB 设置钱包的应用数据库
-func (wrapper *Wrapper) SetExternalDB(db *StormDB) error {
+//deprecated
+//OpenStormDB 打开storm数据库，已由OpenDB替代，存储后端不是storm数据库时返回错误
+func (wrapper *Wrapper) OpenStormDB() (*StormDB, error) {
+	db, err := wrapper.OpenDB()
+	if err != nil {
+		return nil, err
+	}
+	stormDB, ok := db.(*StormDB)
+	if !ok {
+		return nil, fmt.Errorf("wallet db is not storm db")
+	}
+	return stormDB, nil
+}
+
+//openedDB 已打开的数据库，storm数据库文件关闭后返回nil
+func (wrapper *Wrapper) openedDB() StoreDB {
+	if wrapper.sourceDB == nil {
+		return nil
+	}
+	if stormDB, ok := wrapper.sourceDB.(*StormDB); ok && !stormDB.Opened {
+		return nil
+	}
+	return wrapper.sourceDB
+}
+
+//SetExternalDB 设置钱包的应用数据库
+func (wrapper *Wrapper) SetExternalDB(db StoreDB) error {
 
 	//关闭之前的数据库
 	wrapper.CloseDB()
@@ -115,8 +136,14 @@ func (wrapper *Wrapper) SetExternalDB(db *StormDB) error {
 func (wrapper *Wrapper) CloseDB() {
 	// 如果是外部引入的数据库不进行关闭，因为这样会外部无法再操作同一个数据库实力
 	if wrapper.isExternalDB == false {
-		if wrapper.sourceDB != nil && wrapper.sourceDB.Opened {
-			wrapper.sourceDB.Close()
+		if db := wrapper.openedDB(); db != nil {
+			db.Close()
 		}
 	}
 }
+
+//isNilStormDB 接口是否包装了空的*StormDB
+func isNilStormDB(db StoreDB) bool {
+	stormDB, ok := db.(*StormDB)
+	return ok && stormDB == nil
+}
@@ -17,9 +17,14 @@ package openwallet
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
 	"github.com/blocktree/go-owcdrivers/owkeychain"
 	"github.com/blocktree/openwallet/v2/crypto"
 	"github.com/btcsuite/btcutil/hdkeychain"
+	"github.com/tidwall/gjson"
 )
 
 var (
@@ -35,6 +40,27 @@ type unlocked struct {
 
 //AccountOwner 账户拥有者接口
 type AccountOwner interface {
+
+	//GetAccountID 拥有者的账户ID，作为交易单Signatures的键
+	GetAccountID() string
+	//GetPublicKey 拥有者的账户公钥，OW编码
+	GetPublicKey() string
+}
+
+//AccountOwnerKey 以账户公钥表示的拥有者
+type AccountOwnerKey struct {
+	AccountID string `json:"accountID"`
+	PublicKey string `json:"publicKey"`
+}
+
+//GetAccountID 拥有者的账户ID
+func (o *AccountOwnerKey) GetAccountID() string {
+	return o.AccountID
+}
+
+//GetPublicKey 拥有者的账户公钥
+func (o *AccountOwnerKey) GetPublicKey() string {
+	return o.PublicKey
 }
 
 //AssetsAccount 千张包资产账户
@@ -59,9 +85,41 @@ type AssetsAccount struct {
 	core interface{} //核心账户指针
 }
 
-func NewMultiSigAccount(wallets []*Wallet, required uint, creator *Wallet) (*AssetsAccount, error) {
+//NewMultiSigAccount 创建m-of-n多重签名账户，创建者的公钥作为主公钥，其他拥有者的公钥按顺序加入OwnerKeys
+//@param creator 创建者的资产账户，需要PublicKey，HDPath，Symbol等信息
+//@param required 必要签名数
+//@param otherOwnerKeys 其他拥有者的账户公钥，OW编码
+func NewMultiSigAccount(creator *AssetsAccount, required uint64, otherOwnerKeys ...string) (*AssetsAccount, error) {
+
+	if creator == nil || len(creator.PublicKey) == 0 {
+		return nil, fmt.Errorf("creator publicKey is empty")
+	}
+
+	account := &AssetsAccount{
+		WalletID:  creator.WalletID,
+		Alias:     creator.Alias,
+		Index:     creator.Index,
+		HDPath:    creator.HDPath,
+		PublicKey: creator.PublicKey,
+		Required:  required,
+		Symbol:    creator.Symbol,
+		IsTrust:   creator.IsTrust,
+		ExtParam:  creator.ExtParam,
+		ModelType: creator.ModelType,
+	}
 
-	return nil, nil
+	err := account.SetOwnerKeys(otherOwnerKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Required == 0 || account.Required > uint64(len(account.OwnerKeys)) {
+		return nil, fmt.Errorf("required signatures: %d is invalid for %d owners", account.Required, len(account.OwnerKeys))
+	}
+
+	account.AccountID = account.GetAccountID()
+
+	return account, nil
 }
 
 //NewUserAccount 创建账户
@@ -70,10 +128,108 @@ func NewUserAccount() *AssetsAccount {
 	return account
 }
 
+//SetOwnerKeys 组合拥有者，主公钥在首位，忽略空值和重复的公钥
+func (a *AssetsAccount) SetOwnerKeys(otherOwnerKeys ...string) error {
+
+	a.OwnerKeys = []string{
+		a.PublicKey,
+	}
+
+	exist := map[string]bool{
+		a.PublicKey: true,
+	}
+
+	for _, otherKey := range otherOwnerKeys {
+		if len(otherKey) == 0 || exist[otherKey] {
+			continue
+		}
+		_, err := owkeychain.OWDecode(otherKey)
+		if err != nil {
+			return fmt.Errorf("owner key: %s is invalid, unexpected error: %v", otherKey, err)
+		}
+		exist[otherKey] = true
+		a.OwnerKeys = append(a.OwnerKeys, otherKey)
+	}
+
+	return nil
+}
+
+//GetOwners 账户的拥有者列表
 func (a *AssetsAccount) GetOwners() []AccountOwner {
+	owners := make([]AccountOwner, 0, len(a.OwnerKeys))
+	for _, pub := range a.OwnerKeys {
+		if len(pub) == 0 {
+			continue
+		}
+		owners = append(owners, &AccountOwnerKey{
+			AccountID: GenAccountID(pub),
+			PublicKey: pub,
+		})
+	}
+	return owners
+}
+
+//IsMultiSig 是否多重签名账户
+func (a *AssetsAccount) IsMultiSig() bool {
+	return len(a.OwnerKeys) > 1
+}
+
+//GetOwnerPublicKeys 拥有者的账户公钥字节，用于生成多签赎回脚本
+func (a *AssetsAccount) GetOwnerPublicKeys() ([][]byte, error) {
+	pubs := make([][]byte, 0, len(a.OwnerKeys))
+	for _, pub := range a.OwnerKeys {
+		if len(pub) == 0 {
+			continue
+		}
+		pubkey, err := owkeychain.OWDecode(pub)
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, pubkey.GetPublicKeyBytes())
+	}
+	return pubs, nil
+}
+
+//SetExtParam 设置账户的扩展参数
+func (a *AssetsAccount) SetExtParam(key string, value interface{}) error {
+	var ext map[string]interface{}
+
+	if len(a.ExtParam) == 0 {
+		ext = make(map[string]interface{})
+	} else {
+		err := json.Unmarshal([]byte(a.ExtParam), &ext)
+		if err != nil {
+			return err
+		}
+	}
+
+	ext[key] = value
+
+	extJSON, err := json.Marshal(ext)
+	if err != nil {
+		return err
+	}
+	a.ExtParam = string(extJSON)
+
 	return nil
 }
 
+//GetExtParam 获取账户的扩展参数
+func (a *AssetsAccount) GetExtParam() gjson.Result {
+	return gjson.ParseBytes([]byte(a.ExtParam))
+}
+
+//GetScriptType 导入扩展公钥时记录的地址脚本类型，没有记录时由地址解析器按默认类型生成地址
+func (a *AssetsAccount) GetScriptType() AddressScriptType {
+	return AddressScriptType(a.GetExtParam().Get(ExtParamKeyScriptType).String())
+}
+
+//IsSignerAccount 是否签名器账户，账户公钥为签名器的hex编码公钥而不是OW编码扩展公钥，
+//地址的公钥逐个从签名器获取，不能通过账户公钥衍生
+func (a *AssetsAccount) IsSignerAccount() bool {
+	return len(a.PublicKey) > 0 && !strings.HasPrefix(a.PublicKey, "owpub")
+}
+
 //GetAccountID 计算AccountID
 func (a *AssetsAccount) GetAccountID() string {
 
@@ -81,7 +237,11 @@ func (a *AssetsAccount) GetAccountID() string {
 		return a.AccountID
 	}
 
-	a.AccountID = GenAccountID(a.PublicKey)
+	if a.IsSignerAccount() {
+		a.AccountID = GenAccountIDByHex(a.PublicKey)
+	} else {
+		a.AccountID = GenAccountID(a.PublicKey)
+	}
 
 	return a.AccountID
 }
@@ -26,7 +26,7 @@ type AddressDecoderV2 interface {
 
 	// AddressDecode 地址解析
 	AddressDecode(addr string, opts ...interface{}) ([]byte, error)
-	// AddressEncode 地址编码
+	// AddressEncode 地址编码，账户记录了地址脚本类型时opts包含AddressScriptType
 	AddressEncode(pub []byte, opts ...interface{}) (string, error)
 	// AddressVerify 地址校验
 	AddressVerify(address string, opts ...interface{}) bool
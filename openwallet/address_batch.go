@@ -198,16 +198,32 @@ func CreateAddressByAccountWithIndex(account *AssetsAccount, adapter AssetsAdapt
 			return result
 		}
 		start, err := pubkey.GenPublicChild(uint32(addrIsChange))
+		if err != nil {
+			result.Success = false
+			result.Err = err
+			return result
+		}
 		newKey, err := start.GenPublicChild(uint32(addrIndex))
+		if err != nil {
+			result.Success = false
+			result.Err = err
+			return result
+		}
 		newKeys = append(newKeys, newKey.GetPublicKeyBytes())
 	}
 	var err error
 	var address, publicKey string
 
-	if decoderV2 != nil {
-		address, err = decoderV2.AddressEncode(newKeys[0])
+	if len(newKeys) > 1 {
+		//多个拥有者公钥生成多签地址
+		if decoderV2 != nil {
+			address, err = decoderV2.RedeemScriptToAddress(newKeys, account.Required, false)
+		} else {
+			address, err = decoderV1.RedeemScriptToAddress(newKeys, account.Required, false)
+		}
 	} else {
-		address, err = decoderV1.PublicKeyToAddress(newKeys[0], false)
+		address, err = encodeAccountAddress(account, decoderV1, decoderV2, newKeys[0])
+		publicKey = hex.EncodeToString(newKeys[0])
 	}
 	//address, err = decoder.PublicKeyToAddress(newKeys[0], false)
 	if err != nil {
@@ -215,7 +231,6 @@ func CreateAddressByAccountWithIndex(account *AssetsAccount, adapter AssetsAdapt
 		result.Err = err
 		return result
 	}
-	publicKey = hex.EncodeToString(newKeys[0])
 
 	if len(address) == 0 {
 		result.Success = false
@@ -241,3 +256,76 @@ func CreateAddressByAccountWithIndex(account *AssetsAccount, adapter AssetsAdapt
 
 	return result
 }
+
+//CreateAddressBySigner 使用签名器HDPath对应的公钥创建签名器账户的地址，衍生路径为account.HDPath/change/index，
+//签名器实现KeyGenerator时在签名器中生成新的密钥对，地址保存签名器公钥，签名交易时签名器按公钥匹配密钥
+func CreateAddressBySigner(account *AssetsAccount, adapter AssetsAdapter, signer Signer, addrIndex int, addrIsChange int64) AddressCreateResult {
+
+	result := AddressCreateResult{
+		Success: true,
+	}
+
+	decoderV1 := adapter.GetAddressDecode()
+	decoderV2 := adapter.GetAddressDecoderV2()
+	if decoderV1 == nil && decoderV2 == nil {
+		result.Success = false
+		result.Err = fmt.Errorf("assets-adapter not support AddressDecoder interface")
+		return result
+	}
+
+	if len(account.HDPath) == 0 {
+		result.Success = false
+		result.Err = fmt.Errorf("hdPath is empty")
+		return result
+	}
+	hdPath := fmt.Sprintf("%s/%d/%d", account.HDPath, addrIsChange, addrIndex)
+
+	pub, err := SignerPublicKey(signer, hdPath, adapter.CurveType())
+	if err != nil {
+		result.Success = false
+		result.Err = err
+		return result
+	}
+
+	address, err := encodeAccountAddress(account, decoderV1, decoderV2, pub)
+	if err != nil {
+		result.Success = false
+		result.Err = err
+		return result
+	}
+
+	if len(address) == 0 {
+		result.Success = false
+		result.Err = fmt.Errorf("create address content error")
+		return result
+	}
+
+	result.Address = &Address{
+		AccountID: account.AccountID,
+		Symbol:    account.Symbol,
+		Index:     uint64(addrIndex),
+		Address:   address,
+		Balance:   "0",
+		WatchOnly: false,
+		PublicKey: hex.EncodeToString(pub),
+		HDPath:    hdPath,
+		IsChange:  common.NewString(addrIsChange).Bool(),
+	}
+
+	return result
+}
+
+//encodeAccountAddress 公钥转地址，账户记录了地址脚本类型时作为AddressEncode的参数传给地址解析器
+func encodeAccountAddress(account *AssetsAccount, decoderV1 AddressDecoder, decoderV2 AddressDecoderV2, pub []byte) (string, error) {
+	scriptType := account.GetScriptType()
+	if decoderV2 != nil {
+		if len(scriptType) > 0 {
+			return decoderV2.AddressEncode(pub, scriptType)
+		}
+		return decoderV2.AddressEncode(pub)
+	}
+	if len(scriptType) > 0 {
+		return "", fmt.Errorf("address decoder does not support script type: %s", scriptType)
+	}
+	return decoderV1.PublicKeyToAddress(pub, false)
+}
@@ -371,3 +371,49 @@ func (base *BlockchainLocal) SetMaxBlockCache(size uint64, symbol string) error
 	base.blockCacheSize = size
 	return nil
 }
+
+func (base *BlockchainLocal) SaveConfirmWatch(record *ConfirmWatchRecord) error {
+	if record == nil {
+		return fmt.Errorf("the confirm watch record to save is nil")
+	}
+	db, err := base.getDB()
+	if err != nil {
+		return err
+	}
+	defer base.closeDB()
+	return db.Save(record)
+}
+
+func (base *BlockchainLocal) DeleteConfirmWatch(id, symbol string) error {
+	db, err := base.getDB()
+	if err != nil {
+		return err
+	}
+	defer base.closeDB()
+
+	var r ConfirmWatchRecord
+	err = db.One("ID", id, &r)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	return db.DeleteStruct(&r)
+}
+
+func (base *BlockchainLocal) GetConfirmWatches(symbol string) ([]*ConfirmWatchRecord, error) {
+	db, err := base.getDB()
+	if err != nil {
+		return nil, err
+	}
+	defer base.closeDB()
+
+	var list []*ConfirmWatchRecord
+	err = db.Find("Symbol", symbol, &list)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+	return list, nil
+}
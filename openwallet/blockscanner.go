@@ -208,8 +208,18 @@ type TxExtractData struct {
 
 	//交易记录
 	Transaction *Transaction
+
+	//通知类型，0：首次提取，1：确认数增长，2：达到最终确认数
+	NotifyType uint64
 }
 
+// 交易单提取结果通知类型
+const (
+	TxExtractNotifyTypeNew       = 0
+	TxExtractNotifyTypeConfirm   = 1
+	TxExtractNotifyTypeFinalized = 2
+)
+
 func NewBlockExtractData() *TxExtractData {
 	data := TxExtractData{
 		TxInputs:  make([]*TxInput, 0),
@@ -238,6 +248,12 @@ type BlockScannerBase struct {
 	isClose           bool //是否已关闭
 	WalletDAI         WalletDAI
 	BlockchainDAI     BlockchainDAI
+	ChainSource       BlockChainSource         //区块链数据源，设置后启用通用扫描引擎
+	MaxReorgDepth     uint64                   //最大分叉回滚深度，0则回滚到区块缓存的边界
+	confirmDepth      map[string]uint64        //各币种的最终确认数
+	confirmWatching   map[string]*confirmWatch //等待最终确认的交易
+	confirmMu         sync.Mutex               //确认跟踪锁
+	confirmLoaded     bool                     //是否已恢复持久化的确认跟踪
 }
 
 //NewBTCBlockScanner 创建区块链扫描器
@@ -343,7 +359,22 @@ func (bs *BlockScannerBase) RemoveObserver(obj BlockScanNotificationObject) erro
 
 //SetRescanBlockHeight 重置区块链扫描高度
 func (bs *BlockScannerBase) SetRescanBlockHeight(height uint64) error {
-	return nil
+	if bs.ChainSource == nil || bs.BlockchainDAI == nil {
+		return nil
+	}
+
+	if height <= 0 {
+		return fmt.Errorf("block height to rescan must greater than 0")
+	}
+
+	//从指定高度的上一个区块开始扫描
+	header, err := bs.ChainSource.GetBlockByHeight(height - 1)
+	if err != nil {
+		return err
+	}
+	header.Symbol = bs.ChainSource.Symbol()
+
+	return bs.BlockchainDAI.SaveCurrentBlockHead(header)
 }
 
 //SetTask
@@ -431,24 +462,76 @@ func (bs *BlockScannerBase) IsClose() bool {
 
 //ScanBlock 扫描指定高度区块
 func (bs *BlockScannerBase) ScanBlock(height uint64) error {
-	//扫描指定高度区块
-	return fmt.Errorf("ScanBlock is not implemented")
+	if bs.ChainSource == nil {
+		return fmt.Errorf("ScanBlock is not implemented")
+	}
+
+	block, err := bs.ChainSource.GetBlockByHeight(height)
+	if err != nil {
+		bs.saveUnscanRecord(NewUnscanRecord(height, "", err.Error(), bs.ChainSource.Symbol()))
+		return err
+	}
+
+	err = bs.scanBlockTransactions(block)
+	if err != nil {
+		return err
+	}
+
+	block.Symbol = bs.ChainSource.Symbol()
+	bs.NewBlockNotify(block)
+
+	return nil
 }
 
 //GetCurrentBlockHeight 获取当前区块高度
 func (bs *BlockScannerBase) GetCurrentBlockHeader() (*BlockHeader, error) {
-	return nil, fmt.Errorf("GetCurrentBlockHeader is not implemented")
+	if bs.ChainSource == nil || bs.BlockchainDAI == nil {
+		return nil, fmt.Errorf("GetCurrentBlockHeader is not implemented")
+	}
+
+	symbol := bs.ChainSource.Symbol()
+
+	header, err := bs.BlockchainDAI.GetCurrentBlockHead(symbol)
+	if err == nil && header != nil && header.Height > 0 {
+		return header, nil
+	}
+
+	//如果本地没有记录，以链上最新高度的上一个区块为当前区块
+	tip, err := bs.ChainSource.GetChainTip()
+	if err != nil {
+		return nil, err
+	}
+
+	if tip.Height <= 1 {
+		return tip, nil
+	}
+
+	return bs.ChainSource.GetBlockByHeight(tip.Height - 1)
 }
 
 //GetGlobalMaxBlockHeight 获取区块链全网最大高度
 //@required
 func (bs *BlockScannerBase) GetGlobalMaxBlockHeight() uint64 {
-	return 0
+	if bs.ChainSource == nil {
+		return 0
+	}
+	tip, err := bs.ChainSource.GetChainTip()
+	if err != nil {
+		return 0
+	}
+	return tip.Height
 }
 
 //GetScannedBlockHeight 获取已扫区块高度
 func (bs *BlockScannerBase) GetScannedBlockHeight() uint64 {
-	return 0
+	if bs.ChainSource == nil || bs.BlockchainDAI == nil {
+		return 0
+	}
+	header, err := bs.BlockchainDAI.GetCurrentBlockHead(bs.ChainSource.Symbol())
+	if err != nil || header == nil {
+		return 0
+	}
+	return header.Height
 }
 
 func (bs *BlockScannerBase) ExtractTransactionData(txid string, scanTargetFunc BlockScanTargetFunc) (map[string][]*TxExtractData, error) {
@@ -0,0 +1,245 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+//confirmWatch 等待最终确认的交易提取结果
+type confirmWatch struct {
+	sourceKey string
+	data      *TxExtractData
+	depth     uint64
+	confirm   int64
+}
+
+//SetConfirmDepth 设置币种的最终确认数，0则不跟踪确认进度
+func (bs *BlockScannerBase) SetConfirmDepth(symbol string, depth uint64) {
+	bs.confirmMu.Lock()
+	defer bs.confirmMu.Unlock()
+
+	if bs.confirmDepth == nil {
+		bs.confirmDepth = make(map[string]uint64)
+	}
+	if depth == 0 {
+		delete(bs.confirmDepth, symbol)
+		return
+	}
+	bs.confirmDepth[symbol] = depth
+}
+
+//GetConfirmDepth 获取币种的最终确认数
+func (bs *BlockScannerBase) GetConfirmDepth(symbol string) uint64 {
+	bs.confirmMu.Lock()
+	defer bs.confirmMu.Unlock()
+
+	return bs.confirmDepth[symbol]
+}
+
+//WatchConfirmations 加入确认跟踪，交易所在区块达到最终确认数前，新区块会重新通知确认进度
+func (bs *BlockScannerBase) WatchConfirmations(sourceKey string, data *TxExtractData) {
+
+	if data == nil || data.Transaction == nil {
+		return
+	}
+
+	bs.confirmMu.Lock()
+	defer bs.confirmMu.Unlock()
+
+	depth := bs.confirmDepth[data.Transaction.Coin.Symbol]
+	if depth == 0 {
+		return
+	}
+
+	if bs.confirmWatching == nil {
+		bs.confirmWatching = make(map[string]*confirmWatch)
+	}
+
+	key := fmt.Sprintf("%s_%s", sourceKey, data.Transaction.WxID)
+	w := &confirmWatch{
+		sourceKey: sourceKey,
+		data:      data,
+		depth:     depth,
+		confirm:   data.Transaction.Confirm,
+	}
+	bs.confirmWatching[key] = w
+	bs.saveConfirmWatch(key, w)
+}
+
+//UpdateConfirmations 根据当前已扫高度更新跟踪中交易的确认数，并通知观测者
+func (bs *BlockScannerBase) UpdateConfirmations(height uint64) {
+
+	type notify struct {
+		sourceKey string
+		data      *TxExtractData
+	}
+
+	notifies := make([]notify, 0)
+
+	bs.confirmMu.Lock()
+	for key, w := range bs.confirmWatching {
+		tx := w.data.Transaction
+		if height < tx.BlockHeight {
+			continue
+		}
+
+		//确认数未增长且未达到最终确认数则跳过，最终确认数为1时首次更新即完成
+		confirm := int64(height - tx.BlockHeight + 1)
+		if confirm <= w.confirm && uint64(confirm) < w.depth {
+			continue
+		}
+		w.confirm = confirm
+
+		data := *w.data
+		trx := *tx
+		trx.Confirm = confirm
+		data.Transaction = &trx
+		data.NotifyType = TxExtractNotifyTypeConfirm
+
+		if uint64(confirm) >= w.depth {
+			data.NotifyType = TxExtractNotifyTypeFinalized
+			delete(bs.confirmWatching, key)
+			bs.deleteConfirmWatch(key, tx.Coin.Symbol)
+		} else {
+			bs.saveConfirmWatch(key, w)
+		}
+
+		notifies = append(notifies, notify{sourceKey: w.sourceKey, data: &data})
+	}
+	bs.confirmMu.Unlock()
+
+	if len(notifies) == 0 {
+		return
+	}
+
+	bs.Mu.RLock()
+	defer bs.Mu.RUnlock()
+
+	for o := range bs.Observers {
+		for _, n := range notifies {
+			o.BlockExtractDataNotify(n.sourceKey, n.data)
+		}
+	}
+}
+
+//removeConfirmWatchByHeight 移除分叉区块的确认跟踪
+func (bs *BlockScannerBase) removeConfirmWatchByHeight(height uint64) {
+	bs.confirmMu.Lock()
+	defer bs.confirmMu.Unlock()
+
+	for key, w := range bs.confirmWatching {
+		if w.data.Transaction.BlockHeight == height {
+			delete(bs.confirmWatching, key)
+			bs.deleteConfirmWatch(key, w.data.Transaction.Coin.Symbol)
+		}
+	}
+}
+
+//LoadConfirmWatches 从BlockchainDAI恢复币种未完成的确认跟踪，重启后继续通知确认进度
+func (bs *BlockScannerBase) LoadConfirmWatches(symbol string) error {
+
+	dai, ok := bs.BlockchainDAI.(BlockchainConfirmDAI)
+	if !ok {
+		return nil
+	}
+
+	records, err := dai.GetConfirmWatches(symbol)
+	if err != nil {
+		return err
+	}
+
+	bs.confirmMu.Lock()
+	defer bs.confirmMu.Unlock()
+
+	if bs.confirmWatching == nil {
+		bs.confirmWatching = make(map[string]*confirmWatch)
+	}
+
+	for _, r := range records {
+		if r.Data == nil || r.Data.Transaction == nil {
+			continue
+		}
+		bs.confirmWatching[r.ID] = &confirmWatch{
+			sourceKey: r.SourceKey,
+			data:      r.Data,
+			depth:     r.Depth,
+			confirm:   r.Confirm,
+		}
+	}
+
+	return nil
+}
+
+//saveConfirmWatch 持久化确认跟踪，调用方需持有confirmMu
+func (bs *BlockScannerBase) saveConfirmWatch(key string, w *confirmWatch) {
+	dai, ok := bs.BlockchainDAI.(BlockchainConfirmDAI)
+	if !ok {
+		return
+	}
+	err := dai.SaveConfirmWatch(&ConfirmWatchRecord{
+		ID:        key,
+		Symbol:    w.data.Transaction.Coin.Symbol,
+		SourceKey: w.sourceKey,
+		Depth:     w.depth,
+		Confirm:   w.confirm,
+		Data:      w.data,
+	})
+	if err != nil {
+		log.Errorf("block scanner save confirm watch failed; unexpected error: %v", err)
+	}
+}
+
+//deleteConfirmWatch 删除持久化的确认跟踪，调用方需持有confirmMu
+func (bs *BlockScannerBase) deleteConfirmWatch(key, symbol string) {
+	dai, ok := bs.BlockchainDAI.(BlockchainConfirmDAI)
+	if !ok {
+		return
+	}
+	err := dai.DeleteConfirmWatch(key, symbol)
+	if err != nil {
+		log.Errorf("block scanner delete confirm watch failed; unexpected error: %v", err)
+	}
+}
+
+//ConfirmWatchRecord 持久化的确认跟踪记录
+type ConfirmWatchRecord struct {
+	ID        string         `json:"id" storm:"id"`
+	Symbol    string         `json:"symbol"`
+	SourceKey string         `json:"sourceKey"`
+	Depth     uint64         `json:"depth"`
+	Confirm   int64          `json:"confirm"`
+	Data      *TxExtractData `json:"data"`
+}
+
+//BlockchainConfirmDAI 可持久化确认跟踪的区块链数据访问接口，BlockchainDAI实现后扫描器重启不丢失确认进度
+type BlockchainConfirmDAI interface {
+	SaveConfirmWatch(record *ConfirmWatchRecord) error
+	DeleteConfirmWatch(id, symbol string) error
+	GetConfirmWatches(symbol string) ([]*ConfirmWatchRecord, error)
+}
+
+//BlockScanConfirmation 支持跟踪交易确认进度的扫描器
+type BlockScanConfirmation interface {
+
+	//SetConfirmDepth 设置币种的最终确认数
+	SetConfirmDepth(symbol string, depth uint64)
+
+	//GetConfirmDepth 获取币种的最终确认数
+	GetConfirmDepth(symbol string) uint64
+}
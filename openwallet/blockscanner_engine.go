@@ -0,0 +1,345 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+// BlockChainSource 区块链数据源
+// 适配器只需实现与节点交互的最小接口，区块遍历、分叉回滚、本地区块头保存由BlockScannerBase完成。
+type BlockChainSource interface {
+
+	//Symbol 主链币种标识
+	//@required
+	Symbol() string
+
+	//GetChainTip 获取链上最新区块头
+	//@required
+	GetChainTip() (*BlockHeader, error)
+
+	//GetBlockByHeight 获取指定高度的区块头
+	//@required
+	GetBlockByHeight(height uint64) (*BlockHeader, error)
+
+	//GetBlockByHash 获取指定hash的区块头
+	//@required
+	GetBlockByHash(hash string) (*BlockHeader, error)
+
+	//GetBlockTxIDs 获取区块包含的全部交易单ID
+	//@required
+	GetBlockTxIDs(header *BlockHeader) ([]string, error)
+
+	//ExtractTransaction 提取交易单与扫描对象相关的数据
+	//@required
+	ExtractTransaction(header *BlockHeader, txid string, scanTargetFunc BlockScanTargetFuncV2) (map[string][]*TxExtractData, map[string]*SmartContractReceipt, error)
+}
+
+//SetBlockChainSource 设置区块链数据源，启用通用扫描引擎
+func (bs *BlockScannerBase) SetBlockChainSource(source BlockChainSource) error {
+	if source == nil {
+		return fmt.Errorf("block chain source is nil")
+	}
+	bs.ChainSource = source
+	if bs.scanTask == nil {
+		bs.SetTask(bs.ScanBlockTask)
+	}
+	return nil
+}
+
+//ScanBlockTask 通用区块扫描任务，从本地已扫高度遍历到链上最新高度，遇到分叉自动回滚到共同祖先区块
+func (bs *BlockScannerBase) ScanBlockTask() {
+
+	if bs.ChainSource == nil || bs.BlockchainDAI == nil {
+		log.Errorf("block scanner has not set chain source or blockchain dai")
+		return
+	}
+
+	symbol := bs.ChainSource.Symbol()
+
+	//首次扫描恢复重启前未完成的确认跟踪
+	if !bs.confirmLoaded {
+		err := bs.LoadConfirmWatches(symbol)
+		if err != nil {
+			log.Errorf("block scanner can not load confirm watches; unexpected error: %v", err)
+		} else {
+			bs.confirmLoaded = true
+		}
+	}
+
+	//获取本地区块高度
+	local, err := bs.GetCurrentBlockHeader()
+	if err != nil {
+		log.Errorf("block scanner can not get local block header; unexpected error: %v", err)
+		return
+	}
+
+	for {
+
+		//获取最大高度
+		tip, err := bs.ChainSource.GetChainTip()
+		if err != nil {
+			log.Errorf("block scanner can not get chain tip; unexpected error: %v", err)
+			break
+		}
+
+		//是否已到最新高度
+		if local.Height >= tip.Height {
+			log.Infof("block scanner has scanned full chain data. Current height: %d", tip.Height)
+			break
+		}
+
+		//继续扫描下一个区块
+		nextHeight := local.Height + 1
+
+		log.Infof("block scanner scanning height: %d ...", nextHeight)
+
+		block, err := bs.ChainSource.GetBlockByHeight(nextHeight)
+		if err != nil {
+			log.Errorf("block scanner can not get new block data; unexpected error: %v", err)
+			//记录未扫区块
+			bs.saveUnscanRecord(NewUnscanRecord(nextHeight, "", err.Error(), symbol))
+			break
+		}
+
+		//判断hash是否上一区块的hash
+		if local.Hash != block.Previousblockhash {
+
+			log.Infof("block has been fork on height: %d.", nextHeight)
+			log.Infof("block height: %d local hash = %s ", local.Height, local.Hash)
+			log.Infof("block height: %d mainnet hash = %s ", local.Height, block.Previousblockhash)
+
+			ancestor, err := bs.rollbackToCommonAncestor(block)
+			if err != nil {
+				log.Errorf("block scanner can not rollback forked blocks; unexpected error: %v", err)
+				break
+			}
+
+			log.Infof("rescan block on height: %d, hash: %s .", ancestor.Height, ancestor.Hash)
+
+			//重新记录一个新扫描起点
+			ancestor.Symbol = symbol
+			bs.BlockchainDAI.SaveCurrentBlockHead(ancestor)
+			local = ancestor
+			continue
+		}
+
+		err = bs.scanBlockTransactions(block)
+		if err != nil {
+			log.Errorf("block scanner can not extract transactions; unexpected error: %v", err)
+		}
+
+		block.Symbol = symbol
+		block.Fork = false
+
+		//保存本地新高度
+		bs.BlockchainDAI.SaveLocalBlockHead(block)
+		bs.BlockchainDAI.SaveCurrentBlockHead(block)
+		local = block
+
+		//通知新区块给观测者
+		bs.NewBlockNotify(block)
+
+		//通知跟踪中交易的确认进度
+		bs.UpdateConfirmations(block.Height)
+	}
+
+	//重扫失败区块
+	bs.RescanFailedRecord()
+}
+
+//rollbackToCommonAncestor 沿新链的父区块回溯，直到与本地区块缓存的hash一致，
+//期间本地被孤立的区块以Fork=true通知观测者。本地缓存缺少回溯高度的区块时返回错误。
+func (bs *BlockScannerBase) rollbackToCommonAncestor(block *BlockHeader) (*BlockHeader, error) {
+
+	var (
+		symbol = bs.ChainSource.Symbol()
+		remote = block
+		depth  uint64
+	)
+
+	for remote.Height > 1 {
+
+		if bs.MaxReorgDepth > 0 && depth >= bs.MaxReorgDepth {
+			return nil, fmt.Errorf("reorg depth exceeds the limit: %d", bs.MaxReorgDepth)
+		}
+
+		parent, err := bs.ChainSource.GetBlockByHash(remote.Previousblockhash)
+		if err != nil {
+			return nil, err
+		}
+
+		local, err := bs.BlockchainDAI.GetLocalBlockHeadByHeight(parent.Height, symbol)
+		if err != nil || local == nil || len(local.Hash) == 0 {
+			//本地缓存已不存在该高度区块，无法确认共同祖先，也无法通知该高度以下的孤块，停止扫描等待人工处理
+			return nil, fmt.Errorf("block height: %d is not in local block cache, can not find the common ancestor", parent.Height)
+		}
+
+		if local.Hash == parent.Hash {
+			return local, nil
+		}
+
+		log.Infof("block height: %d hash: %s has been orphaned.", local.Height, local.Hash)
+
+		//删除孤块的未扫记录
+		bs.BlockchainDAI.DeleteUnscanRecordByHeight(local.Height, symbol)
+		bs.removeConfirmWatchByHeight(local.Height)
+
+		//通知观测者孤块，由上层回滚相关记录
+		orphan := *local
+		orphan.Fork = true
+		orphan.Symbol = symbol
+		bs.NewBlockNotify(&orphan)
+
+		remote = parent
+		depth++
+	}
+
+	return remote, nil
+}
+
+//scanBlockTransactions 提取区块全部交易单并通知观测者
+func (bs *BlockScannerBase) scanBlockTransactions(block *BlockHeader) error {
+
+	symbol := bs.ChainSource.Symbol()
+
+	txIDs, err := bs.ChainSource.GetBlockTxIDs(block)
+	if err != nil {
+		bs.saveUnscanRecord(NewUnscanRecord(block.Height, "", err.Error(), symbol))
+		return err
+	}
+
+	failed := 0
+	for _, txid := range txIDs {
+		err = bs.extractTransaction(block, txid)
+		if err != nil {
+			failed++
+			log.Errorf("block height: %d extract txid: %s failed; unexpected error: %v", block.Height, txid, err)
+			bs.saveUnscanRecord(NewUnscanRecord(block.Height, txid, err.Error(), symbol))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("block height: %d has %d transactions failed to extract", block.Height, failed)
+	}
+
+	return nil
+}
+
+//extractTransaction 提取单笔交易单并通知观测者
+func (bs *BlockScannerBase) extractTransaction(block *BlockHeader, txid string) error {
+
+	if bs.ScanTargetFuncV2 == nil {
+		return fmt.Errorf("BlockScanTargetFuncV2 is not set up")
+	}
+
+	txData, contractData, err := bs.ChainSource.ExtractTransaction(block, txid, bs.ScanTargetFuncV2)
+	if err != nil {
+		return err
+	}
+
+	bs.Mu.RLock()
+	defer bs.Mu.RUnlock()
+
+	for o := range bs.Observers {
+		for key, items := range txData {
+			for _, item := range items {
+				o.BlockExtractDataNotify(key, item)
+			}
+		}
+		for key, receipt := range contractData {
+			o.BlockExtractSmartContractDataNotify(key, receipt)
+		}
+	}
+
+	//加入确认跟踪
+	for key, items := range txData {
+		for _, item := range items {
+			bs.WatchConfirmations(key, item)
+		}
+	}
+
+	return nil
+}
+
+//RescanFailedRecord 重扫失败记录
+func (bs *BlockScannerBase) RescanFailedRecord() {
+
+	if bs.ChainSource == nil || bs.BlockchainDAI == nil {
+		return
+	}
+
+	symbol := bs.ChainSource.Symbol()
+
+	list, err := bs.BlockchainDAI.GetUnscanRecords(symbol)
+	if err != nil {
+		log.Errorf("block scanner can not get rescan data; unexpected error: %v", err)
+		return
+	}
+
+	blockMap := make(map[uint64][]string)
+	for _, r := range list {
+		if _, exist := blockMap[r.BlockHeight]; !exist {
+			blockMap[r.BlockHeight] = make([]string, 0)
+		}
+		if len(r.TxID) > 0 {
+			blockMap[r.BlockHeight] = append(blockMap[r.BlockHeight], r.TxID)
+		}
+	}
+
+	for height, txs := range blockMap {
+
+		if height == 0 {
+			continue
+		}
+
+		log.Infof("block scanner rescanning height: %d ...", height)
+
+		block, err := bs.ChainSource.GetBlockByHeight(height)
+		if err != nil {
+			log.Errorf("block scanner can not get block data; unexpected error: %v", err)
+			continue
+		}
+
+		//删除旧记录，失败会重新记录
+		bs.BlockchainDAI.DeleteUnscanRecordByHeight(height, symbol)
+
+		if len(txs) == 0 {
+			bs.scanBlockTransactions(block)
+			continue
+		}
+
+		for _, txid := range txs {
+			err = bs.extractTransaction(block, txid)
+			if err != nil {
+				bs.saveUnscanRecord(NewUnscanRecord(height, txid, err.Error(), symbol))
+			}
+		}
+	}
+}
+
+//saveUnscanRecord 记录未扫区块
+func (bs *BlockScannerBase) saveUnscanRecord(record *UnscanRecord) {
+	if bs.BlockchainDAI == nil {
+		return
+	}
+	err := bs.BlockchainDAI.SaveUnscanRecord(record)
+	if err != nil {
+		log.Errorf("block scanner save unscan record failed; unexpected error: %v", err)
+	}
+}
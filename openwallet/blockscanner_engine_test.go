@@ -0,0 +1,420 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testChainSource struct {
+	mu     sync.Mutex
+	blocks []*BlockHeader
+}
+
+func newTestChainSource(length int, tag string) *testChainSource {
+	src := &testChainSource{}
+	src.extend(1, length, tag)
+	return src
+}
+
+//extend 从指定高度开始替换并延长链，模拟分叉
+func (src *testChainSource) extend(from, to int, tag string) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	src.blocks = src.blocks[:from-1]
+	for h := from; h <= to; h++ {
+		prev := ""
+		if h > 1 {
+			prev = src.blocks[h-2].Hash
+		}
+		src.blocks = append(src.blocks, &BlockHeader{
+			Hash:              fmt.Sprintf("%s_%d", tag, h),
+			Previousblockhash: prev,
+			Height:            uint64(h),
+		})
+	}
+}
+
+func (src *testChainSource) Symbol() string {
+	return "TEST"
+}
+
+func (src *testChainSource) GetChainTip() (*BlockHeader, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	b := *src.blocks[len(src.blocks)-1]
+	return &b, nil
+}
+
+func (src *testChainSource) GetBlockByHeight(height uint64) (*BlockHeader, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if height == 0 || height > uint64(len(src.blocks)) {
+		return nil, fmt.Errorf("block height: %d not found", height)
+	}
+	b := *src.blocks[height-1]
+	return &b, nil
+}
+
+func (src *testChainSource) GetBlockByHash(hash string) (*BlockHeader, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	for _, b := range src.blocks {
+		if b.Hash == hash {
+			c := *b
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("block hash: %s not found", hash)
+}
+
+func (src *testChainSource) GetBlockTxIDs(header *BlockHeader) ([]string, error) {
+	return []string{"tx_" + header.Hash}, nil
+}
+
+func (src *testChainSource) ExtractTransaction(header *BlockHeader, txid string, scanTargetFunc BlockScanTargetFuncV2) (map[string][]*TxExtractData, map[string]*SmartContractReceipt, error) {
+	data := NewBlockExtractData()
+	data.Transaction = &Transaction{
+		WxID:        txid,
+		TxID:        txid,
+		Coin:        Coin{Symbol: src.Symbol()},
+		BlockHash:   header.Hash,
+		BlockHeight: header.Height,
+		Confirm:     1,
+	}
+	return map[string][]*TxExtractData{"acc": {data}}, map[string]*SmartContractReceipt{}, nil
+}
+
+type testBlockchainMemory struct {
+	BlockchainDAIBase
+	current  *BlockHeader
+	cache    map[uint64]*BlockHeader
+	watching map[string]*ConfirmWatchRecord
+}
+
+func (base *testBlockchainMemory) SaveConfirmWatch(record *ConfirmWatchRecord) error {
+	if base.watching == nil {
+		base.watching = make(map[string]*ConfirmWatchRecord)
+	}
+	base.watching[record.ID] = record
+	return nil
+}
+
+func (base *testBlockchainMemory) DeleteConfirmWatch(id, symbol string) error {
+	delete(base.watching, id)
+	return nil
+}
+
+func (base *testBlockchainMemory) GetConfirmWatches(symbol string) ([]*ConfirmWatchRecord, error) {
+	list := make([]*ConfirmWatchRecord, 0)
+	for _, r := range base.watching {
+		if r.Symbol == symbol {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+func (base *testBlockchainMemory) SaveCurrentBlockHead(header *BlockHeader) error {
+	base.current = header
+	return nil
+}
+
+func (base *testBlockchainMemory) GetCurrentBlockHead(symbol string) (*BlockHeader, error) {
+	if base.current == nil {
+		return &BlockHeader{}, nil
+	}
+	return base.current, nil
+}
+
+func (base *testBlockchainMemory) SaveLocalBlockHead(header *BlockHeader) error {
+	base.cache[header.Height] = header
+	return nil
+}
+
+func (base *testBlockchainMemory) GetLocalBlockHeadByHeight(height uint64, symbol string) (*BlockHeader, error) {
+	header, ok := base.cache[height]
+	if !ok {
+		return nil, fmt.Errorf("block height: %d not found", height)
+	}
+	return header, nil
+}
+
+func (base *testBlockchainMemory) SaveUnscanRecord(record *UnscanRecord) error {
+	return nil
+}
+
+func (base *testBlockchainMemory) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	return nil
+}
+
+func (base *testBlockchainMemory) GetUnscanRecords(symbol string) ([]*UnscanRecord, error) {
+	return nil, nil
+}
+
+type testBlockObserver struct {
+	mu        sync.Mutex
+	forked    []*BlockHeader
+	extracted []*TxExtractData
+}
+
+func (o *testBlockObserver) BlockScanNotify(header *BlockHeader) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if header.Fork {
+		o.forked = append(o.forked, header)
+	}
+	return nil
+}
+
+func (o *testBlockObserver) BlockExtractDataNotify(sourceKey string, data *TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.extracted = append(o.extracted, data)
+	return nil
+}
+
+func (o *testBlockObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *SmartContractReceipt) error {
+	return nil
+}
+
+func TestBlockScannerBase_ScanBlockTaskReorg(t *testing.T) {
+
+	src := newTestChainSource(10, "a")
+	dai := &testBlockchainMemory{cache: make(map[uint64]*BlockHeader)}
+	obs := &testBlockObserver{}
+
+	bs := NewBlockScannerBase()
+	defer bs.CloseBlockScanner()
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockChainSource(src)
+	bs.SetBlockScanTargetFuncV2(func(target ScanTargetParam) ScanTargetResult {
+		return ScanTargetResult{}
+	})
+	bs.AddObserver(obs)
+
+	//从高度1开始扫描
+	if err := bs.SetRescanBlockHeight(2); err != nil {
+		t.Errorf("SetRescanBlockHeight unexpected error: %v", err)
+		return
+	}
+
+	bs.ScanBlockTask()
+	if h := bs.GetScannedBlockHeight(); h != 10 {
+		t.Errorf("scanned height = %d, want 10", h)
+		return
+	}
+
+	//高度7以后发生深度分叉
+	src.extend(7, 12, "b")
+	bs.ScanBlockTask()
+
+	current, _ := bs.GetCurrentBlockHeader()
+	if current.Height != 12 || current.Hash != "b_12" {
+		t.Errorf("current block = %d %s, want 12 b_12", current.Height, current.Hash)
+		return
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+	if len(obs.forked) != 4 {
+		t.Errorf("forked blocks = %d, want 4", len(obs.forked))
+		return
+	}
+	for i, b := range obs.forked {
+		want := fmt.Sprintf("a_%d", 10-i)
+		if b.Hash != want {
+			t.Errorf("forked block %d = %s, want %s", i, b.Hash, want)
+		}
+	}
+}
+
+func TestBlockScannerBase_ScanBlockTaskReorgWithoutLocalCache(t *testing.T) {
+
+	src := newTestChainSource(10, "a")
+	dai := &testBlockchainMemory{cache: make(map[uint64]*BlockHeader)}
+	obs := &testBlockObserver{}
+
+	bs := NewBlockScannerBase()
+	defer bs.CloseBlockScanner()
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockChainSource(src)
+	bs.SetBlockScanTargetFuncV2(func(target ScanTargetParam) ScanTargetResult {
+		return ScanTargetResult{}
+	})
+	bs.AddObserver(obs)
+
+	if err := bs.SetRescanBlockHeight(2); err != nil {
+		t.Errorf("SetRescanBlockHeight unexpected error: %v", err)
+		return
+	}
+	bs.ScanBlockTask()
+
+	//本地缓存缺少分叉范围内的区块，不能把新链的区块当作共同祖先
+	delete(dai.cache, 8)
+	src.extend(7, 12, "b")
+
+	if _, err := bs.rollbackToCommonAncestor(src.blocks[11]); err == nil {
+		t.Errorf("rollbackToCommonAncestor should return error")
+	}
+
+	bs.ScanBlockTask()
+
+	current, _ := bs.GetCurrentBlockHeader()
+	if current.Height != 10 || current.Hash != "a_10" {
+		t.Errorf("current block = %d %s, want 10 a_10", current.Height, current.Hash)
+	}
+}
+
+func TestBlockScannerBase_UpdateConfirmations(t *testing.T) {
+
+	src := newTestChainSource(3, "a")
+	dai := &testBlockchainMemory{cache: make(map[uint64]*BlockHeader)}
+	obs := &testBlockObserver{}
+
+	bs := NewBlockScannerBase()
+	defer bs.CloseBlockScanner()
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockChainSource(src)
+	bs.SetBlockScanTargetFuncV2(func(target ScanTargetParam) ScanTargetResult {
+		return ScanTargetResult{}
+	})
+	bs.SetConfirmDepth(src.Symbol(), 3)
+	bs.AddObserver(obs)
+
+	//只扫描高度3
+	bs.SetRescanBlockHeight(3)
+	bs.ScanBlockTask()
+
+	src.extend(4, 5, "a")
+	bs.ScanBlockTask()
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+
+	//按交易单归类通知的确认进度
+	got := make(map[string][]string)
+	for _, data := range obs.extracted {
+		txid := data.Transaction.TxID
+		got[txid] = append(got[txid], fmt.Sprintf("%d:%d", data.NotifyType, data.Transaction.Confirm))
+	}
+
+	want := map[string]string{
+		"tx_a_3": "[0:1 1:2 2:3]",
+		"tx_a_4": "[0:1 1:2]",
+		"tx_a_5": "[0:1]",
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("notified transactions = %d, want %d", len(got), len(want))
+		return
+	}
+	for txid, w := range want {
+		if g := fmt.Sprintf("%v", got[txid]); g != w {
+			t.Errorf("%s notifies = %s, want %s", txid, g, w)
+		}
+	}
+}
+
+func TestBlockScannerBase_UpdateConfirmationsDepthOne(t *testing.T) {
+
+	src := newTestChainSource(3, "a")
+	dai := &testBlockchainMemory{cache: make(map[uint64]*BlockHeader)}
+	obs := &testBlockObserver{}
+
+	bs := NewBlockScannerBase()
+	defer bs.CloseBlockScanner()
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockChainSource(src)
+	bs.SetBlockScanTargetFuncV2(func(target ScanTargetParam) ScanTargetResult {
+		return ScanTargetResult{}
+	})
+	bs.SetConfirmDepth(src.Symbol(), 1)
+	bs.AddObserver(obs)
+
+	bs.SetRescanBlockHeight(3)
+	bs.ScanBlockTask()
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+
+	//最终确认数为1，所在区块扫描完成即达到最终确认
+	got := make([]string, 0)
+	for _, data := range obs.extracted {
+		got = append(got, fmt.Sprintf("%d:%d", data.NotifyType, data.Transaction.Confirm))
+	}
+	if g := fmt.Sprintf("%v", got); g != "[0:1 2:1]" {
+		t.Errorf("notifies = %s, want [0:1 2:1]", g)
+	}
+	if len(dai.watching) != 0 {
+		t.Errorf("persisted confirm watches = %d, want 0", len(dai.watching))
+	}
+}
+
+func TestBlockScannerBase_LoadConfirmWatches(t *testing.T) {
+
+	src := newTestChainSource(3, "a")
+	dai := &testBlockchainMemory{cache: make(map[uint64]*BlockHeader)}
+
+	bs := NewBlockScannerBase()
+	bs.SetBlockchainDAI(dai)
+	bs.SetBlockChainSource(src)
+	bs.SetBlockScanTargetFuncV2(func(target ScanTargetParam) ScanTargetResult {
+		return ScanTargetResult{}
+	})
+	bs.SetConfirmDepth(src.Symbol(), 3)
+	bs.SetRescanBlockHeight(3)
+	bs.ScanBlockTask()
+	bs.CloseBlockScanner()
+
+	if len(dai.watching) != 1 {
+		t.Errorf("persisted confirm watches = %d, want 1", len(dai.watching))
+		return
+	}
+
+	//模拟重启，新扫描器从BlockchainDAI恢复确认跟踪
+	obs := &testBlockObserver{}
+	restarted := NewBlockScannerBase()
+	defer restarted.CloseBlockScanner()
+	restarted.SetBlockchainDAI(dai)
+	restarted.SetBlockChainSource(src)
+	restarted.SetBlockScanTargetFuncV2(func(target ScanTargetParam) ScanTargetResult {
+		return ScanTargetResult{}
+	})
+	restarted.SetConfirmDepth(src.Symbol(), 3)
+	restarted.AddObserver(obs)
+
+	src.extend(4, 5, "a")
+	restarted.ScanBlockTask()
+
+	obs.mu.Lock()
+	defer obs.mu.Unlock()
+
+	got := make([]string, 0)
+	for _, data := range obs.extracted {
+		if data.Transaction.TxID == "tx_a_3" {
+			got = append(got, fmt.Sprintf("%d:%d", data.NotifyType, data.Transaction.Confirm))
+		}
+	}
+	if g := fmt.Sprintf("%v", got); g != "[1:2 2:3]" {
+		t.Errorf("tx_a_3 notifies = %s, want [1:2 2:3]", g)
+	}
+}
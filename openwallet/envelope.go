@@ -0,0 +1,366 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/crypto"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+//签名信封格式
+//
+// 完整信封：owenv1:<base64url(json内容 + 4字节校验和)>
+// 分段信封：owenvp:<校验和hex>:<序号>/<总数>:<完整信封的分段>，用于二维码等有长度限制的传输
+// 校验和为json内容两次SHA256的前4字节
+const (
+	EnvelopeVersion     = 1
+	EnvelopePrefix      = "owenv1:"
+	EnvelopeChunkPrefix = "owenvp:"
+
+	envelopeChecksumLen = 4
+)
+
+//信封内容类型
+const (
+	EnvelopeTypeRawTransaction              = "rawTransaction"
+	EnvelopeTypeSmartContractRawTransaction = "smartContractRawTransaction"
+)
+
+//SigningEnvelope 离线签名信封，用于在联网机器和离线签名机器之间传递待签名的交易单
+type SigningEnvelope struct {
+	Version uint32          `json:"v"`
+	Type    string          `json:"t"` //内容类型
+	Payload json.RawMessage `json:"p"` //交易单json
+}
+
+//NewRawTransactionEnvelope 把交易单装入签名信封
+func NewRawTransactionEnvelope(rawTx *RawTransaction) (*SigningEnvelope, error) {
+	return newSigningEnvelope(EnvelopeTypeRawTransaction, rawTx)
+}
+
+//NewSmartContractEnvelope 把智能合约交易单装入签名信封
+func NewSmartContractEnvelope(rawTx *SmartContractRawTransaction) (*SigningEnvelope, error) {
+	return newSigningEnvelope(EnvelopeTypeSmartContractRawTransaction, rawTx)
+}
+
+func newSigningEnvelope(envType string, rawTx interface{}) (*SigningEnvelope, error) {
+	payload, err := json.Marshal(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningEnvelope{
+		Version: EnvelopeVersion,
+		Type:    envType,
+		Payload: payload,
+	}, nil
+}
+
+//RawTransaction 解析信封中的交易单
+func (env *SigningEnvelope) RawTransaction() (*RawTransaction, error) {
+	if env.Type != EnvelopeTypeRawTransaction {
+		return nil, fmt.Errorf("envelope type: %s is not %s", env.Type, EnvelopeTypeRawTransaction)
+	}
+	var rawTx RawTransaction
+	err := json.Unmarshal(env.Payload, &rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return &rawTx, nil
+}
+
+//SmartContractRawTransaction 解析信封中的智能合约交易单
+func (env *SigningEnvelope) SmartContractRawTransaction() (*SmartContractRawTransaction, error) {
+	if env.Type != EnvelopeTypeSmartContractRawTransaction {
+		return nil, fmt.Errorf("envelope type: %s is not %s", env.Type, EnvelopeTypeSmartContractRawTransaction)
+	}
+	var rawTx SmartContractRawTransaction
+	err := json.Unmarshal(env.Payload, &rawTx)
+	if err != nil {
+		return nil, err
+	}
+	return &rawTx, nil
+}
+
+//Encode 编码为带版本和校验和的文本
+func (env *SigningEnvelope) Encode() (string, error) {
+	content, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	data := append(content, envelopeChecksum(content)...)
+	return EnvelopePrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+//Split 编码并按chunkSize分段，每段可以单独生成二维码，chunkSize <= 0 时不分段
+func (env *SigningEnvelope) Split(chunkSize int) ([]string, error) {
+	encoded, err := env.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if chunkSize <= 0 || len(encoded) <= chunkSize {
+		return []string{encoded}, nil
+	}
+
+	data, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encoded, EnvelopePrefix))
+	id := hex.EncodeToString(data[len(data)-envelopeChecksumLen:])
+
+	total := (len(encoded) + chunkSize - 1) / chunkSize
+	chunks := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		chunks = append(chunks, fmt.Sprintf("%s%s:%d/%d:%s", EnvelopeChunkPrefix, id, i+1, total, encoded[i*chunkSize:end]))
+	}
+	return chunks, nil
+}
+
+//DecodeEnvelope 解码信封，支持完整信封或任意顺序的全部分段
+func DecodeEnvelope(texts ...string) (*SigningEnvelope, error) {
+
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("envelope is empty")
+	}
+
+	encoded := strings.TrimSpace(texts[0])
+	if strings.HasPrefix(encoded, EnvelopeChunkPrefix) {
+		joined, err := joinEnvelopeChunks(texts)
+		if err != nil {
+			return nil, err
+		}
+		encoded = joined
+	} else if len(texts) > 1 {
+		return nil, fmt.Errorf("only one complete envelope can be decoded")
+	}
+
+	if !strings.HasPrefix(encoded, EnvelopePrefix) {
+		return nil, fmt.Errorf("envelope version is not supported")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encoded, EnvelopePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("envelope decode failed, unexpected error: %v", err)
+	}
+	if len(data) <= envelopeChecksumLen {
+		return nil, fmt.Errorf("envelope is too short")
+	}
+
+	content := data[:len(data)-envelopeChecksumLen]
+	if !bytes.Equal(envelopeChecksum(content), data[len(data)-envelopeChecksumLen:]) {
+		return nil, fmt.Errorf("envelope checksum is invalid")
+	}
+
+	var env SigningEnvelope
+	err = json.Unmarshal(content, &env)
+	if err != nil {
+		return nil, fmt.Errorf("envelope decode failed, unexpected error: %v", err)
+	}
+
+	if env.Version != EnvelopeVersion {
+		return nil, fmt.Errorf("envelope version: %d is not supported", env.Version)
+	}
+
+	return &env, nil
+}
+
+//joinEnvelopeChunks 合并分段信封
+func joinEnvelopeChunks(chunks []string) (string, error) {
+
+	var (
+		id    string
+		total int
+		parts map[int]string
+	)
+
+	for _, chunk := range chunks {
+		chunk = strings.TrimSpace(chunk)
+		if !strings.HasPrefix(chunk, EnvelopeChunkPrefix) {
+			return "", fmt.Errorf("envelope chunk is invalid")
+		}
+		fields := strings.SplitN(strings.TrimPrefix(chunk, EnvelopeChunkPrefix), ":", 3)
+		if len(fields) != 3 {
+			return "", fmt.Errorf("envelope chunk is invalid")
+		}
+
+		seq := strings.SplitN(fields[1], "/", 2)
+		if len(seq) != 2 {
+			return "", fmt.Errorf("envelope chunk sequence is invalid")
+		}
+		index, err := strconv.Atoi(seq[0])
+		if err != nil {
+			return "", fmt.Errorf("envelope chunk sequence is invalid")
+		}
+		count, err := strconv.Atoi(seq[1])
+		if err != nil || count <= 0 || index <= 0 || index > count {
+			return "", fmt.Errorf("envelope chunk sequence is invalid")
+		}
+
+		if parts == nil {
+			id = fields[0]
+			total = count
+			parts = make(map[int]string, total)
+		} else if fields[0] != id || count != total {
+			return "", fmt.Errorf("envelope chunks are not from the same envelope")
+		}
+		parts[index] = fields[2]
+	}
+
+	var joined strings.Builder
+	for i := 1; i <= total; i++ {
+		part, ok := parts[i]
+		if !ok {
+			return "", fmt.Errorf("envelope chunk: %d/%d is missing", i, total)
+		}
+		joined.WriteString(part)
+	}
+
+	return joined.String(), nil
+}
+
+func envelopeChecksum(content []byte) []byte {
+	hash := crypto.SHA256(crypto.SHA256(content))
+	return hash[:envelopeChecksumLen]
+}
+
+//Sign 使用HDKey签名信封中的交易单，只签名地址公钥属于该密钥且未签名的信息，
+//签名后重新装入信封，返回完成签名的数量
+func (env *SigningEnvelope) Sign(key *hdkeystore.HDKey) (int, error) {
+	return env.SignWithSigner(NewSoftwareSigner(key, nil))
+}
+
+//SignWithSigner 使用签名器签名信封中的交易单，签名器可以是硬件安全模块等外部签名器
+func (env *SigningEnvelope) SignWithSigner(signer Signer) (int, error) {
+
+	var (
+		signatures map[string][]*KeySignature
+		rawTx      *RawTransaction
+		contractTx *SmartContractRawTransaction
+		err        error
+	)
+
+	switch env.Type {
+	case EnvelopeTypeRawTransaction:
+		rawTx, err = env.RawTransaction()
+		if err != nil {
+			return 0, err
+		}
+		signatures = rawTx.Signatures
+	case EnvelopeTypeSmartContractRawTransaction:
+		contractTx, err = env.SmartContractRawTransaction()
+		if err != nil {
+			return 0, err
+		}
+		signatures = contractTx.Signatures
+	default:
+		return 0, fmt.Errorf("envelope type: %s is not supported", env.Type)
+	}
+
+	signed, err := SignKeySignaturesWithSigner(signer, signatures)
+	if err != nil {
+		return 0, err
+	}
+
+	var payload interface{}
+	if rawTx != nil {
+		rawTx.UpdateCompleted()
+		payload = rawTx
+	} else {
+		contractTx.IsCompleted = isAllSigned(contractTx.Signatures)
+		payload = contractTx
+	}
+
+	env.Payload, err = json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return signed, nil
+}
+
+//SignKeySignatures 使用HDKey按签名信息的HDPath衍生私钥签名，地址公钥与衍生的公钥不一致时跳过
+func SignKeySignatures(key *hdkeystore.HDKey, signatures map[string][]*KeySignature) (int, error) {
+	return SignKeySignaturesWithSigner(NewSoftwareSigner(key, nil), signatures)
+}
+
+//SignKeySignaturesWithSigner 使用签名器按签名信息的HDPath签名，地址公钥与签名器的公钥不一致时跳过，
+//没有地址公钥时返回错误
+func SignKeySignaturesWithSigner(signer Signer, signatures map[string][]*KeySignature) (int, error) {
+
+	signed := 0
+	for _, keySignatures := range signatures {
+		for _, keySignature := range keySignatures {
+			if keySignature == nil || len(keySignature.Signature) > 0 || keySignature.Address == nil || len(keySignature.Address.HDPath) == 0 {
+				continue
+			}
+
+			//没有地址公钥无法确认签名器持有该地址的密钥，拒绝签名
+			if len(keySignature.Address.PublicKey) == 0 {
+				return signed, fmt.Errorf("address: %s public key is empty, can not sign", keySignature.Address.Address)
+			}
+
+			publicKey, err := signer.PublicKey(keySignature.Address.HDPath, keySignature.EccType)
+			if err != nil {
+				return signed, err
+			}
+			if !isSignerPublicKey(publicKey, keySignature.Address.PublicKey, keySignature.EccType) {
+				continue
+			}
+
+			msg, err := hex.DecodeString(keySignature.Message)
+			if err != nil {
+				return signed, fmt.Errorf("signature message is not hex, unexpected error: %v", err)
+			}
+
+			signature, v, err := signer.Sign(keySignature.Address.HDPath, keySignature.EccType, msg)
+			if err != nil {
+				return signed, fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
+			}
+
+			if keySignature.RSV {
+				signature = append(signature, v)
+			}
+
+			keySignature.Signature = hex.EncodeToString(signature)
+			signed++
+		}
+	}
+
+	return signed, nil
+}
+
+//isAllSigned 所有签名信息是否都有签名
+func isAllSigned(signatures map[string][]*KeySignature) bool {
+	if len(signatures) == 0 {
+		return false
+	}
+	for _, keySignatures := range signatures {
+		for _, keySignature := range keySignatures {
+			if keySignature == nil || len(keySignature.Signature) == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
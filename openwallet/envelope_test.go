@@ -0,0 +1,149 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/crypto"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+func TestSigningEnvelope_Sign(t *testing.T) {
+
+	key, err := hdkeystore.NewHDKey([]byte("envelope_test_seed_0123456789abcdef"), "envelope", hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Errorf("NewHDKey unexpected error: %v", err)
+		return
+	}
+
+	hdPath := "m/44'/88'/0'/0/0"
+	childKey, _ := key.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_SECP256K1)
+	pub := childKey.GetPublicKeyBytes()
+	msg := crypto.SHA256([]byte("envelope message"))
+
+	rawTx := &RawTransaction{
+		Coin:     Coin{Symbol: "BTC"},
+		Sid:      "envelope_sid",
+		RawHex:   strings.Repeat("ab", 200),
+		Required: 1,
+		Signatures: map[string][]*KeySignature{
+			"owner": {
+				{
+					EccType: owcrypt.ECC_CURVE_SECP256K1,
+					Address: &Address{Address: "addr", HDPath: hdPath, PublicKey: hex.EncodeToString(pub)},
+					Message: hex.EncodeToString(msg),
+				},
+				{
+					EccType: owcrypt.ECC_CURVE_SECP256K1,
+					Address: &Address{Address: "other", HDPath: hdPath, PublicKey: "02" + strings.Repeat("00", 32)},
+					Message: hex.EncodeToString(msg),
+				},
+			},
+		},
+	}
+
+	env, err := NewRawTransactionEnvelope(rawTx)
+	if err != nil {
+		t.Errorf("NewRawTransactionEnvelope unexpected error: %v", err)
+		return
+	}
+
+	chunks, err := env.Split(100)
+	if err != nil || len(chunks) < 2 {
+		t.Errorf("Split = %d chunks, err = %v", len(chunks), err)
+		return
+	}
+
+	//分段可以乱序合并
+	chunks[0], chunks[len(chunks)-1] = chunks[len(chunks)-1], chunks[0]
+	decoded, err := DecodeEnvelope(chunks...)
+	if err != nil {
+		t.Errorf("DecodeEnvelope unexpected error: %v", err)
+		return
+	}
+
+	if _, err = DecodeEnvelope(chunks[1:]...); err == nil {
+		t.Errorf("missing chunk should return error")
+	}
+
+	signed, err := decoded.Sign(key)
+	if err != nil || signed != 1 {
+		t.Errorf("Sign = %d, err = %v", signed, err)
+		return
+	}
+
+	encoded, _ := decoded.Encode()
+	tampered := []byte(encoded)
+	tampered[len(EnvelopePrefix)+5] ^= 1
+	if _, err = DecodeEnvelope(string(tampered)); err == nil {
+		t.Errorf("tampered envelope should return error")
+	}
+
+	result, err := DecodeEnvelope(encoded)
+	if err != nil {
+		t.Errorf("DecodeEnvelope unexpected error: %v", err)
+		return
+	}
+
+	signedTx, err := result.RawTransaction()
+	if err != nil {
+		t.Errorf("RawTransaction unexpected error: %v", err)
+		return
+	}
+
+	keySignatures := signedTx.Signatures["owner"]
+	if len(keySignatures[1].Signature) > 0 {
+		t.Errorf("signature of other public key should be skipped")
+	}
+
+	signature, _ := hex.DecodeString(keySignatures[0].Signature)
+	if owcrypt.Verify(childKey.GetUncompressedPublicKeyBytes(), nil, msg, signature, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		t.Errorf("signature verify failed")
+	}
+
+	if _, err = result.SmartContractRawTransaction(); err == nil {
+		t.Errorf("envelope type mismatch should return error")
+	}
+}
+
+func TestSignKeySignatures_PublicKeyRequired(t *testing.T) {
+
+	key, err := hdkeystore.NewHDKey([]byte("envelope_test_seed_0123456789abcdef"), "envelope", hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Errorf("NewHDKey unexpected error: %v", err)
+		return
+	}
+
+	signatures := map[string][]*KeySignature{
+		"owner": {
+			{
+				EccType: owcrypt.ECC_CURVE_SECP256K1,
+				Address: &Address{Address: "addr", HDPath: "m/44'/88'/0'/0/0"},
+				Message: hex.EncodeToString(crypto.SHA256([]byte("envelope message"))),
+			},
+		},
+	}
+
+	//没有地址公钥时不能盲签
+	signed, err := SignKeySignatures(key, signatures)
+	if err == nil || signed != 0 || len(signatures["owner"][0].Signature) > 0 {
+		t.Errorf("signature without public key should return error, signed = %d", signed)
+	}
+}
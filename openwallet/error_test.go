@@ -37,3 +37,28 @@ func TestConvertError(t *testing.T) {
 	jerr2, _ := json.Marshal(owerr2)
 	log.Infof("jerr2: %v", string(jerr2))
 }
+
+func TestIsSubmitRejected(t *testing.T) {
+	rejected := []error{
+		Errorf(ErrInsufficientFees, "fee too low"),
+		Errorf(ErrDustLimit, "dust"),
+	}
+	for _, err := range rejected {
+		if !IsSubmitRejected(err) {
+			t.Errorf("%v should be rejected", err)
+		}
+	}
+
+	//通用广播错误可能是超时或连接中断，结果未知
+	unknown := []error{
+		Errorf(ErrSubmitRawTransactionFailed, "connection reset"),
+		Errorf(ErrSubmitRawSmartContractTransactionFailed, "timeout"),
+		Errorf(ErrNonceInvaild, "nonce too low"),
+		fmt.Errorf("request timeout"),
+	}
+	for _, err := range unknown {
+		if IsSubmitRejected(err) {
+			t.Errorf("%v should not be rejected", err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+)
+
+//BIP32序列化扩展公钥的版本字节，ypub/zpub等前缀是BIP49/BIP84的约定
+var extendedPublicKeyVersions = map[string][]byte{
+	"xpub": {0x04, 0x88, 0xb2, 0x1e}, //BIP44 P2PKH
+	"ypub": {0x04, 0x9d, 0x7c, 0xb2}, //BIP49 P2SH-P2WPKH
+	"zpub": {0x04, 0xb2, 0x47, 0x46}, //BIP84 P2WPKH
+	"Ypub": {0x02, 0x95, 0xb4, 0x3f}, //多签P2SH-P2WSH
+	"Zpub": {0x02, 0xaa, 0x7e, 0xd3}, //多签P2WSH
+	"tpub": {0x04, 0x35, 0x87, 0xcf}, //测试网
+	"upub": {0x04, 0x4a, 0x52, 0x62}, //测试网BIP49
+	"vpub": {0x04, 0x5f, 0x1c, 0xf6}, //测试网BIP84
+}
+
+//AddressScriptType 地址脚本类型，作为AddressEncode的可选参数传给地址解析器
+type AddressScriptType string
+
+//扩展公钥前缀约定的地址脚本类型
+const (
+	ScriptTypeP2PKH      AddressScriptType = "p2pkh"       //BIP44
+	ScriptTypeP2SHP2WPKH AddressScriptType = "p2sh-p2wpkh" //BIP49
+	ScriptTypeP2WPKH     AddressScriptType = "p2wpkh"      //BIP84
+)
+
+//ExtParamKeyScriptType 资产账户ExtParam中记录地址脚本类型的字段
+const ExtParamKeyScriptType = "scriptType"
+
+//extendedPublicKeyScriptTypes 约定了地址脚本类型的前缀，xpub/tpub由资产适配器按默认类型生成地址
+var extendedPublicKeyScriptTypes = map[string]AddressScriptType{
+	"ypub": ScriptTypeP2SHP2WPKH,
+	"zpub": ScriptTypeP2WPKH,
+	"upub": ScriptTypeP2SHP2WPKH,
+	"vpub": ScriptTypeP2WPKH,
+}
+
+//unsupportedExtendedPublicKeys 多签扩展公钥约定了多签脚本，不能导入为单签账户公钥
+var unsupportedExtendedPublicKeys = map[string]string{
+	"Ypub": "multisig P2SH-P2WSH",
+	"Zpub": "multisig P2WSH",
+}
+
+const (
+	//owpubPrefixLen OW编码公钥的前缀长度
+	owpubPrefixLen = 5
+	//bip32KeyLen BIP32序列化扩展密钥的长度，不含校验和
+	bip32KeyLen = 78
+)
+
+//ParseExtendedPublicKey 解析账户扩展公钥，支持OW编码公钥（owpub），以及BIP32序列化的
+//xpub/ypub/zpub和测试网tpub/upub/vpub，BIP32格式只支持secp256k1曲线。
+//前缀约定的地址脚本类型通过ExtendedPublicKeyScriptType获取，多签的Ypub/Zpub会返回错误
+func ParseExtendedPublicKey(key string, curveType uint32) (*owkeychain.ExtendedKey, error) {
+
+	key = strings.TrimSpace(key)
+
+	data, err := base58CheckDecode(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(key, "owpub") {
+		if len(data) < owpubPrefixLen+4 {
+			return nil, fmt.Errorf("extended public key is invalid")
+		}
+		if keyCurve := binary.BigEndian.Uint32(data[owpubPrefixLen:]); keyCurve != curveType {
+			return nil, fmt.Errorf("extended public key curve: %X does not match the symbol curve: %X", keyCurve, curveType)
+		}
+		return owkeychain.OWDecode(key)
+	}
+
+	if len(key) < 4 {
+		return nil, fmt.Errorf("extended public key is invalid")
+	}
+	if scriptType, ok := unsupportedExtendedPublicKeys[key[:4]]; ok {
+		return nil, fmt.Errorf("extended public key prefix: %s (%s) is not supported by single signature account", key[:4], scriptType)
+	}
+	version, ok := extendedPublicKeyVersions[key[:4]]
+	if !ok {
+		return nil, fmt.Errorf("extended public key prefix: %s is not supported", key[:4])
+	}
+	if len(data) != bip32KeyLen || !bytes.Equal(data[:4], version) {
+		return nil, fmt.Errorf("extended public key is invalid")
+	}
+	if curveType != owcrypt.ECC_CURVE_SECP256K1 {
+		return nil, fmt.Errorf("%s only supports secp256k1 curve", key[:4])
+	}
+
+	pub := data[45:78]
+	if owcrypt.PointDecompress(pub, curveType) == nil {
+		return nil, fmt.Errorf("extended public key is not a valid point")
+	}
+
+	return owkeychain.NewExtendedKey(pub, data[13:45], data[5:9], data[4], binary.BigEndian.Uint32(data[9:13]), false, curveType), nil
+}
+
+//ExtendedPublicKeyScriptType 扩展公钥前缀约定的地址脚本类型，xpub/tpub和owpub没有约定，返回空值
+func ExtendedPublicKeyScriptType(key string) AddressScriptType {
+	key = strings.TrimSpace(key)
+	if len(key) < 4 {
+		return ""
+	}
+	return extendedPublicKeyScriptTypes[key[:4]]
+}
+
+//base58CheckDecode 解码base58check编码的数据，返回去掉校验和的内容
+func base58CheckDecode(key string) ([]byte, error) {
+	decoded, err := owkeychain.Decode(key, owkeychain.BitcoinAlphabet)
+	if err != nil || len(decoded) <= 4 {
+		return nil, fmt.Errorf("extended public key is not base58 encoded")
+	}
+	data := decoded[:len(decoded)-4]
+	checksum := owcrypt.Hash(data, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)[:4]
+	if !bytes.Equal(checksum, decoded[len(decoded)-4:]) {
+		return nil, fmt.Errorf("extended public key checksum is invalid")
+	}
+	return data, nil
+}
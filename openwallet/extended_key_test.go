@@ -0,0 +1,122 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+)
+
+//reencodeExtendedKey 替换BIP32扩展公钥的版本字节
+func reencodeExtendedKey(t *testing.T, key string, prefix string) string {
+	data, err := base58CheckDecode(key)
+	if err != nil {
+		t.Fatalf("base58CheckDecode unexpected error: %v", err)
+	}
+	data = append(append([]byte{}, extendedPublicKeyVersions[prefix]...), data[4:]...)
+	checksum := owcrypt.Hash(data, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)[:4]
+	return owkeychain.Encode(append(data, checksum...), owkeychain.BitcoinAlphabet)
+}
+
+func TestParseExtendedPublicKey(t *testing.T) {
+
+	//BIP32测试向量1，m/0'及其子公钥m/0'/1
+	xpub := "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+	wantPub := "035a784662a4a20a65bf6aab9ae98a6c068a81c52e4b032c0fb5400c706cfccc56"
+	wantChildPub := "03501e454bf00751f24b1b489aa925215d66af2234e3891c3b21a52bedb3cd711c"
+
+	accountKey, err := ParseExtendedPublicKey(xpub, owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("ParseExtendedPublicKey unexpected error: %v", err)
+	}
+	owpub := accountKey.OWEncode()
+
+	for _, key := range []string{xpub, owpub} {
+		pubkey, err := ParseExtendedPublicKey(key, owcrypt.ECC_CURVE_SECP256K1)
+		if err != nil {
+			t.Fatalf("ParseExtendedPublicKey(%s) unexpected error: %v", key[:4], err)
+		}
+		if pubkey.OWEncode() != owpub {
+			t.Errorf("ParseExtendedPublicKey(%s) = %s, want %s", key[:4], pubkey.OWEncode(), owpub)
+		}
+		if hex.EncodeToString(pubkey.GetPublicKeyBytes()) != wantPub {
+			t.Errorf("public key = %x, want %s", pubkey.GetPublicKeyBytes(), wantPub)
+		}
+
+		//非强化子公钥与BIP32衍生结果一致
+		child, err := pubkey.GenPublicChild(1)
+		if err != nil {
+			t.Fatalf("GenPublicChild unexpected error: %v", err)
+		}
+		if hex.EncodeToString(child.GetPublicKeyBytes()) != wantChildPub {
+			t.Errorf("child public key = %x, want %s", child.GetPublicKeyBytes(), wantChildPub)
+		}
+	}
+
+	//约定了地址脚本类型的前缀解析为同一账户公钥，并返回对应的脚本类型
+	scriptTypes := map[string]AddressScriptType{
+		"xpub": "",
+		"tpub": "",
+		"ypub": ScriptTypeP2SHP2WPKH,
+		"upub": ScriptTypeP2SHP2WPKH,
+		"zpub": ScriptTypeP2WPKH,
+		"vpub": ScriptTypeP2WPKH,
+	}
+	for prefix, want := range scriptTypes {
+		key := reencodeExtendedKey(t, xpub, prefix)
+		pubkey, err := ParseExtendedPublicKey(key, owcrypt.ECC_CURVE_SECP256K1)
+		if err != nil {
+			t.Fatalf("ParseExtendedPublicKey(%s) unexpected error: %v", prefix, err)
+		}
+		if pubkey.OWEncode() != owpub {
+			t.Errorf("ParseExtendedPublicKey(%s) = %s, want %s", prefix, pubkey.OWEncode(), owpub)
+		}
+		if scriptType := ExtendedPublicKeyScriptType(key); scriptType != want {
+			t.Errorf("ExtendedPublicKeyScriptType(%s) = %s, want %s", prefix, scriptType, want)
+		}
+	}
+	if scriptType := ExtendedPublicKeyScriptType(owpub); scriptType != "" {
+		t.Errorf("owpub should have no script type, got %s", scriptType)
+	}
+
+	invalid := []struct {
+		name      string
+		key       string
+		curveType uint32
+	}{
+		{"private key", "xprv9uHRZZhk6KAJC1avXpDAp4MDc3sQKNxDiPvvkX8Br5ngLNv1TxvUxt4cV1rGL5hj6KCesnDYUhd7oWgT11eZG7XnxHrnYeSvkzY7d2bhkJ7", owcrypt.ECC_CURVE_SECP256K1},
+		{"bad checksum", xpub[:len(xpub)-1] + "x", owcrypt.ECC_CURVE_SECP256K1},
+		{"xpub curve mismatch", xpub, owcrypt.ECC_CURVE_ED25519},
+		{"owpub curve mismatch", owpub, owcrypt.ECC_CURVE_SECP256R1},
+		{"not base58", "xpub0OIl", owcrypt.ECC_CURVE_SECP256K1},
+	}
+	//多签前缀不能导入为单签账户公钥
+	for prefix := range unsupportedExtendedPublicKeys {
+		invalid = append(invalid, struct {
+			name      string
+			key       string
+			curveType uint32
+		}{prefix, reencodeExtendedKey(t, xpub, prefix), owcrypt.ECC_CURVE_SECP256K1})
+	}
+	for _, v := range invalid {
+		if _, err := ParseExtendedPublicKey(v.key, v.curveType); err == nil {
+			t.Errorf("[%s] should return error", v.name)
+		}
+	}
+}
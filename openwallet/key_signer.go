@@ -0,0 +1,252 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+var (
+	//secp256k1曲线的阶
+	secp256k1N, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+	//sm2曲线的阶
+	sm2N, _ = new(big.Int).SetString("FFFFFFFEFFFFFFFFFFFFFFFFFFFFFFFF7203DF6B21C6052B53BBF40939D54123", 16)
+)
+
+//Signer 签名提供者，私钥可以保存在进程内、PKCS#11硬件安全模块或远程签名服务中，
+//调用方只通过HDPath指定密钥，不接触私钥
+type Signer interface {
+
+	//PublicKey 获取HDPath对应密钥的公钥，ECDSA曲线返回33字节压缩格式
+	PublicKey(hdPath string, eccType uint32) ([]byte, error)
+
+	//Sign 使用HDPath对应的私钥签名消息哈希，返回64字节签名和恢复标识v
+	Sign(hdPath string, eccType uint32, msg []byte) ([]byte, byte, error)
+}
+
+//PasswordVerifier 签名器可选实现，签名交易前验证调用方提供的钱包密码，例如比对硬件安全模块的用户PIN
+type PasswordVerifier interface {
+
+	//VerifyPassword 密码不正确时返回错误
+	VerifyPassword(password string) error
+}
+
+//KeyGenerator 签名器可选实现，在签名器中生成HDPath对应的新密钥对，例如不支持BIP32衍生的硬件安全模块
+type KeyGenerator interface {
+
+	//GenerateKey 生成HDPath对应的密钥对，返回33字节压缩格式公钥
+	GenerateKey(hdPath string, eccType uint32) ([]byte, error)
+}
+
+//SignerPublicKey 获取签名器HDPath对应的公钥，密钥不存在且签名器实现KeyGenerator时在签名器中生成新的密钥对
+func SignerPublicKey(signer Signer, hdPath string, eccType uint32) ([]byte, error) {
+	publicKey, err := signer.PublicKey(hdPath, eccType)
+	if err == nil {
+		return publicKey, nil
+	}
+
+	generator, ok := signer.(KeyGenerator)
+	if !ok {
+		return nil, err
+	}
+	return generator.GenerateKey(hdPath, eccType)
+}
+
+//SoftwareSigner 进程内软件签名器，使用解密后的HDKey衍生私钥签名
+type SoftwareSigner struct {
+	key      *hdkeystore.HDKey
+	txSigner TransactionSigner
+}
+
+//NewSoftwareSigner 创建软件签名器，txSigner为空时使用owcrypt签名
+func NewSoftwareSigner(key *hdkeystore.HDKey, txSigner TransactionSigner) *SoftwareSigner {
+	return &SoftwareSigner{key: key, txSigner: txSigner}
+}
+
+//PublicKey 获取HDPath衍生的公钥
+func (s *SoftwareSigner) PublicKey(hdPath string, eccType uint32) ([]byte, error) {
+	childKey, err := s.key.DerivedKeyWithPath(hdPath, eccType)
+	if err != nil {
+		return nil, err
+	}
+	return childKey.GetPublicKeyBytes(), nil
+}
+
+//Sign 使用HDPath衍生的私钥签名
+func (s *SoftwareSigner) Sign(hdPath string, eccType uint32, msg []byte) ([]byte, byte, error) {
+	childKey, err := s.key.DerivedKeyWithPath(hdPath, eccType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keyBytes, err := childKey.GetPrivateKeyBytes()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if s.txSigner != nil {
+		signature, err := s.txSigner.SignTransactionHash(msg, keyBytes, eccType)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(signature) == 65 {
+			return signature[:64], signature[64], nil
+		}
+		return NormalizeSignature(childKey.GetPublicKeyBytes(), msg, signature, eccType)
+	}
+
+	signature, v, ret := owcrypt.Signature(keyBytes, nil, msg, eccType)
+	if ret != owcrypt.SUCCESS {
+		return nil, 0, fmt.Errorf("transaction hash sign failed")
+	}
+
+	return signature, v, nil
+}
+
+//NormalizeSignature 规范外部签名器返回的64字节r||s签名，
+//secp256k1和secp256r1转换为低S值，ECDSA曲线通过公钥恢复计算v
+func NormalizeSignature(publicKey, msg, signature []byte, eccType uint32) ([]byte, byte, error) {
+
+	if len(signature) != 64 {
+		return nil, 0, fmt.Errorf("signature length: %d is invalid", len(signature))
+	}
+
+	var n *big.Int
+	switch eccType {
+	case owcrypt.ECC_CURVE_SECP256K1:
+		n = secp256k1N
+	case owcrypt.ECC_CURVE_SECP256R1:
+		n = elliptic.P256().Params().N
+	case owcrypt.ECC_CURVE_SM2_STANDARD:
+		n = sm2N
+	default:
+		//非ECDSA曲线没有恢复标识
+		return signature, 0, nil
+	}
+
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return nil, 0, fmt.Errorf("signature is invalid")
+	}
+
+	sig := make([]byte, 65)
+	copy(sig, signature)
+
+	//sm2签名不做低S值转换
+	if eccType != owcrypt.ECC_CURVE_SM2_STANDARD && s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+		copy(sig[32:64], make([]byte, 32))
+		sb := s.Bytes()
+		copy(sig[64-len(sb):64], sb)
+	}
+
+	uncompressed := uncompressedPublicKey(publicKey, eccType)
+	if uncompressed == nil {
+		return nil, 0, fmt.Errorf("public key is invalid")
+	}
+
+	for v := byte(0); v < 2; v++ {
+		sig[64] = v
+		pub, ret := owcrypt.RecoverPubkey(sig, msg, eccType)
+		if ret == owcrypt.SUCCESS && bytes.Equal(pub, uncompressed) {
+			return sig[:64], v, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("signature does not match the public key")
+}
+
+//uncompressedPublicKey 转换为64字节未压缩公钥，不支持的格式返回nil
+func uncompressedPublicKey(publicKey []byte, eccType uint32) []byte {
+	switch {
+	case len(publicKey) == 33:
+		point := owcrypt.PointDecompress(publicKey, eccType)
+		if len(point) != 65 {
+			return nil
+		}
+		return point[1:]
+	case len(publicKey) == 65 && publicKey[0] == 0x04:
+		return publicKey[1:]
+	case len(publicKey) == 64:
+		return publicKey
+	}
+	return nil
+}
+
+//SignRequiredKeySignaturesWithSigner 使用签名器签名，并检查必要的签名都已完成：
+//签名器没有产生签名，或签名器参与的拥有者仍有未签名的签名信息时返回错误；
+//requireAll为true时所有签名信息都必须有签名，用于单签交易，多签交易的其他拥有者另行签名
+func SignRequiredKeySignaturesWithSigner(signer Signer, signatures map[string][]*KeySignature, requireAll bool) (int, error) {
+
+	//记录签名前未签名的签名信息
+	pending := make(map[*KeySignature]bool)
+	for _, keySignatures := range signatures {
+		for _, keySignature := range keySignatures {
+			if keySignature != nil && len(keySignature.Signature) == 0 {
+				pending[keySignature] = true
+			}
+		}
+	}
+
+	signed, err := SignKeySignaturesWithSigner(signer, signatures)
+	if err != nil {
+		return signed, err
+	}
+
+	if signed == 0 {
+		return 0, fmt.Errorf("signer does not hold the key of any signature")
+	}
+
+	for owner, keySignatures := range signatures {
+		participated, completed := false, true
+		for _, keySignature := range keySignatures {
+			if keySignature == nil || len(keySignature.Signature) == 0 {
+				completed = false
+			} else if pending[keySignature] {
+				participated = true
+			}
+		}
+		if !completed && (participated || requireAll) {
+			return signed, fmt.Errorf("signatures of owner: %s are not completed by signer", owner)
+		}
+	}
+
+	return signed, nil
+}
+
+//isSignerPublicKey 地址公钥是否为签名器的公钥，支持压缩和未压缩格式
+func isSignerPublicKey(publicKey []byte, addressPublicKey string, eccType uint32) bool {
+	pub := strings.ToLower(strings.TrimPrefix(addressPublicKey, "0x"))
+	if pub == hex.EncodeToString(publicKey) {
+		return true
+	}
+
+	uncompressed := uncompressedPublicKey(publicKey, eccType)
+	if uncompressed == nil {
+		return false
+	}
+	compressed := hex.EncodeToString(owcrypt.PointCompress(uncompressed, eccType))
+	return pub == compressed || pub == hex.EncodeToString(uncompressed) || pub == "04"+hex.EncodeToString(uncompressed)
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/crypto"
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+)
+
+//highSTransactionSigner 返回高S值且没有v的签名，模拟硬件签名器的输出
+type highSTransactionSigner struct {
+	TransactionSignerBase
+}
+
+func (signer *highSTransactionSigner) SignTransactionHash(msg []byte, privateKey []byte, eccType uint32) ([]byte, error) {
+	signature, _, _ := owcrypt.Signature(privateKey, nil, msg, eccType)
+	n := secp256k1N
+	if eccType == owcrypt.ECC_CURVE_SECP256R1 {
+		n = elliptic.P256().Params().N
+	}
+	s := new(big.Int).Sub(n, new(big.Int).SetBytes(signature[32:]))
+	highS := make([]byte, 64)
+	copy(highS, signature[:32])
+	sb := s.Bytes()
+	copy(highS[64-len(sb):], sb)
+	return highS, nil
+}
+
+func TestSignKeySignaturesWithSigner(t *testing.T) {
+
+	key, err := hdkeystore.NewHDKey([]byte("key_signer_test_seed_0123456789abcdef"), "signer", hdkeystore.OpenwCoinTypePath)
+	if err != nil {
+		t.Fatalf("NewHDKey unexpected error: %v", err)
+	}
+
+	hdPath := "m/44'/88'/0'/0/1"
+	msg := crypto.SHA256([]byte("key signer message"))
+
+	for _, eccType := range []uint32{owcrypt.ECC_CURVE_SECP256K1, owcrypt.ECC_CURVE_SECP256R1} {
+
+		childKey, _ := key.DerivedKeyWithPath(hdPath, eccType)
+		uncompressed := childKey.GetUncompressedPublicKeyBytes()
+		signatures := map[string][]*KeySignature{
+			"owner": {
+				{
+					EccType: eccType,
+					Address: &Address{Address: "addr", HDPath: hdPath, PublicKey: "04" + hex.EncodeToString(uncompressed)},
+					Message: hex.EncodeToString(msg),
+					RSV:     true,
+				},
+			},
+		}
+
+		signer := NewSoftwareSigner(key, &highSTransactionSigner{})
+		signed, err := SignKeySignaturesWithSigner(signer, signatures)
+		if err != nil || signed != 1 {
+			t.Fatalf("SignKeySignaturesWithSigner = %d, err = %v", signed, err)
+		}
+
+		signature, _ := hex.DecodeString(signatures["owner"][0].Signature)
+		if len(signature) != 65 {
+			t.Fatalf("signature length = %d, want 65", len(signature))
+		}
+		if owcrypt.Verify(uncompressed, nil, msg, signature[:64], eccType) != owcrypt.SUCCESS {
+			t.Errorf("signature verify failed")
+		}
+
+		//规范后的签名为低S值
+		n := secp256k1N
+		if eccType == owcrypt.ECC_CURVE_SECP256R1 {
+			n = elliptic.P256().Params().N
+		}
+		if new(big.Int).SetBytes(signature[32:64]).Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+			t.Errorf("signature s is not normalized: %x", signature[32:64])
+		}
+
+		pub, ret := owcrypt.RecoverPubkey(signature, msg, eccType)
+		if ret != owcrypt.SUCCESS || !bytes.Equal(pub, uncompressed) {
+			t.Errorf("recovered public key = %x, want %x", pub, uncompressed)
+		}
+	}
+
+	childKey, _ := key.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_SECP256K1)
+	if _, _, err := NormalizeSignature(childKey.GetPublicKeyBytes(), msg, make([]byte, 64), owcrypt.ECC_CURVE_SECP256K1); err == nil {
+		t.Errorf("invalid signature should return error")
+	}
+}
+
+func TestSignRequiredKeySignaturesWithSigner(t *testing.T) {
+
+	key, _ := hdkeystore.NewHDKey([]byte("key_signer_test_seed_0123456789abcdef"), "signer", hdkeystore.OpenwCoinTypePath)
+	other, _ := hdkeystore.NewHDKey([]byte("key_signer_test_seed_fedcba9876543210"), "other", hdkeystore.OpenwCoinTypePath)
+	hdPath := "m/44'/88'/0'/0/1"
+	msg := crypto.SHA256([]byte("key signer message"))
+
+	newKeySignature := func(k *hdkeystore.HDKey) *KeySignature {
+		childKey, _ := k.DerivedKeyWithPath(hdPath, owcrypt.ECC_CURVE_SECP256K1)
+		return &KeySignature{
+			EccType: owcrypt.ECC_CURVE_SECP256K1,
+			Address: &Address{Address: "addr", HDPath: hdPath, PublicKey: hex.EncodeToString(childKey.GetPublicKeyBytes())},
+			Message: hex.EncodeToString(msg),
+		}
+	}
+
+	signer := NewSoftwareSigner(key, nil)
+
+	//多签交易其他拥有者的签名另行收集
+	signatures := map[string][]*KeySignature{
+		"owner": {newKeySignature(key)},
+		"other": {newKeySignature(other)},
+	}
+	if signed, err := SignRequiredKeySignaturesWithSigner(signer, signatures, false); err != nil || signed != 1 {
+		t.Errorf("SignRequiredKeySignaturesWithSigner = %d, err = %v", signed, err)
+	}
+
+	//单签交易要求全部签名
+	signatures = map[string][]*KeySignature{
+		"owner": {newKeySignature(key)},
+		"other": {newKeySignature(other)},
+	}
+	if _, err := SignRequiredKeySignaturesWithSigner(signer, signatures, true); err == nil {
+		t.Errorf("incomplete signatures should return error")
+	}
+
+	//签名器参与的拥有者签名不完整
+	signatures = map[string][]*KeySignature{
+		"owner": {newKeySignature(key), newKeySignature(other)},
+	}
+	if _, err := SignRequiredKeySignaturesWithSigner(signer, signatures, false); err == nil {
+		t.Errorf("incomplete owner signatures should return error")
+	}
+}
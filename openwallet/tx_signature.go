@@ -0,0 +1,178 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blocktree/go-owcrypt"
+)
+
+//RequiredSignatures 交易单的必要签名数，多签账户以账户的必要签名数为准，
+//其他没有设置时使用账户的必要签名数，默认为1
+func (rawtx *RawTransaction) RequiredSignatures() uint64 {
+	if rawtx.Account != nil && rawtx.Account.IsMultiSig() {
+		return rawtx.Account.Required
+	}
+	if rawtx.Required > 0 {
+		return rawtx.Required
+	}
+	if rawtx.Account != nil && rawtx.Account.Required > 0 {
+		return rawtx.Account.Required
+	}
+	return 1
+}
+
+//SignedOwners 已完成签名的拥有者accountID，拥有者的每个签名信息都有签名才算完成
+func (rawtx *RawTransaction) SignedOwners() []string {
+	owners := make([]string, 0)
+	for owner, keySignatures := range rawtx.Signatures {
+		if len(keySignatures) == 0 {
+			continue
+		}
+		signed := true
+		for _, keySignature := range keySignatures {
+			if keySignature == nil || len(keySignature.Signature) == 0 {
+				signed = false
+				break
+			}
+		}
+		if signed {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+//SignatureCount 已收集的签名数，以完成签名的拥有者计算
+func (rawtx *RawTransaction) SignatureCount() uint64 {
+	return uint64(len(rawtx.SignedOwners()))
+}
+
+//UpdateCompleted 根据已收集的签名数更新IsCompleted，必要签名数为0时不能完成
+func (rawtx *RawTransaction) UpdateCompleted() bool {
+	required := rawtx.RequiredSignatures()
+	rawtx.IsCompleted = required > 0 && rawtx.SignatureCount() >= required
+	return rawtx.IsCompleted
+}
+
+//VerifySignature 使用地址公钥验证签名是否为被签消息的有效签名
+func (ks *KeySignature) VerifySignature() error {
+
+	if ks.Address == nil || len(ks.Address.PublicKey) == 0 {
+		return fmt.Errorf("public key of signature is empty")
+	}
+
+	pub, err := hex.DecodeString(strings.TrimPrefix(ks.Address.PublicKey, "0x"))
+	if err != nil {
+		return fmt.Errorf("public key of signature is invalid")
+	}
+
+	msg, err := hex.DecodeString(strings.TrimPrefix(ks.Message, "0x"))
+	if err != nil || len(msg) == 0 {
+		return fmt.Errorf("message of signature is invalid")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(ks.Signature, "0x"))
+	if err != nil {
+		return fmt.Errorf("signature is invalid")
+	}
+
+	switch ks.EccType {
+	case owcrypt.ECC_CURVE_ED25519, owcrypt.ECC_CURVE_ED25519_NORMAL, owcrypt.ECC_CURVE_X25519:
+	default:
+		pub = uncompressedPublicKey(pub, ks.EccType)
+		if pub == nil {
+			return fmt.Errorf("public key of signature is invalid")
+		}
+		//RSV格式的签名去掉V
+		if len(sig) == 65 {
+			sig = sig[:64]
+		}
+	}
+
+	if owcrypt.Verify(pub, nil, msg, sig, ks.EccType) != owcrypt.SUCCESS {
+		return fmt.Errorf("signature verify failed")
+	}
+
+	return nil
+}
+
+//MergeSignatures 合并其他拥有者签名后的交易单，只补充未签名的签名信息。
+//合并时只采用签名值，地址公钥和被签消息以本交易单为准，本交易单没有的签名信息返回错误，
+//同一签名信息存在不同签名时返回错误
+func (rawtx *RawTransaction) MergeSignatures(signedTxs ...*RawTransaction) error {
+
+	for _, signedTx := range signedTxs {
+		if signedTx == nil {
+			continue
+		}
+
+		if signedTx.RawHex != rawtx.RawHex {
+			return fmt.Errorf("rawHex is not match, can not merge signatures")
+		}
+
+		if len(signedTx.Sid) > 0 && len(rawtx.Sid) > 0 && signedTx.Sid != rawtx.Sid {
+			return fmt.Errorf("sid: %s is not match: %s, can not merge signatures", signedTx.Sid, rawtx.Sid)
+		}
+
+		//先验证全部签名，再合并，避免交易单被部分合并
+		merged := make(map[*KeySignature]string)
+		for owner, keySignatures := range signedTx.Signatures {
+			current, ok := rawtx.Signatures[owner]
+			if !ok {
+				return fmt.Errorf("owner: %s is not in the transaction, can not merge signatures", owner)
+			}
+
+			if len(current) != len(keySignatures) {
+				return fmt.Errorf("owner: %s signatures length is not match", owner)
+			}
+
+			for i, keySignature := range keySignatures {
+				if keySignature == nil || len(keySignature.Signature) == 0 {
+					continue
+				}
+				if current[i] == nil {
+					return fmt.Errorf("owner: %s signature: %d is not in the transaction", owner, i)
+				}
+				if current[i].Signature == keySignature.Signature {
+					continue
+				}
+				if len(current[i].Signature) > 0 {
+					return fmt.Errorf("owner: %s has conflicting signature", owner)
+				}
+				verify := *current[i]
+				verify.Signature = keySignature.Signature
+				if err := verify.VerifySignature(); err != nil {
+					return fmt.Errorf("owner: %s %v", owner, err)
+				}
+				merged[current[i]] = keySignature.Signature
+			}
+		}
+
+		for keySignature, signature := range merged {
+			keySignature.Signature = signature
+		}
+	}
+
+	rawtx.UpdateCompleted()
+
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcdrivers/owkeychain"
+	"github.com/blocktree/go-owcrypt"
+)
+
+func TestRawTransaction_UpdateCompletedMultiSig(t *testing.T) {
+
+	rawTx := &RawTransaction{
+		Account: &AssetsAccount{OwnerKeys: []string{"a", "b", "c"}},
+		Signatures: map[string][]*KeySignature{
+			"a": {{Signature: "sig"}},
+		},
+	}
+
+	//多签账户没有设置必要签名数，不能使用默认值1
+	if rawTx.UpdateCompleted() {
+		t.Errorf("multisig transaction without required signatures should not be completed")
+	}
+
+	//交易单的必要签名数不能低于账户
+	rawTx.Required = 1
+	rawTx.Account.Required = 2
+	if rawTx.UpdateCompleted() {
+		t.Errorf("required signatures of multisig account should not be overridden")
+	}
+
+	rawTx.Signatures["b"] = []*KeySignature{{Signature: "sig"}}
+	if !rawTx.UpdateCompleted() {
+		t.Errorf("multisig transaction should be completed")
+	}
+}
+
+func TestRawTransaction_MergeSignatures(t *testing.T) {
+
+	key, err := owkeychain.DerivedPrivateKeyWithPath([]byte("merge_seed_0123456789abcdef"), "m/44'/88'/0'", owcrypt.ECC_CURVE_SECP256K1)
+	if err != nil {
+		t.Fatalf("DerivedPrivateKeyWithPath unexpected error: %v", err)
+	}
+	prv, _ := key.GetPrivateKeyBytes()
+	pub := hex.EncodeToString(key.GetPublicKeyBytes())
+	msg := owcrypt.Hash([]byte("local"), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+	otherMsg := owcrypt.Hash([]byte("other"), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+	sign := func(m []byte) string {
+		signature, _, ret := owcrypt.Signature(prv, nil, m, owcrypt.ECC_CURVE_SECP256K1)
+		if ret != owcrypt.SUCCESS {
+			t.Fatalf("Signature failed")
+		}
+		return hex.EncodeToString(signature)
+	}
+	newRawTx := func() *RawTransaction {
+		return &RawTransaction{
+			RawHex:   "raw",
+			Required: 1,
+			Signatures: map[string][]*KeySignature{
+				"a": {{EccType: owcrypt.ECC_CURVE_SECP256K1, Address: &Address{PublicKey: pub}, Message: hex.EncodeToString(msg)}},
+			},
+		}
+	}
+
+	//导入的被签消息和签名一致，但与本地交易单的消息不同
+	forged := newRawTx()
+	forged.Signatures["a"][0].Message = hex.EncodeToString(otherMsg)
+	forged.Signatures["a"][0].Signature = sign(otherMsg)
+	rawTx := newRawTx()
+	if err = rawTx.MergeSignatures(forged); err == nil || rawTx.SignatureCount() != 0 {
+		t.Errorf("signature of other message should return error")
+	}
+
+	//本地交易单没有的拥有者
+	unknown := newRawTx()
+	unknown.Signatures["b"] = unknown.Signatures["a"]
+	delete(unknown.Signatures, "a")
+	unknown.Signatures["b"][0].Signature = sign(msg)
+	if err = rawTx.MergeSignatures(unknown); err == nil {
+		t.Errorf("signature of unknown owner should return error")
+	}
+
+	signed := newRawTx()
+	signed.Signatures["a"][0].Address = &Address{PublicKey: "00"}
+	signed.Signatures["a"][0].Signature = sign(msg)
+	if err = rawTx.MergeSignatures(signed); err != nil || !rawTx.IsCompleted {
+		t.Errorf("MergeSignatures unexpected error: %v", err)
+	}
+	if rawTx.Signatures["a"][0].Address.PublicKey != pub {
+		t.Errorf("public key of local transaction should not be replaced")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+//已广播交易的跟踪状态
+const (
+	TxTrackStateMempool   = "mempool"   //在内存池等待打包
+	TxTrackStateInBlock   = "inBlock"   //已被打包，未达到确认数
+	TxTrackStateConfirmed = "confirmed" //达到确认数
+	TxTrackStateDropped   = "dropped"   //从内存池消失，等待重新广播
+	TxTrackStateReplaced  = "replaced"  //已被提高手续费的交易替换
+)
+
+//加速交易的方式
+const (
+	FeeBumpTypeReplace = "replace" //替换原交易，UTXO模型的RBF，账户模型使用相同nonce
+	FeeBumpTypeCPFP    = "cpfp"    //创建花费原交易输出的子交易，由子交易支付手续费
+)
+
+//加速交易单ExtParam中的字段
+const (
+	ExtParamKeyBumpTxID = "bumpTxID" //被加速的交易单ID
+	ExtParamKeyBumpType = "bumpType" //加速方式
+)
+
+//TransactionTrack 已广播交易的跟踪记录
+type TransactionTrack struct {
+	WxID        string          `json:"wxid" storm:"id"`     //通过GenTransactionWxID计算
+	TxID        string          `json:"txid" storm:"index"`  //交易单ID
+	Sid         string          `json:"sid"`                 //业务订单号
+	AccountID   string          `json:"accountID"`           //资产账户ID
+	Coin        Coin            `json:"coin"`                //区块链类型标识
+	State       string          `json:"state" storm:"index"` //跟踪状态
+	RawTx       *RawTransaction `json:"rawTx"`               //已签名的交易单，用于重新广播
+	BlockHeight uint64          `json:"blockHeight"`
+	BlockHash   string          `json:"blockHash"`
+	Confirm     uint64          `json:"confirm"`     //确认数
+	Rebroadcast int             `json:"rebroadcast"` //重新广播次数
+	ReplacedBy  string          `json:"replacedBy"`  //替换该交易的交易单ID
+	SubmitAt    int64           `json:"submitAt"`
+	SeenAt      int64           `json:"seenAt"` //最近一次在内存池或区块中发现的时间
+	UpdateAt    int64           `json:"updatedAt"`
+}
+
+//IsFinal 是否已结束跟踪
+func (track *TransactionTrack) IsFinal() bool {
+	return track.State == TxTrackStateConfirmed || track.State == TxTrackStateReplaced
+}
+
+//TransactionStatus 交易在链上的状态
+type TransactionStatus struct {
+	TxID          string `json:"txid"`
+	InMempool     bool   `json:"inMempool"`   //是否在内存池中
+	BlockHeight   uint64 `json:"blockHeight"` //打包的区块高度，未打包为0
+	BlockHash     string `json:"blockHash"`
+	Confirmations uint64 `json:"confirmations"` //确认数
+}
+
+//TransactionStatusQuerier 查询交易在链上的状态，交易单解析器可选实现，用于跟踪已广播的交易
+type TransactionStatusQuerier interface {
+
+	//GetTransactionStatus 查询交易状态，不在内存池也未被打包的交易返回零值状态
+	GetTransactionStatus(wrapper WalletDAI, txid string) (*TransactionStatus, error)
+}
+
+//TransactionFeeBumper 提高已广播交易的手续费，交易单解析器可选实现
+type TransactionFeeBumper interface {
+
+	//BumpRawTransactionFee 以新的费率构建加速交易单，返回的交易单需要重新签名和广播
+	//@param rawTx 已广播的交易单
+	//@return bumpType 加速方式，FeeBumpTypeReplace或FeeBumpTypeCPFP
+	BumpRawTransactionFee(wrapper WalletDAI, rawTx *RawTransaction, feeRate string) (*RawTransaction, string, error)
+}
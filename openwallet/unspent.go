@@ -0,0 +1,45 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+import "time"
+
+//UnspentLock 未花输出的锁定记录，交易单构建后锁定选中的输出，广播失败或过期后释放
+type UnspentLock struct {
+	Sid       string `json:"sid" storm:"id"`          //未花输出的Sid
+	TxID      string `json:"txid"`                    //未花输出所在的交易单ID
+	Index     uint64 `json:"index"`                   //未花输出的序号
+	AccountID string `json:"accountID" storm:"index"` //资产账户ID
+	RawTxSid  string `json:"rawTxSid" storm:"index"`  //锁定该输出的交易单Sid
+	CreateAt  int64  `json:"createdAt"`
+	ExpireAt  int64  `json:"expireAt"` //过期时间，unix秒
+}
+
+//IsExpired 锁定是否已过期
+func (lock *UnspentLock) IsExpired() bool {
+	return time.Now().Unix() >= lock.ExpireAt
+}
+
+//UnspentLocker 未花输出锁定，UTXO模型的交易单构建器通过WalletDAI断言使用，
+//避免并发构建的交易单花费相同的输出
+type UnspentLocker interface {
+
+	//GetAvailableUnspent 查询未被锁定的未花输出
+	GetAvailableUnspent(offset, limit int, cols ...interface{}) ([]*TxOutPut, error)
+
+	//LockUnspent 把选中的未花输出锁定到交易单的Sid，已被其他交易单锁定返回错误
+	LockUnspent(rawTx *RawTransaction, outputs ...*TxOutPut) error
+}
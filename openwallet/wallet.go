@@ -206,11 +206,7 @@ func (w *Wallet) HDKey(password ...string) (*hdkeystore.HDKey, error) {
 		return nil, errors.New("Wallet key is not exist!")
 	}
 
-	keyjson, err := ioutil.ReadFile(w.KeyFile)
-	if err != nil {
-		return nil, err
-	}
-	key, err := hdkeystore.DecryptHDKey(keyjson, pw)
+	key, err := hdkeystore.LoadHDKeyFile(w.KeyFile, pw)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package openwallet
+
+//提现状态
+const (
+	WithdrawalStatusBuilt     = "built"     //交易单已构建
+	WithdrawalStatusSigned    = "signed"    //交易单已签名
+	WithdrawalStatusSubmitted = "submitted" //交易单已广播
+	WithdrawalStatusConfirmed = "confirmed" //交易已被区块打包
+	WithdrawalStatusFailed    = "failed"    //交易单广播失败
+	WithdrawalStatusUnknown   = "unknown"   //广播结果未知，节点可能已接收交易，确认原交易不存在前不能重新构建
+)
+
+//Withdrawal 提现记录，以RawTransaction.Sid为业务订单号，保证同一订单不会重复出账
+type Withdrawal struct {
+	Sid       string          `json:"sid" storm:"id"`          //业务订单号
+	AccountID string          `json:"accountID" storm:"index"` //资产账户ID
+	TxID      string          `json:"txid" storm:"index"`      //广播后的交易单ID
+	Status    string          `json:"status"`                  //提现状态
+	RawTx     *RawTransaction `json:"rawTx"`                   //构建或签名后的交易单
+	Tx        *Transaction    `json:"tx"`                      //广播后的交易记录
+	Error     string          `json:"error"`                   //失败原因
+	CreateAt  int64           `json:"createdAt"`
+	UpdateAt  int64           `json:"updatedAt"`
+}
+
+//IsFinal 提现是否已广播或完成，不能再重新构建交易单
+func (w *Withdrawal) IsFinal() bool {
+	return w.Status == WithdrawalStatusSubmitted || w.Status == WithdrawalStatusConfirmed
+}
+
+//CanRebuild 提现是否可以重新构建交易单，只有确定广播失败的提现可以
+func (w *Withdrawal) CanRebuild() bool {
+	return w.Status == WithdrawalStatusFailed
+}
+
+//IsSubmitRejected 广播错误是否证明交易未被节点接收，其他错误视为结果未知。
+//ErrSubmitRawTransactionFailed等通用广播错误也用于超时、连接中断，
+//nonce错误可能是原交易已被接收，都不能确定交易未被接收
+func IsSubmitRejected(err error) bool {
+	owErr, ok := err.(*Error)
+	if !ok {
+		return false
+	}
+	switch owErr.Code() {
+	case ErrInsufficientBalanceOfAccount, ErrInsufficientBalanceOfAddress, ErrInsufficientFees,
+		ErrDustLimit, ErrVerifyRawTransactionFailed, ErrInsufficientTokenBalanceOfAddress:
+		return true
+	}
+	return false
+}
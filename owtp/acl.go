@@ -0,0 +1,116 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/mr-tron/base58/base58"
+)
+
+//ACLAnyPeer 匹配所有节点
+const ACLAnyPeer = "*"
+
+//ACLRule 访问规则，PublicKey或PID指定节点，Methods为允许的方法，
+//支持"*"匹配所有方法，"prefix.*"匹配前缀
+type ACLRule struct {
+	PublicKey string   `json:"publicKey"` //节点公钥，base58
+	PID       string   `json:"pid"`       //节点ID，与OWTPAuth.RemotePID一致
+	Methods   []string `json:"methods"`
+}
+
+//ACL 按节点限制可调用的方法，节点ID来自连接的公钥，需开启签名才能防止伪造
+type ACL struct {
+	mu    sync.RWMutex
+	rules map[string][]string
+}
+
+//NewACL 创建访问控制列表
+func NewACL(rules ...ACLRule) (*ACL, error) {
+	acl := &ACL{
+		rules: make(map[string][]string),
+	}
+	for _, rule := range rules {
+		pid := rule.PID
+		if len(rule.PublicKey) > 0 {
+			pub, err := base58.Decode(rule.PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("acl public key is invalid, unexpected error: %v", err)
+			}
+			pid = base58.Encode(owcrypt.Hash(pub, 0, owcrypt.HASH_ALG_SHA256))
+		}
+		if len(pid) == 0 {
+			return nil, fmt.Errorf("acl rule must contain publicKey or pid")
+		}
+		acl.Allow(pid, rule.Methods...)
+	}
+	return acl, nil
+}
+
+//Allow 允许节点调用方法，pid为ACLAnyPeer时对所有节点生效
+func (acl *ACL) Allow(pid string, methods ...string) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	acl.rules[pid] = append(acl.rules[pid], methods...)
+}
+
+//Revoke 撤销节点的所有权限
+func (acl *ACL) Revoke(pid string) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	delete(acl.rules, pid)
+}
+
+//IsAllowed 节点是否可以调用方法
+func (acl *ACL) IsAllowed(pid, method string) bool {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	for _, id := range []string{pid, ACLAnyPeer} {
+		for _, pattern := range acl.rules[id] {
+			if matchMethod(pattern, method) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchMethod(pattern, method string) bool {
+	if pattern == "*" || pattern == method {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(method, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+//Middleware 访问控制中间件，无权限的请求返回ErrUnauthorized
+func (acl *ACL) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			if !acl.IsAllowed(ctx.PID, ctx.Method) {
+				ctx.ResponseStopRun(nil, ErrUnauthorized, "method is not allowed")
+				return
+			}
+			next(ctx)
+		}
+	}
+}
@@ -24,28 +24,30 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
-	"github.com/blocktree/openwallet/v2/common"
 	"github.com/blocktree/openwallet/v2/crypto"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/mr-tron/base58/base58"
 	"math/big"
+	"time"
 )
 
 //KeyAgreement 协商密码
 type KeyAgreement struct {
-	EncryptType            string //协商密码类型
-	PublicKeyInitiator     string //发送方：本地公钥
-	PrivateKeyInitiator    string //发送方：本地私钥
-	TmpPublicKeyInitiator  string //发送方：临时公钥
-	TmpPrivateKeyInitiator string //发送方：临时私钥
-	PublicKeyResponder     string //响应方：本地公钥
-	PrivateKeyResponder    string //响应方：本地私钥
-	TmpPublicKeyResponder  string //响应方：临时公钥
-	TmpPrivateKeyResponder string //响应方：临时私钥
-	S2                     string //响应方：本地验证码，RequestKeyAgreement生成
-	SB                     string //响应方：生成协商密码的必要验证码，RequestKeyAgreement生成
-	SA                     string //发送方：本地验证码，ResponseKeyAgreement生成
-	Key                    string //协商的密钥
+	EncryptType            string        //协商密码类型
+	PublicKeyInitiator     string        //发送方：本地公钥
+	PrivateKeyInitiator    string        //发送方：本地私钥
+	TmpPublicKeyInitiator  string        //发送方：临时公钥
+	TmpPrivateKeyInitiator string        //发送方：临时私钥
+	PublicKeyResponder     string        //响应方：本地公钥
+	PrivateKeyResponder    string        //响应方：本地私钥
+	TmpPublicKeyResponder  string        //响应方：临时公钥
+	TmpPrivateKeyResponder string        //响应方：临时私钥
+	S2                     string        //响应方：本地验证码，RequestKeyAgreement生成
+	SB                     string        //响应方：生成协商密码的必要验证码，RequestKeyAgreement生成
+	SA                     string        //发送方：本地验证码，ResponseKeyAgreement生成
+	Key                    string        //协商的密钥
+	CreatedAt              int64         //密钥协商完成的时间
+	Previous               *KeyAgreement //轮换前的协商密码，宽限期内仍可使用
 }
 
 //Authorization 授权
@@ -227,6 +229,8 @@ type OWTPAuth struct {
 	enable bool
 	//是否协商
 	isConsult bool
+	//协商密码类型
+	encryptType string
 }
 
 func NewOWTPAuthWithCertificate(cert Certificate, enable bool) (*OWTPAuth, error) {
@@ -274,9 +278,11 @@ func (auth *OWTPAuth) GenerateSignature(data *DataPacket) bool {
 	if auth.EnableAuth() {
 		pub := base58.Encode(auth.localPublicKey)
 		//给数据包生成签名
-		dataString := common.NewString(data.Data)
-		plainText := fmt.Sprintf("%d%s%d%d%s", data.Req, data.Method, data.Nonce, data.Timestamp, dataString)
-		hash := owcrypt.Hash([]byte(plainText), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+		plainText, err := data.signPlainText()
+		if err != nil {
+			return false
+		}
+		hash := owcrypt.Hash(plainText, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
 		nodeID := owcrypt.Hash(auth.localPublicKey, 0, owcrypt.HASH_ALG_SHA256)
 		signature, _, ret := owcrypt.Signature(auth.localPrivateKey, nodeID, hash, owcrypt.ECC_CURVE_SM2_STANDARD)
 		if ret != owcrypt.SUCCESS {
@@ -308,10 +314,17 @@ func (auth *OWTPAuth) VerifySignature(data *DataPacket) bool {
 		//log.Debug("VerifySignature packet.Req: ", data.Req)
 		//log.Debug("VerifySignature packet.Signature: ", data.Signature)
 
-		dataString := data.Data.(string)
-		plainText := fmt.Sprintf("%d%s%d%d%s", data.Req, data.Method, data.Nonce, data.Timestamp, dataString)
+		if _, ok := data.Data.(string); !ok {
+			log.Errorf("OWTPAuth: data is not string")
+			return false
+		}
+		plainText, err := data.signPlainText()
+		if err != nil {
+			log.Errorf("OWTPAuth: encode plain text failed")
+			return false
+		}
 		//log.Debug("VerifySignature plainText: ", plainText)
-		hash := owcrypt.Hash([]byte(plainText), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+		hash := owcrypt.Hash(plainText, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
 		//log.Debug("VerifySignature hash: ", hex.EncodeToString(hash))
 		nodeID := owcrypt.Hash(publickey, 0, owcrypt.HASH_ALG_SHA256)
 		//log.Debug("VerifySignature remotePublicKey: ", hex.EncodeToString(auth.remotePublicKey))
@@ -351,7 +364,15 @@ func (auth *OWTPAuth) EnableAuth() bool {
 func (auth *OWTPAuth) EncryptData(data []byte, key []byte) ([]byte, error) {
 	//使用协商密钥加密数据
 	if auth.EnableKeyAgreement() && len(key) > 0 && len(data) > 0 {
-		encD, err := crypto.AESEncrypt(data, key)
+		var (
+			encD []byte
+			err  error
+		)
+		if isX25519KeyAgreement(auth.encryptType) {
+			encD, err = aeadSeal(auth.encryptType, key, data, nil)
+		} else {
+			encD, err = crypto.AESEncrypt(data, key)
+		}
 		if err != nil {
 			return data, err
 		}
@@ -370,7 +391,12 @@ func (auth *OWTPAuth) DecryptData(data []byte, key []byte) ([]byte, error) {
 		if err != nil {
 			return data, err
 		}
-		decD, err := crypto.AESDecrypt(encD, key)
+		var decD []byte
+		if isX25519KeyAgreement(auth.encryptType) {
+			decD, err = aeadOpen(auth.encryptType, key, encD, nil)
+		} else {
+			decD, err = crypto.AESDecrypt(encD, key)
+		}
 		if err != nil {
 			return data, err
 		}
@@ -399,6 +425,16 @@ func (auth *OWTPAuth) EncryptDataPacket(packet *DataPacket, key []byte) error {
 	//使用协商密钥加密数据
 	if auth.EnableKeyAgreement() && len(key) > 0 && len(dataByte) > 0 {
 
+		//认证加密，数据包头作为附加数据
+		if isX25519KeyAgreement(auth.encryptType) {
+			encD, err := aeadSeal(auth.encryptType, key, dataByte, packetAdditionalData(packet))
+			if err != nil {
+				return err
+			}
+			packet.Data = base64.StdEncoding.EncodeToString(encD)
+			return nil
+		}
+
 		//DataPacket = 1时，使用新的加密方案
 		if packet.Version == DataPacketVersionV1 {
 			//把nonce作为salt
@@ -432,6 +468,20 @@ func (auth *OWTPAuth) DecryptDataPacket(packet *DataPacket, key []byte) error {
 	//使用协商密钥解密数据
 	if auth.EnableKeyAgreement() && len(key) > 0 && len(rawData) > 0 {
 
+		//认证解密，数据包头被篡改时失败
+		if isX25519KeyAgreement(auth.encryptType) {
+			encD, err := base64.StdEncoding.DecodeString(rawData)
+			if err != nil {
+				return err
+			}
+			decD, err := aeadOpen(auth.encryptType, key, encD, packetAdditionalData(packet))
+			if err != nil {
+				return err
+			}
+			packet.Data = decD
+			return nil
+		}
+
 		//DataPacket = 1时，使用新的加密方案
 		if packet.Version == DataPacketVersionV1 {
 			//把nonce作为salt
@@ -521,9 +571,15 @@ func (auth *OWTPAuth) VerifyKeyAgreement(keyAgreement *KeyAgreement) bool {
 		return false
 	}
 
-	ret := owcrypt.KeyAgreement_responder_step2(sa, s2, owcrypt.ECC_CURVE_SM2_STANDARD)
-	if ret != owcrypt.SUCCESS {
-		return false
+	if isX25519KeyAgreement(keyAgreement.EncryptType) {
+		if !hmac.Equal(sa, s2) {
+			return false
+		}
+	} else {
+		ret := owcrypt.KeyAgreement_responder_step2(sa, s2, owcrypt.ECC_CURVE_SM2_STANDARD)
+		if ret != owcrypt.SUCCESS {
+			return false
+		}
 	}
 
 	if Debug {
@@ -531,12 +587,23 @@ func (auth *OWTPAuth) VerifyKeyAgreement(keyAgreement *KeyAgreement) bool {
 	}
 
 	auth.isConsult = true
+	auth.encryptType = keyAgreement.EncryptType
 
 	return true
 }
 
 //InitKeyAgreement 发起协商
 func (auth *OWTPAuth) InitKeyAgreement(keyAgreement *KeyAgreement) error {
+
+	if isX25519KeyAgreement(keyAgreement.EncryptType) {
+		if err := auth.initX25519KeyAgreement(keyAgreement); err != nil {
+			return err
+		}
+		auth.isConsult = true
+		auth.encryptType = keyAgreement.EncryptType
+		return nil
+	}
+
 	tmpPrikeyInitiator, tmpPubkeyInitiator := owcrypt.KeyAgreement_initiator_step1(owcrypt.ECC_CURVE_SM2_STANDARD)
 	//auth.tmpPrivateKey = tmpPrikeyInitiator
 	//auth.tmpPublicKey = tmpPubkeyInitiator
@@ -547,6 +614,7 @@ func (auth *OWTPAuth) InitKeyAgreement(keyAgreement *KeyAgreement) error {
 	keyAgreement.PublicKeyInitiator = base58.Encode(auth.localPublicKey)
 
 	auth.isConsult = true
+	auth.encryptType = keyAgreement.EncryptType
 
 	return nil
 }
@@ -577,6 +645,16 @@ func (auth *OWTPAuth) RequestKeyAgreement(keyAgreement *KeyAgreement) error {
 	auth.localPublicKey = localPubkey
 	auth.localPrivateKey = localPrivkey
 
+	if isX25519KeyAgreement(keyAgreement.EncryptType) {
+		if err := auth.requestX25519KeyAgreement(keyAgreement, pubkeyBytes, tmpPubkeyBytes); err != nil {
+			return err
+		}
+		keyAgreement.CreatedAt = time.Now().Unix()
+		auth.isConsult = true
+		auth.encryptType = keyAgreement.EncryptType
+		return nil
+	}
+
 	key, tmpPubkeyResponder, s2, sb, err := auth.requestKeyAgreement(pubkeyBytes, tmpPubkeyBytes, localPubkey, localPrivkey)
 	if err != nil {
 		return err
@@ -585,6 +663,8 @@ func (auth *OWTPAuth) RequestKeyAgreement(keyAgreement *KeyAgreement) error {
 	//auth.secretKey = key
 	//auth.localChecksum = s2
 	auth.isConsult = true
+	auth.encryptType = keyAgreement.EncryptType
+	keyAgreement.CreatedAt = time.Now().Unix()
 
 	keyAgreement.SB = base58.Encode(sb)
 	keyAgreement.Key = base58.Encode(key)
@@ -630,6 +710,17 @@ func (auth *OWTPAuth) ResponseKeyAgreement(keyAgreement *KeyAgreement) error {
 		return err
 	}
 
+	if isX25519KeyAgreement(keyAgreement.EncryptType) {
+		err = auth.responseX25519KeyAgreement(keyAgreement, remotePublicKeyBytes, remoteTmpPublicKeyBytes, sbBytes, tmpPrivateKeyBytes)
+		if err != nil {
+			return err
+		}
+		keyAgreement.CreatedAt = time.Now().Unix()
+		auth.isConsult = true
+		auth.encryptType = keyAgreement.EncryptType
+		return nil
+	}
+
 	key, sa, err := auth.responseKeyAgreement(remotePublicKeyBytes, remoteTmpPublicKeyBytes, sbBytes, tmpPublicKeyBytes, tmpPrivateKeyBytes)
 	if err != nil {
 		return err
@@ -638,6 +729,8 @@ func (auth *OWTPAuth) ResponseKeyAgreement(keyAgreement *KeyAgreement) error {
 	//auth.secretKey = key
 	//auth.localChecksum = sa
 	auth.isConsult = true
+	auth.encryptType = keyAgreement.EncryptType
+	keyAgreement.CreatedAt = time.Now().Unix()
 
 	//result := map[string]interface{}{
 	//	"secretKey":     base58.Encode(key),
@@ -0,0 +1,363 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltPeersBucket  = []byte("peers")
+	boltNoncesBucket = []byte("nonces")
+)
+
+//BoltGCInterval 清理过期数据的周期
+var BoltGCInterval = 10 * time.Minute
+
+func init() {
+	//协商密码保存到持久化存储时需要注册类型
+	gob.Register(&KeyAgreement{})
+}
+
+//boltRecord 节点属性的存储记录
+type boltRecord struct {
+	Expire int64
+	Value  interface{}
+}
+
+//BoltPeerstore 基于bolt文件数据库的Peerstore，节点属性和请求nonce在重启后保留，
+//过期数据定期清理。bolt文件只能被一个进程打开，多个节点共享会话请使用SessionManager
+type BoltPeerstore struct {
+	db        *bolt.DB
+	ttl       time.Duration
+	secret    []byte //节点属性的加密密钥，为空时明文保存
+	closeOnce sync.Once
+	exit      chan struct{}
+}
+
+//NewBoltPeerstore 打开或创建bolt存储，ttl为节点属性最后一次写入后的有效期，0不过期。
+//节点属性明文保存，其中包括协商密码的密钥和临时私钥，能读取文件的人可以解密通信数据，
+//文件需要限制访问权限，或使用NewBoltPeerstoreWithKey加密保存。节点的证书私钥不会保存到Peerstore
+func NewBoltPeerstore(path string, ttl time.Duration) (*BoltPeerstore, error) {
+	return NewBoltPeerstoreWithKey(path, ttl, nil)
+}
+
+//NewBoltPeerstoreWithKey 打开或创建bolt存储，节点属性使用key派生的密钥以AES-256-GCM加密保存，
+//key为空时明文保存。重新打开需要使用相同的key，否则已保存的属性无法读取
+func NewBoltPeerstoreWithKey(path string, ttl time.Duration, key []byte) (*BoltPeerstore, error) {
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltPeersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltNoncesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	store := &BoltPeerstore{
+		db:   db,
+		ttl:  ttl,
+		exit: make(chan struct{}),
+	}
+	if len(key) > 0 {
+		secret := sha256.Sum256(key)
+		store.secret = secret[:]
+	}
+
+	go store.runGC()
+
+	return store, nil
+}
+
+//Close 关闭存储
+func (store *BoltPeerstore) Close() error {
+	store.closeOnce.Do(func() {
+		close(store.exit)
+	})
+	return store.db.Close()
+}
+
+func (store *BoltPeerstore) runGC() {
+	ticker := time.NewTicker(BoltGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := store.GC(); err != nil {
+				log.Errorf("BoltPeerstore GC unexpected error: %v", err)
+			}
+		case <-store.exit:
+			return
+		}
+	}
+}
+
+//GC 清理过期的节点属性和nonce
+func (store *BoltPeerstore) GC() error {
+	now := time.Now().Unix()
+	return store.db.Update(func(tx *bolt.Tx) error {
+
+		//遍历时不能修改bucket，先收集再删除
+		peers := tx.Bucket(boltPeersBucket)
+		var ids [][]byte
+		peers.ForEach(func(id, v []byte) error {
+			if v == nil {
+				ids = append(ids, id)
+			}
+			return nil
+		})
+		for _, id := range ids {
+			b := peers.Bucket(id)
+			var expired [][]byte
+			b.ForEach(func(k, v []byte) error {
+				if r, err := store.decodeRecord(id, k, v); err != nil || (r.Expire > 0 && r.Expire <= now) {
+					expired = append(expired, k)
+				}
+				return nil
+			})
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		nonces := tx.Bucket(boltNoncesBucket)
+		var expired [][]byte
+		nonces.ForEach(func(k, v []byte) error {
+			if boltExpired(v, now) {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := nonces.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encodeBoltRecord(r boltRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeBoltRecord(b []byte) (boltRecord, error) {
+	var r boltRecord
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&r)
+	return r, err
+}
+
+//boltAdditionalData 节点ID和属性名作为认证加密的附加数据，防止密文被挪用到其他属性
+func boltAdditionalData(id, key []byte) []byte {
+	return []byte(fmt.Sprintf("%s/%s", id, key))
+}
+
+//encodeRecord 编码节点属性，设置了密钥时加密
+func (store *BoltPeerstore) encodeRecord(id, key []byte, r boltRecord) ([]byte, error) {
+	v, err := encodeBoltRecord(r)
+	if err != nil || store.secret == nil {
+		return v, err
+	}
+	return aeadSeal(KeyAgreementX25519AESGCM, store.secret, v, boltAdditionalData(id, key))
+}
+
+//decodeRecord 解码节点属性，设置了密钥时先解密
+func (store *BoltPeerstore) decodeRecord(id, key, v []byte) (boltRecord, error) {
+	if store.secret != nil {
+		plain, err := aeadOpen(KeyAgreementX25519AESGCM, store.secret, v, boltAdditionalData(id, key))
+		if err != nil {
+			return boltRecord{}, err
+		}
+		v = plain
+	}
+	return decodeBoltRecord(v)
+}
+
+func boltExpired(v []byte, now int64) bool {
+	if len(v) != 8 {
+		return true
+	}
+	expire := int64(binary.BigEndian.Uint64(v))
+	return expire > 0 && expire <= now
+}
+
+// SavePeer 保存节点
+func (store *BoltPeerstore) SavePeer(peer Peer) {
+
+	config := peer.ConnectConfig()
+
+	b, err := json.Marshal(config)
+	if err == nil {
+		store.Put(peer.PID(), peer.PID(), string(b))
+	}
+}
+
+//PeerInfo 节点信息
+func (store *BoltPeerstore) PeerInfo(id string) PeerInfo {
+
+	var config ConnectConfig
+	b, ok := store.Get(id, id).(string)
+	if ok {
+		err := json.Unmarshal([]byte(b), &config)
+		if err != nil {
+			log.Errorf("json.Unmarshal PeerInfo failed")
+		}
+	}
+	return PeerInfo{
+		ID:     id,
+		Config: config,
+	}
+}
+
+// Get 获取节点属性
+func (store *BoltPeerstore) Get(id string, key string) interface{} {
+	var val interface{}
+	store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltPeersBucket).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		r, err := store.decodeRecord([]byte(id), []byte(key), v)
+		if err != nil {
+			log.Errorf("BoltPeerstore decode [%s] unexpected error: %v", key, err)
+			return nil
+		}
+		if r.Expire > 0 && r.Expire <= time.Now().Unix() {
+			return nil
+		}
+		val = r.Value
+		return nil
+	})
+	return val
+}
+
+// GetString
+func (store *BoltPeerstore) GetString(id string, key string) string {
+	val := store.Get(id, key)
+	if val != nil {
+		if str, ok := val.(string); ok {
+			return str
+		}
+	}
+
+	return ""
+}
+
+// Put 设置节点属性，值需要可以gob编码，自定义类型需先gob.Register
+func (store *BoltPeerstore) Put(id string, key string, val interface{}) error {
+
+	r := boltRecord{Value: val}
+	if store.ttl > 0 {
+		r.Expire = time.Now().Add(store.ttl).Unix()
+	}
+
+	v, err := store.encodeRecord([]byte(id), []byte(key), r)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltPeersBucket).CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), v)
+	})
+}
+
+//Delete 删除节点属性
+func (store *BoltPeerstore) Delete(id string, key string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltPeersBucket).Bucket([]byte(id))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+//Destroy 清空节点的全部属性
+func (store *BoltPeerstore) Destroy(id string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltPeersBucket).DeleteBucket([]byte(id))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+//NonceCache 同一数据库中的请求nonce缓存，用于ServeMux防重放
+func (store *BoltPeerstore) NonceCache() NonceCache {
+	return &boltNonceCache{db: store.db}
+}
+
+//boltNonceCache bolt存储的nonce缓存
+type boltNonceCache struct {
+	db *bolt.DB
+}
+
+//IsExist nonce是否存在且未过期
+func (c *boltNonceCache) IsExist(key string) bool {
+	exist := false
+	c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltNoncesBucket).Get([]byte(key))
+		exist = v != nil && !boltExpired(v, time.Now().Unix())
+		return nil
+	})
+	return exist
+}
+
+//Put 记录nonce，timeout后过期
+func (c *boltNonceCache) Put(key string, val interface{}, timeout time.Duration) error {
+	v := make([]byte, 8)
+	if timeout > 0 {
+		binary.BigEndian.PutUint64(v, uint64(time.Now().Add(timeout).Unix()))
+	}
+	//每个请求都会写入nonce，合并并发的写入减少磁盘同步次数
+	return c.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltNoncesBucket).Put([]byte(key), v)
+	})
+}
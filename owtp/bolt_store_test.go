@@ -0,0 +1,249 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltPeerstore_Reopen(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "peerstore.db")
+
+	store, err := NewBoltPeerstore(path, time.Hour)
+	if err != nil {
+		t.Errorf("NewBoltPeerstore unexpected error: %v", err)
+		return
+	}
+	store.Put("1", keyAgreementCipher, &KeyAgreement{EncryptType: KeyAgreementSM2AES, Key: "secret"})
+	store.Put("1", "name", "alice")
+	store.NonceCache().Put("1_100", "hello", time.Hour)
+	store.Close()
+
+	store, err = NewBoltPeerstore(path, time.Hour)
+	if err != nil {
+		t.Errorf("NewBoltPeerstore unexpected error: %v", err)
+		return
+	}
+	defer store.Close()
+
+	ka, ok := store.Get("1", keyAgreementCipher).(*KeyAgreement)
+	if !ok || ka.Key != "secret" {
+		t.Errorf("key agreement is not restored: %v", store.Get("1", keyAgreementCipher))
+	}
+	if store.GetString("1", "name") != "alice" {
+		t.Errorf("name is not restored")
+	}
+
+	if !store.NonceCache().IsExist("1_100") {
+		t.Errorf("nonce is not restored")
+	}
+
+	store.Destroy("1")
+	if store.Get("1", "name") != nil {
+		t.Errorf("destroyed peer should be empty")
+	}
+}
+
+func TestBoltPeerstore_Encrypted(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "peerstore.db")
+
+	store, err := NewBoltPeerstoreWithKey(path, time.Hour, []byte("passphrase"))
+	if err != nil {
+		t.Errorf("NewBoltPeerstoreWithKey unexpected error: %v", err)
+		return
+	}
+	store.Put("1", keyAgreementCipher, &KeyAgreement{EncryptType: KeyAgreementSM2AES, Key: "secretkeyagreement"})
+	store.Close()
+
+	//文件中不能出现明文的密钥
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("ReadFile unexpected error: %v", err)
+		return
+	}
+	if bytes.Contains(data, []byte("secretkeyagreement")) {
+		t.Errorf("key agreement is saved in plain text")
+	}
+
+	//使用其他密钥打开无法读取
+	store, err = NewBoltPeerstoreWithKey(path, time.Hour, []byte("other"))
+	if err != nil {
+		t.Errorf("NewBoltPeerstoreWithKey unexpected error: %v", err)
+		return
+	}
+	if store.Get("1", keyAgreementCipher) != nil {
+		t.Errorf("key agreement should not be decrypted by other key")
+	}
+	store.Close()
+
+	store, err = NewBoltPeerstoreWithKey(path, time.Hour, []byte("passphrase"))
+	if err != nil {
+		t.Errorf("NewBoltPeerstoreWithKey unexpected error: %v", err)
+		return
+	}
+	defer store.Close()
+	ka, ok := store.Get("1", keyAgreementCipher).(*KeyAgreement)
+	if !ok || ka.Key != "secretkeyagreement" {
+		t.Errorf("key agreement is not restored: %v", store.Get("1", keyAgreementCipher))
+	}
+}
+
+func TestBoltPeerstore_TTL(t *testing.T) {
+
+	store, err := NewBoltPeerstore(filepath.Join(t.TempDir(), "peerstore.db"), time.Second)
+	if err != nil {
+		t.Errorf("NewBoltPeerstore unexpected error: %v", err)
+		return
+	}
+	defer store.Close()
+
+	store.Put("1", "name", "alice")
+	if store.GetString("1", "name") != "alice" {
+		t.Errorf("name is not saved")
+	}
+	store.NonceCache().Put("1_100", "hello", time.Second)
+
+	time.Sleep(2 * time.Second)
+	if store.Get("1", "name") != nil {
+		t.Errorf("expired value should be nil")
+	}
+	if store.NonceCache().IsExist("1_100") {
+		t.Errorf("expired nonce should not exist")
+	}
+
+	if err = store.GC(); err != nil {
+		t.Errorf("GC unexpected error: %v", err)
+	}
+}
+
+func TestServeMux_PersistentNonceCache(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "nonce.db")
+	peer := &HTTPClient{pid: "1", _auth: &OWTPAuth{enable: true}}
+
+	store, err := NewBoltPeerstore(path, 0)
+	if err != nil {
+		t.Errorf("NewBoltPeerstore unexpected error: %v", err)
+		return
+	}
+	mux := NewServeMux(0)
+	mux.SetNonceCache(store.NonceCache())
+	mux.completeRequest(&Context{PID: "1", nonce: 7, Method: "hello", Peer: peer})
+	store.Close()
+
+	//重启后重放同一个nonce
+	store, err = NewBoltPeerstore(path, 0)
+	if err != nil {
+		t.Errorf("NewBoltPeerstore unexpected error: %v", err)
+		return
+	}
+	defer store.Close()
+	mux = NewServeMux(0)
+	mux.SetNonceCache(store.NonceCache())
+
+	if status, _ := mux.checkNonceReplayReason("1", 7); status != ErrReplayAttack {
+		t.Errorf("replayed nonce status = %d, want %d", status, ErrReplayAttack)
+	}
+	if status, _ := mux.checkNonceReplayReason("1", 8); status != StatusSuccess {
+		t.Errorf("new nonce status = %d, want %d", status, StatusSuccess)
+	}
+}
+
+func TestOWTPNode_BoltPeerstoreRestart(t *testing.T) {
+
+	addr := freeAddress(t)
+	path := filepath.Join(t.TempDir(), "server.db")
+	serverCert := NewRandomCertificate()
+
+	startServer := func() (*OWTPNode, *BoltPeerstore) {
+		store, err := NewBoltPeerstore(path, time.Hour)
+		if err != nil {
+			t.Fatalf("NewBoltPeerstore unexpected error: %v", err)
+		}
+		server := NewOWTPNode(serverCert, 0, 0)
+		server.SetPeerstore(store)
+		server.SetNonceCache(store.NonceCache())
+		server.HandleFunc("hello", func(ctx *Context) {
+			ctx.Response(ctx.Params().Get("index").Int(), StatusSuccess, "success")
+		})
+		err = server.Listen(ConnectConfig{
+			Address:         addr,
+			ConnectType:     HTTP,
+			EnableSignature: true,
+		})
+		if err != nil {
+			t.Fatalf("Listen unexpected error: %v", err)
+		}
+		return server, store
+	}
+
+	server, store := startServer()
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{
+		Address:            addr,
+		ConnectType:        HTTP,
+		EnableSignature:    true,
+		EnableKeyAgreement: true,
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	call := func(index int) {
+		resp, err := client.CallSync("server", "hello", map[string]interface{}{"index": index})
+		if err != nil {
+			t.Errorf("CallSync unexpected error: %v", err)
+			return
+		}
+		if resp.Status != StatusSuccess || resp.JsonData().Int() != int64(index) {
+			t.Errorf("call %d response status: %d, msg: %s", index, resp.Status, resp.Msg)
+		}
+	}
+
+	call(1)
+	ka := client.Peerstore().Get("server", keyAgreementCipher).(*KeyAgreement)
+	key := ka.Key
+
+	//服务端证书私钥不保存到bolt文件
+	saved, ok := store.Get(client.NodeID(), keyAgreementCipher).(*KeyAgreement)
+	if !ok || len(saved.PublicKeyResponder) == 0 || len(saved.PrivateKeyResponder) > 0 || len(saved.PrivateKeyInitiator) > 0 {
+		t.Errorf("saved key agreement should not contain certificate private key: %+v", saved)
+	}
+
+	//服务端重启后，客户端不需要重新协商密码
+	server.Close()
+	store.Close()
+	client.GetOnlinePeer("server").(*HTTPClient).httpClient.Client().Transport.(*http.Transport).CloseIdleConnections()
+	server, store = startServer()
+	defer store.Close()
+	defer server.Close()
+
+	call(2)
+	ka = client.Peerstore().Get("server", keyAgreementCipher).(*KeyAgreement)
+	if ka.Key != key {
+		t.Errorf("key agreement should survive server restart")
+	}
+}
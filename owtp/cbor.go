@@ -0,0 +1,388 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+//CBOR(RFC 7049)的主类型
+const (
+	cborUint   byte = 0
+	cborNegInt byte = 1
+	cborBytes  byte = 2
+	cborText   byte = 3
+	cborArray  byte = 4
+	cborMap    byte = 5
+	cborTag    byte = 6
+	cborSimple byte = 7
+)
+
+const (
+	cborFalse   byte = 0xf4
+	cborTrue    byte = 0xf5
+	cborNull    byte = 0xf6
+	cborFloat64 byte = 0xfb
+
+	//cborMaxDepth 解码的最大嵌套层数
+	cborMaxDepth = 64
+)
+
+//cborMarshal 以规范格式编码，整数使用最短长度，map的键按编码后的长度和字节序排序，
+//相同的值总是得到相同的编码，可以作为签名原文
+func cborMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := cborEncode(&buf, v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cborWriteHead(buf *bytes.Buffer, major byte, n uint64) {
+	major = major << 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(major | 27)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		buf.Write(b[:])
+	}
+}
+
+func cborEncodeInt(buf *bytes.Buffer, n int64) {
+	if n < 0 {
+		cborWriteHead(buf, cborNegInt, uint64(-(n + 1)))
+	} else {
+		cborWriteHead(buf, cborUint, uint64(n))
+	}
+}
+
+func cborEncode(buf *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		buf.WriteByte(cborNull)
+	case bool:
+		if value {
+			buf.WriteByte(cborTrue)
+		} else {
+			buf.WriteByte(cborFalse)
+		}
+	case string:
+		cborWriteHead(buf, cborText, uint64(len(value)))
+		buf.WriteString(value)
+	case []byte:
+		cborWriteHead(buf, cborBytes, uint64(len(value)))
+		buf.Write(value)
+	case int:
+		cborEncodeInt(buf, int64(value))
+	case int32:
+		cborEncodeInt(buf, int64(value))
+	case int64:
+		cborEncodeInt(buf, value)
+	case uint:
+		cborWriteHead(buf, cborUint, uint64(value))
+	case uint32:
+		cborWriteHead(buf, cborUint, uint64(value))
+	case uint64:
+		cborWriteHead(buf, cborUint, value)
+	case float64:
+		//整数值的浮点数按整数编码，保证与JSON解析的结果一致
+		if value == math.Trunc(value) && value >= math.MinInt64 && value < math.MaxInt64 {
+			cborEncodeInt(buf, int64(value))
+			return nil
+		}
+		buf.WriteByte(cborFloat64)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(value))
+		buf.Write(b[:])
+	case json.Number:
+		n, err := jsonNumberValue(value)
+		if err != nil {
+			return err
+		}
+		return cborEncode(buf, n)
+	case []interface{}:
+		cborWriteHead(buf, cborArray, uint64(len(value)))
+		for _, item := range value {
+			err := cborEncode(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		type entry struct {
+			key   []byte
+			value interface{}
+		}
+		entries := make([]entry, 0, len(value))
+		for k, item := range value {
+			var kb bytes.Buffer
+			cborWriteHead(&kb, cborText, uint64(len(k)))
+			kb.WriteString(k)
+			entries = append(entries, entry{key: kb.Bytes(), value: item})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			if len(entries[i].key) != len(entries[j].key) {
+				return len(entries[i].key) < len(entries[j].key)
+			}
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		cborWriteHead(buf, cborMap, uint64(len(entries)))
+		for _, e := range entries {
+			buf.Write(e.key)
+			err := cborEncode(buf, e.value)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		//其他类型先转为json的通用结构
+		generic, err := cborGenericValue(v)
+		if err != nil {
+			return err
+		}
+		return cborEncode(buf, generic)
+	}
+	return nil
+}
+
+//cborGenericValue 通过json把任意值转为通用结构，数字保持为json.Number
+func cborGenericValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var generic interface{}
+	err = dec.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+func jsonNumberValue(n json.Number) (interface{}, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		var u uint64
+		if _, err := fmt.Sscan(s, &u); err == nil {
+			return u, nil
+		}
+	}
+	return n.Float64()
+}
+
+//cborUnmarshal 解码为通用结构，map为map[string]interface{}，整数为int64或uint64
+func cborUnmarshal(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("cbor: unexpected trailing data")
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) readHead() (byte, byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		b, err = d.next(1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(b[0]), nil
+	case info == 25:
+		b, err = d.next(2)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err = d.next(4)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err = d.next(8)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return major, info, binary.BigEndian.Uint64(b), nil
+	}
+	return 0, 0, 0, fmt.Errorf("cbor: indefinite length is not supported")
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("cbor: nesting is too deep")
+	}
+
+	major, info, n, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: negative integer overflow")
+		}
+		return -int64(n) - 1, nil
+	case cborBytes:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case cborText:
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborArray:
+		//每个元素至少1字节，提前检查长度防止超大分配
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		arr := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, nil
+	case cborMap:
+		if n > uint64(len(d.data)-d.pos) {
+			return nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("cbor: map key must be text string")
+			}
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = item
+		}
+		return m, nil
+	case cborTag:
+		//忽略标签，只返回内容
+		return d.decode(depth + 1)
+	case cborSimple:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return float16ToFloat64(uint16(n)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(n))), nil
+		case 27:
+			return math.Float64frombits(n), nil
+		}
+	}
+
+	return nil, fmt.Errorf("cbor: unsupported type: %d/%d", major, info)
+}
+
+func float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1.0
+	}
+	exp := int((h >> 10) & 0x1f)
+	frac := float64(h & 0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/tidwall/gjson"
+)
+
+//数据包编码格式
+const (
+	EncodingJSON = "json" //默认编码，兼容旧版本节点
+	EncodingCBOR = "cbor" //二进制编码，数据包以CBOR自描述标签开头
+)
+
+//EncodingHeader 协商编码的请求头，发起方带入ConnectConfig.Encoding，
+//接收方支持时在响应中原样返回，协商成功前双方都使用json
+const EncodingHeader = "e"
+
+//cborSelfDescribe CBOR自描述标签55799，用于识别二进制数据包
+var cborSelfDescribe = []byte{0xd9, 0xd9, 0xf7}
+
+//IsCBORDataPacket 数据是否CBOR编码的数据包
+func IsCBORDataPacket(b []byte) bool {
+	return bytes.HasPrefix(b, cborSelfDescribe)
+}
+
+//Encoding 数据包的编码格式
+func (dp *DataPacket) Encoding() string {
+	if dp.encoding == EncodingCBOR {
+		return EncodingCBOR
+	}
+	return EncodingJSON
+}
+
+//SetEncoding 设置数据包的编码格式，不支持的格式使用json
+func (dp *DataPacket) SetEncoding(encoding string) {
+	dp.encoding = encoding
+}
+
+//acceptEncoding 接收方按发起方请求的编码选择，不支持的编码使用json
+func acceptEncoding(requested string) string {
+	if requested == EncodingCBOR {
+		return EncodingCBOR
+	}
+	return EncodingJSON
+}
+
+//requestEncodingHeader 发起方的请求头加入期望的编码，json不需要协商
+func requestEncodingHeader(header map[string]string, encoding string) map[string]string {
+	if acceptEncoding(encoding) == EncodingJSON {
+		return header
+	}
+	newHeader := make(map[string]string, len(header)+1)
+	for k, v := range header {
+		newHeader[k] = v
+	}
+	newHeader[EncodingHeader] = encoding
+	return newHeader
+}
+
+//negotiatedEncoding 节点协商后的编码
+type negotiatedEncoding struct {
+	emu   sync.RWMutex
+	value string
+}
+
+func (e *negotiatedEncoding) encoding() string {
+	e.emu.RLock()
+	defer e.emu.RUnlock()
+	return acceptEncoding(e.value)
+}
+
+func (e *negotiatedEncoding) setEncoding(encoding string) {
+	e.emu.Lock()
+	e.value = encoding
+	e.emu.Unlock()
+}
+
+//peerEncoding 节点已协商的编码，没有协商的节点使用json
+func peerEncoding(peer Peer) string {
+	if negotiator, ok := peer.(interface{ encoding() string }); ok {
+		return negotiator.encoding()
+	}
+	return EncodingJSON
+}
+
+//EncodeDataPacket 按数据包的编码格式编码
+func EncodeDataPacket(dp *DataPacket) ([]byte, error) {
+
+	if dp.Encoding() != EncodingCBOR {
+		return json.Marshal(dp)
+	}
+
+	packet := map[string]interface{}{
+		"r": dp.Req,
+		"m": dp.Method,
+		"n": dp.Nonce,
+		"t": dp.Timestamp,
+		"d": dp.Data,
+		"v": dp.Version,
+	}
+	if len(dp.Signature) > 0 {
+		packet["s"] = dp.Signature
+	}
+
+	//协商密码只编码有值的字段
+	secretData := make(map[string]interface{})
+	for k, v := range map[string]string{
+		"pk":  dp.SecretData.PublicKeyInitiator,
+		"tpk": dp.SecretData.TmpPublicKeyInitiator,
+		"et":  dp.SecretData.EncryptType,
+		"pko": dp.SecretData.PublicKeyResponder,
+		"tpo": dp.SecretData.TmpPublicKeyResponder,
+		"sb":  dp.SecretData.SB,
+		"sa":  dp.SecretData.SA,
+		"s2":  dp.SecretData.S2,
+	} {
+		if len(v) > 0 {
+			secretData[k] = v
+		}
+	}
+	if len(secretData) > 0 {
+		packet["k"] = secretData
+	}
+
+	body, err := cborMarshal(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, cborSelfDescribe...), body...), nil
+}
+
+//DecodeDataPacket 解码数据包，自动识别json或cbor编码
+func DecodeDataPacket(b []byte) (*DataPacket, error) {
+
+	if !IsCBORDataPacket(b) {
+		dp := NewDataPacket(gjson.ParseBytes(b))
+		dp.size = len(b)
+		return dp, nil
+	}
+
+	value, err := cborUnmarshal(b[len(cborSelfDescribe):])
+	if err != nil {
+		return nil, err
+	}
+
+	packet, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cbor data packet is not map")
+	}
+
+	dp := &DataPacket{encoding: EncodingCBOR, size: len(b)}
+	dp.Req = cborUint64(packet["r"])
+	dp.Method = cborString(packet["m"])
+	dp.Nonce = cborUint64(packet["n"])
+	dp.Timestamp = int64(cborUint64(packet["t"]))
+	dp.Signature = cborString(packet["s"])
+	dp.Version = int64(cborUint64(packet["v"]))
+
+	if secretData, ok := packet["k"].(map[string]interface{}); ok {
+		dp.SecretData.PublicKeyInitiator = cborString(secretData["pk"])
+		dp.SecretData.TmpPublicKeyInitiator = cborString(secretData["tpk"])
+		dp.SecretData.EncryptType = cborString(secretData["et"])
+		dp.SecretData.PublicKeyResponder = cborString(secretData["pko"])
+		dp.SecretData.TmpPublicKeyResponder = cborString(secretData["tpo"])
+		dp.SecretData.SB = cborString(secretData["sb"])
+		dp.SecretData.SA = cborString(secretData["sa"])
+		dp.SecretData.S2 = cborString(secretData["s2"])
+	}
+
+	//与json数据包一致，数据主体转为字符串，对象和数组为json文本
+	var dataString string
+	d := packet["d"]
+	switch data := d.(type) {
+	case nil:
+	case string:
+		dataString = data
+	case map[string]interface{}, []interface{}:
+		js, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		dataString = string(js)
+	default:
+		dataString = common.NewString(data).String()
+	}
+	dp.Data = dataString
+
+	//保存数据主体的规范编码，用于校验签名
+	dp.signedData, err = cborMarshal(d)
+	if err != nil {
+		return nil, err
+	}
+	dp.signedFor = dataString
+
+	return dp, nil
+}
+
+//signPlainText 签名原文，json编码为[r+m+n+t+d]的文本，cbor编码为[r,m,n,t]和d的规范编码
+func (dp *DataPacket) signPlainText() ([]byte, error) {
+
+	if dp.Encoding() != EncodingCBOR {
+		dataString := common.NewString(dp.Data)
+		return []byte(fmt.Sprintf("%d%s%d%d%s", dp.Req, dp.Method, dp.Nonce, dp.Timestamp, dataString)), nil
+	}
+
+	head, err := cborMarshal([]interface{}{dp.Req, dp.Method, dp.Nonce, dp.Timestamp})
+	if err != nil {
+		return nil, err
+	}
+
+	//接收的数据包使用解码时保存的规范编码，数据主体已被修改时重新编码
+	if s, ok := dp.Data.(string); ok && dp.signedData != nil && s == dp.signedFor {
+		return append(head, dp.signedData...), nil
+	}
+
+	data, err := cborMarshal(dp.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(head, data...), nil
+}
+
+func cborUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case int64:
+		return uint64(n)
+	case uint64:
+		return n
+	case float64:
+		return uint64(n)
+	}
+	return 0
+}
+
+func cborString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCBORMarshal_Canonical(t *testing.T) {
+
+	a, err := cborMarshal(map[string]interface{}{"bb": 1, "a": []interface{}{"x", 1.5, true}, "c": nil})
+	if err != nil {
+		t.Errorf("cborMarshal unexpected error: %v", err)
+		return
+	}
+
+	b, _ := cborMarshal(map[string]interface{}{"c": nil, "a": []interface{}{"x", 1.5, true}, "bb": float64(1)})
+	if !bytes.Equal(a, b) {
+		t.Errorf("same value should have same encoding: %x != %x", a, b)
+	}
+
+	v, err := cborUnmarshal(a)
+	if err != nil {
+		t.Errorf("cborUnmarshal unexpected error: %v", err)
+		return
+	}
+	c, _ := cborMarshal(v)
+	if !bytes.Equal(a, c) {
+		t.Errorf("re-encoded value is different: %x != %x", a, c)
+	}
+
+	if _, err = cborUnmarshal(a[:len(a)-1]); err == nil {
+		t.Errorf("truncated data should return error")
+	}
+}
+
+func TestDecodeDataPacket(t *testing.T) {
+
+	cert := NewRandomCertificate()
+	local, _ := NewOWTPAuthWithCertificate(cert, true)
+	remote, _ := NewOWTPAuthWithCertificate(NewRandomCertificate(), true)
+	remote.remotePublicKey = local.localPublicKey
+
+	for _, encoding := range []string{EncodingJSON, EncodingCBOR} {
+
+		packet := DataPacket{
+			Req:       WSRequest,
+			Method:    "getInfo",
+			Nonce:     1181076977457565696,
+			Timestamp: 1528520843,
+			Data:      map[string]interface{}{"foo": "hello", "height": 100, "rate": 0.25},
+			Version:   CurrentDataPacketVersion,
+		}
+		packet.SetEncoding(encoding)
+
+		if !local.GenerateSignature(&packet) {
+			t.Errorf("%s GenerateSignature failed", encoding)
+			continue
+		}
+
+		b, err := EncodeDataPacket(&packet)
+		if err != nil {
+			t.Errorf("%s EncodeDataPacket unexpected error: %v", encoding, err)
+			continue
+		}
+
+		if IsCBORDataPacket(b) != (encoding == EncodingCBOR) {
+			t.Errorf("%s packet prefix is wrong", encoding)
+		}
+
+		decoded, err := DecodeDataPacket(b)
+		if err != nil {
+			t.Errorf("%s DecodeDataPacket unexpected error: %v", encoding, err)
+			continue
+		}
+
+		if decoded.Encoding() != encoding || decoded.Method != packet.Method || decoded.Nonce != packet.Nonce {
+			t.Errorf("%s decoded packet is different: %+v", encoding, decoded)
+		}
+
+		if !remote.VerifySignature(decoded) {
+			t.Errorf("%s VerifySignature failed", encoding)
+		}
+
+		decoded.Timestamp++
+		if remote.VerifySignature(decoded) {
+			t.Errorf("%s tampered packet should not pass verification", encoding)
+		}
+	}
+}
+
+func TestOWTPNode_NegotiateEncoding(t *testing.T) {
+
+	for _, connectType := range []string{Websocket, HTTP} {
+
+		addr := freeAddress(t)
+
+		server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		server.HandleFunc("hello", func(ctx *Context) {
+			ctx.Response(nil, StatusSuccess, "success")
+		})
+		err := server.Listen(ConnectConfig{Address: addr, ConnectType: connectType})
+		if err != nil {
+			t.Errorf("%s Listen unexpected error: %v", connectType, err)
+			server.Close()
+			continue
+		}
+
+		client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		peer, err := client.Connect("server", ConnectConfig{Address: addr, ConnectType: connectType, Encoding: EncodingCBOR})
+		if err != nil {
+			t.Errorf("%s Connect unexpected error: %v", connectType, err)
+			client.Close()
+			server.Close()
+			continue
+		}
+
+		//http在首个响应中确认编码
+		for i := 0; i < 2; i++ {
+			resp, err := client.CallSync("server", "hello", map[string]interface{}{"name": "chance"})
+			if err != nil || resp.Status != StatusSuccess {
+				t.Errorf("%s CallSync = %+v, err = %v", connectType, resp, err)
+			}
+		}
+
+		if encoding := peerEncoding(peer); encoding != EncodingCBOR {
+			t.Errorf("%s negotiated encoding = %s, want cbor", connectType, encoding)
+		}
+
+		if connectType == Websocket {
+			if encoding := peerEncoding(server.GetOnlinePeer(client.NodeID())); encoding != EncodingCBOR {
+				t.Errorf("server side encoding = %s, want cbor", encoding)
+			}
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestDial_EncodingFallback(t *testing.T) {
+
+	//旧版本节点不返回编码请求头
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.ReadMessage()
+	}))
+	defer server.Close()
+
+	node := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer node.Close()
+
+	url := "ws://" + strings.TrimPrefix(server.URL, "http://") + "/"
+	client, err := dial("old", url, node, map[string]string{EncodingHeader: EncodingCBOR}, 0, 0, nil)
+	if err != nil {
+		t.Errorf("dial unexpected error: %v", err)
+		return
+	}
+	defer client.close()
+
+	if encoding := peerEncoding(client); encoding != EncodingJSON {
+		t.Errorf("encoding = %s, want json fallback", encoding)
+	}
+}
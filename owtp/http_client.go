@@ -16,14 +16,13 @@
 package owtp
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/imroc/req"
 	"github.com/mr-tron/base58/base58"
-	"github.com/tidwall/gjson"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -53,6 +52,7 @@ type HTTPClient struct {
 	httpClient      *req.Req
 	baseURL         string
 	authHeader      map[string]string
+	negotiatedEncoding
 }
 
 func HTTPDial(
@@ -60,6 +60,16 @@ func HTTPDial(
 	handler PeerHandler,
 	header map[string]string,
 	timeout time.Duration) (*HTTPClient, error) {
+	return httpDial(pid, url, handler, header, timeout, nil)
+}
+
+//httpDial 创建HTTP客户端，tlsConfig不为空时使用自定义的TLS配置
+func httpDial(
+	pid, url string,
+	handler PeerHandler,
+	header map[string]string,
+	timeout time.Duration,
+	tlsConfig *tls.Config) (*HTTPClient, error) {
 
 	//var (
 	//	httpHeader http.Header
@@ -91,6 +101,15 @@ func HTTPDial(
 		handler:    handler,
 	}
 
+	if tlsConfig != nil {
+		client.httpClient.SetClient(&http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		})
+	}
+
 	client.httpClient.SetTimeout(timeout)
 
 	client.isConnect = true
@@ -159,6 +178,10 @@ func NewHTTPClientWithHeader(responseWriter http.ResponseWriter, request *http.R
 	//}
 
 	client, err := NewHTTPClient(auth.RemotePID(), responseWriter, request, hander, auth)
+	if err != nil {
+		return nil, err
+	}
+	client.setEncoding(acceptEncoding(header.Get(EncodingHeader)))
 
 	return client, nil
 }
@@ -281,7 +304,23 @@ func (c *HTTPClient) sendHTTPRequest(data DataPacket) error {
 		return errors.New("API url is not setup. ")
 	}
 
-	r, err := c.httpClient.Post(c.baseURL, req.BodyJSON(&data), req.Header(c.authHeader))
+	var (
+		r   *req.Resp
+		err error
+	)
+	if data.Encoding() == EncodingCBOR {
+		body, encErr := EncodeDataPacket(&data)
+		if encErr != nil {
+			return encErr
+		}
+		header := req.Header{"Content-Type": "application/cbor"}
+		for k, v := range c.authHeader {
+			header[k] = v
+		}
+		r, err = c.httpClient.Post(c.baseURL, body, header)
+	} else {
+		r, err = c.httpClient.Post(c.baseURL, req.BodyJSON(&data), req.Header(c.authHeader))
+	}
 
 	if Debug {
 		log.Std.Info("%+v", r)
@@ -299,9 +338,15 @@ func (c *HTTPClient) sendHTTPRequest(data DataPacket) error {
 		return fmt.Errorf("%s", r.Response().Status)
 	}
 
-	resp := gjson.ParseBytes(r.Bytes())
+	//服务端返回相同的编码才算协商成功，之后的请求使用协商的编码
+	if requested := c.authHeader[EncodingHeader]; len(requested) > 0 && r.Response().Header.Get(EncodingHeader) == requested {
+		c.setEncoding(acceptEncoding(requested))
+	}
 
-	packet := NewDataPacket(resp)
+	packet, err := DecodeDataPacket(r.Bytes())
+	if err != nil {
+		return err
+	}
 
 	//有可能存在数据已返回，上层才添加请求
 	go c.handler.OnPeerNewDataPacketReceived(c, packet)
@@ -319,7 +364,7 @@ func (c *HTTPClient) openPipe() error {
 
 // writeResponse 输出数据
 func (c *HTTPClient) writeResponse(data DataPacket) error {
-	respBytes, err := json.Marshal(data)
+	respBytes, err := EncodeDataPacket(&data)
 	if err != nil {
 		return err
 	}
@@ -332,7 +377,15 @@ func (c *HTTPClient) writeResponse(data DataPacket) error {
 		return fmt.Errorf("responseWriter is nil")
 	}
 	w := c.responseWriter
-	w.Header().Set("Content-type", "application/json")
+	//支持发起方请求的编码时原样返回，完成协商
+	if encoding := c.encoding(); encoding != EncodingJSON {
+		w.Header().Set(EncodingHeader, encoding)
+	}
+	if data.Encoding() == EncodingCBOR {
+		w.Header().Set("Content-type", "application/cbor")
+	} else {
+		w.Header().Set("Content-type", "application/json")
+	}
 	//w.Header().Set("Access-Control-Allow-Headers", "*")
 	//if w.Header().Get("Access-Control-Allow-Origin") == "" {
 	//	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -361,7 +414,10 @@ func (c *HTTPClient) HandleRequest() error {
 		return fmt.Errorf("body is empty")
 	}
 
-	packet := NewDataPacket(gjson.ParseBytes(s))
+	packet, err := DecodeDataPacket(s)
+	if err != nil {
+		return err
+	}
 
 	//转交给处理器处理数据包
 	c.handler.OnPeerNewDataPacketReceived(c, packet)
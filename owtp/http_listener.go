@@ -16,6 +16,7 @@
 package owtp
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/pkg/errors"
@@ -30,6 +31,7 @@ type httpListener struct {
 	laddr           string
 	peerstore       Peerstore //节点存储器
 	enableSignature bool
+	bindIdentity    bool //校验客户端TLS证书绑定的节点身份
 }
 
 //serve 监听服务
@@ -47,6 +49,18 @@ func (l *httpListener) serve() error {
 //ServeHTTP 实现HTTP服务监听
 func (l *httpListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	if l.bindIdentity {
+		if err := verifyRequestTLSIdentity(r); err != nil {
+			HttpError(w, err.Error(), http.StatusOK)
+			return
+		}
+	}
+
+	//超过数据包大小上限的请求读取时返回错误
+	if limit := packetSizeLimit(l.handler); limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
 	//建立节点
 	peer, err := NewHTTPClientWithHeader(w, r, l.handler, l.enableSignature)
 	if err != nil {
@@ -80,15 +94,24 @@ func (l *httpListener) Accept() (Peer, error) {
 
 //ListenAddr 创建OWTP协议通信监听
 func HttpListenAddr(addr string, enableSignature bool, handler PeerHandler) (*httpListener, error) {
+	return httpListenAddr(addr, enableSignature, handler, nil, false)
+}
+
+//httpListenAddr 创建HTTP通信监听，tlsConfig不为空时监听https
+func httpListenAddr(addr string, enableSignature bool, handler PeerHandler, tlsConfig *tls.Config, bindIdentity bool) (*httpListener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
 	listener := httpListener{
 		Listener:        l,
 		laddr:           addr,
 		handler:         handler,
 		enableSignature: enableSignature,
+		bindIdentity:    bindIdentity && tlsConfig != nil,
 	}
 
 	go listener.serve()
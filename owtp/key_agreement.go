@@ -0,0 +1,421 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/mr-tron/base58/base58"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+//协商密码类型，通过SecretData.EncryptType协商
+const (
+	KeyAgreementSM2AES                 = "aes"                      //SM2协商，AES-CBC加密，默认
+	KeyAgreementX25519ChaCha20Poly1305 = "x25519-chacha20-poly1305" //X25519协商，ChaCha20-Poly1305认证加密
+	KeyAgreementX25519AESGCM           = "x25519-aes-256-gcm"       //X25519协商，AES-256-GCM认证加密
+)
+
+//isX25519KeyAgreement 是否X25519协商
+func isX25519KeyAgreement(encryptType string) bool {
+	return encryptType == KeyAgreementX25519ChaCha20Poly1305 || encryptType == KeyAgreementX25519AESGCM
+}
+
+//x25519KeyPair 生成X25519临时密钥对
+func x25519KeyPair() ([]byte, []byte, error) {
+	priv := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, priv); err != nil {
+		return nil, nil, err
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, pub, nil
+}
+
+//signX25519PublicKey 节点私钥签名临时公钥，防止协商被中间人替换，返回公钥+签名
+func signX25519PublicKey(tmpPub, pub, priv []byte) ([]byte, error) {
+	nodeID := owcrypt.Hash(pub, 0, owcrypt.HASH_ALG_SHA256)
+	hash := owcrypt.Hash(tmpPub, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+	signature, _, ret := owcrypt.Signature(priv, nodeID, hash, owcrypt.ECC_CURVE_SM2_STANDARD)
+	if ret != owcrypt.SUCCESS {
+		return nil, fmt.Errorf("sign x25519 public key failed")
+	}
+	return append(append([]byte{}, tmpPub...), signature...), nil
+}
+
+//verifyX25519PublicKey 校验临时公钥的签名，返回临时公钥
+func verifyX25519PublicKey(signed, pub []byte) ([]byte, error) {
+	if len(signed) <= curve25519.PointSize {
+		return nil, fmt.Errorf("x25519 public key is not signed")
+	}
+	tmpPub := signed[:curve25519.PointSize]
+	nodeID := owcrypt.Hash(pub, 0, owcrypt.HASH_ALG_SHA256)
+	hash := owcrypt.Hash(tmpPub, 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+	ret := owcrypt.Verify(pub, nodeID, hash, signed[curve25519.PointSize:], owcrypt.ECC_CURVE_SM2_STANDARD)
+	if ret != owcrypt.SUCCESS {
+		return nil, fmt.Errorf("x25519 public key signature verify invalid")
+	}
+	return tmpPub, nil
+}
+
+//x25519DeriveKey 通过HKDF-SHA256派生协商密钥和双方的校验值
+func x25519DeriveKey(encryptType string, tmpPriv, remoteTmpPub, tmpPubInitiator, tmpPubResponder, pubInitiator, pubResponder []byte) (key, sb, sa []byte, err error) {
+
+	shared, err := curve25519.X25519(tmpPriv, remoteTmpPub)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	salt := append(append([]byte{}, tmpPubInitiator...), tmpPubResponder...)
+	info := []byte("owtp key agreement " + encryptType)
+	info = append(info, owcrypt.Hash(pubInitiator, 0, owcrypt.HASH_ALG_SHA256)...)
+	info = append(info, owcrypt.Hash(pubResponder, 0, owcrypt.HASH_ALG_SHA256)...)
+
+	out := make([]byte, 96)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, info), out); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return out[:32], out[32:64], out[64:], nil
+}
+
+//initX25519KeyAgreement 发起X25519协商
+func (auth *OWTPAuth) initX25519KeyAgreement(keyAgreement *KeyAgreement) error {
+
+	tmpPriv, tmpPub, err := x25519KeyPair()
+	if err != nil {
+		return err
+	}
+
+	signed, err := signX25519PublicKey(tmpPub, auth.localPublicKey, auth.localPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	keyAgreement.TmpPrivateKeyInitiator = base58.Encode(tmpPriv)
+	keyAgreement.TmpPublicKeyInitiator = base58.Encode(signed)
+	keyAgreement.PublicKeyInitiator = base58.Encode(auth.localPublicKey)
+
+	return nil
+}
+
+//requestX25519KeyAgreement 响应方计算X25519协商密钥
+func (auth *OWTPAuth) requestX25519KeyAgreement(keyAgreement *KeyAgreement, pubInitiator, signedTmpPubInitiator []byte) error {
+
+	//开启签名时，发起方公钥必须是连接的节点公钥
+	if auth.enable && !hmac.Equal(pubInitiator, auth.remotePublicKey) {
+		return fmt.Errorf("initiator public key is different of remote peer")
+	}
+
+	tmpPubInitiator, err := verifyX25519PublicKey(signedTmpPubInitiator, pubInitiator)
+	if err != nil {
+		return err
+	}
+
+	tmpPriv, tmpPub, err := x25519KeyPair()
+	if err != nil {
+		return err
+	}
+
+	key, sb, sa, err := x25519DeriveKey(keyAgreement.EncryptType, tmpPriv, tmpPubInitiator, tmpPubInitiator, tmpPub, pubInitiator, auth.localPublicKey)
+	if err != nil {
+		return err
+	}
+
+	signed, err := signX25519PublicKey(tmpPub, auth.localPublicKey, auth.localPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	keyAgreement.Key = base58.Encode(key)
+	keyAgreement.SB = base58.Encode(sb)
+	keyAgreement.S2 = base58.Encode(sa)
+	keyAgreement.TmpPublicKeyResponder = base58.Encode(signed)
+
+	return nil
+}
+
+//responseX25519KeyAgreement 发起方计算X25519协商密钥，并校验响应方的校验值
+func (auth *OWTPAuth) responseX25519KeyAgreement(keyAgreement *KeyAgreement, pubResponder, signedTmpPubResponder, sb, tmpPrivInitiator []byte) error {
+
+	tmpPubResponder, err := verifyX25519PublicKey(signedTmpPubResponder, pubResponder)
+	if err != nil {
+		return err
+	}
+
+	tmpPubInitiator, err := curve25519.X25519(tmpPrivInitiator, curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+
+	key, expectedSB, sa, err := x25519DeriveKey(keyAgreement.EncryptType, tmpPrivInitiator, tmpPubResponder, tmpPubInitiator, tmpPubResponder, auth.localPublicKey, pubResponder)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(sb, expectedSB) {
+		return fmt.Errorf("x25519 key agreement checksum is invalid")
+	}
+
+	keyAgreement.Key = base58.Encode(key)
+	keyAgreement.SA = base58.Encode(sa)
+
+	return nil
+}
+
+//newAEAD 创建认证加密算法
+func newAEAD(encryptType string, key []byte) (cipher.AEAD, error) {
+	switch encryptType {
+	case KeyAgreementX25519ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	case KeyAgreementX25519AESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	default:
+		return nil, fmt.Errorf("unsupported encrypt type: %s", encryptType)
+	}
+}
+
+//aeadSeal 认证加密，输出随机nonce+密文
+func aeadSeal(encryptType string, key, plainText, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(encryptType, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plainText)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plainText, additionalData), nil
+}
+
+//aeadOpen 认证解密
+func aeadOpen(encryptType string, key, cipherText, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(encryptType, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) < aead.NonceSize() {
+		return nil, fmt.Errorf("cipher text is too short")
+	}
+	nonce := cipherText[:aead.NonceSize()]
+	return aead.Open(nil, nonce, cipherText[aead.NonceSize():], additionalData)
+}
+
+//packetAdditionalData 数据包头作为认证加密的附加数据，防止密文被挪用到其他数据包
+func packetAdditionalData(packet *DataPacket) []byte {
+	return []byte(fmt.Sprintf("%d%s%d%d", packet.Req, packet.Method, packet.Nonce, packet.Timestamp))
+}
+
+//KeyAgreementGracePeriod 密钥轮换后旧密钥仍可使用的时长，用于处理轮换前已发出的请求和响应
+var KeyAgreementGracePeriod = 30 * time.Second
+
+//supportedKeyAgreementTypes 支持的协商密码类型
+var supportedKeyAgreementTypes = []string{KeyAgreementSM2AES, KeyAgreementX25519ChaCha20Poly1305, KeyAgreementX25519AESGCM}
+
+//maxPreviousKeyAgreements 宽限期内保留的旧协商密码数量上限
+const maxPreviousKeyAgreements = 8
+
+//findPrevious 查找宽限期内符合条件的旧协商密码，旧密码在被替换后的宽限期内有效
+func (ka *KeyAgreement) findPrevious(match func(prev *KeyAgreement) bool) *KeyAgreement {
+	for next, prev := ka, ka.Previous; prev != nil; next, prev = prev, prev.Previous {
+		if time.Since(time.Unix(next.CreatedAt, 0)) > KeyAgreementGracePeriod {
+			return nil
+		}
+		if match(prev) {
+			return prev
+		}
+	}
+	return nil
+}
+
+//copyWith 复制协商密码及宽限期内的旧协商密码，并由privateKey设置本节点的证书私钥，privateKey为空时清除
+func (ka *KeyAgreement) copyWith(publicKey, privateKey string) *KeyAgreement {
+	c := *ka
+	c.PrivateKeyInitiator = ""
+	c.PrivateKeyResponder = ""
+	if len(privateKey) > 0 {
+		if c.PublicKeyInitiator == publicKey {
+			c.PrivateKeyInitiator = privateKey
+		}
+		if c.PublicKeyResponder == publicKey {
+			c.PrivateKeyResponder = privateKey
+		}
+	}
+	if c.Previous != nil {
+		c.Previous = c.Previous.copyWith(publicKey, privateKey)
+	}
+	return &c
+}
+
+//putKeyAgreement 保存节点的协商密码，本节点的证书私钥不保存，避免持久化的Peerstore泄露节点身份私钥
+func (node *OWTPNode) putKeyAgreement(pid string, ka *KeyAgreement) error {
+	return node.Peerstore().Put(pid, keyAgreementCipher, ka.copyWith("", ""))
+}
+
+//getKeyAgreement 读取节点的协商密码副本，不存在返回nil，本节点的证书私钥由证书重新填充
+func (node *OWTPNode) getKeyAgreement(pid string) (*KeyAgreement, error) {
+	value := node.Peerstore().Get(pid, keyAgreementCipher)
+	if value == nil {
+		return nil, nil
+	}
+	ka, ok := value.(*KeyAgreement)
+	if !ok {
+		return nil, fmt.Errorf("keyAgreement chipher type error")
+	}
+	return ka.copyWith(base58.Encode(node.cert.PublicKeyBytes()), base58.Encode(node.cert.PrivateKeyBytes())), nil
+}
+
+//rotateFrom 轮换后保留宽限期内的旧协商密码
+func (ka *KeyAgreement) rotateFrom(old *KeyAgreement) {
+	ka.Previous = nil
+	if old == nil || len(old.Key) == 0 || old.Key == ka.Key {
+		return
+	}
+	next := ka
+	for n, i := old, 0; n != nil && i < maxPreviousKeyAgreements; n, i = n.Previous, i+1 {
+		if time.Since(time.Unix(next.CreatedAt, 0)) > KeyAgreementGracePeriod {
+			break
+		}
+		prev := *n
+		prev.Previous = nil
+		next.Previous = &prev
+		next = &prev
+	}
+}
+
+//SetKeyAgreementTypes 设置允许对方发起协商的密码类型，未设置时允许全部支持的类型
+func (node *OWTPNode) SetKeyAgreementTypes(types ...string) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.keyAgreementTypes = types
+}
+
+//allowKeyAgreementType 是否允许对方发起的协商密码类型，为空时按默认的aes处理
+func (node *OWTPNode) allowKeyAgreementType(encryptType string) bool {
+	if len(encryptType) == 0 {
+		encryptType = KeyAgreementSM2AES
+	}
+
+	node.mu.RLock()
+	allowed := node.keyAgreementTypes
+	node.mu.RUnlock()
+	if len(allowed) == 0 {
+		allowed = supportedKeyAgreementTypes
+	}
+
+	for _, t := range allowed {
+		if t == encryptType {
+			return true
+		}
+	}
+	return false
+}
+
+//pendingKeyAgreement 正在协商的参数，协商响应校验通过后才保存到节点存储
+func (node *OWTPNode) pendingKeyAgreement(pid string) *KeyAgreement {
+	value, ok := node.keyAgreements.Load(pid)
+	if !ok {
+		return nil
+	}
+	return value.(*KeyAgreement)
+}
+
+//keyRotation 节点的密钥轮换状态，请求数只在内存中计数
+type keyRotation struct {
+	mu       sync.Mutex
+	key      string //计数对应的协商密码，以发起方临时公钥区分
+	messages uint64 //使用当前密钥发送的请求数
+}
+
+//keyRotationExpired 协商密钥是否达到轮换条件
+func keyRotationExpired(config ConnectConfig, ka *KeyAgreement, messages uint64) bool {
+	if config.KeyRotationMessages > 0 && messages >= uint64(config.KeyRotationMessages) {
+		return true
+	}
+	if config.KeyRotationMinutes > 0 && time.Since(time.Unix(ka.CreatedAt, 0)) >= time.Duration(config.KeyRotationMinutes)*time.Minute {
+		return true
+	}
+	return false
+}
+
+//rotateKeyAgreement 发送请求前检查协商密钥，达到轮换条件时由发起方重新协商，
+//轮换期间该节点的其他请求等待新密钥
+func (node *OWTPNode) rotateKeyAgreement(peer Peer) error {
+
+	config := peer.ConnectConfig()
+	if config.KeyRotationMessages <= 0 && config.KeyRotationMinutes <= 0 {
+		return nil
+	}
+
+	if peer.auth() == nil || !peer.auth().EnableKeyAgreement() {
+		return nil
+	}
+
+	value, _ := node.keyRotations.LoadOrStore(peer.PID(), &keyRotation{})
+	rotation := value.(*keyRotation)
+	rotation.mu.Lock()
+	defer rotation.mu.Unlock()
+
+	ka, err := node.getKeyAgreement(peer.PID())
+	//只有发起方负责轮换
+	if err != nil || ka == nil || len(ka.TmpPrivateKeyInitiator) == 0 || len(ka.Key) == 0 {
+		return nil
+	}
+
+	//重新协商后重新计数
+	if rotation.key != ka.TmpPublicKeyInitiator {
+		rotation.key = ka.TmpPublicKeyInitiator
+		rotation.messages = 0
+	}
+
+	if keyRotationExpired(config, ka, rotation.messages) {
+		log.Debugf("peer[%s] key agreement is rotating after %d messages", peer.PID(), rotation.messages)
+		err := node.callKeyAgreement(peer, ka.EncryptType)
+		if err != nil {
+			return err
+		}
+		ka, err = node.getKeyAgreement(peer.PID())
+		if err != nil {
+			return err
+		}
+		if ka == nil {
+			return fmt.Errorf("keyAgreement chipher is not saved")
+		}
+		rotation.key = ka.TmpPublicKeyInitiator
+		rotation.messages = 0
+	}
+
+	rotation.messages++
+	return nil
+}
@@ -0,0 +1,287 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+func TestX25519KeyAgreement(t *testing.T) {
+
+	for _, encryptType := range []string{KeyAgreementX25519ChaCha20Poly1305, KeyAgreementX25519AESGCM} {
+
+		initiatorCert := NewRandomCertificate()
+		responderCert := NewRandomCertificate()
+		initiator, _ := NewOWTPAuthWithCertificate(initiatorCert, true)
+		responder, _ := NewOWTPAuthWithCertificate(responderCert, true)
+		responder.remotePublicKey = initiatorCert.PublicKeyBytes()
+
+		ka := &KeyAgreement{EncryptType: encryptType}
+		if err := initiator.InitKeyAgreement(ka); err != nil {
+			t.Errorf("InitKeyAgreement unexpected error: %v", err)
+			return
+		}
+
+		//响应方只获得发起方的公开参数
+		priv, pub := responderCert.KeyPair()
+		rka := &KeyAgreement{
+			EncryptType:           encryptType,
+			PublicKeyInitiator:    ka.PublicKeyInitiator,
+			TmpPublicKeyInitiator: ka.TmpPublicKeyInitiator,
+			PublicKeyResponder:    pub,
+			PrivateKeyResponder:   priv,
+		}
+		if err := responder.RequestKeyAgreement(rka); err != nil {
+			t.Errorf("RequestKeyAgreement unexpected error: %v", err)
+			return
+		}
+
+		ka.PublicKeyResponder = rka.PublicKeyResponder
+		ka.TmpPublicKeyResponder = rka.TmpPublicKeyResponder
+		ka.SB = rka.SB
+		if err := initiator.ResponseKeyAgreement(ka); err != nil {
+			t.Errorf("ResponseKeyAgreement unexpected error: %v", err)
+			return
+		}
+
+		if ka.Key != rka.Key {
+			t.Errorf("%s key is different", encryptType)
+		}
+
+		rka.SA = ka.SA
+		if !responder.VerifyKeyAgreement(rka) {
+			t.Errorf("%s VerifyKeyAgreement failed", encryptType)
+		}
+
+		//认证加密，篡改数据包头后解密失败
+		secretKey, _ := base58.Decode(ka.Key)
+		packet := &DataPacket{
+			Req:       WSRequest,
+			Method:    "hello",
+			Nonce:     1,
+			Timestamp: time.Now().Unix(),
+			Data:      map[string]interface{}{"a": 1},
+		}
+		if err := initiator.EncryptDataPacket(packet, secretKey); err != nil {
+			t.Errorf("EncryptDataPacket unexpected error: %v", err)
+			return
+		}
+		cipherText := packet.Data
+
+		if err := responder.DecryptDataPacket(packet, secretKey); err != nil {
+			t.Errorf("DecryptDataPacket unexpected error: %v", err)
+			return
+		}
+		if string(packet.Data.([]byte)) != `{"a":1}` {
+			t.Errorf("decrypt data: %s", packet.Data)
+		}
+
+		packet.Data = cipherText
+		packet.Method = "transfer"
+		if err := responder.DecryptDataPacket(packet, secretKey); err == nil {
+			t.Errorf("%s tampered packet should not be decrypted", encryptType)
+		}
+
+		//临时公钥被其他节点替换，签名校验失败
+		otherAuth, _ := NewOWTPAuthWithCertificate(NewRandomCertificate(), true)
+		other := &KeyAgreement{EncryptType: encryptType}
+		otherAuth.InitKeyAgreement(other)
+		forged := &KeyAgreement{
+			EncryptType:           encryptType,
+			PublicKeyInitiator:    ka.PublicKeyInitiator,
+			TmpPublicKeyInitiator: other.TmpPublicKeyInitiator,
+			PublicKeyResponder:    pub,
+			PrivateKeyResponder:   priv,
+		}
+		if err := responder.RequestKeyAgreement(forged); err == nil {
+			t.Errorf("%s forged tmp public key should be rejected", encryptType)
+		}
+	}
+}
+
+func TestOWTPNode_KeyRotation(t *testing.T) {
+	for _, connectType := range []string{Websocket, HTTP} {
+		testKeyRotation(t, connectType, KeyAgreementX25519ChaCha20Poly1305)
+		testKeyRotation(t, connectType, KeyAgreementX25519AESGCM)
+	}
+}
+
+func testKeyRotation(t *testing.T, connectType, encryptType string) {
+
+	addr := freeAddress(t)
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	server.HandleFunc("echo", func(ctx *Context) {
+		ctx.Response(ctx.Params().Get("index").Int(), StatusSuccess, "success")
+	})
+	err := server.Listen(ConnectConfig{
+		Address:         addr,
+		ConnectType:     connectType,
+		EnableSignature: true,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err = client.Connect("server", ConnectConfig{
+		Address:             addr,
+		ConnectType:         connectType,
+		EnableSignature:     true,
+		EnableKeyAgreement:  true,
+		KeyAgreementType:    encryptType,
+		KeyRotationMessages: 3,
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	keys := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		resp, err := client.CallSync("server", "echo", map[string]interface{}{"index": i})
+		if err != nil {
+			t.Errorf("%s %s CallSync unexpected error: %v", connectType, encryptType, err)
+			return
+		}
+		if resp.Status != StatusSuccess || resp.JsonData().Int() != int64(i) {
+			t.Errorf("%s %s call %d response status: %d, msg: %s, result: %v", connectType, encryptType, i, resp.Status, resp.Msg, resp.Result)
+			return
+		}
+		ka := client.Peerstore().Get("server", keyAgreementCipher).(*KeyAgreement)
+		keys[ka.Key] = true
+	}
+
+	//每3个请求轮换一次密钥
+	if len(keys) != 4 {
+		t.Errorf("%s %s rotated keys = %d, want 4", connectType, encryptType, len(keys))
+	}
+}
+
+func TestOWTPNode_KeyRotationConcurrent(t *testing.T) {
+
+	addr := freeAddress(t)
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	server.HandleFunc("echo", func(ctx *Context) {
+		//延迟响应，轮换时仍有使用旧密钥的请求
+		time.Sleep(20 * time.Millisecond)
+		ctx.Response(ctx.Params().Get("index").Int(), StatusSuccess, "success")
+	})
+	err := server.Listen(ConnectConfig{
+		Address:         addr,
+		ConnectType:     Websocket,
+		EnableSignature: true,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err = client.Connect("server", ConnectConfig{
+		Address:             addr,
+		ConnectType:         Websocket,
+		EnableSignature:     true,
+		EnableKeyAgreement:  true,
+		KeyAgreementType:    KeyAgreementX25519ChaCha20Poly1305,
+		KeyRotationMessages: 2,
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var failed int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.CallSync("server", "echo", map[string]interface{}{"index": i})
+			if err != nil || resp.Status != StatusSuccess || resp.JsonData().Int() != int64(i) {
+				atomic.AddInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		t.Errorf("%d requests failed during key rotation", failed)
+	}
+
+	ka := client.Peerstore().Get("server", keyAgreementCipher).(*KeyAgreement)
+	n := 0
+	for prev := ka.Previous; prev != nil; prev = prev.Previous {
+		if prev.Key == ka.Key {
+			t.Errorf("previous key should be different")
+		}
+		n++
+	}
+	if n == 0 || n > maxPreviousKeyAgreements {
+		t.Errorf("rotated key agreement keeps %d previous keys", n)
+	}
+}
+
+func TestOWTPNode_KeyAgreementTypes(t *testing.T) {
+
+	addr := freeAddress(t)
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	server.SetKeyAgreementTypes(KeyAgreementX25519AESGCM)
+	err := server.Listen(ConnectConfig{
+		Address:         addr,
+		ConnectType:     Websocket,
+		EnableSignature: true,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	for encryptType, allowed := range map[string]bool{
+		KeyAgreementX25519AESGCM:           true,
+		KeyAgreementX25519ChaCha20Poly1305: false,
+		KeyAgreementSM2AES:                 false,
+	} {
+		client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		_, err = client.Connect("server", ConnectConfig{
+			Address:            addr,
+			ConnectType:        Websocket,
+			EnableSignature:    true,
+			EnableKeyAgreement: true,
+			KeyAgreementType:   encryptType,
+		})
+		if allowed && err != nil {
+			t.Errorf("%s Connect unexpected error: %v", encryptType, err)
+		}
+		if !allowed && err == nil {
+			t.Errorf("%s is not allowed, key agreement should fail", encryptType)
+		}
+		client.Close()
+	}
+}
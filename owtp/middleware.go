@@ -0,0 +1,110 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"runtime/debug"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+//Middleware 中间件，包装路由处理方法，调用next继续执行，不调用则中断
+type Middleware func(next HandlerFunc) HandlerFunc
+
+//Use 添加全局中间件，按添加顺序由外到内执行，内置方法不经过中间件
+func (mux *ServeMux) Use(middlewares ...Middleware) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.middlewares = append(mux.middlewares, middlewares...)
+}
+
+//chain 组合全局中间件和路由中间件
+func (mux *ServeMux) chain(entry muxEntry) HandlerFunc {
+
+	h := entry.h
+	for i := len(entry.middlewares) - 1; i >= 0; i-- {
+		h = entry.middlewares[i](h)
+	}
+
+	if entry.inner {
+		return h
+	}
+
+	mux.mu.RLock()
+	middlewares := mux.middlewares
+	mux.mu.RUnlock()
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+//notFoundMethod 找不到方法的处理
+func notFoundMethod(ctx *Context) {
+	ctx.Resp = responseError("can not find method", ErrNotFoundMethod)
+}
+
+//RouteGroup 路由组，组内路由使用相同的方法名前缀和中间件
+type RouteGroup struct {
+	mux         *ServeMux
+	prefix      string
+	middlewares []Middleware
+}
+
+//Group 创建路由组
+func (mux *ServeMux) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		mux:         mux,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+//Use 添加路由组的中间件，只对之后绑定的路由生效
+func (g *RouteGroup) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+//Group 创建子路由组，继承前缀和中间件
+func (g *RouteGroup) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		mux:         g.mux,
+		prefix:      g.prefix + prefix,
+		middlewares: append(append([]Middleware{}, g.middlewares...), middlewares...),
+	}
+}
+
+//HandleFunc 绑定组内路由，方法名为前缀+method
+func (g *RouteGroup) HandleFunc(method string, handler HandlerFunc, middlewares ...Middleware) {
+	all := append(append([]Middleware{}, g.middlewares...), middlewares...)
+	g.mux.HandleFunc(g.prefix+method, handler, all...)
+}
+
+//RecoveryMiddleware 捕获路由方法的panic，返回服务器错误，panic详情只记录在日志，不返回给对方
+func RecoveryMiddleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Errorf("OWTP: method [%s] panic: %v\n%s", ctx.Method, r, debug.Stack())
+					ctx.ResponseStopRun(nil, ErrInternalServerError, "internal server error")
+				}
+			}()
+			next(ctx)
+		}
+	}
+}
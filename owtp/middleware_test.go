@@ -0,0 +1,151 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mr-tron/base58/base58"
+)
+
+func serveTestRequest(mux *ServeMux, pid, method string) *Context {
+	ctx := &Context{
+		Req:    WSRequest,
+		PID:    pid,
+		Method: method,
+		nonce:  1,
+		Peer:   &HTTPClient{pid: pid, _auth: &OWTPAuth{}},
+	}
+	mux.ServeOWTP(pid, ctx)
+	return ctx
+}
+
+func traceMiddleware(name string, trace *[]string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			*trace = append(*trace, name+">")
+			next(ctx)
+			*trace = append(*trace, "<"+name)
+		}
+	}
+}
+
+func TestServeMux_MiddlewareOrder(t *testing.T) {
+
+	var trace []string
+
+	mux := NewServeMux(0)
+	mux.Use(traceMiddleware("global", &trace))
+	group := mux.Group("wallet.", traceMiddleware("group", &trace))
+	group.HandleFunc("getInfo", func(ctx *Context) {
+		trace = append(trace, "handler")
+		ctx.Response(nil, StatusSuccess, "success")
+	}, traceMiddleware("route", &trace))
+
+	ctx := serveTestRequest(mux, "1", "wallet.getInfo")
+	if ctx.Resp.Status != StatusSuccess {
+		t.Errorf("response status: %d, msg: %s", ctx.Resp.Status, ctx.Resp.Msg)
+	}
+
+	got := strings.Join(trace, " ")
+	want := "global> group> route> handler <route <group <global"
+	if got != want {
+		t.Errorf("trace = %s, want %s", got, want)
+	}
+
+	//找不到的方法也经过全局中间件
+	trace = nil
+	ctx = serveTestRequest(mux, "1", "getInfo")
+	if ctx.Resp.Status != ErrNotFoundMethod {
+		t.Errorf("response status: %d, want %d", ctx.Resp.Status, ErrNotFoundMethod)
+	}
+	if got = strings.Join(trace, " "); got != "global> <global" {
+		t.Errorf("trace = %s", got)
+	}
+}
+
+func TestServeMux_RecoveryMiddleware(t *testing.T) {
+
+	finished := false
+
+	mux := NewServeMux(0)
+	mux.Use(RecoveryMiddleware())
+	mux.HandleFunc(FinishMethod, func(ctx *Context) {
+		finished = true
+	})
+	mux.HandleFunc("panic", func(ctx *Context) {
+		panic("boom")
+	})
+
+	ctx := serveTestRequest(mux, "1", "panic")
+	if ctx.Resp.Status != ErrInternalServerError {
+		t.Errorf("response status: %d, want %d", ctx.Resp.Status, ErrInternalServerError)
+	}
+	//panic详情不返回给对方
+	if strings.Contains(ctx.Resp.Msg, "boom") {
+		t.Errorf("response msg should not contain panic detail: %s", ctx.Resp.Msg)
+	}
+	if finished {
+		t.Errorf("finish should not run after panic")
+	}
+}
+
+func TestACL_Middleware(t *testing.T) {
+
+	cert := NewRandomCertificate()
+	pub := base58.Encode(cert.PublicKeyBytes())
+
+	acl, err := NewACL(
+		ACLRule{PublicKey: pub, Methods: []string{"admin.*"}},
+		ACLRule{PID: ACLAnyPeer, Methods: []string{"getInfo"}},
+	)
+	if err != nil {
+		t.Errorf("NewACL unexpected error: %v", err)
+		return
+	}
+
+	mux := NewServeMux(0)
+	mux.Use(acl.Middleware())
+	handler := func(ctx *Context) {
+		ctx.Response(nil, StatusSuccess, "success")
+	}
+	mux.HandleFunc("getInfo", handler)
+	mux.HandleFunc("admin.shutdown", handler)
+
+	tests := []struct {
+		pid    string
+		method string
+		status uint64
+	}{
+		{cert.ID(), "admin.shutdown", StatusSuccess},
+		{cert.ID(), "getInfo", StatusSuccess},
+		{"other", "getInfo", StatusSuccess},
+		{"other", "admin.shutdown", ErrUnauthorized},
+	}
+
+	for _, test := range tests {
+		ctx := serveTestRequest(mux, test.pid, test.method)
+		if ctx.Resp.Status != test.status {
+			t.Errorf("%s call %s status: %d, want %d", test.pid, test.method, ctx.Resp.Status, test.status)
+		}
+	}
+
+	acl.Revoke(cert.ID())
+	if acl.IsAllowed(cert.ID(), "admin.shutdown") {
+		t.Errorf("revoked peer should not be allowed")
+	}
+}
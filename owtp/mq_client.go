@@ -16,24 +16,53 @@
 package owtp
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
-	"github.com/gorilla/websocket"
 	"github.com/streadway/amqp"
-	"github.com/tidwall/gjson"
 	"net"
 	"sync"
 )
 
+//MQConnection mq连接接口，*amqp.Connection已实现
+type MQConnection interface {
+	Close() error
+	LocalAddr() net.Addr
+}
+
+//MQChannel mq通道接口，*amqp.Channel已实现，测试时可使用进程内的实现代替
+type MQChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+//mqDial 连接mq服务并打开通道
+var mqDial = func(url string) (MQConnection, MQChannel, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, channel, nil
+}
+
 //MQClient 基于mq的通信客户端
 type MQClient struct {
 	_auth           Authorization
-	conn            *amqp.Connection
-	channel         *amqp.Channel
+	conn            MQConnection
+	channel         MQChannel
 	handler         PeerHandler
-	_send           chan []byte
+	_send           chan mqMessage
 	isHost          bool
 	ReadBufferSize  int
 	WriteBufferSize int
@@ -43,10 +72,20 @@ type MQClient struct {
 	closeOnce       sync.Once
 	done            func()
 	config          ConnectConfig //节点配置
+	replyTo         string        //监听器创建的节点，响应发送到对方的队列
+	replyQueue      string        //接收对方响应的队列，为空时使用ReadQueueName
+	replyRoutes     sync.Map      //对方请求指定的回复队列，nonce: 队列
+	exit            chan struct{}
+	negotiatedEncoding
 }
 
 // Dial connects a client to the given URL.
 func MQDial(pid, url string, handler PeerHandler) (*MQClient, error) {
+	return mqDialWithConfig(pid, url, ConnectConfig{ConnectType: MQ}, nil, handler)
+}
+
+//mqDialWithConfig 连接mq服务，在通知节点加入前设置好配置和授权，读取队列依赖配置
+func mqDialWithConfig(pid, url string, config ConnectConfig, auth Authorization, handler PeerHandler) (*MQClient, error) {
 
 	if handler == nil {
 		return nil, errors.New("hander should not be nil! ")
@@ -59,19 +98,16 @@ func MQDial(pid, url string, handler PeerHandler) (*MQClient, error) {
 	//}
 	log.Debug("Connecting URL:", url)
 
-	conn, err := amqp.Dial(url)
+	conn, channel, err := mqDial(url)
 	if err != nil {
 		return nil, err
 	}
 
-	channel, err := conn.Channel()
-	if err != nil {
-		return nil, err
-	}
-	client, err := NewMQClient(pid, conn, channel, handler, nil, nil)
+	client, err := NewMQClient(pid, conn, channel, handler, auth, nil)
 	if err != nil {
 		return nil, err
 	}
+	client.config = config
 
 	client.isConnect = true
 	client.isHost = true //我方主动连接
@@ -80,7 +116,7 @@ func MQDial(pid, url string, handler PeerHandler) (*MQClient, error) {
 	return client, nil
 }
 
-func NewMQClient(pid string, conn *amqp.Connection, channel *amqp.Channel, hander PeerHandler, auth Authorization, done func()) (*MQClient, error) {
+func NewMQClient(pid string, conn MQConnection, channel MQChannel, hander PeerHandler, auth Authorization, done func()) (*MQClient, error) {
 
 	if hander == nil {
 		return nil, errors.New("hander should not be nil! ")
@@ -90,7 +126,8 @@ func NewMQClient(pid string, conn *amqp.Connection, channel *amqp.Channel, hande
 		pid:     pid,
 		conn:    conn,
 		channel: channel,
-		_send:   make(chan []byte, MaxMessageSize),
+		_send:   make(chan mqMessage, MaxMessageSize),
+		exit:    make(chan struct{}),
 		_auth:   auth,
 		done:    done,
 		config: ConnectConfig{
@@ -153,7 +190,11 @@ func (c *MQClient) close() error {
 			c.done = nil
 		}
 
-		err = c.conn.Close()
+		//监听器创建的节点共用监听器的连接，不需要关闭
+		if c.conn != nil {
+			err = c.conn.Close()
+		}
+		close(c.exit)
 		c.isConnect = false
 		c.handler.OnPeerClose(c, "client close")
 	})
@@ -170,6 +211,11 @@ func (c *MQClient) LocalAddr() net.Addr {
 
 //RemoteAddr 远程节点地址
 func (c *MQClient) RemoteAddr() net.Addr {
+	if len(c.replyTo) > 0 {
+		return &MqAddr{
+			NetWork: c.replyTo,
+		}
+	}
 	if c.conn == nil {
 		return nil
 	}
@@ -179,15 +225,31 @@ func (c *MQClient) RemoteAddr() net.Addr {
 	return addr
 }
 
+//mqMessage 待发送的消息，queue为空时发送到节点默认的队列
+type mqMessage struct {
+	body  []byte
+	queue string
+}
+
 //Send 发送消息
 func (c *MQClient) send(data DataPacket) error {
 
-	respBytes, err := json.Marshal(data)
+	respBytes, err := EncodeDataPacket(&data)
 	if err != nil {
 		return err
 	}
 
-	c._send <- respBytes
+	message := mqMessage{body: respBytes}
+
+	//响应发送到请求指定的回复队列
+	if data.Req == WSResponse {
+		if queue, ok := c.replyRoutes.Load(data.Nonce); ok {
+			c.replyRoutes.Delete(data.Nonce)
+			message.queue = queue.(string)
+		}
+	}
+
+	c._send <- message
 	return nil
 }
 
@@ -201,6 +263,11 @@ func (c *MQClient) openPipe() error {
 	//发送通道
 	go c.writePump()
 
+	//监听器创建的节点由监听器分发消息
+	if len(c.replyTo) > 0 {
+		return nil
+	}
+
 	//监听消息
 	go c.readPump()
 
@@ -219,30 +286,62 @@ func (c *MQClient) writePump() {
 		case message, ok := <-c._send:
 			//发送消息
 			if !ok {
-				c.write(websocket.CloseMessage, []byte{})
 				return
 			}
 			if Debug {
-				log.Debug("Send: ", string(message))
+				log.Debug("Send: ", string(message.body))
 			}
-			if err := c.write(websocket.TextMessage, message); err != nil {
+			if err := c.write(message); err != nil {
 				return
 			}
-
+		case <-c.exit:
+			return
 		}
 	}
 }
 
 // write 输出数据
-func (c *MQClient) write(mt int, message []byte) error {
+func (c *MQClient) write(message mqMessage) error {
 	if c.channel == nil {
 		return new(amqp.Error)
 	}
 	exchange := c.ConnectConfig().Exchange
 	queueName := c.ConnectConfig().WriteQueueName
+	if len(c.replyTo) > 0 {
+		queueName = c.replyTo
+	}
+	if len(message.queue) > 0 {
+		queueName = message.queue
+	}
+	replyTo := c.ConnectConfig().ReadQueueName
+	if len(c.replyQueue) > 0 {
+		replyTo = c.replyQueue
+	}
+	contentType := "text/plain"
+	if IsCBORDataPacket(message.body) {
+		contentType = "application/cbor"
+	}
+
+	//带上本节点公钥和读取队列，监听方以此区分节点和回复消息
+	headers := amqp.Table{}
+	if auth, ok := c._auth.(*OWTPAuth); ok {
+		for k, v := range auth.HTTPAuthHeader() {
+			headers[k] = v
+		}
+	}
+
+	//发起方带上期望的编码，监听方回复已协商的编码
+	if encoding := c.encoding(); encoding != EncodingJSON {
+		headers[EncodingHeader] = encoding
+	} else if encoding := acceptEncoding(c.ConnectConfig().Encoding); encoding != EncodingJSON {
+		headers[EncodingHeader] = encoding
+	}
+
 	err := c.channel.Publish(exchange, queueName, false, false, amqp.Publishing{
-		ContentType: "text/plain",
-		Body:        []byte(message),
+		ContentType: contentType,
+		Headers:     headers,
+		ReplyTo:     replyTo,
+		Body:        message.body,
 	})
 	return err
 }
@@ -275,8 +374,21 @@ func (c *MQClient) readPump() {
 	go func() {
 		//fmt.Println(*msgs)
 		for d := range messages {
-			packet := NewDataPacket(gjson.ParseBytes(d.Body))
-			fmt.Printf("packet：%s", string(d.Body))
+			packet, err := DecodeDataPacket(d.Body)
+			if err != nil {
+				log.Error("mq decode data packet unexpected error: ", err)
+				continue
+			}
+			//监听方返回相同的编码才算协商成功
+			if requested := acceptEncoding(c.ConnectConfig().Encoding); requested != EncodingJSON {
+				if e, _ := d.Headers[EncodingHeader].(string); e == requested {
+					c.setEncoding(requested)
+				}
+			}
+			//对方请求指定了其他回复队列，例如竞争消费的监听器发送的流式数据块，响应需要回到该监听器
+			if packet.Req == WSRequest && len(d.ReplyTo) > 0 && d.ReplyTo != c.ConnectConfig().WriteQueueName {
+				c.replyRoutes.Store(packet.Nonce, d.ReplyTo)
+			}
 			//开一个goroutine处理消息
 			go c.handler.OnPeerNewDataPacketReceived(c, packet)
 		}
@@ -0,0 +1,371 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/mr-tron/base58/base58"
+	"github.com/streadway/amqp"
+)
+
+//MQPrefetchCount 监听器未确认消息的最大数量，多个监听器消费同一队列时，由mq服务按此分配请求
+var MQPrefetchCount = 16
+
+//MQPeerIdleTimeout 监听器创建的节点没有处理中的请求，且空闲超过该时间后关闭，小于等于0不关闭
+var MQPeerIdleTimeout = 10 * time.Minute
+
+//mqListenerPeer 监听器创建的节点及其活动状态
+type mqListenerPeer struct {
+	*MQClient
+	lastSeen time.Time //最近一次收到或处理完消息的时间
+	pending  int       //处理中的请求数
+}
+
+//owtp mq监听器，消费ReadQueueName的消息，按发送方公钥和回复队列区分节点
+type mqListener struct {
+	conn            MQConnection
+	channel         MQChannel
+	config          ConnectConfig
+	cert            Certificate
+	handler         PeerHandler
+	enableSignature bool
+	incoming        chan Peer
+	closed          chan struct{}
+	closeOnce       sync.Once
+	mu              sync.Mutex
+	peers           map[string]*mqListenerPeer
+	replyQueue      string //监听器独占的回复队列，接收对方对本监听器所发请求的响应
+}
+
+//MQListenAddr 连接mq服务，创建mq通信监听
+func MQListenAddr(config ConnectConfig, cert Certificate, enableSignature bool, handler PeerHandler) (*mqListener, error) {
+
+	url := "amqp://" + config.Account + ":" + config.Password + "@" + strings.TrimSuffix(config.Address, "/") + "/"
+
+	conn, channel, err := mqDial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := NewMQListener(channel, config, cert, enableSignature, handler)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	listener.conn = conn
+
+	return listener, nil
+}
+
+//NewMQListener 使用已打开的通道创建mq通信监听，多个监听器可以消费同一个队列分担请求。
+//同一发送方的请求会被分配到不同的监听器，开启签名或协商密码时，监听器所在的节点需要使用相同的证书，
+//并通过SetPeerstore和SetNonceCache使用共享的存储，否则每次换到其他监听器都要重新协商密码，
+//防重放也只在单个节点内有效。无法共享存储时，应为每个发送方使用独立的队列
+func NewMQListener(channel MQChannel, config ConnectConfig, cert Certificate, enableSignature bool, handler PeerHandler) (*mqListener, error) {
+
+	if handler == nil {
+		return nil, fmt.Errorf("hander should not be nil! ")
+	}
+
+	queueName := config.ReadQueueName
+	if len(queueName) == 0 {
+		return nil, fmt.Errorf("readQueueName must contain by config")
+	}
+
+	_, err := channel.QueueDeclare(queueName, true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = channel.QueueBind(queueName, queueName, config.Exchange, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	//限制未确认的消息数量，竞争消费者之间才能平均分配
+	err = channel.Qos(MQPrefetchCount, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := channel.Consume(queueName, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	//竞争消费时对方的响应不能发送到共享队列，否则可能被其他监听器消费，例如流式响应数据块的确认
+	suffix := make([]byte, 8)
+	if _, err = rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	replyQueue := queueName + "." + hex.EncodeToString(suffix)
+
+	_, err = channel.QueueDeclare(replyQueue, false, true, true, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = channel.QueueBind(replyQueue, replyQueue, config.Exchange, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := channel.Consume(replyQueue, "", false, true, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := &mqListener{
+		channel:         channel,
+		config:          config,
+		cert:            cert,
+		handler:         handler,
+		enableSignature: enableSignature,
+		incoming:        make(chan Peer),
+		closed:          make(chan struct{}),
+		peers:           make(map[string]*mqListenerPeer),
+		replyQueue:      replyQueue,
+	}
+
+	go listener.serve(messages, replies)
+
+	return listener, nil
+}
+
+//serve 分发队列和回复队列中的消息，定时关闭空闲的节点
+func (l *mqListener) serve(messages, replies <-chan amqp.Delivery) {
+
+	var idleCheck <-chan time.Time
+	if MQPeerIdleTimeout > 0 {
+		ticker := time.NewTicker(MQPeerIdleTimeout / 2)
+		defer ticker.Stop()
+		idleCheck = ticker.C
+	}
+
+	for {
+		select {
+		case d, ok := <-messages:
+			if !ok {
+				log.Error("mq listener: consume channel is closed")
+				l.Close()
+				return
+			}
+			l.dispatch(d)
+		case d, ok := <-replies:
+			if !ok {
+				log.Error("mq listener: reply channel is closed")
+				l.Close()
+				return
+			}
+			l.dispatch(d)
+		case now := <-idleCheck:
+			l.closeIdlePeers(now)
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+//dispatch 把消息交给发送方对应的节点处理，处理完成后确认消息
+func (l *mqListener) dispatch(d amqp.Delivery) {
+
+	if len(d.ReplyTo) == 0 {
+		log.Error("mq listener: message without reply-to is dropped")
+		d.Reject(false)
+		return
+	}
+
+	if limit := packetSizeLimit(l.handler); limit > 0 && int64(len(d.Body)) > limit {
+		log.Errorf("mq listener: message size %d exceeds the limit %d, dropped", len(d.Body), limit)
+		d.Reject(false)
+		return
+	}
+
+	packet, err := DecodeDataPacket(d.Body)
+	if err != nil {
+		log.Error("mq listener: decode data packet unexpected error: ", err)
+		d.Reject(false)
+		return
+	}
+
+	peer, isNew, err := l.getPeer(d)
+	if err != nil {
+		log.Error("mq listener: create peer unexpected error: ", err)
+		d.Reject(false)
+		return
+	}
+
+	//新节点先交给节点管理加入在线列表
+	if isNew {
+		select {
+		case l.incoming <- peer.MQClient:
+		case <-l.closed:
+			l.donePeer(peer)
+			d.Reject(true)
+			return
+		}
+	}
+
+	go func() {
+		l.handler.OnPeerNewDataPacketReceived(peer.MQClient, packet)
+		l.donePeer(peer)
+		d.Ack(false)
+	}()
+}
+
+//getPeer 获取发送方节点，不存在则创建，返回的节点记录一个处理中的请求
+func (l *mqListener) getPeer(d amqp.Delivery) (*mqListenerPeer, bool, error) {
+
+	a, _ := d.Headers["a"].(string)
+	key := d.ReplyTo + "/" + a
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if peer, ok := l.peers[key]; ok && peer.IsConnected() {
+		peer.lastSeen = time.Now()
+		peer.pending++
+		return peer, false, nil
+	}
+
+	var (
+		remotePublicKey []byte
+		err             error
+	)
+
+	if len(a) == 0 {
+		//没有公钥的节点，与websocket一致使用随机公钥
+		_, remotePublicKey = owcrypt.KeyAgreement_initiator_step1(owcrypt.ECC_CURVE_SM2_STANDARD)
+	} else {
+		remotePublicKey, err = base58.Decode(a)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	auth := &OWTPAuth{
+		remotePublicKey: remotePublicKey,
+		enable:          l.enableSignature,
+		localPublicKey:  l.cert.PublicKeyBytes(),
+		localPrivateKey: l.cert.PrivateKeyBytes(),
+	}
+
+	peer := &mqListenerPeer{lastSeen: time.Now(), pending: 1}
+	client, err := NewMQClient(auth.RemotePID(), nil, l.channel, l.handler, auth, func() {
+		l.removePeer(key, peer)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	client.config = l.config
+	client.replyTo = d.ReplyTo
+	client.replyQueue = l.replyQueue
+	e, _ := d.Headers[EncodingHeader].(string)
+	client.setEncoding(acceptEncoding(e))
+	peer.MQClient = client
+
+	l.peers[key] = peer
+
+	return peer, true, nil
+}
+
+//donePeer 节点的请求处理完成
+func (l *mqListener) donePeer(peer *mqListenerPeer) {
+	l.mu.Lock()
+	peer.pending--
+	peer.lastSeen = time.Now()
+	l.mu.Unlock()
+}
+
+//closeIdlePeers 关闭没有处理中的请求且空闲超时的节点
+func (l *mqListener) closeIdlePeers(now time.Time) {
+
+	l.mu.Lock()
+	idle := make([]*MQClient, 0)
+	for _, peer := range l.peers {
+		if peer.pending <= 0 && now.Sub(peer.lastSeen) >= MQPeerIdleTimeout {
+			idle = append(idle, peer.MQClient)
+		}
+	}
+	l.mu.Unlock()
+
+	//关闭时通过done回调移出节点列表
+	for _, peer := range idle {
+		peer.close()
+	}
+}
+
+func (l *mqListener) removePeer(key string, peer *mqListenerPeer) {
+	l.mu.Lock()
+	if l.peers[key] == peer {
+		delete(l.peers, key)
+	}
+	l.mu.Unlock()
+}
+
+//Accept 接收新节点链接，线程阻塞
+func (l *mqListener) Accept() (Peer, error) {
+	select {
+	case c, ok := <-l.incoming:
+		if !ok {
+			return nil, fmt.Errorf("listener is closed")
+		}
+		return c, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("listener is closed")
+	}
+}
+
+//Close 关闭监听，断开所有节点
+func (l *mqListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+
+		l.mu.Lock()
+		peers := make([]*MQClient, 0, len(l.peers))
+		for _, peer := range l.peers {
+			peers = append(peers, peer.MQClient)
+		}
+		l.mu.Unlock()
+
+		for _, peer := range peers {
+			peer.close()
+		}
+
+		err = l.channel.Close()
+		if l.conn != nil {
+			err = l.conn.Close()
+		}
+	})
+	return err
+}
+
+//Addr 监听地址
+func (l *mqListener) Addr() net.Addr {
+	return &MqAddr{
+		NetWork: l.config.Address,
+	}
+}
@@ -0,0 +1,337 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/cache"
+	"github.com/streadway/amqp"
+)
+
+//testMQBroker 进程内的mq服务，按路由键投递到同名队列，同一队列的消费者竞争消费
+type testMQBroker struct {
+	mu     sync.Mutex
+	queues map[string]chan amqp.Delivery
+}
+
+func (b *testMQBroker) queue(name string) chan amqp.Delivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.queues == nil {
+		b.queues = make(map[string]chan amqp.Delivery)
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		q = make(chan amqp.Delivery, 1024)
+		b.queues[name] = q
+	}
+	return q
+}
+
+type testMQAcker struct{}
+
+func (testMQAcker) Ack(tag uint64, multiple bool) error                { return nil }
+func (testMQAcker) Nack(tag uint64, multiple bool, requeue bool) error { return nil }
+func (testMQAcker) Reject(tag uint64, requeue bool) error              { return nil }
+
+type testMQConnection struct{}
+
+func (testMQConnection) Close() error        { return nil }
+func (testMQConnection) LocalAddr() net.Addr { return &MqAddr{NetWork: "memory"} }
+
+type testMQChannel struct {
+	broker *testMQBroker
+}
+
+func (c *testMQChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.broker.queue(key) <- amqp.Delivery{
+		Acknowledger: testMQAcker{},
+		Headers:      msg.Headers,
+		ContentType:  msg.ContentType,
+		ReplyTo:      msg.ReplyTo,
+		Body:         msg.Body,
+	}
+	return nil
+}
+
+func (c *testMQChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return c.broker.queue(queue), nil
+}
+
+func (c *testMQChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.broker.queue(name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *testMQChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return nil
+}
+
+func (c *testMQChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return nil
+}
+
+func (c *testMQChannel) NotifyClose(ch chan *amqp.Error) chan *amqp.Error {
+	return ch
+}
+
+func (c *testMQChannel) Close() error {
+	return nil
+}
+
+func TestMQListener_CompetingConsumers(t *testing.T) {
+
+	defer testMQDial()()
+
+	var (
+		mu     sync.Mutex
+		served = make(map[string]int)
+	)
+
+	//两个服务节点消费同一个队列
+	for i := 0; i < 2; i++ {
+		server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		defer server.Close()
+		err := server.Listen(ConnectConfig{
+			Address:       "memory",
+			ConnectType:   MQ,
+			Exchange:      "owtp",
+			ReadQueueName: "owtp.server",
+		})
+		if err != nil {
+			t.Errorf("Listen unexpected error: %v", err)
+			return
+		}
+		serverID := server.NodeID()
+		server.HandleFunc("hello", func(ctx *Context) {
+			mu.Lock()
+			served[serverID]++
+			mu.Unlock()
+			ctx.Resp = Response{Status: StatusSuccess, Msg: serverID}
+		})
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{
+		Address:        "memory",
+		ConnectType:    MQ,
+		Exchange:       "owtp",
+		WriteQueueName: "owtp.server",
+		ReadQueueName:  "owtp.client",
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	total := 20
+	for i := 0; i < total; i++ {
+		resp, err := client.CallSync("server", "hello", map[string]interface{}{"index": i})
+		if err != nil {
+			t.Errorf("CallSync unexpected error: %v", err)
+			return
+		}
+		if resp.Status != StatusSuccess {
+			t.Errorf("response status: %d, msg: %s", resp.Status, resp.Msg)
+			return
+		}
+	}
+
+	count := 0
+	for id, n := range served {
+		t.Logf("server %s served %d requests", id, n)
+		count += n
+	}
+	if count != total {
+		t.Errorf("served requests = %d, want %d", count, total)
+	}
+}
+
+//testMQDial 使用进程内的mq服务，返回恢复mqDial的函数
+func testMQDial() func() {
+	broker := &testMQBroker{}
+	dial := mqDial
+	mqDial = func(url string) (MQConnection, MQChannel, error) {
+		return testMQConnection{}, &testMQChannel{broker: broker}, nil
+	}
+	return func() {
+		mqDial = dial
+	}
+}
+
+func TestMQListener_CompetingConsumersKeyAgreement(t *testing.T) {
+
+	defer testMQDial()()
+
+	var (
+		mu     sync.Mutex
+		served = make(map[int]int)
+	)
+
+	//竞争消费的节点使用相同的证书，共享协商密码和nonce缓存
+	cert := NewRandomCertificate()
+	peerstore := NewOWTPPeerstore()
+	nonceCache, _ := cache.NewCache("memory", `{"interval":60}`)
+
+	for i := 0; i < 2; i++ {
+		server := NewOWTPNode(cert, 0, 0)
+		defer server.Close()
+		server.SetPeerstore(peerstore)
+		server.SetNonceCache(nonceCache)
+		err := server.Listen(ConnectConfig{
+			Address:       "memory",
+			ConnectType:   MQ,
+			Exchange:      "owtp",
+			ReadQueueName: "owtp.server",
+		})
+		if err != nil {
+			t.Errorf("Listen unexpected error: %v", err)
+			return
+		}
+		index := i
+		server.HandleFunc("hello", func(ctx *Context) {
+			mu.Lock()
+			served[index]++
+			mu.Unlock()
+			ctx.Response(ctx.Params().Get("index").Int(), StatusSuccess, "success")
+		})
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{
+		Address:            "memory",
+		ConnectType:        MQ,
+		Exchange:           "owtp",
+		WriteQueueName:     "owtp.server",
+		ReadQueueName:      "owtp.client",
+		EnableKeyAgreement: true,
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	ka, _ := peerstore.Get(client.NodeID(), keyAgreementCipher).(*KeyAgreement)
+	if ka == nil {
+		t.Errorf("key agreement is not shared")
+		return
+	}
+	key := ka.Key
+
+	total := 20
+	for i := 0; i < total; i++ {
+		resp, err := client.CallSync("server", "hello", map[string]interface{}{"index": i})
+		if err != nil {
+			t.Errorf("CallSync unexpected error: %v", err)
+			return
+		}
+		if resp.Status != StatusSuccess || resp.JsonData().Int() != int64(i) {
+			t.Errorf("response status: %d, result: %v", resp.Status, resp.Result)
+			return
+		}
+	}
+
+	if len(served) != 2 {
+		t.Errorf("requests should be served by both listeners: %v", served)
+	}
+
+	//共享协商密码，换到其他监听器不需要重新协商
+	ka, _ = peerstore.Get(client.NodeID(), keyAgreementCipher).(*KeyAgreement)
+	if ka == nil || ka.Key != key {
+		t.Errorf("key agreement should not be regenerated")
+	}
+}
+
+func TestMQListener_CloseIdlePeers(t *testing.T) {
+
+	defer testMQDial()()
+
+	timeout := MQPeerIdleTimeout
+	MQPeerIdleTimeout = 50 * time.Millisecond
+	defer func() {
+		MQPeerIdleTimeout = timeout
+	}()
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	server.HandleFunc("hello", func(ctx *Context) {
+		ctx.Resp = Response{Status: StatusSuccess}
+	})
+	err := server.Listen(ConnectConfig{
+		Address:       "memory",
+		ConnectType:   MQ,
+		Exchange:      "owtp",
+		ReadQueueName: "owtp.server",
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err = client.Connect("server", ConnectConfig{
+		Address:        "memory",
+		ConnectType:    MQ,
+		Exchange:       "owtp",
+		WriteQueueName: "owtp.server",
+		ReadQueueName:  "owtp.client",
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	call := func() {
+		resp, err := client.CallSync("server", "hello", nil)
+		if err != nil || resp.Status != StatusSuccess {
+			t.Errorf("CallSync = %+v, err = %v", resp, err)
+		}
+	}
+
+	call()
+
+	server.mu.RLock()
+	listener := server.listeners[MQ].(*mqListener)
+	server.mu.RUnlock()
+
+	peers := func() int {
+		listener.mu.Lock()
+		defer listener.mu.Unlock()
+		return len(listener.peers)
+	}
+
+	if peers() != 1 {
+		t.Errorf("listener peers = %d, want 1", peers())
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if peers() != 0 {
+		t.Errorf("idle peers should be closed, listener peers = %d", peers())
+	}
+
+	//关闭后的节点再次发送请求时重新创建
+	call()
+	if peers() != 1 {
+		t.Errorf("listener peers = %d, want 1", peers())
+	}
+}
@@ -51,9 +51,10 @@ type RequestFunc func(resp Response)
 type RequestQueue map[uint64]requestEntry
 
 type muxEntry struct {
-	h      HandlerFunc
-	method string
-	inner  bool
+	h           HandlerFunc
+	method      string
+	inner       bool
+	middlewares []Middleware
 }
 
 type requestEntry struct {
@@ -62,6 +63,8 @@ type requestEntry struct {
 	h        RequestFunc
 	respChan chan Response
 	time     int64
+	replay   bool        //断线重连后是否重新发送
+	params   interface{} //重新发送的请求参数
 }
 
 type Response struct {
@@ -99,6 +102,12 @@ type Context struct {
 	Peer Peer
 	//数据包版本
 	Version int64
+	//请求使用的协商密码，响应时使用相同的密钥
+	keyAgreement *KeyAgreement
+	//所属节点，用于发送流式响应
+	node *OWTPNode
+	//流式响应
+	stream *streamWriter
 }
 
 //NewContext
@@ -176,6 +185,15 @@ func (resp *Response) JsonData() gjson.Result {
 	return jsondata
 }
 
+//NonceCache 已完成请求的nonce缓存，用于防重放，beego的cache.Cache可直接使用，
+//多个节点共享时可使用redis缓存，或使用redis会话存储的SessionManager.NonceCache
+type NonceCache interface {
+	//IsExist nonce是否存在且未过期
+	IsExist(key string) bool
+	//Put 记录nonce，timeout后过期
+	Put(key string, val interface{}, timeout time.Duration) error
+}
+
 //ServeMux 多路复用服务
 type ServeMux struct {
 	//读写锁
@@ -189,9 +207,11 @@ type ServeMux struct {
 	//节点的请求队列
 	peerRequest map[string]RequestQueue
 	//请求缓存
-	peerRequestCache cache.Cache
+	peerRequestCache NonceCache
 	//请求nonce的市场限制
 	requestNonceLimit time.Duration
+	//全局中间件
+	middlewares []Middleware
 }
 
 func NewServeMux(timeoutSEC int) *ServeMux {
@@ -214,8 +234,9 @@ func NewServeMux(timeoutSEC int) *ServeMux {
 //HandleFunc 路由处理器绑定
 //@param method API方法名
 //@param handler 处理方法入口
-func (mux *ServeMux) HandleFunc(method string, handler HandlerFunc) {
-	mux.handleFunc(method, handler, false)
+//@param middlewares 只对该路由生效的中间件
+func (mux *ServeMux) HandleFunc(method string, handler HandlerFunc, middlewares ...Middleware) {
+	mux.handleFunc(method, handler, false, middlewares...)
 }
 
 //handleFuncInner 设置内置方法
@@ -227,7 +248,7 @@ func (mux *ServeMux) handleFuncInner(method string, handler HandlerFunc) {
 //@param method API方法名
 //@param handler 处理方法入口
 //@param handler 是否内置方法
-func (mux *ServeMux) handleFunc(method string, handler HandlerFunc, inner bool) {
+func (mux *ServeMux) handleFunc(method string, handler HandlerFunc, inner bool, middlewares ...Middleware) {
 	mux.mu.Lock()
 	defer mux.mu.Unlock()
 
@@ -244,8 +265,28 @@ func (mux *ServeMux) handleFunc(method string, handler HandlerFunc, inner bool)
 	if mux.m == nil {
 		mux.m = make(map[string]muxEntry)
 	}
-	mux.m[method] = muxEntry{h: handler, method: method, inner: inner}
+	mux.m[method] = muxEntry{h: handler, method: method, inner: inner, middlewares: middlewares}
+
+}
+
+//isInnerMethod 是否内置方法
+func (mux *ServeMux) isInnerMethod(method string) bool {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	return mux.m[method].inner
+}
 
+//SetNonceCache 设置请求nonce缓存
+func (mux *ServeMux) SetNonceCache(c NonceCache) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.peerRequestCache = c
+}
+
+func (mux *ServeMux) nonceCache() NonceCache {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	return mux.peerRequestCache
 }
 
 //AddRequest 添加请求到队列
@@ -255,6 +296,11 @@ func (mux *ServeMux) handleFunc(method string, handler HandlerFunc, inner bool)
 //@param respChan 同步请求的响应通道
 //@param sync 是否同步
 func (mux *ServeMux) AddRequest(peer Peer, nonce uint64, time int64, method string, reqFunc RequestFunc, respChan chan Response, sync bool) error {
+	return mux.addRequest(peer, nonce, time, method, reqFunc, respChan, sync, false, nil)
+}
+
+//addRequest 添加请求到队列，replay为true时，断线重连后使用params重新发送
+func (mux *ServeMux) addRequest(peer Peer, nonce uint64, time int64, method string, reqFunc RequestFunc, respChan chan Response, sync, replay bool, params interface{}) error {
 
 	mux.mu.Lock()
 	defer mux.mu.Unlock()
@@ -280,7 +326,7 @@ func (mux *ServeMux) AddRequest(peer Peer, nonce uint64, time int64, method stri
 		return errors.New("OWTP: nonce exist. ")
 	}
 
-	requestQueue[nonce] = requestEntry{sync, method, reqFunc, respChan, time}
+	requestQueue[nonce] = requestEntry{sync, method, reqFunc, respChan, time, replay, params}
 
 	mux.peerRequest[pid] = requestQueue
 	return nil
@@ -331,6 +377,36 @@ func (mux *ServeMux) ResetRequestQueue(pid string) {
 	mux.peerRequest[pid] = requestQueue
 }
 
+//touchRequest 刷新请求时间，重新计算超时
+func (mux *ServeMux) touchRequest(pid string, nonce uint64) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	requestQueue := mux.peerRequest[pid]
+	if r, exist := requestQueue[nonce]; exist {
+		r.time = time.Now().Unix()
+		requestQueue[nonce] = r
+	}
+}
+
+//takeReplayRequests 取出可以重新发送的请求，从队列中移除
+func (mux *ServeMux) takeReplayRequests(pid string) []requestEntry {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	requests := make([]requestEntry, 0)
+	requestQueue := mux.peerRequest[pid]
+	for n, r := range requestQueue {
+		if !r.replay {
+			continue
+		}
+		requests = append(requests, r)
+		delete(requestQueue, n)
+	}
+
+	return requests
+}
+
 //ServeOWTP OWTP协议消息监听方法
 func (mux *ServeMux) ServeOWTP(pid string, ctx *Context) {
 
@@ -355,14 +431,12 @@ func (mux *ServeMux) ServeOWTP(pid string, ctx *Context) {
 
 			if !ctx.stop {
 				//路由方法存在
-				if ok {
-					//执行路由方法
-					f.h(ctx)
-
-				} else {
+				if !ok {
 					//找不到方法的处理
-					ctx.Resp = responseError("can not find method", ErrNotFoundMethod)
+					f = muxEntry{h: notFoundMethod, method: ctx.Method}
 				}
+				//经过中间件执行路由方法
+				mux.chain(f)(ctx)
 			}
 
 			if !ctx.stop {
@@ -410,6 +484,14 @@ func (mux *ServeMux) ServeOWTP(pid string, ctx *Context) {
 	}
 }
 
+//requestTimeout 请求的超时时间
+func (mux *ServeMux) requestTimeout() time.Duration {
+	if mux.timeout == 0 {
+		return DefaultTimoutSEC * time.Second
+	}
+	return mux.timeout
+}
+
 // timeoutRequestHandle 超时请求检查
 func (mux *ServeMux) timeoutRequestHandle() {
 	if mux.timeout == 0 {
@@ -480,8 +562,8 @@ func (mux *ServeMux) completeRequest(ctx *Context) {
 	}
 
 	//添加已完成的请求
-	if mux.peerRequestCache != nil {
-		mux.peerRequestCache.Put(
+	if nonceCache := mux.nonceCache(); nonceCache != nil {
+		nonceCache.Put(
 			fmt.Sprintf("%s_%d", ctx.PID, ctx.nonce),
 			ctx.Method,
 			mux.requestNonceLimit)
@@ -522,7 +604,7 @@ func (mux *ServeMux) checkNonceReplayReason(pid string, nonce uint64) (uint64, s
 	}
 
 	//检查是否重放
-	if mux.peerRequestCache.IsExist(fmt.Sprintf("%s_%d", pid, nonce)) {
+	if mux.nonceCache().IsExist(fmt.Sprintf("%s_%d", pid, nonce)) {
 		return ErrReplayAttack, "this is a replay attack"
 	}
 
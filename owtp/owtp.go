@@ -17,6 +17,7 @@
 package owtp
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -104,6 +105,30 @@ type ConnectConfig struct {
 	ReadBufferSize     int    `json:"readBufferSize"`     //socket读取缓存
 	WriteBufferSize    int    `json:"writeBufferSize"`    //socket写入缓存
 	EnableKeyAgreement bool   `json:"enableKeyAgreement"` //是否开启协商密码
+	Encoding           string `json:"encoding"`           //期望的数据包编码格式，json或cbor，默认json，连接时与对方协商，对方不支持时使用json
+
+	//协商密码，EnableKeyAgreement开启后有效，由发起方负责密钥轮换
+	KeyAgreementType    string `json:"keyAgreementType"`    //协商密码类型，默认aes，可选x25519-chacha20-poly1305，x25519-aes-256-gcm
+	KeyRotationMessages int    `json:"keyRotationMessages"` //发送请求数达到后重新协商密钥，0不轮换
+	KeyRotationMinutes  int    `json:"keyRotationMinutes"`  //协商密钥使用时间达到后重新协商，0不轮换
+
+	//断线重连，只对主动连接的websocket和mq节点有效
+	EnableReconnect      bool     `json:"enableReconnect"`      //是否开启断线自动重连
+	ReconnectMaxAttempts int      `json:"reconnectMaxAttempts"` //最大重连次数，0不限制
+	ReconnectBaseMS      int      `json:"reconnectBaseMS"`      //首次重连等待毫秒数，之后每次加倍，默认500
+	ReconnectMaxMS       int      `json:"reconnectMaxMS"`       //重连等待的上限毫秒数，默认30000
+	ReconnectJitter      float64  `json:"reconnectJitter"`      //重连等待的随机抖动比例，0~1，默认0.2
+	ReplayMethods        []string `json:"replayMethods"`        //幂等的方法，重连成功后重新发送未完成的请求
+
+	//TLS配置，EnableSSL开启后对http和websocket有效
+	TLSCertFile     string      `json:"tlsCertFile"`     //证书文件，监听时为服务端证书，连接时为客户端证书，未配置时使用节点签署的证书
+	TLSKeyFile      string      `json:"tlsKeyFile"`      //证书私钥文件
+	TLSCAFile       string      `json:"tlsCAFile"`       //CA证书文件，监听时校验客户端证书，连接时校验服务端证书
+	TLSServerName   string      `json:"tlsServerName"`   //校验服务端证书的域名
+	TLSPinnedKeys   []string    `json:"tlsPinnedKeys"`   //固定对方证书公钥，参考TLSPublicKeyPin，未配置CA时代替证书链校验
+	TLSBindIdentity bool        `json:"tlsBindIdentity"` //对方TLS证书必须由其节点证书签署，监听时还校验与请求的节点公钥一致
+	TLSRemoteID     string      `json:"tlsRemoteID"`     //连接时校验服务端TLS证书绑定的节点ID，绑定身份时默认为连接的节点ID
+	TLSConfig       *tls.Config `json:"-"`               //自定义TLS配置，设置后其他TLS配置不生效
 }
 
 //节点主配置 作为json解析工具
@@ -135,6 +160,24 @@ type OWTPNode struct {
 	peerstore Peerstore
 	//在线节点
 	onlinePeers map[string]Peer
+	//主动关闭的节点，不进行断线重连
+	closedPeers map[Peer]bool
+	//正在重连的节点
+	reconnectingPeers map[string]bool
+	//节点已关闭
+	closed bool
+	//主题订阅
+	pubsub *pubsub
+	//流式请求的接收状态
+	streams sync.Map
+	//请求限流器
+	limiter *rateLimiter
+	//协商密钥轮换状态
+	keyRotations sync.Map
+	//正在协商的参数，响应校验通过前不保存到节点存储
+	keyAgreements sync.Map
+	//允许对方发起协商的密码类型
+	keyAgreementTypes []string
 	//服务监听器
 	//listener Listener
 	//服务监听器
@@ -208,6 +251,10 @@ func NewNode(config NodeConfig) *OWTPNode {
 
 	//内部配置一个协商密码处理过程
 	node.serveMux.handleFuncInner(KeyAgreementMethod, node.keyAgreement)
+	//主题订阅的处理过程
+	node.handleTopicFunc()
+	//流式响应的处理过程
+	node.serveMux.handleFuncInner(StreamChunkMethod, node.receiveStreamChunk)
 
 	//马上执行
 	go node.Run()
@@ -233,6 +280,10 @@ func NewOWTPNode(cert Certificate, readBufferSize, writeBufferSize int) *OWTPNod
 
 	//内部配置一个协商密码处理过程
 	node.serveMux.handleFuncInner(KeyAgreementMethod, node.keyAgreement)
+	//主题订阅的处理过程
+	node.handleTopicFunc()
+	//流式响应的处理过程
+	node.serveMux.handleFuncInner(StreamChunkMethod, node.receiveStreamChunk)
 
 	//马上执行
 	go node.Run()
@@ -255,6 +306,11 @@ func (node *OWTPNode) SetPeerstore(store Peerstore) {
 	node.peerstore = store
 }
 
+//SetNonceCache 设置防重放的nonce缓存，使用持久化或共享的缓存，重启或多节点部署时防重放仍有效
+func (node *OWTPNode) SetNonceCache(c NonceCache) {
+	node.serveMux.SetNonceCache(c)
+}
+
 //Peerstore 节点存储器
 func (node *OWTPNode) Peerstore() Peerstore {
 	return node.peerstore
@@ -274,26 +330,36 @@ func (node *OWTPNode) Listen(config ConnectConfig) error {
 	//	return fmt.Errorf("the node is listening, please close listener first")
 	//}
 
-	if connectType == Websocket || connectType == MQ {
-		l, err := WSListenAddr(addr, node.cert, enableSignature, node)
+	var tlsConfig *tls.Config
+	if config.EnableSSL && connectType != MQ {
+		c, err := node.tlsConfig(config, true)
+		if err != nil {
+			return err
+		}
+		tlsConfig = c
+	}
+
+	if connectType == Websocket {
+		l, err := wsListenAddr(addr, node.cert, enableSignature, node, tlsConfig, config.TLSBindIdentity)
 		if err != nil {
 			return err
 		}
 		node.listeners[connectType] = l
 
-		go func(listener Listener) {
-			for {
-				peer, err := listener.Accept()
-				if err != nil {
-					return
-				}
-				node.Join <- peer
-			}
-		}(l)
+		go node.acceptPeers(l)
 
 		//node.listening = true
+	} else if connectType == MQ {
+		//消费ReadQueueName，多个节点监听同一队列可分担请求
+		l, err := MQListenAddr(config, node.cert, enableSignature, node)
+		if err != nil {
+			return err
+		}
+		node.listeners[connectType] = l
+
+		go node.acceptPeers(l)
 	} else if connectType == HTTP {
-		l, err := HttpListenAddr(addr, enableSignature, node)
+		l, err := httpListenAddr(addr, enableSignature, node, tlsConfig, config.TLSBindIdentity)
 		if err != nil {
 			return err
 		}
@@ -304,6 +370,17 @@ func (node *OWTPNode) Listen(config ConnectConfig) error {
 	return nil
 }
 
+//acceptPeers 接收监听器的新节点
+func (node *OWTPNode) acceptPeers(listener Listener) {
+	for {
+		peer, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		node.Join <- peer
+	}
+}
+
 //listening 是否监听中
 func (node *OWTPNode) Listening(connectType string) bool {
 	_, exist := node.listeners[connectType]
@@ -334,7 +411,11 @@ func (node *OWTPNode) Connect(pid string, config ConnectConfig) (Peer, error) {
 		//该节点未开启，首先请求开启协商密码
 		if peer != nil && peer.auth().EnableKeyAgreement() == false {
 			log.Debugf("first connect to call KeyAgreement request")
-			err = node.KeyAgreement(pid, "aes")
+			consultType := config.KeyAgreementType
+			if len(consultType) == 0 {
+				consultType = KeyAgreementSM2AES
+			}
+			err = node.KeyAgreement(pid, consultType)
 			if err != nil {
 				return nil, err
 			}
@@ -388,6 +469,18 @@ func (node *OWTPNode) connect(pid string, config ConnectConfig) (Peer, error) {
 		return nil, fmt.Errorf("connectType must contain by config")
 	}
 
+	var tlsConfig *tls.Config
+	if enableSSL && connectType != MQ {
+		//绑定身份但没有其他方式校验服务端证书时，以连接的节点ID作为服务端身份，防止中间人冒充
+		if config.TLSBindIdentity && len(config.TLSRemoteID) == 0 && len(config.TLSPinnedKeys) == 0 && len(config.TLSCAFile) == 0 {
+			config.TLSRemoteID = pid
+		}
+		tlsConfig, err = node.tlsConfig(config, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	//websocket类型
 	if connectType == Websocket {
 
@@ -400,7 +493,7 @@ func (node *OWTPNode) connect(pid string, config ConnectConfig) (Peer, error) {
 		url := protocol + strings.TrimSuffix(addr, "/") + "/"
 
 		//建立链接，记录默认的客户端
-		client, err := Dial(pid, url, node, auth.HTTPAuthHeader(), readBufferSize, writeBufferSize)
+		client, err := dial(pid, url, node, requestEncodingHeader(auth.HTTPAuthHeader(), config.Encoding), readBufferSize, writeBufferSize, tlsConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -419,14 +512,10 @@ func (node *OWTPNode) connect(pid string, config ConnectConfig) (Peer, error) {
 		url := "amqp://" + mqAccount + ":" + mqPassword + "@" + strings.TrimSuffix(addr, "/") + "/"
 
 		//建立链接，记录默认的客户端
-		client, err := MQDial(pid, url, node)
+		client, err := mqDialWithConfig(pid, url, config, auth, node)
 		if err != nil {
 			return nil, err
 		}
-		//设置授权规则
-		client._auth = auth
-		//设置配置
-		client.config = config
 		peer = client
 	}
 
@@ -442,7 +531,7 @@ func (node *OWTPNode) connect(pid string, config ConnectConfig) (Peer, error) {
 		url := protocol + strings.TrimSuffix(addr, "/") + "/"
 
 		//建立链接，记录默认的客户端
-		client, err := HTTPDial(pid, url, node, auth.HTTPAuthHeader(), timeout)
+		client, err := httpDial(pid, url, node, requestEncodingHeader(auth.HTTPAuthHeader(), config.Encoding), timeout, tlsConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -509,9 +598,20 @@ func (node *OWTPNode) Run() error {
 			//客户端离开
 			log.Debug("Node Leave:", peer.PID())
 
+			//开启断线重连的节点，保留可重新发送的请求
+			var replayRequests []requestEntry
+			reconnect := node.shouldReconnect(peer)
+			if reconnect {
+				replayRequests = node.serveMux.takeReplayRequests(peer.PID())
+			}
+
 			node.serveMux.ResetRequestQueue(peer.PID())
 			node.RemoveOfflinePeer(peer.PID())
 
+			if reconnect {
+				go node.reconnect(peer.PID(), peer.ConnectConfig(), replayRequests)
+			}
+
 			if node.disconnectHandler != nil {
 				go node.disconnectHandler(node, node.Peerstore().PeerInfo(peer.PID()))
 			}
@@ -547,12 +647,17 @@ func (node *OWTPNode) ClosePeer(pid string) {
 	if peer == nil {
 		return
 	}
+	node.markClosedPeer(peer)
 	peer.close()
 }
 
 //Close 关闭节点
 func (node *OWTPNode) Close() {
 
+	node.mu.Lock()
+	node.closed = true
+	node.mu.Unlock()
+
 	for _, listener := range node.listeners {
 		listener.Close()
 	}
@@ -648,8 +753,48 @@ func (node *OWTPNode) Call(
 		}
 	}
 
-	//添加请求队列到Map，处理完成回调方法
+	err = node.sendRequest(peer, method, params, reqFunc, respChan, sync)
+	if err != nil {
+		return err
+	}
+
+	if sync {
+		//等待返回
+		result := <-respChan
+		reqFunc(result)
+	}
+
+	return nil
+}
+
+//sendRequest 封装请求数据包并发送，响应通过reqFunc或respChan返回
+func (node *OWTPNode) sendRequest(
+	peer Peer,
+	method string,
+	params interface{},
+	reqFunc RequestFunc,
+	respChan chan Response,
+	sync bool) error {
+
 	nonce := uint64(node.nonceGen.Generate().Int64())
+	return node.sendRequestWithNonce(peer, nonce, method, params, reqFunc, respChan, sync)
+}
+
+//sendRequestWithNonce 使用指定的nonce发送请求
+func (node *OWTPNode) sendRequestWithNonce(
+	peer Peer,
+	nonce uint64,
+	method string,
+	params interface{},
+	reqFunc RequestFunc,
+	respChan chan Response,
+	sync bool) error {
+
+	var (
+		err error
+	)
+
+	//添加请求队列到Map，处理完成回调方法
 	time := time.Now().Unix()
 
 	//封装数据包
@@ -661,17 +806,32 @@ func (node *OWTPNode) Call(
 		Data:      params,
 		Version:   CurrentDataPacketVersion,
 	}
+	packet.SetEncoding(peerEncoding(peer))
+
+	//协商密钥达到轮换条件，先重新协商
+	if method != KeyAgreementMethod {
+		err = node.rotateKeyAgreement(peer)
+		if err != nil {
+			return err
+		}
+	}
 
 	//如果开启了协商密码，添加协商密码参数
 	if peer.auth() != nil && peer.auth().EnableKeyAgreement() {
-		value := node.Peerstore().Get(peer.PID(), keyAgreementCipher)
-		if value == nil {
-			return fmt.Errorf("keyAgreement is enabled, but cipher is empty")
+		//协商请求使用正在协商的参数
+		var ka *KeyAgreement
+		if method == KeyAgreementMethod {
+			ka = node.pendingKeyAgreement(peer.PID())
 		}
-
-		ka, ok := value.(*KeyAgreement)
-		if !ok {
-			return fmt.Errorf("keyAgreement is enabled, type error")
+		if ka == nil {
+			k, err := node.getKeyAgreement(peer.PID())
+			if err != nil {
+				return fmt.Errorf("keyAgreement is enabled, type error")
+			}
+			if k == nil {
+				return fmt.Errorf("keyAgreement is enabled, but cipher is empty")
+			}
+			ka = k
 		}
 
 		//加密数据
@@ -700,7 +860,8 @@ func (node *OWTPNode) Call(
 	}
 
 	//添加请求到队列，异步或同步等待结果，应该在发送前就添加请求，如果发送失败，删除请求
-	err = node.serveMux.AddRequest(peer, nonce, time, method, reqFunc, respChan, sync)
+	replay := isReplayMethod(peer.ConnectConfig(), method)
+	err = node.serveMux.addRequest(peer, nonce, time, method, reqFunc, respChan, sync, replay, params)
 	if err != nil {
 		return err
 	}
@@ -713,12 +874,6 @@ func (node *OWTPNode) Call(
 		return err
 	}
 
-	if sync {
-		//等待返回
-		result := <-respChan
-		reqFunc(result)
-	}
-
 	return nil
 }
 
@@ -768,8 +923,18 @@ func (node *OWTPNode) encryptPacket(peer Peer, packet *DataPacket, key string) e
 }
 
 //HandleFunc 绑定路由器方法
-func (node *OWTPNode) HandleFunc(method string, handler HandlerFunc) {
-	node.serveMux.HandleFunc(method, handler)
+func (node *OWTPNode) HandleFunc(method string, handler HandlerFunc, middlewares ...Middleware) {
+	node.serveMux.HandleFunc(method, handler, middlewares...)
+}
+
+//Use 添加全局中间件
+func (node *OWTPNode) Use(middlewares ...Middleware) {
+	node.serveMux.Use(middlewares...)
+}
+
+//Group 创建路由组
+func (node *OWTPNode) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return node.serveMux.Group(prefix, middlewares...)
 }
 
 //HandlePrepareFunc 绑定准备前的处理方法
@@ -810,8 +975,9 @@ func (node *OWTPNode) callKeyAgreement(peer Peer, consultType string) error {
 		return err
 	}
 
-	//保存请求协商密码的参数
-	node.peerstore.Put(peer.PID(), keyAgreementCipher, ka)
+	//协商完成前保留原密钥，响应校验通过后才保存
+	node.keyAgreements.Store(peer.PID(), ka)
+	defer node.keyAgreements.Delete(peer.PID())
 
 	callErr := node.Call(
 		peer.PID(),
@@ -856,17 +1022,20 @@ func (node *OWTPNode) wrapDataPacketForResponse(peer Peer, ctx *Context) *DataPa
 	//如果开启了协商密码，添加协商密码参数
 	if peer.auth().EnableKeyAgreement() {
 
-		value := node.Peerstore().Get(peer.PID(), keyAgreementCipher)
-		if value == nil {
-			errMsg := fmt.Sprintf("keyAgreement is enabled, but pid: %s cipher is empty", peer.PID())
-			packet.Data = responseError(errMsg, ErrKeyAgreementFailed)
-			return packet
-		}
-
-		ka, ok := value.(*KeyAgreement)
-		if !ok {
-			packet.Data = responseError("keyAgreement is enabled, type error", ErrKeyAgreementFailed)
-			return packet
+		//使用请求所用的协商密码响应，轮换宽限期内可能是旧密码
+		ka := ctx.keyAgreement
+		if ka == nil {
+			k, err := node.getKeyAgreement(peer.PID())
+			if err != nil {
+				packet.Data = responseError("keyAgreement is enabled, type error", ErrKeyAgreementFailed)
+				return packet
+			}
+			if k == nil {
+				errMsg := fmt.Sprintf("keyAgreement is enabled, but pid: %s cipher is empty", peer.PID())
+				packet.Data = responseError(errMsg, ErrKeyAgreementFailed)
+				return packet
+			}
+			ka = k
 		}
 
 		//加密数据
@@ -887,12 +1056,11 @@ func (node *OWTPNode) wrapDataPacketForResponse(peer Peer, ctx *Context) *DataPa
 	return packet
 }
 
-//handleKeyAgreementForRequest 处理请求的协商密码，获得密钥
-func (node *OWTPNode) handleKeyAgreementForRequest(peer Peer, packet *DataPacket) ([]byte, error) {
+//handleKeyAgreementForRequest 处理请求的协商密码，返回请求使用的协商密码
+func (node *OWTPNode) handleKeyAgreementForRequest(peer Peer, packet *DataPacket) (*KeyAgreement, error) {
 	var (
 		//协商密码
-		secretKey []byte
-		ka        *KeyAgreement
+		ka *KeyAgreement
 	)
 
 	//检查是否已经连接服务
@@ -901,127 +1069,162 @@ func (node *OWTPNode) handleKeyAgreementForRequest(peer Peer, packet *DataPacket
 	}
 
 	//验证授权
-	if peer.auth() != nil {
-
-		//获取缓存中的协商密码组
-		value := node.Peerstore().Get(peer.PID(), keyAgreementCipher)
-		if value == nil {
-			//不存在，重新构建协商密码组
-			ka = &KeyAgreement{}
-
-		} else {
-			//协商密码存在
-			k, ok := value.(*KeyAgreement)
-			if !ok {
-				return nil, fmt.Errorf("keyAgreement chipher is not saved")
-			}
-			ka = k
-
-		}
+	if peer.auth() == nil {
+		return nil, nil
+	}
 
-		//发起方的请求协商密码的参数
+	//获取缓存中的协商密码组
+	//协商密码存在时返回副本，避免并发的请求互相修改
+	ka, err := node.getKeyAgreement(peer.PID())
+	if err != nil {
+		return nil, fmt.Errorf("keyAgreement chipher is not saved")
+	}
+	if ka == nil {
+		//不存在，重新构建协商密码组
+		ka = &KeyAgreement{}
+	}
 
+	//轮换前使用旧密钥发出的请求，宽限期内继续使用旧密钥
+	prev := ka.findPrevious(func(prev *KeyAgreement) bool {
+		return len(prev.TmpPublicKeyInitiator) > 0 && prev.TmpPublicKeyInitiator == packet.SecretData.TmpPublicKeyInitiator
+	})
+	if prev != nil {
+		p := *prev
 		if peer.IsHost() {
-			//对方是服务端，请求带SecretData.S2
-			ka.S2 = packet.SecretData.S2
+			p.S2 = packet.SecretData.S2
 		} else {
-			//对方是客户端，请求带SecretData.SA
-			ka.SA = packet.SecretData.SA
+			p.SA = packet.SecretData.SA
+		}
+		if !peer.auth().VerifyKeyAgreement(&p) {
+			return nil, fmt.Errorf("previous keyAgreement verify failed")
 		}
+		return &p, nil
+	}
 
-		ka.PublicKeyInitiator = packet.SecretData.PublicKeyInitiator
-		ka.TmpPublicKeyInitiator = packet.SecretData.TmpPublicKeyInitiator
+	//保留当前协商密码，重新协商后作为旧密码
+	var current *KeyAgreement
+	if len(ka.Key) > 0 {
+		c := *ka
+		current = &c
+	}
 
-		//验证协商密码
-		if !peer.auth().VerifyKeyAgreement(ka) {
+	//发起方的请求协商密码的参数
 
-			//协商不通过，需要重新协商
-			log.Warning("keyAgreement is regenerating")
+	if peer.IsHost() {
+		//对方是服务端，请求带SecretData.S2
+		ka.S2 = packet.SecretData.S2
+	} else {
+		//对方是客户端，请求带SecretData.SA
+		ka.SA = packet.SecretData.SA
+	}
 
-			//传入响应公私钥
-			ka.PublicKeyResponder = base58.Encode(node.cert.PublicKeyBytes())
-			ka.PrivateKeyResponder = base58.Encode(node.cert.PrivateKeyBytes())
+	ka.PublicKeyInitiator = packet.SecretData.PublicKeyInitiator
+	ka.TmpPublicKeyInitiator = packet.SecretData.TmpPublicKeyInitiator
+	ka.EncryptType = packet.SecretData.EncryptType
 
-			//请求协商
-			err := peer.auth().RequestKeyAgreement(ka)
-			if err != nil {
-				return nil, err
-			}
+	//验证协商密码
+	if !peer.auth().VerifyKeyAgreement(ka) {
+
+		//协商不通过，需要重新协商
+		log.Warning("keyAgreement is regenerating")
 
-			//TODO:应该用一个临时密钥进行加密保存到缓存中
-			node.peerstore.Put(peer.PID(), keyAgreementCipher, ka)
+		if !node.allowKeyAgreementType(ka.EncryptType) {
+			return nil, fmt.Errorf("keyAgreement encrypt type: %s is not allowed", ka.EncryptType)
+		}
 
-			//数据包配置响应方的协商密码参数
-			packet.SecretData.PublicKeyResponder = ka.PublicKeyResponder
-			packet.SecretData.TmpPublicKeyResponder = ka.TmpPublicKeyResponder
-			packet.SecretData.SB = ka.SB
+		//传入响应公私钥
+		ka.PublicKeyResponder = base58.Encode(node.cert.PublicKeyBytes())
+		ka.PrivateKeyResponder = base58.Encode(node.cert.PrivateKeyBytes())
 
+		//请求协商
+		err := peer.auth().RequestKeyAgreement(ka)
+		if err != nil {
+			return nil, err
 		}
+		ka.rotateFrom(current)
 
-		//加载到授权中
-		secretKey, _ = base58.Decode(ka.Key)
-		ka = nil
+		//本节点证书私钥不保存，其他参数需要Peerstore限制访问或加密保存
+		node.putKeyAgreement(peer.PID(), ka)
+
+		//数据包配置响应方的协商密码参数
+		packet.SecretData.PublicKeyResponder = ka.PublicKeyResponder
+		packet.SecretData.TmpPublicKeyResponder = ka.TmpPublicKeyResponder
+		packet.SecretData.SB = ka.SB
 
 	}
 
-	return secretKey, nil
+	return ka, nil
 }
 
 //handleKeyAgreementForResponse 处理协商密码的响应方结果
 func (node *OWTPNode) handleKeyAgreementForResponse(peer Peer, packet *DataPacket) ([]byte, error) {
-	var (
-		//协商密码
-		secretKey []byte
-	)
 
-	//验证授权
-	if peer.auth() != nil {
+	//验证授权，已开启协商密码
+	if peer.auth() == nil || !peer.auth().EnableKeyAgreement() {
+		return nil, nil
+	}
 
-		//已开启协商密码
-		if peer.auth().EnableKeyAgreement() {
+	//获取协商密码组
+	ka, err := node.getKeyAgreement(peer.PID())
+	if err != nil {
+		return nil, fmt.Errorf("keyAgreement chipher is not saved")
+	}
 
-			//获取协商密码组
-			value := node.Peerstore().Get(peer.PID(), keyAgreementCipher)
-			if value == nil {
-				return nil, fmt.Errorf("keyAgreement is not inited")
-			}
+	//协商请求的响应，计算新密钥，校验通过后替换原密钥
+	if pending := node.pendingKeyAgreement(peer.PID()); pending != nil && packet.Method == KeyAgreementMethod {
+		if err := node.responseKeyAgreement(peer, packet, pending); err != nil {
+			return nil, err
+		}
+		pending.rotateFrom(ka)
+		node.putKeyAgreement(peer.PID(), pending)
+		return base58.Decode(pending.Key)
+	}
 
-			ka, ok := value.(*KeyAgreement)
-			if !ok {
-				return nil, fmt.Errorf("keyAgreement chipher is not saved")
-			}
+	if ka == nil {
+		return nil, fmt.Errorf("keyAgreement is not inited")
+	}
 
-			//本地还没完成生成协商密码
-			//if len(ka.SA) == 0 || len(ka.Key) == 0  {
-			if len(ka.SA) == 0 || len(ka.Key) == 0 || ka.SB != packet.SecretData.SB {
+	if len(ka.Key) > 0 && ka.SB == packet.SecretData.SB {
+		return base58.Decode(ka.Key)
+	}
 
-				//加载响应方的协商密码参数
-				ka.PublicKeyResponder = packet.SecretData.PublicKeyResponder
-				ka.TmpPublicKeyResponder = packet.SecretData.TmpPublicKeyResponder
-				ka.SB = packet.SecretData.SB
+	//轮换前使用旧密钥的请求，响应也使用旧密钥
+	prev := ka.findPrevious(func(prev *KeyAgreement) bool {
+		return prev.SB == packet.SecretData.SB
+	})
+	if prev != nil {
+		return base58.Decode(prev.Key)
+	}
 
-				//计算协商密码
-				err := peer.auth().ResponseKeyAgreement(ka)
-				if err != nil {
-					log.Errorf("ResponseKeyAgreement unexpected error: %v", err.Error())
-					//协商失败，断开连接
-					peer.close()
-					return nil, err
-				}
+	//对方重新生成了协商密码，使用本地的发起方参数重新计算
+	c := *ka
+	if err := node.responseKeyAgreement(peer, packet, &c); err != nil {
+		return nil, err
+	}
+	c.rotateFrom(ka)
+	node.putKeyAgreement(peer.PID(), &c)
 
-				//保存协商密码
-				node.Peerstore().Put(peer.PID(), keyAgreementCipher, ka)
+	return base58.Decode(c.Key)
+}
 
-			}
-			//协商密码
-			secretKey, _ = base58.Decode(ka.Key)
+//responseKeyAgreement 加载响应方的协商密码参数，计算协商密码，失败时断开连接
+func (node *OWTPNode) responseKeyAgreement(peer Peer, packet *DataPacket, ka *KeyAgreement) error {
 
-			ka = nil
+	//加载响应方的协商密码参数
+	ka.PublicKeyResponder = packet.SecretData.PublicKeyResponder
+	ka.TmpPublicKeyResponder = packet.SecretData.TmpPublicKeyResponder
+	ka.SB = packet.SecretData.SB
 
-		}
+	//计算协商密码
+	err := peer.auth().ResponseKeyAgreement(ka)
+	if err != nil {
+		log.Errorf("ResponseKeyAgreement unexpected error: %v", err.Error())
+		//协商失败，断开连接
+		peer.close()
+		return err
 	}
 
-	return secretKey, nil
+	return nil
 }
 
 func (node *OWTPNode) GetValueForPeer(peer Peer, key string) interface{} {
@@ -1061,7 +1264,7 @@ func (node *OWTPNode) OnPeerNewDataPacketReceived(peer Peer, packet *DataPacket)
 	if packet.Req == WSRequest {
 
 		//处理请求的协商密码，获得密钥
-		secretKey, err = node.handleKeyAgreementForRequest(peer, packet)
+		ka, err := node.handleKeyAgreementForRequest(peer, packet)
 		if err != nil {
 			log.Critical("keyAgreement failed: ", packet)
 			packet.Req = WSResponse
@@ -1081,11 +1284,19 @@ func (node *OWTPNode) OnPeerNewDataPacketReceived(peer Peer, packet *DataPacket)
 			Method:        packet.Method,
 			peerstore:     node.Peerstore(),
 			Peer:          peer,
+			node:          node,
+			keyAgreement:  ka,
+		}
+		if ka != nil {
+			secretKey, _ = base58.Decode(ka.Key)
 		}
 
+		//限流检查，在验证签名前拒绝超出限制的请求
+		release := node.limitRequest(&ctx, packet)
+		defer release()
+
 		//授权检查，只检查请求过来的签名
-		verfifySigned := peer.auth().VerifySignature(packet)
-		if !verfifySigned {
+		if !ctx.stop && !peer.auth().VerifySignature(packet) {
 			//终止路由方法
 			ctx.ResponseStopRun(nil, ErrUnauthorized, "verify signature failed, unauthorized")
 		}
@@ -1099,7 +1310,12 @@ func (node *OWTPNode) OnPeerNewDataPacketReceived(peer Peer, packet *DataPacket)
 
 		node.serveMux.ServeOWTP(peer.PID(), &ctx)
 
+		//流式响应的数据块全部确认后，再发送最终响应
+		ctx.waitStream()
+
 		retPacket := node.wrapDataPacketForResponse(peer, &ctx)
+		//响应使用与请求相同的编码
+		retPacket.SetEncoding(packet.Encoding())
 
 		peer.send(*retPacket)
 	} else if packet.Req == WSResponse {
@@ -30,7 +30,7 @@ const (
 type DataPacket struct {
 	/*
 
-		本协议传输数据，格式编码采用json。消息接收与发送，都遵循数据包规范定义字段内容。
+		本协议传输数据，格式编码默认采用json，可通过ConnectConfig.Encoding选用cbor。消息接收与发送，都遵循数据包规范定义字段内容。
 
 		| 参数名 | 类型   | 示例             | 描述                                                                                |
 		|--------|--------|------------------|-----------------------------------------------------------------------------------|
@@ -53,6 +53,11 @@ type DataPacket struct {
 	Signature  string      `json:"s"`
 	SecretData SecretData  `json:"k"`
 	Version    int64       `json:"v"`
+
+	encoding   string //编码格式，默认json
+	signedData []byte //接收时数据主体的规范编码
+	signedFor  string //规范编码对应的数据主体
+	size       int    //接收时数据包的字节数
 }
 
 //KeyAgreement 协商密码
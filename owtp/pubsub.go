@@ -0,0 +1,578 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/tidwall/gjson"
+)
+
+//订阅主题的内置方法，订阅、取消订阅和轮询会执行prepare，可在prepare中检查主题的权限
+const (
+	SubscribeMethod   = "internal_subscribe"
+	UnsubscribeMethod = "internal_unsubscribe"
+	PollMethod        = "internal_poll"
+	PublishMethod     = "internal_publish"
+)
+
+var (
+	//MaxPendingTopicMessages 每个订阅节点未确认消息的上限，超过后丢弃最早的消息
+	MaxPendingTopicMessages = 1024
+	//TopicRedeliverPeriod 未确认消息的重新推送间隔
+	TopicRedeliverPeriod = 5 * time.Second
+	//TopicPollWait HTTP轮询没有消息时的最长等待时间
+	TopicPollWait = 20 * time.Second
+	//TopicSubscriberExpire 订阅节点断开连接或停止轮询超过该时长，移除订阅及未确认的消息
+	TopicSubscriberExpire = 10 * time.Minute
+)
+
+//TopicMessage 订阅主题收到的消息
+type TopicMessage struct {
+	ID    uint64       //消息序号，确认后不再推送
+	Topic string       //主题
+	Data  gjson.Result //消息内容
+}
+
+//TopicHandler 处理订阅的主题消息，返回错误时消息会重新推送
+type TopicHandler func(msg *TopicMessage) error
+
+//topicMessage 待确认的主题消息
+type topicMessage struct {
+	ID      uint64      `json:"id"`
+	Topic   string      `json:"topic"`
+	Data    interface{} `json:"data"`
+	sending bool
+}
+
+//topicSubscriber 订阅节点
+type topicSubscriber struct {
+	topics   map[string]bool
+	poll     bool //HTTP节点通过轮询获取消息
+	pending  []*topicMessage
+	notify   chan struct{}
+	lastSeen time.Time //最后在线或轮询的时间
+}
+
+//pubsub 节点的主题订阅管理
+type pubsub struct {
+	mu sync.Mutex
+	//订阅我方主题的节点
+	subscribers map[string]*topicSubscriber
+	//我方订阅的主题处理方法，pid -> topic -> handler
+	handlers map[string]map[string]TopicHandler
+	//正在轮询的节点
+	polling map[string]bool
+	//消息序号
+	sequence uint64
+	//是否已启动重新推送
+	redelivering bool
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{
+		subscribers: make(map[string]*topicSubscriber),
+		handlers:    make(map[string]map[string]TopicHandler),
+		polling:     make(map[string]bool),
+	}
+}
+
+//handleTopicFunc 绑定主题订阅的内置处理方法
+func (node *OWTPNode) handleTopicFunc() {
+	node.pubsub = newPubsub()
+	node.serveMux.HandleFunc(SubscribeMethod, node.subscribeTopic)
+	node.serveMux.HandleFunc(UnsubscribeMethod, node.unsubscribeTopic)
+	node.serveMux.HandleFunc(PollMethod, node.pollTopic)
+	node.serveMux.handleFuncInner(PublishMethod, node.receiveTopicMessage)
+}
+
+//Publish 向订阅主题的节点推送消息，节点确认前会重复推送
+func (node *OWTPNode) Publish(topic string, data interface{}) error {
+
+	if len(topic) == 0 {
+		return fmt.Errorf("topic is empty")
+	}
+
+	ps := node.pubsub
+	ps.mu.Lock()
+
+	ps.sequence++
+	id := ps.sequence
+
+	pids := make([]string, 0)
+	for pid, sub := range ps.subscribers {
+		if !sub.topics[topic] {
+			continue
+		}
+
+		sub.pending = append(sub.pending, &topicMessage{ID: id, Topic: topic, Data: data})
+		if len(sub.pending) > MaxPendingTopicMessages {
+			log.Warningf("peer[%s] pending topic messages over %d, drop the oldest", pid, MaxPendingTopicMessages)
+			sub.pending = sub.pending[len(sub.pending)-MaxPendingTopicMessages:]
+		}
+
+		if sub.poll {
+			//通知等待中的轮询
+			select {
+			case sub.notify <- struct{}{}:
+			default:
+			}
+		} else {
+			pids = append(pids, pid)
+		}
+	}
+
+	node.startRedeliver()
+
+	ps.mu.Unlock()
+
+	for _, pid := range pids {
+		node.deliverTopicMessages(pid)
+	}
+
+	return nil
+}
+
+//Subscribers 订阅主题的节点
+func (node *OWTPNode) Subscribers(topic string) []string {
+	ps := node.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	pids := make([]string, 0)
+	for pid, sub := range ps.subscribers {
+		if sub.topics[topic] {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+//deliverTopicMessages 推送未确认的消息给长连接节点
+func (node *OWTPNode) deliverTopicMessages(pid string) {
+
+	peer := node.GetOnlinePeer(pid)
+	if peer == nil {
+		//节点离线，重连后再推送
+		return
+	}
+
+	ps := node.pubsub
+	ps.mu.Lock()
+	sub := ps.subscribers[pid]
+	if sub == nil || sub.poll {
+		ps.mu.Unlock()
+		return
+	}
+	messages := make([]*topicMessage, 0)
+	for _, msg := range sub.pending {
+		if !msg.sending {
+			msg.sending = true
+			messages = append(messages, msg)
+		}
+	}
+	ps.mu.Unlock()
+
+	for _, msg := range messages {
+		id := msg.ID
+		err := node.sendRequest(peer, PublishMethod, msg, func(resp Response) {
+			if resp.Status == StatusSuccess {
+				node.ackTopicMessages(pid, []uint64{id})
+			} else {
+				node.resetTopicMessage(pid, id)
+			}
+		}, nil, false)
+		if err != nil {
+			node.resetTopicMessage(pid, id)
+		}
+	}
+}
+
+//startRedeliver 启动定时重新推送，调用前需持有pubsub锁
+func (node *OWTPNode) startRedeliver() {
+	ps := node.pubsub
+	if !ps.redelivering {
+		ps.redelivering = true
+		go node.redeliverTopicMessages()
+	}
+}
+
+//redeliverTopicMessages 定时重新推送未确认的消息，并移除过期的订阅节点
+func (node *OWTPNode) redeliverTopicMessages() {
+	ticker := time.NewTicker(TopicRedeliverPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		node.mu.RLock()
+		closed := node.closed
+		node.mu.RUnlock()
+		if closed {
+			return
+		}
+
+		ps := node.pubsub
+		ps.mu.Lock()
+		pids := make([]string, 0)
+		for pid, sub := range ps.subscribers {
+			if !sub.poll {
+				pids = append(pids, pid)
+			}
+		}
+		ps.mu.Unlock()
+
+		//长连接节点在线时刷新最后在线时间
+		online := make(map[string]bool)
+		for _, pid := range pids {
+			online[pid] = node.GetOnlinePeer(pid) != nil
+		}
+		node.expireTopicSubscribers(online)
+
+		for _, pid := range pids {
+			if online[pid] {
+				node.deliverTopicMessages(pid)
+			}
+		}
+	}
+}
+
+//expireTopicSubscribers 移除断开连接或停止轮询超过TopicSubscriberExpire的订阅节点
+func (node *OWTPNode) expireTopicSubscribers(online map[string]bool) {
+	ps := node.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	now := time.Now()
+	for pid, sub := range ps.subscribers {
+		if online[pid] {
+			sub.lastSeen = now
+			continue
+		}
+		if now.Sub(sub.lastSeen) > TopicSubscriberExpire {
+			log.Warningf("peer[%s] topic subscriber expired, drop %d pending messages", pid, len(sub.pending))
+			delete(ps.subscribers, pid)
+		}
+	}
+}
+
+//ackTopicMessages 确认消息，不再推送
+func (node *OWTPNode) ackTopicMessages(pid string, ids []uint64) {
+	if len(ids) == 0 {
+		return
+	}
+
+	ps := node.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	sub := ps.subscribers[pid]
+	if sub == nil {
+		return
+	}
+
+	acked := make(map[uint64]bool)
+	for _, id := range ids {
+		acked[id] = true
+	}
+
+	pending := make([]*topicMessage, 0, len(sub.pending))
+	for _, msg := range sub.pending {
+		if !acked[msg.ID] {
+			pending = append(pending, msg)
+		}
+	}
+	sub.pending = pending
+}
+
+//resetTopicMessage 推送失败，等待重新推送
+func (node *OWTPNode) resetTopicMessage(pid string, id uint64) {
+	ps := node.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	sub := ps.subscribers[pid]
+	if sub == nil {
+		return
+	}
+	for _, msg := range sub.pending {
+		if msg.ID == id {
+			msg.sending = false
+		}
+	}
+}
+
+//subscribeTopic 处理订阅主题的请求
+func (node *OWTPNode) subscribeTopic(ctx *Context) {
+
+	topic := ctx.Params().Get("topic").String()
+	if len(topic) == 0 {
+		ctx.Response(nil, ErrBadRequest, "topic is empty")
+		return
+	}
+
+	ps := node.pubsub
+	ps.mu.Lock()
+	sub := ps.subscribers[ctx.PID]
+	if sub == nil {
+		sub = &topicSubscriber{
+			topics: make(map[string]bool),
+			notify: make(chan struct{}, 1),
+		}
+		ps.subscribers[ctx.PID] = sub
+	}
+	sub.topics[topic] = true
+	sub.poll = ctx.Peer.ConnectConfig().ConnectType == HTTP
+	sub.lastSeen = time.Now()
+	node.startRedeliver()
+	ps.mu.Unlock()
+
+	//重新订阅时推送之前未确认的消息
+	go node.deliverTopicMessages(ctx.PID)
+
+	ctx.Response(nil, StatusSuccess, "success")
+}
+
+//unsubscribeTopic 处理取消订阅的请求
+func (node *OWTPNode) unsubscribeTopic(ctx *Context) {
+
+	topic := ctx.Params().Get("topic").String()
+
+	ps := node.pubsub
+	ps.mu.Lock()
+	if sub := ps.subscribers[ctx.PID]; sub != nil {
+		delete(sub.topics, topic)
+
+		pending := make([]*topicMessage, 0, len(sub.pending))
+		for _, msg := range sub.pending {
+			if msg.Topic != topic {
+				pending = append(pending, msg)
+			}
+		}
+		sub.pending = pending
+
+		if len(sub.topics) == 0 {
+			delete(ps.subscribers, ctx.PID)
+		}
+	}
+	ps.mu.Unlock()
+
+	ctx.Response(nil, StatusSuccess, "success")
+}
+
+//pollTopic 处理HTTP节点的轮询，确认上次收到的消息，返回未确认的消息
+func (node *OWTPNode) pollTopic(ctx *Context) {
+
+	acks := make([]uint64, 0)
+	for _, id := range ctx.Params().Get("acks").Array() {
+		acks = append(acks, id.Uint())
+	}
+	node.ackTopicMessages(ctx.PID, acks)
+
+	wait := time.Duration(ctx.Params().Get("wait").Int()) * time.Second
+	if wait <= 0 || wait > TopicPollWait {
+		wait = TopicPollWait
+	}
+
+	ps := node.pubsub
+	ps.mu.Lock()
+	sub := ps.subscribers[ctx.PID]
+	if sub == nil {
+		ps.mu.Unlock()
+		ctx.Response(nil, ErrBadRequest, "no topic subscribed")
+		return
+	}
+	sub.lastSeen = time.Now()
+	notify := sub.notify
+	empty := len(sub.pending) == 0
+	if empty {
+		//清除之前遗留的通知
+		select {
+		case <-notify:
+		default:
+		}
+	}
+	ps.mu.Unlock()
+
+	//没有消息时等待新消息或超时
+	if empty {
+		select {
+		case <-notify:
+		case <-time.After(wait):
+		}
+	}
+
+	ps.mu.Lock()
+	messages := make([]*topicMessage, 0)
+	if sub = ps.subscribers[ctx.PID]; sub != nil {
+		sub.lastSeen = time.Now()
+		messages = append(messages, sub.pending...)
+	}
+	ps.mu.Unlock()
+
+	ctx.Response(map[string]interface{}{"messages": messages}, StatusSuccess, "success")
+}
+
+//Subscribe 订阅节点的主题，长连接由对方推送，HTTP连接通过轮询获取消息
+func (node *OWTPNode) Subscribe(pid, topic string, handler TopicHandler) error {
+
+	if handler == nil {
+		return fmt.Errorf("handler should not be nil")
+	}
+
+	ps := node.pubsub
+	ps.mu.Lock()
+	if ps.handlers[pid] == nil {
+		ps.handlers[pid] = make(map[string]TopicHandler)
+	}
+	ps.handlers[pid][topic] = handler
+	ps.mu.Unlock()
+
+	resp, err := node.CallSync(pid, SubscribeMethod, map[string]interface{}{"topic": topic})
+	if err == nil && resp.Status != StatusSuccess {
+		err = fmt.Errorf("subscribe topic failed, unexpected error: %s", resp.Msg)
+	}
+	if err != nil {
+		node.removeTopicHandler(pid, topic)
+		return err
+	}
+
+	peer := node.GetOnlinePeer(pid)
+	if peer != nil && peer.ConnectConfig().ConnectType == HTTP {
+		ps.mu.Lock()
+		polling := ps.polling[pid]
+		ps.polling[pid] = true
+		ps.mu.Unlock()
+		if !polling {
+			go node.pollTopicMessages(pid)
+		}
+	}
+
+	return nil
+}
+
+//Unsubscribe 取消订阅节点的主题
+func (node *OWTPNode) Unsubscribe(pid, topic string) error {
+
+	node.removeTopicHandler(pid, topic)
+
+	resp, err := node.CallSync(pid, UnsubscribeMethod, map[string]interface{}{"topic": topic})
+	if err != nil {
+		return err
+	}
+	if resp.Status != StatusSuccess {
+		return fmt.Errorf("unsubscribe topic failed, unexpected error: %s", resp.Msg)
+	}
+	return nil
+}
+
+func (node *OWTPNode) removeTopicHandler(pid, topic string) {
+	ps := node.pubsub
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.handlers[pid], topic)
+	if len(ps.handlers[pid]) == 0 {
+		delete(ps.handlers, pid)
+	}
+}
+
+//handleTopicMessage 调用订阅时绑定的处理方法
+func (node *OWTPNode) handleTopicMessage(pid string, msg *TopicMessage) error {
+	ps := node.pubsub
+	ps.mu.Lock()
+	handler := ps.handlers[pid][msg.Topic]
+	ps.mu.Unlock()
+
+	//已取消订阅的消息直接确认
+	if handler == nil {
+		return nil
+	}
+	return handler(msg)
+}
+
+//receiveTopicMessage 处理对方推送的主题消息，成功响应即确认
+func (node *OWTPNode) receiveTopicMessage(ctx *Context) {
+
+	params := ctx.Params()
+	msg := &TopicMessage{
+		ID:    params.Get("id").Uint(),
+		Topic: params.Get("topic").String(),
+		Data:  params.Get("data"),
+	}
+
+	err := node.handleTopicMessage(ctx.PID, msg)
+	if err != nil {
+		ctx.Response(nil, ErrCustomError, err.Error())
+		return
+	}
+
+	ctx.Response(nil, StatusSuccess, "success")
+}
+
+//pollTopicMessages HTTP连接轮询主题消息，处理成功的消息在下次轮询时确认
+func (node *OWTPNode) pollTopicMessages(pid string) {
+
+	defer func() {
+		ps := node.pubsub
+		ps.mu.Lock()
+		delete(ps.polling, pid)
+		ps.mu.Unlock()
+	}()
+
+	acks := make([]uint64, 0)
+	for {
+		node.mu.RLock()
+		closed := node.closed
+		node.mu.RUnlock()
+
+		ps := node.pubsub
+		ps.mu.Lock()
+		subscribed := len(ps.handlers[pid]) > 0
+		ps.mu.Unlock()
+
+		if closed || !subscribed {
+			return
+		}
+
+		resp, err := node.CallSync(pid, PollMethod, map[string]interface{}{
+			"acks": acks,
+			"wait": int(TopicPollWait / time.Second),
+		})
+		if err == nil && resp.Status != StatusSuccess {
+			err = fmt.Errorf("%s", resp.Msg)
+		}
+		if err != nil {
+			log.Warningf("peer[%s] poll topic messages failed, unexpected error: %v", pid, err)
+			time.Sleep(TopicRedeliverPeriod)
+			continue
+		}
+
+		result, _ := json.Marshal(resp.Result)
+		acks = make([]uint64, 0)
+		for _, m := range gjson.ParseBytes(result).Get("messages").Array() {
+			msg := &TopicMessage{
+				ID:    m.Get("id").Uint(),
+				Topic: m.Get("topic").String(),
+				Data:  m.Get("data"),
+			}
+			if node.handleTopicMessage(pid, msg) == nil {
+				acks = append(acks, msg.ID)
+			}
+		}
+	}
+}
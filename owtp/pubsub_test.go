@@ -0,0 +1,193 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func createTopicServer(t *testing.T, connectType string) (*OWTPNode, string) {
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	err := server.Listen(ConnectConfig{
+		Address:     "127.0.0.1:0",
+		ConnectType: connectType,
+	})
+	if err != nil {
+		t.Fatalf("Listen unexpected error: %v", err)
+	}
+
+	//通过prepare检查主题权限
+	server.HandlePrepareFunc(func(ctx *Context) {
+		if ctx.Method == SubscribeMethod && ctx.Params().Get("topic").String() == "private" {
+			ctx.ResponseStopRun(nil, ErrUnauthorized, "topic is forbidden")
+		}
+	})
+
+	return server, server.listeners[connectType].Addr().String()
+}
+
+func TestOWTPNode_PublishWebsocket(t *testing.T) {
+
+	period := TopicRedeliverPeriod
+	TopicRedeliverPeriod = 50 * time.Millisecond
+	defer func() {
+		TopicRedeliverPeriod = period
+	}()
+
+	server, addr := createTopicServer(t, Websocket)
+	defer server.Close()
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{Address: addr, ConnectType: Websocket})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	received := make(chan *TopicMessage, 10)
+	var deliveries int32
+	err = client.Subscribe("server", "block", func(msg *TopicMessage) error {
+		//第一次处理失败，消息应重新推送
+		if atomic.AddInt32(&deliveries, 1) == 1 {
+			return fmt.Errorf("handle failed")
+		}
+		received <- msg
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Subscribe unexpected error: %v", err)
+		return
+	}
+
+	if err = client.Subscribe("server", "private", func(msg *TopicMessage) error { return nil }); err == nil {
+		t.Errorf("subscribe forbidden topic should return error")
+	}
+
+	if subs := server.Subscribers("block"); len(subs) != 1 || subs[0] != client.NodeID() {
+		t.Errorf("Subscribers = %v", subs)
+	}
+
+	server.Publish("block", map[string]interface{}{"height": 100})
+
+	select {
+	case msg := <-received:
+		if msg.Topic != "block" || msg.Data.Get("height").Int() != 100 {
+			t.Errorf("received message: %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("topic message is not received")
+		return
+	}
+
+	if n := atomic.LoadInt32(&deliveries); n != 2 {
+		t.Errorf("deliveries = %d, want 2", n)
+	}
+
+	err = client.Unsubscribe("server", "block")
+	if err != nil {
+		t.Errorf("Unsubscribe unexpected error: %v", err)
+	}
+	if subs := server.Subscribers("block"); len(subs) != 0 {
+		t.Errorf("Subscribers after unsubscribe = %v", subs)
+	}
+}
+
+func TestOWTPNode_PublishHTTPPolling(t *testing.T) {
+
+	server, addr := createTopicServer(t, HTTP)
+	defer server.Close()
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{Address: addr, ConnectType: HTTP})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	received := make(chan *TopicMessage, 10)
+	err = client.Subscribe("server", "deposit", func(msg *TopicMessage) error {
+		received <- msg
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Subscribe unexpected error: %v", err)
+		return
+	}
+
+	server.Publish("deposit", "tx_0001")
+
+	select {
+	case msg := <-received:
+		if msg.Topic != "deposit" || msg.Data.String() != "tx_0001" {
+			t.Errorf("received message: %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("topic message is not received")
+	}
+}
+
+func TestOWTPNode_ExpireTopicSubscriber(t *testing.T) {
+
+	period, expire := TopicRedeliverPeriod, TopicSubscriberExpire
+	TopicRedeliverPeriod = 50 * time.Millisecond
+	TopicSubscriberExpire = 300 * time.Millisecond
+	defer func() {
+		TopicRedeliverPeriod, TopicSubscriberExpire = period, expire
+	}()
+
+	server, addr := createTopicServer(t, Websocket)
+	defer server.Close()
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{Address: addr, ConnectType: Websocket})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	err = client.Subscribe("server", "block", func(msg *TopicMessage) error { return nil })
+	if err != nil {
+		t.Errorf("Subscribe unexpected error: %v", err)
+		return
+	}
+
+	//断开连接后推送的消息等待重连
+	client.ClosePeer("server")
+	time.Sleep(100 * time.Millisecond)
+	server.Publish("block", map[string]interface{}{"height": 100})
+	if subs := server.Subscribers("block"); len(subs) != 1 {
+		t.Errorf("Subscribers before expired = %v", subs)
+		return
+	}
+
+	time.Sleep(time.Second)
+
+	if subs := server.Subscribers("block"); len(subs) != 0 {
+		t.Errorf("Subscribers after expired = %v", subs)
+	}
+	server.pubsub.mu.Lock()
+	n := len(server.pubsub.subscribers)
+	server.pubsub.mu.Unlock()
+	if n != 0 {
+		t.Errorf("expired subscriber should be removed with pending messages")
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+//rateLimitPrunePeriod 清理空闲令牌桶的周期
+var rateLimitPrunePeriod = time.Minute
+
+//RateLimit 令牌桶限流，Rate为每秒补充的请求数，Burst为允许的突发请求数，Rate为0不限制
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+//RateLimitConfig 请求限流配置，各项为0表示不限制，内置方法只检查数据包大小
+type RateLimitConfig struct {
+	PeerLimit     RateLimit            `json:"peerLimit"`     //每个节点的请求速率
+	MethodLimits  map[string]RateLimit `json:"methodLimits"`  //每个节点调用指定方法的请求速率
+	MaxConcurrent int                  `json:"maxConcurrent"` //每个节点同时处理的请求数
+	MaxPacketSize int                  `json:"maxPacketSize"` //请求数据包的最大字节数，监听器在传输层读取时限制
+}
+
+//tokenBucket 令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	limit  RateLimit //令牌桶的限流配置，清理时计算是否已补满
+}
+
+//refill 按时间补充令牌，返回当前令牌数
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) float64 {
+	burst := float64(limit.burst())
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	return b.tokens
+}
+
+func (limit RateLimit) burst() int {
+	if limit.Burst < 1 {
+		return 1
+	}
+	return limit.Burst
+}
+
+//wait 获得一个令牌需要等待的时间
+func (limit RateLimit) wait(tokens float64) time.Duration {
+	return time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+}
+
+//rateLimiter 节点请求限流器
+type rateLimiter struct {
+	mu        sync.Mutex
+	config    RateLimitConfig
+	buckets   map[string]*tokenBucket
+	running   map[string]int
+	lastPrune time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config:    config,
+		buckets:   make(map[string]*tokenBucket),
+		running:   make(map[string]int),
+		lastPrune: time.Now(),
+	}
+}
+
+func (l *rateLimiter) bucket(key string, limit RateLimit, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.burst()), last: now}
+		l.buckets[key] = b
+	}
+	b.limit = limit
+	return b
+}
+
+//prune 清理已补满的令牌桶，避免节点过多时占用内存。
+//未补满的令牌桶删除后会以满令牌重建，相当于重置限流，所以只删除按空闲时间已补满的令牌桶
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < rateLimitPrunePeriod {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		idle := now.Sub(b.last).Seconds()
+		if b.tokens+idle*b.limit.Rate >= float64(b.limit.burst()) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+//acquire 申请处理请求，成功返回释放方法，失败返回重试等待时间
+func (l *rateLimiter) acquire(pid, method string, size int, inner bool) (func(), time.Duration, error) {
+
+	if l.config.MaxPacketSize > 0 && size > l.config.MaxPacketSize {
+		return nil, 0, fmt.Errorf("packet size %d exceeds the limit %d", size, l.config.MaxPacketSize)
+	}
+
+	if inner {
+		return func() {}, 0, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	if l.config.MaxConcurrent > 0 && l.running[pid] >= l.config.MaxConcurrent {
+		return nil, time.Second, fmt.Errorf("too many concurrent requests")
+	}
+
+	//先检查所有令牌桶，都有令牌才扣除
+	var (
+		buckets []*tokenBucket
+		wait    time.Duration
+	)
+	check := func(key string, limit RateLimit) {
+		if limit.Rate <= 0 {
+			return
+		}
+		b := l.bucket(key, limit, now)
+		if tokens := b.refill(limit, now); tokens < 1 {
+			if w := limit.wait(tokens); w > wait {
+				wait = w
+			}
+		}
+		buckets = append(buckets, b)
+	}
+
+	check(pid, l.config.PeerLimit)
+	if limit, ok := l.config.MethodLimits[method]; ok {
+		check(pid+"/"+method, limit)
+	}
+
+	if wait > 0 {
+		return nil, wait, fmt.Errorf("too many requests")
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	l.running[pid]++
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.running[pid]--; l.running[pid] <= 0 {
+			delete(l.running, pid)
+		}
+	}
+
+	return release, 0, nil
+}
+
+//SetRateLimit 设置请求限流，超出限制的请求响应ErrDenialOfService，
+//结果中的retryAfter为建议重试等待的毫秒数
+func (node *OWTPNode) SetRateLimit(config RateLimitConfig) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.limiter = newRateLimiter(config)
+}
+
+//maxPacketSize 传输层允许读取的最大字节数，0为不限制
+func (node *OWTPNode) maxPacketSize() int64 {
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	if node.limiter == nil {
+		return 0
+	}
+	return int64(node.limiter.config.MaxPacketSize)
+}
+
+//packetSizeLimit 监听器通过处理器获取数据包大小上限，在读取数据前限制，避免超大数据包占用内存
+func packetSizeLimit(handler PeerHandler) int64 {
+	if limiter, ok := handler.(interface{ maxPacketSize() int64 }); ok {
+		return limiter.maxPacketSize()
+	}
+	return 0
+}
+
+//limitRequest 请求限流检查，超出限制时终止路由方法，返回处理完成后的释放方法
+func (node *OWTPNode) limitRequest(ctx *Context, packet *DataPacket) func() {
+
+	node.mu.RLock()
+	limiter := node.limiter
+	node.mu.RUnlock()
+
+	if limiter == nil {
+		return func() {}
+	}
+
+	release, retryAfter, err := limiter.acquire(ctx.PID, ctx.Method, packet.size, node.serveMux.isInnerMethod(ctx.Method))
+	if err != nil {
+		result := map[string]interface{}{
+			"retryAfter": int64(retryAfter / time.Millisecond),
+		}
+		ctx.ResponseStopRun(result, ErrDenialOfService, err.Error())
+		return func() {}
+	}
+
+	return release
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Acquire(t *testing.T) {
+
+	limiter := newRateLimiter(RateLimitConfig{
+		PeerLimit: RateLimit{Rate: 100, Burst: 3},
+		MethodLimits: map[string]RateLimit{
+			"send": {Rate: 1, Burst: 1},
+		},
+		MaxConcurrent: 2,
+		MaxPacketSize: 1024,
+	})
+
+	if _, _, err := limiter.acquire("1", "getInfo", 2048, false); err == nil {
+		t.Errorf("oversize packet should be rejected")
+	}
+
+	release, _, err := limiter.acquire("1", "send", 10, false)
+	if err != nil {
+		t.Errorf("acquire unexpected error: %v", err)
+		return
+	}
+	release()
+
+	//方法令牌用完，节点令牌未扣除
+	_, wait, err := limiter.acquire("1", "send", 10, false)
+	if err == nil || wait <= 0 || wait > time.Second {
+		t.Errorf("method limit: err = %v, wait = %v", err, wait)
+	}
+
+	r1, _, err := limiter.acquire("1", "getInfo", 10, false)
+	if err != nil {
+		t.Errorf("acquire unexpected error: %v", err)
+		return
+	}
+	r2, _, err := limiter.acquire("1", "getInfo", 10, false)
+	if err != nil {
+		t.Errorf("acquire unexpected error: %v", err)
+		return
+	}
+
+	if _, _, err = limiter.acquire("1", "getInfo", 10, false); err == nil {
+		t.Errorf("concurrent requests should be rejected")
+	}
+
+	//其他节点不受影响
+	if _, _, err = limiter.acquire("2", "getInfo", 10, false); err != nil {
+		t.Errorf("acquire unexpected error: %v", err)
+	}
+
+	//内置方法不限流
+	if _, _, err = limiter.acquire("1", "internal_keyAgreement", 10, true); err != nil {
+		t.Errorf("acquire unexpected error: %v", err)
+	}
+
+	r1()
+	r2()
+
+	//节点突发令牌已用完
+	if _, wait, err = limiter.acquire("1", "getInfo", 10, false); err == nil || wait <= 0 {
+		t.Errorf("peer limit: err = %v, wait = %v", err, wait)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, _, err = limiter.acquire("1", "getInfo", 10, false); err != nil {
+		t.Errorf("acquire after refill unexpected error: %v", err)
+	}
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+
+	limiter := newRateLimiter(RateLimitConfig{})
+	now := time.Now()
+	slow := RateLimit{Rate: 0.01, Burst: 10}
+	fast := RateLimit{Rate: 1, Burst: 10}
+	limiter.buckets["slow"] = &tokenBucket{tokens: 0, last: now, limit: slow}
+	limiter.buckets["fast"] = &tokenBucket{tokens: 0, last: now, limit: fast}
+
+	//空闲一个清理周期后，补充较慢的令牌桶仍未补满，删除后会重置限流
+	limiter.prune(now.Add(rateLimitPrunePeriod))
+	if _, ok := limiter.buckets["slow"]; !ok {
+		t.Errorf("bucket not refilled should not be pruned")
+	}
+	if _, ok := limiter.buckets["fast"]; ok {
+		t.Errorf("refilled bucket should be pruned")
+	}
+}
+
+func TestOWTPNode_RateLimit(t *testing.T) {
+
+	broker := &testMQBroker{}
+	dial := mqDial
+	mqDial = func(url string) (MQConnection, MQChannel, error) {
+		return testMQConnection{}, &testMQChannel{broker: broker}, nil
+	}
+	defer func() {
+		mqDial = dial
+	}()
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	server.SetRateLimit(RateLimitConfig{
+		PeerLimit: RateLimit{Rate: 1, Burst: 2},
+	})
+	server.HandleFunc("hello", func(ctx *Context) {
+		ctx.Response(nil, StatusSuccess, "success")
+	})
+	err := server.Listen(ConnectConfig{
+		Address:       "memory",
+		ConnectType:   MQ,
+		Exchange:      "owtp",
+		ReadQueueName: "owtp.server",
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err = client.Connect("server", ConnectConfig{
+		Address:        "memory",
+		ConnectType:    MQ,
+		Exchange:       "owtp",
+		WriteQueueName: "owtp.server",
+		ReadQueueName:  "owtp.client",
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	for i, want := range []uint64{StatusSuccess, StatusSuccess, ErrDenialOfService} {
+		resp, err := client.CallSync("server", "hello", nil)
+		if err != nil {
+			t.Errorf("CallSync unexpected error: %v", err)
+			return
+		}
+		if resp.Status != want {
+			t.Errorf("call %d status: %d, want %d", i, resp.Status, want)
+		}
+		if want == ErrDenialOfService {
+			retryAfter := resp.JsonData().Get("retryAfter").Int()
+			if retryAfter <= 0 {
+				t.Errorf("retryAfter = %d, want > 0", retryAfter)
+			}
+			t.Logf("msg: %s, retryAfter: %dms", resp.Msg, retryAfter)
+		}
+	}
+}
+
+func TestOWTPNode_MaxPacketSize(t *testing.T) {
+
+	for _, connectType := range []string{Websocket, HTTP} {
+
+		server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		server.SetRateLimit(RateLimitConfig{MaxPacketSize: 4096})
+		server.HandleFunc("hello", func(ctx *Context) {
+			ctx.Response(nil, StatusSuccess, "success")
+		})
+		err := server.Listen(ConnectConfig{Address: "127.0.0.1:0", ConnectType: connectType})
+		if err != nil {
+			t.Errorf("Listen unexpected error: %v", err)
+			return
+		}
+		addr := server.listeners[connectType].Addr().String()
+
+		client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		//HTTP响应的错误内容不是数据包，请求等待超时
+		client.serveMux.timeout = time.Second
+		_, err = client.Connect("server", ConnectConfig{Address: addr, ConnectType: connectType})
+		if err != nil {
+			t.Errorf("%s Connect unexpected error: %v", connectType, err)
+			return
+		}
+
+		resp, err := client.CallSync("server", "hello", map[string]interface{}{"data": "small"})
+		if err != nil || resp.Status != StatusSuccess {
+			t.Errorf("%s small packet should pass, resp: %+v, err: %v", connectType, resp, err)
+		}
+
+		//超过上限的数据包在传输层被拒绝，不会交给路由处理
+		resp, err = client.CallSync("server", "hello", map[string]interface{}{"data": strings.Repeat("a", 8192)})
+		if err == nil && resp.Status == StatusSuccess {
+			t.Errorf("%s oversize packet should be rejected", connectType)
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+const (
+	DefaultReconnectBaseMS = 500
+	DefaultReconnectMaxMS  = 30000
+	DefaultReconnectJitter = 0.2
+)
+
+//reconnectDelay 第attempt次重连前的等待时间，指数退避并加入随机抖动
+func reconnectDelay(config ConnectConfig, attempt int) time.Duration {
+
+	base := config.ReconnectBaseMS
+	if base <= 0 {
+		base = DefaultReconnectBaseMS
+	}
+	max := config.ReconnectMaxMS
+	if max <= 0 {
+		max = DefaultReconnectMaxMS
+	}
+	jitter := config.ReconnectJitter
+	if jitter <= 0 {
+		jitter = DefaultReconnectJitter
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	delay := float64(base)
+	for i := 1; i < attempt && delay < float64(max); i++ {
+		delay = delay * 2
+	}
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+
+	//在[1-jitter, 1+jitter]范围内随机，避免大量节点同时重连
+	delay = delay * (1 + jitter*(rand.Float64()*2-1))
+
+	return time.Duration(delay) * time.Millisecond
+}
+
+//isReplayMethod 方法是否在重连后重新发送
+func isReplayMethod(config ConnectConfig, method string) bool {
+	if !config.EnableReconnect {
+		return false
+	}
+	for _, m := range config.ReplayMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+//markClosedPeer 标记主动关闭的节点，断开后不重连
+func (node *OWTPNode) markClosedPeer(peer Peer) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.closedPeers == nil {
+		node.closedPeers = make(map[Peer]bool)
+	}
+	node.closedPeers[peer] = true
+}
+
+//shouldReconnect 节点断开后是否需要重连
+func (node *OWTPNode) shouldReconnect(peer Peer) bool {
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	closedByLocal := node.closedPeers[peer]
+	delete(node.closedPeers, peer)
+
+	if node.closed || closedByLocal {
+		return false
+	}
+
+	//只有我方主动连接的长连接才能重连
+	config := peer.ConnectConfig()
+	if !config.EnableReconnect || !peer.IsHost() {
+		return false
+	}
+	if config.ConnectType != Websocket && config.ConnectType != MQ {
+		return false
+	}
+
+	if node.reconnectingPeers[peer.PID()] {
+		return false
+	}
+	if node.reconnectingPeers == nil {
+		node.reconnectingPeers = make(map[string]bool)
+	}
+	node.reconnectingPeers[peer.PID()] = true
+
+	return true
+}
+
+//reconnect 按退避策略重连节点，成功后重新发送幂等的请求
+func (node *OWTPNode) reconnect(pid string, config ConnectConfig, requests []requestEntry) {
+
+	defer func() {
+		node.mu.Lock()
+		delete(node.reconnectingPeers, pid)
+		node.mu.Unlock()
+	}()
+
+	//等待重连的请求已移出请求队列，按原请求时间计算超时
+	timeout := node.serveMux.requestTimeout()
+
+	for attempt := 1; config.ReconnectMaxAttempts <= 0 || attempt <= config.ReconnectMaxAttempts; attempt++ {
+
+		requests = waitReconnect(reconnectDelay(config, attempt), requests, timeout)
+
+		node.mu.RLock()
+		closed := node.closed
+		node.mu.RUnlock()
+		if closed {
+			break
+		}
+
+		//Connect会重新进行协商密码
+		peer, err := node.Connect(pid, config)
+		if err != nil {
+			log.Warningf("peer[%s] reconnect attempt %d failed, unexpected error: %v", pid, attempt, err)
+			continue
+		}
+
+		log.Infof("peer[%s] reconnected after %d attempts", pid, attempt)
+
+		requests = failExpiredRequests(requests, time.Now(), timeout)
+		for _, r := range requests {
+			err = node.sendRequest(peer, r.method, r.params, r.h, r.respChan, r.sync)
+			if err != nil {
+				failRequest(r, responseError(err.Error(), ErrNetworkDisconnected))
+			}
+		}
+		return
+	}
+
+	log.Errorf("peer[%s] reconnect failed", pid)
+
+	for _, r := range requests {
+		failRequest(r, responseError("network disconnected", ErrNetworkDisconnected))
+	}
+}
+
+//waitReconnect 等待delay后重连，等待期间返回已超时请求的超时响应，返回未超时的请求
+func waitReconnect(delay time.Duration, requests []requestEntry, timeout time.Duration) []requestEntry {
+
+	wakeAt := time.Now().Add(delay)
+	for {
+		now := time.Now()
+		requests = failExpiredRequests(requests, now, timeout)
+		if !now.Before(wakeAt) {
+			return requests
+		}
+
+		//在最早的请求超时或重连时间醒来
+		next := wakeAt
+		for _, r := range requests {
+			if deadline := requestDeadline(r, timeout); deadline.Before(next) {
+				next = deadline
+			}
+		}
+		time.Sleep(next.Sub(now))
+	}
+}
+
+//requestDeadline 请求的超时时间点
+func requestDeadline(r requestEntry, timeout time.Duration) time.Time {
+	return time.Unix(r.time, 0).Add(timeout)
+}
+
+//failExpiredRequests 返回已超时请求的超时响应，返回未超时的请求
+func failExpiredRequests(requests []requestEntry, now time.Time, timeout time.Duration) []requestEntry {
+	pending := requests[:0]
+	for _, r := range requests {
+		if now.Before(requestDeadline(r, timeout)) {
+			pending = append(pending, r)
+			continue
+		}
+		errInfo := fmt.Sprintf("request timeout over %s", timeout.String())
+		failRequest(r, responseError(errInfo, ErrRequestTimeout))
+	}
+	return pending
+}
+
+//failRequest 返回请求的异常响应
+func failRequest(r requestEntry, resp Response) {
+	if r.sync {
+		r.respChan <- resp
+	} else {
+		r.h(resp)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+
+	config := ConnectConfig{
+		ReconnectBaseMS: 100,
+		ReconnectMaxMS:  1000,
+		ReconnectJitter: 0.5,
+	}
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 150 * time.Millisecond},
+		{3, 200 * time.Millisecond, 600 * time.Millisecond},
+		{10, 500 * time.Millisecond, 1500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		delay := reconnectDelay(config, test.attempt)
+		if delay < test.min || delay > test.max {
+			t.Errorf("attempt %d delay = %v, want [%v, %v]", test.attempt, delay, test.min, test.max)
+		}
+	}
+}
+
+func TestOWTPNode_ReconnectAndReplay(t *testing.T) {
+
+	var calls int32
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	err := server.Listen(ConnectConfig{
+		Address:     "127.0.0.1:0",
+		ConnectType: Websocket,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+	server.HandleFunc("echo", func(ctx *Context) {
+		//第一次请求时断开连接，模拟负载均衡切换
+		if atomic.AddInt32(&calls, 1) == 1 {
+			ctx.Peer.close()
+			return
+		}
+		ctx.Response(nil, StatusSuccess, "success")
+	})
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err = client.Connect("server", ConnectConfig{
+		Address:         server.listeners[Websocket].Addr().String(),
+		ConnectType:     Websocket,
+		EnableReconnect: true,
+		ReconnectBaseMS: 20,
+		ReplayMethods:   []string{"echo"},
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	resp, err := client.CallSync("server", "echo", nil)
+	if err != nil {
+		t.Errorf("CallSync unexpected error: %v", err)
+		return
+	}
+	if resp.Status != StatusSuccess {
+		t.Errorf("response status: %d, msg: %s", resp.Status, resp.Msg)
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("echo calls = %d, want 2", calls)
+	}
+	if !client.IsConnectPeer("server") {
+		t.Errorf("peer should be reconnected")
+	}
+}
+
+func TestOWTPNode_ReplayRequestTimeout(t *testing.T) {
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	err := server.Listen(ConnectConfig{
+		Address:     "127.0.0.1:0",
+		ConnectType: Websocket,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+	//断开连接后服务不再可用，重连一直失败
+	server.HandleFunc("echo", func(ctx *Context) {
+		server.Close()
+	})
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	client.serveMux.timeout = time.Second
+	_, err = client.Connect("server", ConnectConfig{
+		Address:         server.listeners[Websocket].Addr().String(),
+		ConnectType:     Websocket,
+		EnableReconnect: true,
+		ReconnectBaseMS: 20,
+		ReplayMethods:   []string{"echo"},
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	done := make(chan Response, 1)
+	go func() {
+		resp, _ := client.CallSync("server", "echo", nil)
+		done <- *resp
+	}()
+
+	select {
+	case resp := <-done:
+		if resp.Status != ErrRequestTimeout {
+			t.Errorf("response status: %d, msg: %s", resp.Status, resp.Msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("replayed request should time out while reconnecting")
+	}
+}
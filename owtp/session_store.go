@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/session"
+	"net/http"
 	"time"
 )
 
@@ -134,7 +135,13 @@ func (store *SessionManager) Put(id string, key string, val interface{}) error {
 	if err != nil {
 		return err
 	}
-	return session.Set(key, val)
+	err = session.Set(key, val)
+	if err != nil {
+		return err
+	}
+	//写回存储，redis等外部存储才能保存
+	releaseSession(session)
+	return nil
 }
 
 //Delete
@@ -143,7 +150,12 @@ func (store *SessionManager) Delete(id string, key string) error {
 	if err != nil {
 		return err
 	}
-	return session.Delete(key)
+	err = session.Delete(key)
+	if err != nil {
+		return err
+	}
+	releaseSession(session)
+	return nil
 }
 
 //Destroy
@@ -200,6 +212,81 @@ func (store *SessionManager) GetActiveSession() int {
 	return store.provider.SessionAll()
 }
 
+//NonceCache 基于会话存储的请求nonce缓存，用于ServeMux防重放。
+//使用redis等共享的provider时，多个节点可以共享nonce，每个nonce保存为一个会话，
+//会话的有效期为Maxlifetime，nonce的过期时间另外记录
+func (store *SessionManager) NonceCache() NonceCache {
+	return &sessionNonceCache{store: store}
+}
+
+//sessionNonceCache 会话存储的nonce缓存
+type sessionNonceCache struct {
+	store *SessionManager
+}
+
+func (c *sessionNonceCache) sessionID(key string) string {
+	return c.store.config.SessionIDPrefix + "nonce_" + key
+}
+
+//IsExist nonce是否存在且未过期
+func (c *sessionNonceCache) IsExist(key string) bool {
+	sid := c.sessionID(key)
+	//读取不存在的会话会创建新会话，先检查是否存在
+	if !c.store.provider.SessionExist(sid) {
+		return false
+	}
+	session, err := c.store.provider.SessionRead(sid)
+	if err != nil {
+		return false
+	}
+	expire, ok := session.Get("expire").(int64)
+	if !ok {
+		return false
+	}
+	return expire == 0 || time.Now().Unix() <= expire
+}
+
+//Put 记录nonce，timeout后过期
+func (c *sessionNonceCache) Put(key string, val interface{}, timeout time.Duration) error {
+	session, err := c.store.provider.SessionRead(c.sessionID(key))
+	if err != nil {
+		return err
+	}
+	expire := int64(0)
+	if timeout > 0 {
+		expire = time.Now().Add(timeout).Unix()
+	}
+	err = session.Set("expire", expire)
+	if err != nil {
+		return err
+	}
+	releaseSession(session)
+	return nil
+}
+
+//discardResponseWriter 丢弃输出的ResponseWriter，节点属性不通过HTTP响应保存
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(statusCode int) {}
+
+//releaseSession 写回会话存储，cookie等需要写入响应的provider不能传nil
+func releaseSession(session session.Store) {
+	session.SessionRelease(&discardResponseWriter{})
+}
+
 func (store *SessionManager) sessionID(pid string) (string, error) {
 	return store.config.SessionIDPrefix + pid, nil
 }
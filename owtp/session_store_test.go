@@ -16,10 +16,19 @@
 package owtp
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
-	"github.com/blocktree/openwallet/v2/session"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/session"
+	_ "github.com/blocktree/openwallet/v2/session/redis"
 )
 
 func TestMem(t *testing.T) {
@@ -52,3 +61,257 @@ func TestMem(t *testing.T) {
 	fmt.Printf("username = %s \n", username)
 	fmt.Printf("username2 = %s \n", username2)
 }
+
+//testRedisServer 进程内的redis服务，只实现session/redis使用的命令
+type testRedisServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]string
+}
+
+func newTestRedisServer(t *testing.T) *testRedisServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen unexpected error: %v", err)
+	}
+	srv := &testRedisServer{listener: l, values: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *testRedisServer) Addr() string {
+	return srv.listener.Addr().String()
+}
+
+func (srv *testRedisServer) Close() error {
+	return srv.listener.Close()
+}
+
+//readCommand 读取RESP数组格式的命令
+func (srv *testRedisServer) readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func (srv *testRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := srv.readCommand(r)
+		if err != nil || len(args) == 0 {
+			return
+		}
+		srv.mu.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			if v, ok := srv.values[args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case "SET":
+			srv.values[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case "SETEX":
+			srv.values[args[1]] = args[3]
+			reply = "+OK\r\n"
+		case "DEL":
+			delete(srv.values, args[1])
+			reply = ":1\r\n"
+		case "EXISTS":
+			_, ok := srv.values[args[1]]
+			if ok {
+				reply = ":1\r\n"
+			} else {
+				reply = ":0\r\n"
+			}
+		default:
+			reply = "+OK\r\n"
+		}
+		srv.mu.Unlock()
+		if _, err = io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func TestSessionManager_CookieProvider(t *testing.T) {
+	conf := &session.ManagerConfig{
+		Gclifetime:     10,
+		ProviderConfig: `{"cookieName":"owtp","securityKey":"owtpcookiehashkey"}`,
+	}
+	store, err := NewSessionManager("cookie", conf)
+	if err != nil {
+		t.Errorf("NewSessionManager unexpected error: %v", err)
+		return
+	}
+
+	//cookie会话写回时需要ResponseWriter，不能因此panic
+	if err = store.Put("123456", "username", "owtp"); err != nil {
+		t.Errorf("Put unexpected error: %v", err)
+	}
+	if err = store.Delete("123456", "username"); err != nil {
+		t.Errorf("Delete unexpected error: %v", err)
+	}
+}
+
+func TestMQListener_CompetingConsumersRedisSession(t *testing.T) {
+
+	defer testMQDial()()
+
+	redisServer := newTestRedisServer(t)
+	defer redisServer.Close()
+
+	var (
+		mu     sync.Mutex
+		served = make(map[int]int)
+		stores = make([]*SessionManager, 0)
+	)
+
+	//竞争消费的节点使用相同的证书，各自连接redis共享协商密码和nonce
+	cert := NewRandomCertificate()
+
+	for i := 0; i < 2; i++ {
+		store, err := NewSessionManager("redis", &session.ManagerConfig{
+			Gclifetime:     3600,
+			ProviderConfig: redisServer.Addr(),
+		})
+		if err != nil {
+			t.Errorf("NewSessionManager unexpected error: %v", err)
+			return
+		}
+		stores = append(stores, store)
+
+		server := NewOWTPNode(cert, 0, 0)
+		defer server.Close()
+		server.SetPeerstore(store)
+		server.SetNonceCache(store.NonceCache())
+		err = server.Listen(ConnectConfig{
+			Address:       "memory",
+			ConnectType:   MQ,
+			Exchange:      "owtp",
+			ReadQueueName: "owtp.server",
+		})
+		if err != nil {
+			t.Errorf("Listen unexpected error: %v", err)
+			return
+		}
+		index := i
+		server.HandleFunc("hello", func(ctx *Context) {
+			mu.Lock()
+			served[index]++
+			mu.Unlock()
+			ctx.Response(ctx.Params().Get("index").Int(), StatusSuccess, "success")
+		})
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{
+		Address:            "memory",
+		ConnectType:        MQ,
+		Exchange:           "owtp",
+		WriteQueueName:     "owtp.server",
+		ReadQueueName:      "owtp.client",
+		EnableKeyAgreement: true,
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	//协商密码经redis保存，两个会话管理器读取到相同的密钥
+	ka0, _ := stores[0].Get(client.NodeID(), keyAgreementCipher).(*KeyAgreement)
+	ka1, _ := stores[1].Get(client.NodeID(), keyAgreementCipher).(*KeyAgreement)
+	if ka0 == nil || ka1 == nil || ka0.Key != ka1.Key {
+		t.Errorf("key agreement is not shared by redis")
+		return
+	}
+	key := ka0.Key
+
+	total := 20
+	for i := 0; i < total; i++ {
+		resp, err := client.CallSync("server", "hello", map[string]interface{}{"index": i})
+		if err != nil {
+			t.Errorf("CallSync unexpected error: %v", err)
+			return
+		}
+		if resp.Status != StatusSuccess || resp.JsonData().Int() != int64(i) {
+			t.Errorf("response status: %d, result: %v", resp.Status, resp.Result)
+			return
+		}
+	}
+
+	if len(served) != 2 {
+		t.Errorf("requests should be served by both listeners: %v", served)
+	}
+
+	ka0, _ = stores[0].Get(client.NodeID(), keyAgreementCipher).(*KeyAgreement)
+	if ka0 == nil || ka0.Key != key {
+		t.Errorf("key agreement should not be regenerated")
+	}
+}
+
+func TestSessionManager_NonceCache(t *testing.T) {
+
+	redisServer := newTestRedisServer(t)
+	defer redisServer.Close()
+
+	caches := make([]NonceCache, 0)
+	for i := 0; i < 2; i++ {
+		store, err := NewSessionManager("redis", &session.ManagerConfig{
+			Gclifetime:     3600,
+			ProviderConfig: redisServer.Addr(),
+		})
+		if err != nil {
+			t.Errorf("NewSessionManager unexpected error: %v", err)
+			return
+		}
+		caches = append(caches, store.NonceCache())
+	}
+
+	if caches[1].IsExist("1_100") {
+		t.Errorf("nonce should not exist before put")
+	}
+
+	//一个节点记录的nonce，其他节点可以检查到
+	if err := caches[0].Put("1_100", "hello", time.Minute); err != nil {
+		t.Errorf("Put unexpected error: %v", err)
+		return
+	}
+	if !caches[1].IsExist("1_100") {
+		t.Errorf("nonce should be shared by redis")
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+//StreamChunkMethod 流式响应数据块的内置方法
+const StreamChunkMethod = "internal_streamChunk"
+
+//StreamWindowSize 流式响应未确认数据块的序号范围，最小未确认的数据块之后达到该数量时WriteChunk阻塞等待确认
+var StreamWindowSize = 8
+
+//StreamHandler 按顺序处理流式响应的数据块，返回错误时中断传输
+type StreamHandler func(seq uint64, chunk gjson.Result) error
+
+//streamWriter 响应方的流式发送状态
+type streamWriter struct {
+	seq    uint64          //已发送的最大序号
+	base   uint64          //最小的未确认序号
+	window uint64          //可发送的序号范围，与接收方的缓存范围一致
+	acked  map[uint64]bool //已确认但序号不连续的数据块
+	cond   *sync.Cond
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	err    error
+}
+
+func newStreamWriter() *streamWriter {
+	s := &streamWriter{
+		base:   1,
+		window: streamWindow(),
+		acked:  make(map[uint64]bool),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+//next 等待窗口可用后分配下一个序号。接收方对乱序缓存的数据块也会确认，
+//所以窗口按最小未确认的序号计算，保证发送的序号不超出接收方的缓存范围
+func (s *streamWriter) next() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.err == nil && s.seq+1 >= s.base+s.window {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.seq++
+	return s.seq, nil
+}
+
+//ack 数据块已确认，移动窗口
+func (s *streamWriter) ack(seq uint64, err error) {
+	s.mu.Lock()
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.acked[seq] = true
+	for s.acked[s.base] {
+		delete(s.acked, s.base)
+		s.base++
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+func (s *streamWriter) error() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func streamWindow() uint64 {
+	if StreamWindowSize < 1 {
+		return 1
+	}
+	return uint64(StreamWindowSize)
+}
+
+//WriteChunk 发送流式响应的数据块，每个数据块单独加密和签名，
+//未确认的数据块达到StreamWindowSize时阻塞，对方中断后返回错误。
+//支持websocket和mq节点，http节点只能返回一次响应，不支持流式响应
+func (ctx *Context) WriteChunk(data interface{}) error {
+
+	if ctx.node == nil || ctx.Peer == nil || ctx.Req != WSRequest {
+		return fmt.Errorf("context can not write chunk")
+	}
+
+	if _, ok := ctx.Peer.(*HTTPClient); ok {
+		return fmt.Errorf("http peer can not write chunk")
+	}
+
+	if ctx.stream == nil {
+		ctx.stream = newStreamWriter()
+	}
+	s := ctx.stream
+
+	seq, err := s.next()
+	if err != nil {
+		return err
+	}
+	s.wg.Add(1)
+
+	params := map[string]interface{}{
+		"nonce": ctx.nonce,
+		"seq":   seq,
+		"data":  data,
+	}
+
+	done := func(resp Response) {
+		var err error
+		if resp.Status != StatusSuccess {
+			err = fmt.Errorf("stream is interrupted, unexpected error: %s", resp.Msg)
+		}
+		s.ack(seq, err)
+		s.wg.Done()
+	}
+
+	err = ctx.node.sendRequest(ctx.Peer, StreamChunkMethod, params, done, nil, false)
+	if err != nil {
+		done(responseError(err.Error(), ErrNetworkDisconnected))
+		return s.error()
+	}
+
+	return nil
+}
+
+//waitStream 等待所有数据块确认
+func (ctx *Context) waitStream() {
+	if ctx.stream != nil {
+		ctx.stream.wg.Wait()
+	}
+}
+
+//streamReceiver 请求方的流式接收状态，数据块可能乱序到达，按序号回调
+type streamReceiver struct {
+	mu      sync.Mutex
+	handler StreamHandler
+	next    uint64
+	window  uint64 //可缓存的数据块序号范围，超出的数据块视为错误
+	buffer  map[uint64]gjson.Result
+	err     error
+}
+
+func (r *streamReceiver) receive(seq uint64, chunk gjson.Result) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	//重复的数据块
+	if seq < r.next {
+		return nil
+	}
+
+	//发送方最多有StreamWindowSize个未确认的数据块，超出窗口的序号不缓存，避免占用内存
+	if seq >= r.next+r.window {
+		r.err = fmt.Errorf("stream chunk seq: %d is out of window", seq)
+		return r.err
+	}
+
+	r.buffer[seq] = chunk
+	for {
+		c, ok := r.buffer[r.next]
+		if !ok {
+			break
+		}
+		delete(r.buffer, r.next)
+		if err := r.handler(r.next, c); err != nil {
+			r.err = err
+			return err
+		}
+		r.next++
+	}
+
+	return nil
+}
+
+func streamKey(pid string, nonce uint64) string {
+	return fmt.Sprintf("%s_%d", pid, nonce)
+}
+
+//CallStream 流式请求，对方通过Context.WriteChunk发送的数据块按序回调handler，
+//每收到一个数据块刷新请求的超时时间，全部完成后返回最终响应
+func (node *OWTPNode) CallStream(pid, method string, params interface{}, handler StreamHandler) (*Response, error) {
+
+	if handler == nil {
+		return nil, fmt.Errorf("handler should not be nil")
+	}
+
+	var (
+		err      error
+		respChan = make(chan Response, 1)
+	)
+
+	//检查是否已经连接服务
+	peer := node.GetOnlinePeer(pid)
+	if peer == nil {
+		peerInfo := node.peerstore.PeerInfo(pid)
+		peer, err = node.Connect(pid, peerInfo.Config) //重新连接
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nonce := uint64(node.nonceGen.Generate().Int64())
+	key := streamKey(peer.PID(), nonce)
+	receiver := &streamReceiver{
+		handler: handler,
+		next:    1,
+		window:  streamWindow(),
+		buffer:  make(map[uint64]gjson.Result),
+	}
+
+	node.streams.Store(key, receiver)
+	defer node.streams.Delete(key)
+
+	err = node.sendRequestWithNonce(peer, nonce, method, params, nil, respChan, true)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := <-respChan
+
+	receiver.mu.Lock()
+	err = receiver.err
+	receiver.mu.Unlock()
+	if err != nil {
+		return &resp, err
+	}
+
+	return &resp, nil
+}
+
+//receiveStreamChunk 处理对方发送的数据块，处理成功响应即确认
+func (node *OWTPNode) receiveStreamChunk(ctx *Context) {
+
+	params := ctx.Params()
+	nonce := params.Get("nonce").Uint()
+
+	value, ok := node.streams.Load(streamKey(ctx.PID, nonce))
+	if !ok {
+		ctx.Response(nil, ErrBadRequest, "stream is not found")
+		return
+	}
+
+	//数据块到达，请求未超时
+	node.serveMux.touchRequest(ctx.PID, nonce)
+
+	err := value.(*streamReceiver).receive(params.Get("seq").Uint(), params.Get("data"))
+	if err != nil {
+		ctx.Response(nil, ErrCustomError, err.Error())
+		return
+	}
+
+	ctx.Response(nil, StatusSuccess, "success")
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestOWTPNode_CallStream(t *testing.T) {
+
+	total := 100
+	var (
+		mu       sync.Mutex
+		writeErr error
+	)
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	err := server.Listen(ConnectConfig{
+		Address:     "127.0.0.1:0",
+		ConnectType: Websocket,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+	server.HandleFunc("export", func(ctx *Context) {
+		for i := 1; i <= total; i++ {
+			err := ctx.WriteChunk(map[string]interface{}{"index": i})
+			mu.Lock()
+			writeErr = err
+			mu.Unlock()
+			if err != nil {
+				ctx.Response(nil, ErrCustomError, err.Error())
+				return
+			}
+		}
+		ctx.Response(map[string]interface{}{"total": total}, StatusSuccess, "success")
+	})
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err = client.Connect("server", ConnectConfig{
+		Address:            server.listeners[Websocket].Addr().String(),
+		ConnectType:        Websocket,
+		EnableKeyAgreement: true,
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	//数据块按顺序回调
+	count := 0
+	resp, err := client.CallStream("server", "export", nil, func(seq uint64, chunk gjson.Result) error {
+		count++
+		if seq != uint64(count) || chunk.Get("index").Int() != int64(count) {
+			return fmt.Errorf("chunk %d is out of order: %s", seq, chunk.Raw)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("CallStream unexpected error: %v", err)
+		return
+	}
+	if resp.Status != StatusSuccess || count != total {
+		t.Errorf("response status: %d, msg: %s, chunks: %d", resp.Status, resp.Msg, count)
+	}
+
+	//接收方中断传输
+	_, err = client.CallStream("server", "export", nil, func(seq uint64, chunk gjson.Result) error {
+		if seq == 5 {
+			return fmt.Errorf("stop")
+		}
+		return nil
+	})
+	if err == nil {
+		t.Errorf("interrupted stream should return error")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if writeErr == nil {
+		t.Errorf("WriteChunk should return error after stream is interrupted")
+	}
+}
+
+func TestOWTPNode_CallStreamMQ(t *testing.T) {
+
+	defer testMQDial()()
+
+	total := 30
+
+	//两个服务节点竞争消费同一队列，数据块的确认需要回到发送数据块的监听器
+	for i := 0; i < 2; i++ {
+		server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		defer server.Close()
+		err := server.Listen(ConnectConfig{
+			Address:       "memory",
+			ConnectType:   MQ,
+			Exchange:      "owtp",
+			ReadQueueName: "owtp.server",
+		})
+		if err != nil {
+			t.Errorf("Listen unexpected error: %v", err)
+			return
+		}
+		server.HandleFunc("export", func(ctx *Context) {
+			for i := 1; i <= total; i++ {
+				if err := ctx.WriteChunk(map[string]interface{}{"index": i}); err != nil {
+					ctx.Response(nil, ErrCustomError, err.Error())
+					return
+				}
+			}
+			ctx.Response(nil, StatusSuccess, "success")
+		})
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+	_, err := client.Connect("server", ConnectConfig{
+		Address:        "memory",
+		ConnectType:    MQ,
+		Exchange:       "owtp",
+		WriteQueueName: "owtp.server",
+		ReadQueueName:  "owtp.client",
+	})
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	for i := 0; i < 4; i++ {
+		count := 0
+		resp, err := client.CallStream("server", "export", nil, func(seq uint64, chunk gjson.Result) error {
+			count++
+			if seq != uint64(count) {
+				return fmt.Errorf("chunk %d is out of order", seq)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("CallStream unexpected error: %v", err)
+			return
+		}
+		if resp.Status != StatusSuccess || count != total {
+			t.Errorf("response status: %d, msg: %s, chunks: %d", resp.Status, resp.Msg, count)
+		}
+	}
+}
+
+func TestStreamReceiver_Window(t *testing.T) {
+
+	receiver := &streamReceiver{
+		handler: func(seq uint64, chunk gjson.Result) error { return nil },
+		next:    1,
+		window:  4,
+		buffer:  make(map[uint64]gjson.Result),
+	}
+
+	if err := receiver.receive(4, gjson.Result{}); err != nil {
+		t.Errorf("chunk in window unexpected error: %v", err)
+	}
+
+	//超出窗口的数据块中断接收
+	if err := receiver.receive(5, gjson.Result{}); err == nil {
+		t.Errorf("chunk out of window should return error")
+	}
+	if len(receiver.buffer) != 1 {
+		t.Errorf("chunk out of window should not be buffered")
+	}
+}
@@ -0,0 +1,285 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/mr-tron/base58/base58"
+)
+
+//oidOWTPIdentity TLS证书中的节点身份扩展
+var oidOWTPIdentity = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 54321, 1, 1}
+
+//TLSCertificateValidity 节点生成的TLS证书有效期
+var TLSCertificateValidity = 365 * 24 * time.Hour
+
+//tlsIdentity 节点公钥对TLS证书公钥的签名，证明TLS证书由节点签署
+type tlsIdentity struct {
+	PublicKey []byte
+	Signature []byte
+}
+
+//tlsIdentityHash TLS证书公钥的签名摘要
+func tlsIdentityHash(spki []byte) []byte {
+	return owcrypt.Hash(append([]byte("owtp-tls-identity"), spki...), 0, owcrypt.HASH_ALG_DOUBLE_SHA256)
+}
+
+//NewTLSCertificate 创建绑定节点身份的自签名TLS证书，证书公钥由节点私钥签名，
+//hosts为证书的域名或IP，对方可通过VerifyTLSIdentity获得节点公钥
+func NewTLSCertificate(cert Certificate, hosts ...string) (tls.Certificate, error) {
+
+	if len(cert.PrivateKeyBytes()) == 0 {
+		return tls.Certificate{}, fmt.Errorf("certificate private key is empty")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	nodeID := owcrypt.Hash(cert.PublicKeyBytes(), 0, owcrypt.HASH_ALG_SHA256)
+	signature, _, ret := owcrypt.Signature(cert.PrivateKeyBytes(), nodeID, tlsIdentityHash(spki), owcrypt.ECC_CURVE_SM2_STANDARD)
+	if ret != owcrypt.SUCCESS {
+		return tls.Certificate{}, fmt.Errorf("sign tls identity failed")
+	}
+
+	identity, err := asn1.Marshal(tlsIdentity{PublicKey: cert.PublicKeyBytes(), Signature: signature})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: cert.ID()},
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.Add(TLSCertificateValidity),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: oidOWTPIdentity, Value: identity}},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+//VerifyTLSIdentity 校验TLS证书绑定的节点身份，返回节点公钥
+func VerifyTLSIdentity(c *x509.Certificate) ([]byte, error) {
+
+	for _, ext := range c.Extensions {
+		if !ext.Id.Equal(oidOWTPIdentity) {
+			continue
+		}
+
+		var identity tlsIdentity
+		if _, err := asn1.Unmarshal(ext.Value, &identity); err != nil {
+			return nil, fmt.Errorf("tls identity is invalid, unexpected error: %v", err)
+		}
+
+		nodeID := owcrypt.Hash(identity.PublicKey, 0, owcrypt.HASH_ALG_SHA256)
+		ret := owcrypt.Verify(identity.PublicKey, nodeID, tlsIdentityHash(c.RawSubjectPublicKeyInfo), identity.Signature, owcrypt.ECC_CURVE_SM2_STANDARD)
+		if ret != owcrypt.SUCCESS {
+			return nil, fmt.Errorf("tls identity signature verify invalid")
+		}
+
+		return identity.PublicKey, nil
+	}
+
+	return nil, fmt.Errorf("tls certificate is not bound to node identity")
+}
+
+//TLSPublicKeyPin TLS证书公钥的固定值，sha256后base64编码，用于ConnectConfig.TLSPinnedKeys
+func TLSPublicKeyPin(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+//tlsConfig 根据连接配置创建TLS配置，server为监听方
+func (node *OWTPNode) tlsConfig(config ConnectConfig, server bool) (*tls.Config, error) {
+
+	if config.TLSConfig != nil {
+		return config.TLSConfig.Clone(), nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: config.TLSServerName,
+	}
+
+	//本方证书，未指定文件时，监听方或需要绑定身份时使用节点签署的证书
+	if len(config.TLSCertFile) > 0 {
+		c, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls certificate failed, unexpected error: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{c}
+	} else if server || config.TLSBindIdentity {
+		c, err := NewTLSCertificate(node.cert)
+		if err != nil {
+			return nil, fmt.Errorf("create tls certificate failed, unexpected error: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{c}
+	}
+
+	var pool *x509.CertPool
+	if len(config.TLSCAFile) > 0 {
+		ca, err := ioutil.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls ca failed, unexpected error: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("tls ca file contains no certificate")
+		}
+	}
+
+	customVerify := config.TLSBindIdentity || len(config.TLSPinnedKeys) > 0 || len(config.TLSRemoteID) > 0
+
+	if server {
+		tlsConfig.ClientCAs = pool
+		if pool != nil {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else if customVerify {
+			tlsConfig.ClientAuth = tls.RequireAnyClientCert
+		}
+	} else {
+		tlsConfig.RootCAs = pool
+		if pool == nil && customVerify {
+			//自签名证书，以固定公钥或节点身份代替证书链校验，任意节点都能签署绑定身份的证书，必须指定对方身份
+			if len(config.TLSPinnedKeys) == 0 && len(config.TLSRemoteID) == 0 {
+				return nil, fmt.Errorf("tls remote id or pinned keys is required to verify the server certificate")
+			}
+			tlsConfig.InsecureSkipVerify = true
+		}
+	}
+
+	if customVerify {
+		tlsConfig.VerifyPeerCertificate = verifyTLSPeer(config)
+	}
+
+	return tlsConfig, nil
+}
+
+//verifyTLSPeer 校验对方证书的固定公钥和绑定的节点身份
+func verifyTLSPeer(config ConnectConfig) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("peer tls certificate is missing")
+		}
+
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+
+		if len(config.TLSPinnedKeys) > 0 {
+			pin := TLSPublicKeyPin(leaf)
+			pinned := false
+			for _, p := range config.TLSPinnedKeys {
+				if p == pin {
+					pinned = true
+					break
+				}
+			}
+			if !pinned {
+				return fmt.Errorf("peer tls public key is not pinned")
+			}
+		}
+
+		if config.TLSBindIdentity || len(config.TLSRemoteID) > 0 {
+			pub, err := VerifyTLSIdentity(leaf)
+			if err != nil {
+				return err
+			}
+			if len(config.TLSRemoteID) > 0 {
+				id := base58.Encode(owcrypt.Hash(pub, 0, owcrypt.HASH_ALG_SHA256))
+				if id != config.TLSRemoteID {
+					return fmt.Errorf("peer tls identity is %s, not %s", id, config.TLSRemoteID)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+//verifyRequestTLSIdentity 校验请求的客户端证书绑定的节点公钥与请求头的公钥一致
+func verifyRequestTLSIdentity(r *http.Request) error {
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("client tls certificate is required")
+	}
+
+	pub, err := VerifyTLSIdentity(r.TLS.PeerCertificates[0])
+	if err != nil {
+		return err
+	}
+
+	a, err := base58.Decode(r.Header.Get("a"))
+	if err != nil || !bytes.Equal(a, pub) {
+		return fmt.Errorf("client tls identity is different of node public key")
+	}
+
+	return nil
+}
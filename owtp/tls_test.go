@@ -0,0 +1,244 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package owtp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen unexpected error: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestVerifyTLSIdentity(t *testing.T) {
+
+	cert := NewRandomCertificate()
+	tlsCert, err := NewTLSCertificate(cert, "127.0.0.1", "localhost")
+	if err != nil {
+		t.Errorf("NewTLSCertificate unexpected error: %v", err)
+		return
+	}
+
+	pub, err := VerifyTLSIdentity(tlsCert.Leaf)
+	if err != nil {
+		t.Errorf("VerifyTLSIdentity unexpected error: %v", err)
+		return
+	}
+	if !bytes.Equal(pub, cert.PublicKeyBytes()) {
+		t.Errorf("tls identity is not the node public key")
+	}
+
+	//身份扩展复制到其他证书公钥上，签名校验失败
+	other, err := NewTLSCertificate(NewRandomCertificate())
+	if err != nil {
+		t.Errorf("NewTLSCertificate unexpected error: %v", err)
+		return
+	}
+	forged := *other.Leaf
+	forged.Extensions = tlsCert.Leaf.Extensions
+	if _, err = VerifyTLSIdentity(&forged); err == nil {
+		t.Errorf("forged tls identity should be invalid")
+	}
+
+	if _, err = VerifyTLSIdentity(&x509.Certificate{}); err == nil {
+		t.Errorf("certificate without identity should be invalid")
+	}
+}
+
+func TestOWTPNode_TLSBindIdentity(t *testing.T) {
+
+	addr := freeAddress(t)
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+	server.HandleFunc("hello", func(ctx *Context) {
+		ctx.Response(nil, StatusSuccess, "success")
+	})
+	err := server.Listen(ConnectConfig{
+		Address:         addr,
+		ConnectType:     Websocket,
+		EnableSSL:       true,
+		EnableSignature: true,
+		TLSBindIdentity: true,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+
+	config := ConnectConfig{
+		Address:         addr,
+		ConnectType:     Websocket,
+		EnableSSL:       true,
+		EnableSignature: true,
+		TLSBindIdentity: true,
+		TLSRemoteID:     server.NodeID(),
+	}
+
+	_, err = client.Connect("server", config)
+	if err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+		return
+	}
+
+	resp, err := client.CallSync("server", "hello", nil)
+	if err != nil {
+		t.Errorf("CallSync unexpected error: %v", err)
+		return
+	}
+	if resp.Status != StatusSuccess {
+		t.Errorf("response status: %d, msg: %s", resp.Status, resp.Msg)
+	}
+
+	//服务端身份不一致
+	other := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer other.Close()
+	config.TLSRemoteID = other.NodeID()
+	if _, err = other.Connect("server", config); err == nil {
+		t.Errorf("connect to unexpected identity should fail")
+	}
+
+	//客户端没有绑定身份的证书
+	config.TLSBindIdentity = false
+	config.TLSRemoteID = server.NodeID()
+	if _, err = other.Connect("server", config); err == nil {
+		t.Errorf("connect without client certificate should fail")
+	}
+}
+
+func TestOWTPNode_TLSBindIdentityDefaultRemoteID(t *testing.T) {
+
+	addr := freeAddress(t)
+
+	server := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer server.Close()
+
+	//冒充者用自己的节点证书签署了绑定身份的TLS证书
+	mitm := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer mitm.Close()
+	err := mitm.Listen(ConnectConfig{
+		Address:         addr,
+		ConnectType:     Websocket,
+		EnableSSL:       true,
+		EnableSignature: true,
+		TLSBindIdentity: true,
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+	defer client.Close()
+
+	config := ConnectConfig{
+		Address:         addr,
+		ConnectType:     Websocket,
+		EnableSSL:       true,
+		EnableSignature: true,
+		TLSBindIdentity: true,
+	}
+
+	//只开启绑定身份，以连接的节点ID校验服务端身份
+	if _, err = client.Connect(server.NodeID(), config); err == nil {
+		t.Errorf("connect to another node's certificate should fail")
+		return
+	}
+
+	if _, err = client.Connect(mitm.NodeID(), config); err != nil {
+		t.Errorf("Connect unexpected error: %v", err)
+	}
+
+	//没有可校验的服务端身份时拒绝连接
+	if _, err = client.tlsConfig(config, false); err == nil {
+		t.Errorf("tls config without remote identity should return error")
+	}
+}
+
+func TestOWTPNode_TLSPinnedKeys(t *testing.T) {
+
+	addr := freeAddress(t)
+
+	serverCert := NewRandomCertificate()
+	tlsCert, err := NewTLSCertificate(serverCert, "127.0.0.1")
+	if err != nil {
+		t.Errorf("NewTLSCertificate unexpected error: %v", err)
+		return
+	}
+
+	server := NewOWTPNode(serverCert, 0, 0)
+	defer server.Close()
+	server.HandleFunc("hello", func(ctx *Context) {
+		ctx.Response(nil, StatusSuccess, "success")
+	})
+	err = server.Listen(ConnectConfig{
+		Address:     addr,
+		ConnectType: HTTP,
+		EnableSSL:   true,
+		TLSConfig:   tlsConfigWithCertificate(tlsCert),
+	})
+	if err != nil {
+		t.Errorf("Listen unexpected error: %v", err)
+		return
+	}
+
+	for _, test := range []struct {
+		pin    string
+		status bool
+	}{
+		{TLSPublicKeyPin(tlsCert.Leaf), true},
+		{"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", false},
+	} {
+		client := NewOWTPNode(NewRandomCertificate(), 0, 0)
+		_, err = client.Connect("server", ConnectConfig{
+			Address:       addr,
+			ConnectType:   HTTP,
+			EnableSSL:     true,
+			TLSPinnedKeys: []string{test.pin},
+		})
+		if err != nil {
+			t.Errorf("Connect unexpected error: %v", err)
+			client.Close()
+			return
+		}
+		resp, err := client.CallSync("server", "hello", nil)
+		if err == nil && resp.Status != StatusSuccess {
+			err = fmt.Errorf("response status: %d, msg: %s", resp.Status, resp.Msg)
+		}
+		t.Logf("pin %s call error: %v", test.pin, err)
+		if (err == nil) != test.status {
+			t.Errorf("pin %s call error: %v", test.pin, err)
+		}
+		client.Close()
+	}
+}
+
+func tlsConfigWithCertificate(c tls.Certificate) *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{c}}
+}
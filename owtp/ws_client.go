@@ -16,14 +16,13 @@
 package owtp
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/gorilla/websocket"
 	"github.com/mr-tron/base58/base58"
-	"github.com/tidwall/gjson"
 	"net"
 	"net/http"
 	"sync"
@@ -53,6 +52,7 @@ type WSClient struct {
 	closeOnce       sync.Once
 	done            func()
 	config          ConnectConfig //节点配置
+	negotiatedEncoding
 }
 
 // Dial connects a client to the given URL.
@@ -61,6 +61,16 @@ func Dial(
 	handler PeerHandler,
 	header map[string]string,
 	ReadBufferSize, WriteBufferSize int) (*WSClient, error) {
+	return dial(pid, url, handler, header, ReadBufferSize, WriteBufferSize, nil)
+}
+
+//dial 连接websocket服务，tlsConfig不为空时使用自定义的TLS配置
+func dial(
+	pid, url string,
+	handler PeerHandler,
+	header map[string]string,
+	ReadBufferSize, WriteBufferSize int,
+	tlsConfig *tls.Config) (*WSClient, error) {
 
 	var (
 		httpHeader http.Header
@@ -77,6 +87,7 @@ func Dial(
 		HandshakeTimeout: 60 * time.Second,
 		ReadBufferSize:   ReadBufferSize,
 		WriteBufferSize:  WriteBufferSize,
+		TLSClientConfig:  tlsConfig,
 	}
 
 	if header != nil {
@@ -86,7 +97,7 @@ func Dial(
 		}
 	}
 
-	ws, _, err := dialer.Dial(url, httpHeader)
+	ws, resp, err := dialer.Dial(url, httpHeader)
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +107,11 @@ func Dial(
 		return nil, err
 	}
 
+	//服务端返回相同的编码才算协商成功
+	if requested := header[EncodingHeader]; len(requested) > 0 && resp != nil && resp.Header.Get(EncodingHeader) == requested {
+		client.setEncoding(acceptEncoding(requested))
+	}
+
 	client.isConnect = true
 	client.isHost = true //我方主动连接
 	client.handler.OnPeerOpen(client)
@@ -158,6 +174,10 @@ func NewWSClientWithHeader(header http.Header, cert Certificate, conn *websocket
 	}
 
 	client, err := NewWSClient(auth.RemotePID(), conn, handler, auth, done)
+	if err != nil {
+		return nil, err
+	}
+	client.setEncoding(acceptEncoding(header.Get(EncodingHeader)))
 
 	return client, nil
 }
@@ -262,7 +282,7 @@ func (c *WSClient) RemoteAddr() net.Addr {
 func (c *WSClient) send(data DataPacket) error {
 
 	//log.Emergency("Send DataPacket:", data)
-	respBytes, err := json.Marshal(data)
+	respBytes, err := EncodeDataPacket(&data)
 	if err != nil {
 		return err
 	}
@@ -315,7 +335,12 @@ func (c *WSClient) writePump() {
 			if Debug {
 				log.Debug("Send: ", string(message))
 			}
-			if err := c.write(websocket.TextMessage, message); err != nil {
+			//cbor编码的数据包以二进制消息发送
+			messageType := websocket.TextMessage
+			if IsCBORDataPacket(message) {
+				messageType = websocket.BinaryMessage
+			}
+			if err := c.write(messageType, message); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -361,7 +386,11 @@ func (c *WSClient) readPump() {
 			log.Debug("Read: ", string(message))
 		}
 
-		packet := NewDataPacket(gjson.ParseBytes(message))
+		packet, err := DecodeDataPacket(message)
+		if err != nil {
+			log.Error("peer:", c.PID(), "decode data packet unexpected error: ", err)
+			continue
+		}
 
 		//开一个goroutine处理消息
 		go c.handler.OnPeerNewDataPacketReceived(c, packet)
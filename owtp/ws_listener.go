@@ -17,6 +17,7 @@ package owtp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/blocktree/openwallet/v2/log"
 	ws "github.com/gorilla/websocket"
@@ -44,6 +45,7 @@ type wsListener struct {
 	laddr           string
 	enableSignature bool
 	cert            Certificate
+	bindIdentity    bool //校验客户端TLS证书绑定的节点身份
 }
 
 //serve 监听服务
@@ -63,16 +65,35 @@ func (l *wsListener) serve() error {
 //ServeHTTP 实现HTTP服务监听
 func (l *wsListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	if l.bindIdentity {
+		if err := verifyRequestTLSIdentity(r); err != nil {
+			log.Error("verifyRequestTLSIdentity unexpected error:", err)
+			http.Error(w, "tls identity not passed", 401)
+			return
+		}
+	}
+
 	//创建一个上下文通知，监控节点是否已经关闭
 	ctx, cancel := context.WithCancel(context.Background())
 	httpCtx := r.Context()
 
-	c, err := upgrader.Upgrade(w, r, nil)
+	//支持发起方请求的编码时原样返回，完成协商
+	var responseHeader http.Header
+	if encoding := acceptEncoding(r.Header.Get(EncodingHeader)); encoding != EncodingJSON {
+		responseHeader = http.Header{EncodingHeader: []string{encoding}}
+	}
+
+	c, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		http.Error(w, "Failed to upgrade websocket", 400)
 		return
 	}
 
+	//超过数据包大小上限的消息会中断连接
+	if limit := packetSizeLimit(l.handler); limit > 0 {
+		c.SetReadLimit(limit)
+	}
+
 	peer, err := NewWSClientWithHeader(r.Header, l.cert, c, l.handler, l.enableSignature, cancel)
 	if err != nil {
 		log.Error("NewWSClientWithHeader unexpected error:", err)
@@ -123,10 +144,18 @@ func (l *wsListener) Accept() (Peer, error) {
 
 //WSListenAddr 创建websocket通信监听
 func WSListenAddr(addr string, cert Certificate, enableSignature bool, handler PeerHandler) (*wsListener, error) {
+	return wsListenAddr(addr, cert, enableSignature, handler, nil, false)
+}
+
+//wsListenAddr 创建websocket通信监听，tlsConfig不为空时监听wss
+func wsListenAddr(addr string, cert Certificate, enableSignature bool, handler PeerHandler, tlsConfig *tls.Config, bindIdentity bool) (*wsListener, error) {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
 	listener := wsListener{
 		Listener:        l,
 		laddr:           addr,
@@ -135,6 +164,7 @@ func WSListenAddr(addr string, cert Certificate, enableSignature bool, handler P
 		incoming:        make(chan Peer),
 		closed:          make(chan struct{}),
 		enableSignature: enableSignature,
+		bindIdentity:    bindIdentity && tlsConfig != nil,
 	}
 
 	go listener.serve()
@@ -0,0 +1,31 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+//Package pkcs11 实现openwallet.Signer，私钥保存在PKCS#11硬件安全模块中，不会离开设备。
+//
+// 需要cgo和pkcs11编译标签：go build -tags pkcs11
+//
+// 密钥按标签查找，标签为Config.KeyPrefix + HDPath，公钥和私钥对象使用相同的标签。
+// HSM中的密钥不支持BIP32衍生，每个HDPath对应一个独立生成的密钥对。签名器实现openwallet.KeyGenerator，
+// 通过openw.WalletManager.SetWalletSigner设置为钱包签名器后，使用CreateSignerAssetsAccount创建账户，
+// 账户和地址的密钥在设备中按HDPath生成，地址保存设备公钥，签名交易时按公钥匹配设备中的密钥。支持secp256k1和secp256r1曲线（CKM_ECDSA）。
+// 签名器实现openwallet.PasswordVerifier，签名交易时提供的钱包密码需与用户PIN一致。
+//
+// 使用SoftHSM测试：
+//
+//	softhsm2-util --init-token --free --label openwallet --pin 1234 --so-pin 1234
+//	PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_TOKEN_LABEL=openwallet PKCS11_PIN=1234 \
+//		go test -tags pkcs11 ./pkcs11/
+package pkcs11
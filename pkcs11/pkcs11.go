@@ -0,0 +1,717 @@
+//go:build pkcs11
+// +build pkcs11
+
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package pkcs11
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef unsigned long ck_ulong;
+typedef unsigned char ck_byte;
+
+typedef struct {
+	ck_byte major;
+	ck_byte minor;
+} ck_version;
+
+typedef struct {
+	ck_ulong type;
+	void *value;
+	ck_ulong value_len;
+} ck_attribute;
+
+typedef struct {
+	ck_ulong mechanism;
+	void *parameter;
+	ck_ulong parameter_len;
+} ck_mechanism;
+
+typedef struct {
+	ck_byte label[32];
+	ck_byte manufacturer_id[32];
+	ck_byte model[16];
+	ck_byte serial_number[16];
+	ck_ulong flags;
+	ck_ulong max_session_count;
+	ck_ulong session_count;
+	ck_ulong max_rw_session_count;
+	ck_ulong rw_session_count;
+	ck_ulong max_pin_len;
+	ck_ulong min_pin_len;
+	ck_ulong total_public_memory;
+	ck_ulong free_public_memory;
+	ck_ulong total_private_memory;
+	ck_ulong free_private_memory;
+	ck_version hardware_version;
+	ck_version firmware_version;
+	ck_byte utc_time[16];
+} ck_token_info;
+
+typedef struct {
+	void *create_mutex;
+	void *destroy_mutex;
+	void *lock_mutex;
+	void *unlock_mutex;
+	ck_ulong flags;
+	void *reserved;
+} ck_c_initialize_args;
+
+// PKCS#11 v2.40函数列表，只声明用到的函数类型，其余按位置占位
+typedef struct {
+	ck_version version;
+	ck_ulong (*C_Initialize)(void *);
+	ck_ulong (*C_Finalize)(void *);
+	void *C_GetInfo;
+	void *C_GetFunctionList;
+	ck_ulong (*C_GetSlotList)(ck_byte, ck_ulong *, ck_ulong *);
+	void *C_GetSlotInfo;
+	ck_ulong (*C_GetTokenInfo)(ck_ulong, ck_token_info *);
+	void *C_GetMechanismList;
+	void *C_GetMechanismInfo;
+	void *C_InitToken;
+	void *C_InitPIN;
+	void *C_SetPIN;
+	ck_ulong (*C_OpenSession)(ck_ulong, ck_ulong, void *, void *, ck_ulong *);
+	ck_ulong (*C_CloseSession)(ck_ulong);
+	void *C_CloseAllSessions;
+	void *C_GetSessionInfo;
+	void *C_GetOperationState;
+	void *C_SetOperationState;
+	ck_ulong (*C_Login)(ck_ulong, ck_ulong, ck_byte *, ck_ulong);
+	ck_ulong (*C_Logout)(ck_ulong);
+	void *C_CreateObject;
+	void *C_CopyObject;
+	ck_ulong (*C_DestroyObject)(ck_ulong, ck_ulong);
+	void *C_GetObjectSize;
+	ck_ulong (*C_GetAttributeValue)(ck_ulong, ck_ulong, ck_attribute *, ck_ulong);
+	void *C_SetAttributeValue;
+	ck_ulong (*C_FindObjectsInit)(ck_ulong, ck_attribute *, ck_ulong);
+	ck_ulong (*C_FindObjects)(ck_ulong, ck_ulong *, ck_ulong, ck_ulong *);
+	ck_ulong (*C_FindObjectsFinal)(ck_ulong);
+	void *C_EncryptInit;
+	void *C_Encrypt;
+	void *C_EncryptUpdate;
+	void *C_EncryptFinal;
+	void *C_DecryptInit;
+	void *C_Decrypt;
+	void *C_DecryptUpdate;
+	void *C_DecryptFinal;
+	void *C_DigestInit;
+	void *C_Digest;
+	void *C_DigestUpdate;
+	void *C_DigestKey;
+	void *C_DigestFinal;
+	ck_ulong (*C_SignInit)(ck_ulong, ck_mechanism *, ck_ulong);
+	ck_ulong (*C_Sign)(ck_ulong, ck_byte *, ck_ulong, ck_byte *, ck_ulong *);
+	void *C_SignUpdate;
+	void *C_SignFinal;
+	void *C_SignRecoverInit;
+	void *C_SignRecover;
+	void *C_VerifyInit;
+	void *C_Verify;
+	void *C_VerifyUpdate;
+	void *C_VerifyFinal;
+	void *C_VerifyRecoverInit;
+	void *C_VerifyRecover;
+	void *C_DigestEncryptUpdate;
+	void *C_DecryptDigestUpdate;
+	void *C_SignEncryptUpdate;
+	void *C_DecryptVerifyUpdate;
+	void *C_GenerateKey;
+	ck_ulong (*C_GenerateKeyPair)(ck_ulong, ck_mechanism *, ck_attribute *, ck_ulong, ck_attribute *, ck_ulong, ck_ulong *, ck_ulong *);
+} ck_function_list;
+
+typedef ck_ulong (*ck_get_function_list)(ck_function_list **);
+
+static void *p11_load(const char *path, ck_function_list **list) {
+	void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (handle == NULL) {
+		return NULL;
+	}
+	ck_get_function_list get_function_list = (ck_get_function_list)dlsym(handle, "C_GetFunctionList");
+	if (get_function_list == NULL || get_function_list(list) != 0 || *list == NULL) {
+		dlclose(handle);
+		return NULL;
+	}
+	return handle;
+}
+
+static void p11_unload(void *handle) {
+	dlclose(handle);
+}
+
+static ck_ulong p11_initialize(ck_function_list *f, char *reserved) {
+	ck_c_initialize_args args;
+	memset(&args, 0, sizeof(args));
+	args.flags = 0x2; // CKF_OS_LOCKING_OK
+	args.reserved = reserved;
+	return f->C_Initialize(&args);
+}
+
+static ck_ulong p11_finalize(ck_function_list *f) {
+	return f->C_Finalize(NULL);
+}
+
+static ck_ulong p11_get_slot_list(ck_function_list *f, ck_ulong *slots, ck_ulong *count) {
+	return f->C_GetSlotList(1, slots, count);
+}
+
+static ck_ulong p11_get_token_label(ck_function_list *f, ck_ulong slot, ck_byte *label) {
+	ck_token_info info;
+	ck_ulong rv = f->C_GetTokenInfo(slot, &info);
+	if (rv == 0) {
+		memcpy(label, info.label, sizeof(info.label));
+	}
+	return rv;
+}
+
+static ck_ulong p11_open_session(ck_function_list *f, ck_ulong slot, ck_ulong *session) {
+	return f->C_OpenSession(slot, 0x4 | 0x2, NULL, NULL, session); // CKF_SERIAL_SESSION | CKF_RW_SESSION
+}
+
+static ck_ulong p11_close_session(ck_function_list *f, ck_ulong session) {
+	return f->C_CloseSession(session);
+}
+
+static ck_ulong p11_login(ck_function_list *f, ck_ulong session, ck_byte *pin, ck_ulong pin_len) {
+	return f->C_Login(session, 1, pin, pin_len); // CKU_USER
+}
+
+static ck_ulong p11_logout(ck_function_list *f, ck_ulong session) {
+	return f->C_Logout(session);
+}
+
+static ck_ulong p11_find_objects(ck_function_list *f, ck_ulong session, ck_attribute *tmpl, ck_ulong count,
+	ck_ulong *objects, ck_ulong max, ck_ulong *found) {
+	ck_ulong rv = f->C_FindObjectsInit(session, tmpl, count);
+	if (rv != 0) {
+		return rv;
+	}
+	rv = f->C_FindObjects(session, objects, max, found);
+	f->C_FindObjectsFinal(session);
+	return rv;
+}
+
+static ck_ulong p11_get_attribute(ck_function_list *f, ck_ulong session, ck_ulong object, ck_attribute *attr) {
+	return f->C_GetAttributeValue(session, object, attr, 1);
+}
+
+static ck_ulong p11_destroy_object(ck_function_list *f, ck_ulong session, ck_ulong object) {
+	return f->C_DestroyObject(session, object);
+}
+
+static ck_ulong p11_sign(ck_function_list *f, ck_ulong session, ck_ulong key, ck_ulong mechanism,
+	ck_byte *msg, ck_ulong msg_len, ck_byte *sig, ck_ulong *sig_len) {
+	ck_mechanism mech = {mechanism, NULL, 0};
+	ck_ulong rv = f->C_SignInit(session, &mech, key);
+	if (rv != 0) {
+		return rv;
+	}
+	return f->C_Sign(session, msg, msg_len, sig, sig_len);
+}
+
+static ck_ulong p11_generate_key_pair(ck_function_list *f, ck_ulong session, ck_ulong mechanism,
+	ck_attribute *pub, ck_ulong pub_count, ck_attribute *priv, ck_ulong priv_count,
+	ck_ulong *pub_key, ck_ulong *priv_key) {
+	ck_mechanism mech = {mechanism, NULL, 0};
+	return f->C_GenerateKeyPair(session, &mech, pub, pub_count, priv, priv_count, pub_key, priv_key);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/crypto"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//PKCS#11常量
+const (
+	ckoPublicKey  = 0x2
+	ckoPrivateKey = 0x3
+
+	ckkEC = 0x3
+
+	ckaClass       = 0x0
+	ckaToken       = 0x1
+	ckaPrivate     = 0x2
+	ckaLabel       = 0x3
+	ckaKeyType     = 0x100
+	ckaID          = 0x102
+	ckaSensitive   = 0x103
+	ckaSign        = 0x108
+	ckaVerify      = 0x10a
+	ckaExtractable = 0x162
+	ckaECParams    = 0x180
+	ckaECPoint     = 0x181
+
+	ckmECKeyPairGen = 0x1040
+	ckmECDSA        = 0x1041
+
+	ckrOK                         = 0x0
+	ckrUserAlreadyLoggedIn        = 0x100
+	ckrCryptokiAlreadyInitialized = 0x191
+)
+
+var (
+	//曲线OID的DER编码，用于CKA_EC_PARAMS
+	secp256k1Params = []byte{0x06, 0x05, 0x2b, 0x81, 0x04, 0x00, 0x0a}
+	secp256r1Params = []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+)
+
+//Config PKCS#11签名器配置
+type Config struct {
+	Module     string //PKCS#11模块动态库路径，例如：/usr/lib/softhsm/libsofthsm2.so
+	TokenLabel string //令牌标签，为空使用第一个令牌
+	PIN        string //用户PIN
+	KeyPrefix  string //密钥标签前缀，用于区分不同钱包的密钥
+	InitParams string //C_Initialize的pReserved参数，部分模块（例如NSS）需要
+}
+
+//token 已登录的令牌会话，由同一模块的签名器共享
+type token struct {
+	mu          sync.Mutex
+	handle      unsafe.Pointer
+	funcs       *C.ck_function_list
+	session     C.ck_ulong
+	initialized bool
+	closed      bool
+}
+
+//Signer PKCS#11签名器，实现openwallet.Signer和openwallet.PasswordVerifier
+type Signer struct {
+	token     *token
+	keyPrefix string
+	pinHash   [32]byte //用户PIN的哈希，用于验证签名交易时提供的钱包密码
+}
+
+//New 加载PKCS#11模块，打开令牌会话并登录
+func New(config Config) (*Signer, error) {
+
+	path := C.CString(config.Module)
+	defer C.free(unsafe.Pointer(path))
+
+	t := &token{}
+	t.handle = C.p11_load(path, &t.funcs)
+	if t.handle == nil {
+		return nil, fmt.Errorf("load pkcs11 module: %s failed", config.Module)
+	}
+
+	var reserved *C.char
+	if len(config.InitParams) > 0 {
+		reserved = C.CString(config.InitParams)
+		defer C.free(unsafe.Pointer(reserved))
+	}
+
+	rv := C.p11_initialize(t.funcs, reserved)
+	switch rv {
+	case ckrOK:
+		t.initialized = true
+	case ckrCryptokiAlreadyInitialized:
+		//模块已被其他组件初始化，关闭时不调用C_Finalize
+	default:
+		C.p11_unload(t.handle)
+		return nil, rvError("C_Initialize", rv)
+	}
+
+	err := t.open(config.TokenLabel, config.PIN)
+	if err != nil {
+		t.close()
+		return nil, err
+	}
+
+	return &Signer{token: t, keyPrefix: config.KeyPrefix, pinHash: sha256.Sum256([]byte(config.PIN))}, nil
+}
+
+//WithKeyPrefix 使用相同的令牌会话创建另一个密钥标签前缀的签名器，用于为每个钱包选择独立的密钥
+func (s *Signer) WithKeyPrefix(keyPrefix string) *Signer {
+	return &Signer{token: s.token, keyPrefix: keyPrefix, pinHash: s.pinHash}
+}
+
+//VerifyPassword 签名交易时钱包密码为令牌的用户PIN
+func (s *Signer) VerifyPassword(password string) error {
+	h := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare(h[:], s.pinHash[:]) != 1 {
+		return fmt.Errorf("wallet password is incorrect")
+	}
+	return nil
+}
+
+//Close 登出并关闭令牌会话，共享该会话的签名器都不可再使用
+func (s *Signer) Close() error {
+	return s.token.close()
+}
+
+//GenerateKey 在令牌中生成HDPath对应的不可导出密钥对，返回压缩格式公钥
+func (s *Signer) GenerateKey(hdPath string, eccType uint32) ([]byte, error) {
+
+	params, err := curveParams(eccType)
+	if err != nil {
+		return nil, err
+	}
+
+	label := s.label(hdPath)
+
+	s.token.mu.Lock()
+	defer s.token.mu.Unlock()
+
+	if _, err := s.token.findKey(ckoPublicKey, label); err == nil {
+		return nil, fmt.Errorf("key: %s already exists", label)
+	}
+
+	id := crypto.SHA256([]byte(label))[:20]
+	pubTemplate := newTemplate([]attribute{
+		{ckaClass, ulongValue(ckoPublicKey)},
+		{ckaKeyType, ulongValue(ckkEC)},
+		{ckaToken, boolValue(true)},
+		{ckaVerify, boolValue(true)},
+		{ckaLabel, []byte(label)},
+		{ckaID, id},
+		{ckaECParams, params},
+	})
+	defer pubTemplate.free()
+
+	privTemplate := newTemplate([]attribute{
+		{ckaClass, ulongValue(ckoPrivateKey)},
+		{ckaKeyType, ulongValue(ckkEC)},
+		{ckaToken, boolValue(true)},
+		{ckaPrivate, boolValue(true)},
+		{ckaSensitive, boolValue(true)},
+		{ckaExtractable, boolValue(false)},
+		{ckaSign, boolValue(true)},
+		{ckaLabel, []byte(label)},
+		{ckaID, id},
+	})
+	defer privTemplate.free()
+
+	var pubKey, privKey C.ck_ulong
+	rv := C.p11_generate_key_pair(s.token.funcs, s.token.session, ckmECKeyPairGen,
+		pubTemplate.attrs, pubTemplate.count, privTemplate.attrs, privTemplate.count, &pubKey, &privKey)
+	if rv != ckrOK {
+		return nil, rvError("C_GenerateKeyPair", rv)
+	}
+
+	return s.token.publicKey(pubKey, eccType)
+}
+
+//DeleteKey 删除令牌中HDPath对应的密钥对
+func (s *Signer) DeleteKey(hdPath string) error {
+
+	label := s.label(hdPath)
+
+	s.token.mu.Lock()
+	defer s.token.mu.Unlock()
+
+	for _, class := range []C.ck_ulong{ckoPrivateKey, ckoPublicKey} {
+		object, err := s.token.findKey(class, label)
+		if err != nil {
+			continue
+		}
+		rv := C.p11_destroy_object(s.token.funcs, s.token.session, object)
+		if rv != ckrOK {
+			return rvError("C_DestroyObject", rv)
+		}
+	}
+	return nil
+}
+
+//PublicKey 获取HDPath对应密钥的压缩格式公钥
+func (s *Signer) PublicKey(hdPath string, eccType uint32) ([]byte, error) {
+
+	if _, err := curveParams(eccType); err != nil {
+		return nil, err
+	}
+
+	s.token.mu.Lock()
+	defer s.token.mu.Unlock()
+
+	object, err := s.token.findKey(ckoPublicKey, s.label(hdPath))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.token.publicKey(object, eccType)
+}
+
+//Sign 使用令牌中HDPath对应的私钥签名消息哈希，返回低S值的64字节签名和恢复标识v
+func (s *Signer) Sign(hdPath string, eccType uint32, msg []byte) ([]byte, byte, error) {
+
+	publicKey, err := s.PublicKey(hdPath, eccType)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(msg) == 0 {
+		return nil, 0, fmt.Errorf("message is empty")
+	}
+
+	s.token.mu.Lock()
+	object, err := s.token.findKey(ckoPrivateKey, s.label(hdPath))
+	if err != nil {
+		s.token.mu.Unlock()
+		return nil, 0, err
+	}
+
+	signature := make([]byte, 128)
+	signatureLen := C.ck_ulong(len(signature))
+	rv := C.p11_sign(s.token.funcs, s.token.session, object, ckmECDSA,
+		(*C.ck_byte)(unsafe.Pointer(&msg[0])), C.ck_ulong(len(msg)),
+		(*C.ck_byte)(unsafe.Pointer(&signature[0])), &signatureLen)
+	s.token.mu.Unlock()
+	if rv != ckrOK {
+		return nil, 0, rvError("C_Sign", rv)
+	}
+
+	return openwallet.NormalizeSignature(publicKey, msg, signature[:signatureLen], eccType)
+}
+
+//label 密钥在令牌中的标签
+func (s *Signer) label(hdPath string) string {
+	return s.keyPrefix + hdPath
+}
+
+//open 查找令牌，打开会话并登录
+func (t *token) open(tokenLabel, pin string) error {
+
+	var count C.ck_ulong
+	rv := C.p11_get_slot_list(t.funcs, nil, &count)
+	if rv != ckrOK {
+		return rvError("C_GetSlotList", rv)
+	}
+	if count == 0 {
+		return fmt.Errorf("no pkcs11 token is present")
+	}
+
+	slots := make([]C.ck_ulong, count)
+	rv = C.p11_get_slot_list(t.funcs, &slots[0], &count)
+	if rv != ckrOK {
+		return rvError("C_GetSlotList", rv)
+	}
+
+	found := false
+	var slot C.ck_ulong
+	for _, id := range slots[:count] {
+		label := make([]byte, 32)
+		rv = C.p11_get_token_label(t.funcs, id, (*C.ck_byte)(unsafe.Pointer(&label[0])))
+		if rv != ckrOK {
+			continue
+		}
+		if len(tokenLabel) == 0 || strings.TrimRight(string(label), " \x00") == tokenLabel {
+			slot = id
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("pkcs11 token: %s not found", tokenLabel)
+	}
+
+	rv = C.p11_open_session(t.funcs, slot, &t.session)
+	if rv != ckrOK {
+		return rvError("C_OpenSession", rv)
+	}
+
+	pinBytes := C.CBytes([]byte(pin))
+	defer C.free(pinBytes)
+	rv = C.p11_login(t.funcs, t.session, (*C.ck_byte)(pinBytes), C.ck_ulong(len(pin)))
+	if rv != ckrOK && rv != ckrUserAlreadyLoggedIn {
+		C.p11_close_session(t.funcs, t.session)
+		t.session = 0
+		return rvError("C_Login", rv)
+	}
+
+	return nil
+}
+
+//close 关闭会话，释放模块
+func (t *token) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	if t.session != 0 {
+		C.p11_logout(t.funcs, t.session)
+		C.p11_close_session(t.funcs, t.session)
+	}
+	if t.initialized {
+		C.p11_finalize(t.funcs)
+	}
+	C.p11_unload(t.handle)
+	return nil
+}
+
+//findKey 按类型和标签查找EC密钥对象
+func (t *token) findKey(class C.ck_ulong, label string) (C.ck_ulong, error) {
+
+	if t.closed {
+		return 0, fmt.Errorf("pkcs11 signer is closed")
+	}
+
+	tmpl := newTemplate([]attribute{
+		{ckaClass, ulongValue(class)},
+		{ckaKeyType, ulongValue(ckkEC)},
+		{ckaLabel, []byte(label)},
+	})
+	defer tmpl.free()
+
+	var object, found C.ck_ulong
+	rv := C.p11_find_objects(t.funcs, t.session, tmpl.attrs, tmpl.count, &object, 1, &found)
+	if rv != ckrOK {
+		return 0, rvError("C_FindObjects", rv)
+	}
+	if found == 0 {
+		return 0, fmt.Errorf("key: %s not found", label)
+	}
+	return object, nil
+}
+
+//publicKey 读取公钥对象的EC点，检查曲线后返回压缩格式公钥
+func (t *token) publicKey(object C.ck_ulong, eccType uint32) ([]byte, error) {
+
+	params, err := t.attribute(object, ckaECParams)
+	if err != nil {
+		return nil, err
+	}
+	expected, _ := curveParams(eccType)
+	if !bytes.Equal(params, expected) {
+		return nil, fmt.Errorf("key curve does not match the ecc type")
+	}
+
+	point, err := t.attribute(object, ckaECPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	//CKA_EC_POINT为DER编码的OCTET STRING，部分模块直接返回未压缩点
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err == nil && len(rest) == 0 {
+		point = raw
+	}
+	if len(point) != 65 || point[0] != 0x04 {
+		return nil, fmt.Errorf("ec point is invalid")
+	}
+
+	return owcrypt.PointCompress(point, eccType), nil
+}
+
+//attribute 读取对象的属性值
+func (t *token) attribute(object C.ck_ulong, typ C.ck_ulong) ([]byte, error) {
+
+	tmpl := newTemplate([]attribute{{typ, nil}})
+	defer tmpl.free()
+
+	rv := C.p11_get_attribute(t.funcs, t.session, object, tmpl.attrs)
+	if rv != ckrOK {
+		return nil, rvError("C_GetAttributeValue", rv)
+	}
+
+	size := tmpl.attrs.value_len
+	if size == 0 {
+		return nil, nil
+	}
+	//由模板释放
+	value := C.malloc(C.size_t(size))
+	tmpl.attrs.value = value
+
+	rv = C.p11_get_attribute(t.funcs, t.session, object, tmpl.attrs)
+	if rv != ckrOK {
+		return nil, rvError("C_GetAttributeValue", rv)
+	}
+
+	return C.GoBytes(value, C.int(tmpl.attrs.value_len)), nil
+}
+
+//attribute 属性类型和值
+type attribute struct {
+	typ   C.ck_ulong
+	value []byte
+}
+
+//template 分配在C内存中的属性模板，使用后需要释放
+type template struct {
+	attrs *C.ck_attribute
+	count C.ck_ulong
+}
+
+func newTemplate(attrs []attribute) *template {
+	t := &template{count: C.ck_ulong(len(attrs))}
+	t.attrs = (*C.ck_attribute)(C.calloc(C.size_t(len(attrs)), C.sizeof_ck_attribute))
+	list := (*[1 << 16]C.ck_attribute)(unsafe.Pointer(t.attrs))[:len(attrs):len(attrs)]
+	for i, a := range attrs {
+		list[i]._type = a.typ
+		if len(a.value) > 0 {
+			list[i].value = C.CBytes(a.value)
+			list[i].value_len = C.ck_ulong(len(a.value))
+		}
+	}
+	return t
+}
+
+func (t *template) free() {
+	list := (*[1 << 16]C.ck_attribute)(unsafe.Pointer(t.attrs))[:t.count:t.count]
+	for i := range list {
+		if list[i].value != nil {
+			C.free(list[i].value)
+		}
+	}
+	C.free(unsafe.Pointer(t.attrs))
+}
+
+func ulongValue(v C.ck_ulong) []byte {
+	value := make([]byte, C.sizeof_ck_ulong)
+	*(*C.ck_ulong)(unsafe.Pointer(&value[0])) = v
+	return value
+}
+
+func boolValue(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+//curveParams 曲线类型对应的CKA_EC_PARAMS
+func curveParams(eccType uint32) ([]byte, error) {
+	switch eccType {
+	case owcrypt.ECC_CURVE_SECP256K1:
+		return secp256k1Params, nil
+	case owcrypt.ECC_CURVE_SECP256R1:
+		return secp256r1Params, nil
+	}
+	return nil, fmt.Errorf("ecc type: %d is not supported by pkcs11 signer", eccType)
+}
+
+func rvError(function string, rv C.ck_ulong) error {
+	return fmt.Errorf("%s failed, unexpected error: CKR 0x%X", function, uint64(rv))
+}
@@ -0,0 +1,123 @@
+//go:build pkcs11
+// +build pkcs11
+
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package pkcs11
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/crypto"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//testSigner 通过环境变量配置测试令牌，没有配置时跳过测试
+func testSigner(t *testing.T) *Signer {
+	module := os.Getenv("PKCS11_MODULE")
+	if len(module) == 0 {
+		t.Skip("PKCS11_MODULE is not set")
+	}
+
+	signer, err := New(Config{
+		Module:     module,
+		TokenLabel: os.Getenv("PKCS11_TOKEN_LABEL"),
+		PIN:        os.Getenv("PKCS11_PIN"),
+		KeyPrefix:  fmt.Sprintf("openwallet-test-%d:", time.Now().UnixNano()),
+		InitParams: os.Getenv("PKCS11_INIT_PARAMS"),
+	})
+	if err != nil {
+		t.Fatalf("New failed unexpected error: %v", err)
+	}
+	return signer
+}
+
+func TestSigner_Sign(t *testing.T) {
+
+	signer := testSigner(t)
+	defer signer.Close()
+
+	hdPath := "m/44'/88'/1'/0/0"
+	msg := crypto.SHA256([]byte("pkcs11 signer message"))
+
+	for _, eccType := range []uint32{owcrypt.ECC_CURVE_SECP256R1, owcrypt.ECC_CURVE_SECP256K1} {
+
+		publicKey, err := signer.GenerateKey(hdPath, eccType)
+		if err != nil {
+			if eccType == owcrypt.ECC_CURVE_SECP256K1 {
+				t.Logf("token does not support secp256k1: %v", err)
+				continue
+			}
+			t.Fatalf("GenerateKey failed unexpected error: %v", err)
+		}
+
+		if _, err := signer.GenerateKey(hdPath, eccType); err == nil {
+			t.Errorf("existing key should not be generated again")
+		}
+
+		pub, err := signer.PublicKey(hdPath, eccType)
+		if err != nil || hex.EncodeToString(pub) != hex.EncodeToString(publicKey) {
+			t.Errorf("PublicKey = %x, want %x, err = %v", pub, publicKey, err)
+		}
+
+		signatures := map[string][]*openwallet.KeySignature{
+			"owner": {
+				{
+					EccType: eccType,
+					Address: &openwallet.Address{Address: "addr", HDPath: hdPath, PublicKey: hex.EncodeToString(publicKey)},
+					Message: hex.EncodeToString(msg),
+					RSV:     true,
+				},
+			},
+		}
+
+		signed, err := openwallet.SignKeySignaturesWithSigner(signer, signatures)
+		if err != nil || signed != 1 {
+			t.Fatalf("SignKeySignaturesWithSigner = %d, err = %v", signed, err)
+		}
+
+		signature, _ := hex.DecodeString(signatures["owner"][0].Signature)
+		uncompressed := owcrypt.PointDecompress(publicKey, eccType)
+		if owcrypt.Verify(uncompressed[1:], nil, msg, signature[:64], eccType) != owcrypt.SUCCESS {
+			t.Errorf("signature verify failed")
+		}
+		recovered, ret := owcrypt.RecoverPubkey(signature, msg, eccType)
+		if ret != owcrypt.SUCCESS || hex.EncodeToString(recovered) != hex.EncodeToString(uncompressed[1:]) {
+			t.Errorf("recovered public key = %x, want %x", recovered, uncompressed[1:])
+		}
+
+		//其他钱包的前缀找不到密钥
+		if _, err := signer.WithKeyPrefix("other:").PublicKey(hdPath, eccType); err == nil {
+			t.Errorf("key of other prefix should not be found")
+		}
+
+		if err := signer.DeleteKey(hdPath); err != nil {
+			t.Errorf("DeleteKey failed unexpected error: %v", err)
+		}
+		if _, _, err := signer.Sign(hdPath, eccType, msg); err == nil {
+			t.Errorf("deleted key should not sign")
+		}
+	}
+
+	if _, err := signer.GenerateKey(hdPath, owcrypt.ECC_CURVE_ED25519); err == nil {
+		t.Errorf("ed25519 should not be supported")
+	}
+}
@@ -20,8 +20,8 @@
 //
 // Usage:
 // import(
-//   _ "github.com/astaxie/beego/session/redis"
-//   "github.com/astaxie/beego/session"
+//   _ "github.com/blocktree/openwallet/v2/session/redis"
+//   "github.com/blocktree/openwallet/v2/session"
 // )
 //
 // 	func init() {
@@ -39,7 +39,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/astaxie/beego/session"
+	"github.com/blocktree/openwallet/v2/session"
 
 	"github.com/gomodule/redigo/redis"
 )
@@ -0,0 +1,217 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+const (
+	//默认缓存1000个区块
+	defaultBlockCacheSize = 1000
+)
+
+var (
+	_ openwallet.BlockchainDAI        = (*BlockchainStore)(nil)
+	_ openwallet.BlockchainConfirmDAI = (*BlockchainStore)(nil)
+)
+
+//BlockchainStore 区块链数据访问接口的关系型数据库实现
+type BlockchainStore struct {
+	db             *DB
+	mu             sync.RWMutex
+	blockCacheSize map[string]uint64 //各币种区块缓存数量
+}
+
+//NewBlockchainStore 创建区块链数据访问接口
+func NewBlockchainStore(db *DB) *BlockchainStore {
+	return &BlockchainStore{
+		db:             db,
+		blockCacheSize: make(map[string]uint64),
+	}
+}
+
+func (base *BlockchainStore) SaveCurrentBlockHead(header *openwallet.BlockHeader) error {
+	if header == nil {
+		return fmt.Errorf("the block header to save is nil")
+	}
+	data, err := encode(header)
+	if err != nil {
+		return err
+	}
+	return base.db.save(base.db, &record{
+		table:   "ow_block_head",
+		keys:    []string{"symbol"},
+		columns: []string{"symbol", "height", "hash", "data"},
+		values:  []interface{}{header.Symbol, header.Height, header.Hash, data},
+	})
+}
+
+func (base *BlockchainStore) GetCurrentBlockHead(symbol string) (*openwallet.BlockHeader, error) {
+	var header openwallet.BlockHeader
+	err := base.db.one(base.db, "ow_block_head", &header, "symbol = ?", symbol)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return &header, nil
+}
+
+func (base *BlockchainStore) SaveLocalBlockHead(header *openwallet.BlockHeader) error {
+	if header == nil {
+		return fmt.Errorf("the block header to save is nil")
+	}
+	data, err := encode(header)
+	if err != nil {
+		return err
+	}
+
+	cacheSize := base.getMaxBlockCache(header.Symbol)
+
+	return base.db.inTx(func(tx *sql.Tx) error {
+		err := base.db.save(tx, &record{
+			table:   "ow_block_cache",
+			keys:    []string{"symbol", "height"},
+			columns: []string{"symbol", "height", "hash", "data"},
+			values:  []interface{}{header.Symbol, header.Height, header.Hash, data},
+		})
+		if err != nil {
+			return err
+		}
+
+		//移除超出缓存数量的旧区块
+		if header.Height > cacheSize {
+			_, err = tx.Exec(base.db.rebind("DELETE FROM ow_block_cache WHERE symbol = ? AND height <= ?"),
+				header.Symbol, header.Height-cacheSize)
+		}
+		return err
+	})
+}
+
+func (base *BlockchainStore) GetLocalBlockHeadByHeight(height uint64, symbol string) (*openwallet.BlockHeader, error) {
+	var header openwallet.BlockHeader
+	err := base.db.one(base.db, "ow_block_cache", &header, "symbol = ? AND height = ?", symbol, height)
+	if err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+func (base *BlockchainStore) SaveUnscanRecord(unscan *openwallet.UnscanRecord) error {
+	if unscan == nil {
+		return fmt.Errorf("the unscan record to save is nil")
+	}
+	data, err := encode(unscan)
+	if err != nil {
+		return err
+	}
+	return base.db.save(base.db, &record{
+		table:   "ow_unscan_record",
+		keys:    []string{"id"},
+		columns: []string{"id", "symbol", "block_height", "txid", "data"},
+		values:  []interface{}{unscan.ID, unscan.Symbol, unscan.BlockHeight, unscan.TxID, data},
+	})
+}
+
+func (base *BlockchainStore) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	_, err := base.db.Exec(base.db.rebind("DELETE FROM ow_unscan_record WHERE symbol = ? AND block_height = ?"), symbol, height)
+	return err
+}
+
+func (base *BlockchainStore) DeleteUnscanRecordByID(id string, symbol string) error {
+	_, err := base.db.Exec(base.db.rebind("DELETE FROM ow_unscan_record WHERE id = ?"), id)
+	return err
+}
+
+func (base *BlockchainStore) GetTransactionsByTxID(txid, symbol string) ([]*openwallet.Transaction, error) {
+	var list []*openwallet.Transaction
+	qs := &query{}
+	qs.eq("txid", txid)
+	qs.eq("symbol", symbol)
+	err := base.db.find("ow_transaction", qs, 0, -1, &list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (base *BlockchainStore) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	var list []*openwallet.UnscanRecord
+	qs := &query{}
+	if len(symbol) > 0 {
+		qs.eq("symbol", symbol)
+	}
+	err := base.db.find("ow_unscan_record", qs, 0, -1, &list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (base *BlockchainStore) SaveConfirmWatch(watch *openwallet.ConfirmWatchRecord) error {
+	if watch == nil {
+		return fmt.Errorf("the confirm watch record to save is nil")
+	}
+	data, err := encode(watch)
+	if err != nil {
+		return err
+	}
+	return base.db.save(base.db, &record{
+		table:   "ow_confirm_watch",
+		keys:    []string{"id"},
+		columns: []string{"id", "symbol", "data"},
+		values:  []interface{}{watch.ID, watch.Symbol, data},
+	})
+}
+
+func (base *BlockchainStore) DeleteConfirmWatch(id, symbol string) error {
+	_, err := base.db.Exec(base.db.rebind("DELETE FROM ow_confirm_watch WHERE id = ?"), id)
+	return err
+}
+
+func (base *BlockchainStore) GetConfirmWatches(symbol string) ([]*openwallet.ConfirmWatchRecord, error) {
+	var list []*openwallet.ConfirmWatchRecord
+	qs := &query{}
+	qs.eq("symbol", symbol)
+	err := base.db.find("ow_confirm_watch", qs, 0, -1, &list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (base *BlockchainStore) SetMaxBlockCache(size uint64, symbol string) error {
+	base.mu.Lock()
+	defer base.mu.Unlock()
+	base.blockCacheSize[symbol] = size
+	return nil
+}
+
+//getMaxBlockCache 获取币种的区块缓存数量，未设置使用默认值
+func (base *BlockchainStore) getMaxBlockCache(symbol string) uint64 {
+	base.mu.RLock()
+	defer base.mu.RUnlock()
+	if size, ok := base.blockCacheSize[symbol]; ok && size > 0 {
+		return size
+	}
+	if size, ok := base.blockCacheSize[""]; ok && size > 0 {
+		return size
+	}
+	return defaultBlockCacheSize
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+)
+
+//migration 数据库结构版本
+type migration struct {
+	version    int64
+	statements []string
+}
+
+//migrations 按版本顺序升级的数据库结构，已发布的版本不可修改，只能追加新版本
+var migrations = []migration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE ow_block_head (
+				symbol VARCHAR(64) NOT NULL,
+				height BIGINT NOT NULL,
+				hash VARCHAR(255) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (symbol)
+			)`,
+			`CREATE TABLE ow_block_cache (
+				symbol VARCHAR(64) NOT NULL,
+				height BIGINT NOT NULL,
+				hash VARCHAR(255) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (symbol, height)
+			)`,
+			`CREATE TABLE ow_unscan_record (
+				id VARCHAR(255) NOT NULL,
+				symbol VARCHAR(64) NOT NULL,
+				block_height BIGINT NOT NULL,
+				txid VARCHAR(255) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (id)
+			)`,
+			`CREATE INDEX idx_ow_unscan_record_height ON ow_unscan_record (symbol, block_height)`,
+			`CREATE TABLE ow_wallet (
+				app_id VARCHAR(255) NOT NULL,
+				wallet_id VARCHAR(255) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (app_id, wallet_id)
+			)`,
+			`CREATE TABLE ow_assets_account (
+				app_id VARCHAR(255) NOT NULL,
+				account_id VARCHAR(255) NOT NULL,
+				wallet_id VARCHAR(255) NOT NULL,
+				symbol VARCHAR(64) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (app_id, account_id)
+			)`,
+			`CREATE TABLE ow_address (
+				app_id VARCHAR(255) NOT NULL,
+				address VARCHAR(255) NOT NULL,
+				account_id VARCHAR(255) NOT NULL,
+				symbol VARCHAR(64) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (app_id, address)
+			)`,
+			`CREATE INDEX idx_ow_address_account ON ow_address (app_id, account_id)`,
+			`CREATE TABLE ow_transaction (
+				app_id VARCHAR(255) NOT NULL,
+				wxid VARCHAR(255) NOT NULL,
+				txid VARCHAR(255) NOT NULL,
+				account_id VARCHAR(255) NOT NULL,
+				symbol VARCHAR(64) NOT NULL,
+				block_height BIGINT NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (app_id, wxid)
+			)`,
+			`CREATE INDEX idx_ow_transaction_txid ON ow_transaction (app_id, txid)`,
+			`CREATE INDEX idx_ow_transaction_height ON ow_transaction (app_id, block_height)`,
+			`CREATE TABLE ow_tx_input (
+				app_id VARCHAR(255) NOT NULL,
+				sid VARCHAR(255) NOT NULL,
+				txid VARCHAR(255) NOT NULL,
+				account_id VARCHAR(255) NOT NULL,
+				address VARCHAR(255) NOT NULL,
+				symbol VARCHAR(64) NOT NULL,
+				block_height BIGINT NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (app_id, sid)
+			)`,
+			`CREATE INDEX idx_ow_tx_input_account ON ow_tx_input (app_id, account_id)`,
+			`CREATE INDEX idx_ow_tx_input_height ON ow_tx_input (app_id, block_height)`,
+			`CREATE TABLE ow_tx_output (
+				app_id VARCHAR(255) NOT NULL,
+				sid VARCHAR(255) NOT NULL,
+				txid VARCHAR(255) NOT NULL,
+				account_id VARCHAR(255) NOT NULL,
+				address VARCHAR(255) NOT NULL,
+				symbol VARCHAR(64) NOT NULL,
+				block_height BIGINT NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (app_id, sid)
+			)`,
+			`CREATE INDEX idx_ow_tx_output_account ON ow_tx_output (app_id, account_id)`,
+			`CREATE INDEX idx_ow_tx_output_height ON ow_tx_output (app_id, block_height)`,
+		},
+	},
+	{
+		version: 2,
+		statements: []string{
+			`CREATE TABLE ow_confirm_watch (
+				id VARCHAR(255) NOT NULL,
+				symbol VARCHAR(64) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (id)
+			)`,
+			`CREATE INDEX idx_ow_confirm_watch_symbol ON ow_confirm_watch (symbol)`,
+		},
+	},
+	{
+		version: 3,
+		statements: []string{
+			`CREATE TABLE ow_object (
+				app_id VARCHAR(255) NOT NULL,
+				bucket VARCHAR(255) NOT NULL,
+				id VARCHAR(255) NOT NULL,
+				data TEXT NOT NULL,
+				PRIMARY KEY (app_id, bucket, id)
+			)`,
+		},
+	},
+	{
+		version: 4,
+		statements: []string{
+			`CREATE TABLE ow_object_index (
+				app_id VARCHAR(255) NOT NULL,
+				bucket VARCHAR(128) NOT NULL,
+				field VARCHAR(64) NOT NULL,
+				value VARCHAR(255) NOT NULL,
+				id VARCHAR(255) NOT NULL,
+				PRIMARY KEY (app_id, bucket, field, id)
+			)`,
+			`CREATE INDEX idx_ow_object_index_value ON ow_object_index (app_id, bucket, field, value)`,
+		},
+	},
+}
+
+//migrationLockKey 数据库结构升级的锁标识
+const migrationLockKey = "ow_schema_migrations"
+
+//Migrate 升级数据库结构到最新版本，已执行的版本记录在ow_schema_migrations表。
+//多个进程同时启动时，通过数据库锁保证同一版本只执行一次。
+func (db *DB) Migrate() error {
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ow_schema_migrations (
+		version BIGINT NOT NULL,
+		applied_at BIGINT NOT NULL,
+		PRIMARY KEY (version)
+	)`)
+	if err != nil {
+		return fmt.Errorf("can not create migrations table, unexpected error: %v", err)
+	}
+
+	ctx := context.Background()
+
+	//锁与升级需要在同一个连接上执行
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := db.lockMigration(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("can not lock schema migrations, unexpected error: %v", err)
+	}
+	defer unlock()
+
+	for _, m := range migrations {
+
+		current, err := schemaVersion(conn.QueryRowContext(ctx, "SELECT MAX(version) FROM ow_schema_migrations"))
+		if err != nil {
+			return err
+		}
+		if m.version <= current {
+			continue
+		}
+
+		log.Infof("sql store migrating schema to version: %d", m.version)
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		//先写入版本记录，sqlite等无法加锁的数据库由主键保证并发时只有一个进程执行成功
+		_, err = tx.Exec(db.rebind("INSERT INTO ow_schema_migrations (version, applied_at) VALUES (?, ?)"), m.version, time.Now().Unix())
+		if err == nil {
+			for _, stmt := range m.statements {
+				if _, err = tx.Exec(stmt); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+
+			//其他进程已完成此版本
+			applied, verr := schemaVersion(conn.QueryRowContext(ctx, "SELECT MAX(version) FROM ow_schema_migrations"))
+			if verr == nil && applied >= m.version {
+				continue
+			}
+			return fmt.Errorf("migrate schema to version: %d failed, unexpected error: %v", m.version, err)
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("migrate schema to version: %d failed, unexpected error: %v", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+//lockMigration 获取数据库结构升级的会话锁，sqlite由数据库文件锁和版本主键保证
+func (db *DB) lockMigration(ctx context.Context, conn *sql.Conn) (func(), error) {
+
+	switch db.driver {
+	case DriverPostgres:
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", migrationLockKey)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", migrationLockKey)
+		}, nil
+	case DriverMySQL:
+		var locked sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockKey, 60).Scan(&locked)
+		if err != nil {
+			return nil, err
+		}
+		if locked.Int64 != 1 {
+			return nil, fmt.Errorf("wait for schema migrations lock timeout")
+		}
+		return func() {
+			conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockKey)
+		}, nil
+	default:
+		return func() {}, nil
+	}
+}
+
+//SchemaVersion 当前数据库结构版本，0为未初始化
+func (db *DB) SchemaVersion() (int64, error) {
+	return schemaVersion(db.QueryRow("SELECT MAX(version) FROM ow_schema_migrations"))
+}
+
+//schemaVersion 解析数据库结构版本查询结果
+func schemaVersion(row *sql.Row) (int64, error) {
+	var version sql.NullInt64
+	err := row.Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("can not get schema version, unexpected error: %v", err)
+	}
+	return version.Int64, nil
+}
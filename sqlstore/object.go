@@ -0,0 +1,266 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//objectTable 有独立数据表的结构体，按类型名保存到对应的数据表，不再存入ow_object
+type objectTable struct {
+	table   string
+	key     string            //主键字段名
+	columns map[string]string //结构体字段对应的数据表查询字段
+}
+
+//objectTables 钱包、账户、地址和交易记录使用独立的数据表，与WalletStore共用同一份数据
+var objectTables = map[string]objectTable{
+	"Wallet":        {table: "ow_wallet", key: "WalletID", columns: walletColumns},
+	"AssetsAccount": {table: "ow_assets_account", key: "AccountID", columns: accountColumns},
+	"Address":       {table: "ow_address", key: "Address", columns: addressColumns},
+	"Transaction":   {table: "ow_transaction", key: "WxID", columns: transactionColumns},
+	"TxInput":       {table: "ow_tx_input", key: "Sid", columns: rechargeColumns},
+	"TxOutPut":      {table: "ow_tx_output", key: "Sid", columns: rechargeColumns},
+}
+
+//ObjectBucket 按应用和分组保存的json对象，供openw.Store等键值存储使用。
+//有独立数据表的结构体通过SaveRecord和FindRecords读写，其他对象保存在ow_object，
+//索引字段的值保存在ow_object_index，按索引查询时不需要加载整个分组
+type ObjectBucket struct {
+	db    *DB
+	e     execer
+	tx    *sql.Tx
+	appID string
+}
+
+//Objects 应用的对象存储
+func (db *DB) Objects(appID string) *ObjectBucket {
+	return &ObjectBucket{db: db, e: db, appID: appID}
+}
+
+//ObjectAppIDs 已保存钱包或对象的全部应用ID
+func (db *DB) ObjectAppIDs() ([]string, error) {
+	rows, err := db.Query("SELECT app_id FROM ow_object UNION SELECT app_id FROM ow_wallet ORDER BY app_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apps := make([]string, 0)
+	for rows.Next() {
+		var appID string
+		if err = rows.Scan(&appID); err != nil {
+			return nil, err
+		}
+		apps = append(apps, appID)
+	}
+	return apps, rows.Err()
+}
+
+//Begin 开启事务，返回在事务中读写的对象存储
+func (b *ObjectBucket) Begin() (*ObjectBucket, error) {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectBucket{db: b.db, e: tx, tx: tx, appID: b.appID}, nil
+}
+
+//Commit 提交事务
+func (b *ObjectBucket) Commit() error {
+	if b.tx == nil {
+		return nil
+	}
+	return b.tx.Commit()
+}
+
+//Rollback 回滚事务，已提交的事务调用无影响
+func (b *ObjectBucket) Rollback() error {
+	if b.tx == nil {
+		return nil
+	}
+	err := b.tx.Rollback()
+	if err == sql.ErrTxDone {
+		return nil
+	}
+	return err
+}
+
+//inTx 多条语句需要原子执行，已在事务中时直接执行
+func (b *ObjectBucket) inTx(fn func(e execer) error) error {
+	if b.tx != nil {
+		return fn(b.tx)
+	}
+	return b.db.inTx(func(tx *sql.Tx) error {
+		return fn(tx)
+	})
+}
+
+//HasTable 结构体类型是否有独立的数据表
+func (b *ObjectBucket) HasTable(name string) bool {
+	_, ok := objectTables[name]
+	return ok
+}
+
+//SaveRecord 保存有独立数据表的结构体，主键相同则替换
+func (b *ObjectBucket) SaveRecord(obj interface{}) error {
+	store := &WalletStore{db: b.db, appID: b.appID}
+	switch v := obj.(type) {
+	case *openwallet.Wallet:
+		return store.saveWallet(b.e, v)
+	case *openwallet.AssetsAccount:
+		return store.saveAssetsAccount(b.e, v)
+	case *openwallet.Address:
+		return store.saveAddress(b.e, v)
+	case *openwallet.Transaction:
+		return store.saveTransaction(b.e, v)
+	case *openwallet.TxInput:
+		return store.saveRecharge(b.e, "ow_tx_input", v.Sid, &v.Recharge, v)
+	case *openwallet.TxOutPut:
+		return store.saveRecharge(b.e, "ow_tx_output", v.Sid, &v.Recharge, v)
+	default:
+		return fmt.Errorf("%T has no sql table", obj)
+	}
+}
+
+//FindRecords 按成对的字段名和值查询有独立数据表的结构体，有对应数据表字段的条件直接在数据库查询
+func (b *ObjectBucket) FindRecords(name string, list interface{}, offset, limit int, cols ...interface{}) error {
+	t, ok := objectTables[name]
+	if !ok {
+		return fmt.Errorf("%s has no sql table", name)
+	}
+	qs, err := newQuery(t.columns, cols...)
+	if err != nil {
+		return err
+	}
+	qs.eq("app_id", b.appID)
+	return b.db.findIn(b.e, t.table, qs, offset, limit, list)
+}
+
+//DeleteRecord 删除有独立数据表的结构体，返回记录是否存在
+func (b *ObjectBucket) DeleteRecord(name, id string) (bool, error) {
+	t, ok := objectTables[name]
+	if !ok {
+		return false, fmt.Errorf("%s has no sql table", name)
+	}
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE app_id = ? AND %s = ?", t.table, t.columns[t.key])
+	return execAffected(b.e, b.db.rebind(stmt), b.appID, id)
+}
+
+//Save 保存对象，主键相同则替换，indexes为索引字段的值
+func (b *ObjectBucket) Save(bucket, id string, data []byte, indexes map[string]string) error {
+	return b.inTx(func(e execer) error {
+		err := b.db.save(e, &record{
+			table:   "ow_object",
+			keys:    []string{"app_id", "bucket", "id"},
+			columns: []string{"app_id", "bucket", "id", "data"},
+			values:  []interface{}{b.appID, bucket, id, string(data)},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = e.Exec(b.db.rebind("DELETE FROM ow_object_index WHERE app_id = ? AND bucket = ? AND id = ?"), b.appID, bucket, id)
+		if err != nil {
+			return err
+		}
+		for field, value := range indexes {
+			err = b.db.save(e, &record{
+				table:   "ow_object_index",
+				keys:    []string{"app_id", "bucket", "field", "id"},
+				columns: []string{"app_id", "bucket", "field", "id", "value"},
+				values:  []interface{}{b.appID, bucket, field, id, value},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//Delete 删除对象及其索引，返回对象是否存在
+func (b *ObjectBucket) Delete(bucket, id string) (bool, error) {
+	var exist bool
+	err := b.inTx(func(e execer) error {
+		_, err := e.Exec(b.db.rebind("DELETE FROM ow_object_index WHERE app_id = ? AND bucket = ? AND id = ?"), b.appID, bucket, id)
+		if err != nil {
+			return err
+		}
+		exist, err = execAffected(e, b.db.rebind("DELETE FROM ow_object WHERE app_id = ? AND bucket = ? AND id = ?"), b.appID, bucket, id)
+		return err
+	})
+	return exist, err
+}
+
+//Get 按主键获取对象，不存在返回nil
+func (b *ObjectBucket) Get(bucket, id string) ([]byte, error) {
+	var raw string
+	err := b.e.QueryRow(b.db.rebind("SELECT data FROM ow_object WHERE app_id = ? AND bucket = ? AND id = ?"), b.appID, bucket, id).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(raw), nil
+}
+
+//Load 按主键顺序加载分组中索引字段值全部相等的对象，indexes为空时加载整个分组
+func (b *ObjectBucket) Load(bucket string, indexes map[string]string) (ids []string, data [][]byte, err error) {
+
+	stmt := "SELECT id, data FROM ow_object o WHERE app_id = ? AND bucket = ?"
+	args := []interface{}{b.appID, bucket}
+	for field, value := range indexes {
+		stmt += " AND EXISTS (SELECT 1 FROM ow_object_index i WHERE i.app_id = o.app_id AND i.bucket = o.bucket AND i.id = o.id AND i.field = ? AND i.value = ?)"
+		args = append(args, field, value)
+	}
+	stmt += " ORDER BY id"
+
+	rows, err := b.e.Query(b.db.rebind(stmt), args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id  string
+			raw string
+		)
+		if err = rows.Scan(&id, &raw); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		data = append(data, []byte(raw))
+	}
+	return ids, data, rows.Err()
+}
+
+//execAffected 执行语句，返回是否有记录受影响
+func execAffected(e execer, stmt string, args ...interface{}) (bool, error) {
+	result, err := e.Exec(stmt, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
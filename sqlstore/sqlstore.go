@@ -0,0 +1,362 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+// Package sqlstore 基于关系型数据库的区块链与钱包数据存储
+//
+// 支持PostgreSQL(github.com/lib/pq)，MySQL(github.com/go-sql-driver/mysql)，
+// SQLite(github.com/mattn/go-sqlite3)，多个扫描器和API进程可共享同一数据库。
+// SQLite驱动依赖cgo，使用时需自行导入：
+//	import _ "github.com/mattn/go-sqlite3"
+//
+// Usage:
+//	db, err := sqlstore.Open("postgres", "user=a password=b dbname=c sslmode=disable")
+//	err = db.Migrate()
+//	blockchain := sqlstore.NewBlockchainStore(db)
+//	wallet := sqlstore.NewWalletStore(db, appID, w, keyFile)
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/blocktree/openwallet/v2/common"
+	// import sql Driver
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+//数据库驱动名称
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite3"
+)
+
+//DB 关系型数据库连接
+type DB struct {
+	*sql.DB
+	driver string
+}
+
+//Open 打开数据库连接
+//@param driver 驱动名称：mysql，postgres，sqlite3
+//@param dsn 数据源连接串
+func Open(driver, dsn string) (*DB, error) {
+
+	switch driver {
+	case DriverMySQL, DriverPostgres, DriverSQLite:
+	default:
+		return nil, fmt.Errorf("sql driver: %s is not support", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("can not open sql database, unexpected error: %v", err)
+	}
+
+	if driver == DriverSQLite {
+		//sqlite不支持多连接并发写
+		db.SetMaxOpenConns(1)
+	}
+
+	return &DB{DB: db, driver: driver}, nil
+}
+
+//Driver 数据库驱动名称
+func (db *DB) Driver() string {
+	return db.driver
+}
+
+//rebind 把?占位符转换为数据库驱动的占位符
+func (db *DB) rebind(query string) string {
+	if db.driver != DriverPostgres {
+		return query
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+//execer 执行语句的接口，*sql.DB和*sql.Tx都已实现
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//record 数据表记录
+type record struct {
+	table   string
+	keys    []string      //主键字段
+	columns []string      //全部字段，包含主键
+	values  []interface{} //对应字段的值
+}
+
+//primaryKeys 各数据表的主键字段，分页查询按主键排序保证结果稳定
+var primaryKeys = map[string]string{
+	"ow_block_head":     "symbol",
+	"ow_block_cache":    "symbol, height",
+	"ow_unscan_record":  "id",
+	"ow_confirm_watch":  "id",
+	"ow_wallet":         "app_id, wallet_id",
+	"ow_assets_account": "app_id, account_id",
+	"ow_address":        "app_id, address",
+	"ow_transaction":    "app_id, wxid",
+	"ow_tx_input":       "app_id, sid",
+	"ow_tx_output":      "app_id, sid",
+	"ow_object":         "app_id, bucket, id",
+	"ow_object_index":   "app_id, bucket, field, id",
+}
+
+//save 保存记录，已存在则替换，单条语句完成插入或更新，多进程并发写入不会出现主键冲突
+func (db *DB) save(e execer, r *record) error {
+
+	isKey := func(column string) bool {
+		for _, k := range r.keys {
+			if k == column {
+				return true
+			}
+		}
+		return false
+	}
+
+	updates := make([]string, 0, len(r.columns))
+	for _, c := range r.columns {
+		if isKey(c) {
+			continue
+		}
+		if db.driver == DriverMySQL {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", c, c))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = excluded.%s", c, c))
+		}
+	}
+
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(r.columns)), ",")
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", r.table, strings.Join(r.columns, ","), marks)
+
+	switch {
+	case db.driver == DriverMySQL && len(updates) > 0:
+		stmt += " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	case db.driver == DriverMySQL:
+		stmt = strings.Replace(stmt, "INSERT INTO", "INSERT IGNORE INTO", 1)
+	case len(updates) > 0:
+		//postgres和sqlite(3.24+)支持的upsert语法
+		stmt += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(r.keys, ","), strings.Join(updates, ", "))
+	default:
+		stmt += fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", strings.Join(r.keys, ","))
+	}
+
+	_, err := e.Exec(db.rebind(stmt), r.values...)
+	return err
+}
+
+//inTx 在事务中执行
+func (db *DB) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//query 查询条件
+type query struct {
+	where   []string
+	args    []interface{}
+	filters map[string]interface{} //无对应字段的条件，查询后按结构体字段过滤
+}
+
+//newQuery 解析成对的查询条件，字段名为结构体字段名，有对应数据表字段的直接转为sql条件
+func newQuery(columns map[string]string, cols ...interface{}) (*query, error) {
+
+	if len(cols)%2 != 0 {
+		return nil, fmt.Errorf("condition param is not pair")
+	}
+
+	qs := &query{
+		where:   make([]string, 0),
+		args:    make([]interface{}, 0),
+		filters: make(map[string]interface{}),
+	}
+
+	for i := 0; i < len(cols); i = i + 2 {
+		field := common.NewString(cols[i]).String()
+		val := cols[i+1]
+		if column, ok := columns[field]; ok {
+			qs.eq(column, val)
+		} else {
+			qs.filters[field] = val
+		}
+	}
+
+	return qs, nil
+}
+
+//eq 添加相等条件
+func (qs *query) eq(column string, val interface{}) {
+	qs.where = append(qs.where, column+" = ?")
+	qs.args = append(qs.args, val)
+}
+
+//match 对象是否符合结构体字段的过滤条件
+func (qs *query) match(obj interface{}) bool {
+	v := reflect.Indirect(reflect.ValueOf(obj))
+	for field, val := range qs.filters {
+		f := v.FieldByName(field)
+		if !f.IsValid() {
+			return false
+		}
+		want := reflect.ValueOf(val)
+		if !want.IsValid() || !want.Type().ConvertibleTo(f.Type()) {
+			return false
+		}
+		if !reflect.DeepEqual(f.Interface(), want.Convert(f.Type()).Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+//find 按条件查询数据表的data字段，解析到list中，list为切片的指针
+func (db *DB) find(table string, qs *query, offset, limit int, list interface{}) error {
+	return db.findIn(db, table, qs, offset, limit, list)
+}
+
+//findIn 在数据库或事务中查询，list的元素可以是结构体或结构体指针
+func (db *DB) findIn(e execer, table string, qs *query, offset, limit int, list interface{}) error {
+
+	stmt := fmt.Sprintf("SELECT data FROM %s", table)
+	if len(qs.where) > 0 {
+		stmt += " WHERE " + strings.Join(qs.where, " AND ")
+	}
+
+	if order, ok := primaryKeys[table]; ok {
+		stmt += " ORDER BY " + order
+	}
+
+	//没有结构体过滤条件时，直接使用数据库分页
+	paging := len(qs.filters) == 0
+	if paging {
+		if limit > 0 {
+			stmt += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+		} else if offset > 0 {
+			//数据库要求OFFSET需要与LIMIT一起使用
+			paging = false
+		}
+	}
+
+	rows, err := e.Query(db.rebind(stmt), qs.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sliceValue := reflect.ValueOf(list).Elem()
+	elemType := sliceValue.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+	skipped := 0
+
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+
+		obj := reflect.New(elemType)
+		err = json.Unmarshal([]byte(data), obj.Interface())
+		if err != nil {
+			return err
+		}
+
+		if !paging {
+			if !qs.match(obj.Interface()) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if limit > 0 && sliceValue.Len() >= limit {
+				break
+			}
+		}
+
+		if isPtr {
+			sliceValue.Set(reflect.Append(sliceValue, obj))
+		} else {
+			sliceValue.Set(reflect.Append(sliceValue, obj.Elem()))
+		}
+	}
+
+	return rows.Err()
+}
+
+//one 按条件查询一条记录的data字段，解析到obj中
+func (db *DB) one(e execer, table string, obj interface{}, where string, args ...interface{}) error {
+	var data string
+	err := e.QueryRow(db.rebind(fmt.Sprintf("SELECT data FROM %s WHERE %s", table, where)), args...).Scan(&data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), obj)
+}
+
+//oneForUpdate 在事务中查询一条记录并锁定，直到事务结束。sqlite不支持FOR UPDATE，
+//先执行空更新获取写锁，避免读后再升级写锁时与其他进程冲突
+func (db *DB) oneForUpdate(tx *sql.Tx, table string, obj interface{}, where string, args ...interface{}) error {
+	if db.driver == DriverSQLite {
+		_, err := tx.Exec(db.rebind(fmt.Sprintf("UPDATE %s SET data = data WHERE %s", table, where)), args...)
+		if err != nil {
+			return err
+		}
+		return db.one(tx, table, obj, where, args...)
+	}
+	return db.one(tx, table, obj, where+" FOR UPDATE", args...)
+}
+
+//encode 对象编码为json字符串
+func encode(obj interface{}) (string, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,314 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sqlstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestDB(t *testing.T) *DB {
+	db, err := Open(DriverSQLite, ":memory:")
+	if err != nil {
+		t.Fatalf("Open unexpected error: %v", err)
+	}
+	err = db.Migrate()
+	if err != nil {
+		t.Fatalf("Migrate unexpected error: %v", err)
+	}
+	return db
+}
+
+func TestDB_Migrate(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	//重复执行不会报错
+	err := db.Migrate()
+	if err != nil {
+		t.Errorf("Migrate unexpected error: %v", err)
+		return
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Errorf("SchemaVersion unexpected error: %v", err)
+		return
+	}
+	if version != migrations[len(migrations)-1].version {
+		t.Errorf("schema version = %d, want %d", version, migrations[len(migrations)-1].version)
+	}
+}
+
+func TestDB_MigrateConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlstore")
+	if err != nil {
+		t.Fatalf("TempDir unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//多个进程同时启动升级同一个数据库
+	dsn := filepath.Join(dir, "ow.db") + "?_busy_timeout=5000"
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := Open(DriverSQLite, dsn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+			errs <- db.Migrate()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Migrate unexpected error: %v", err)
+		}
+	}
+}
+
+func TestDB_SaveUpsert(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	base := NewBlockchainStore(db)
+	for i := uint64(1); i <= 3; i++ {
+		err := base.SaveCurrentBlockHead(&openwallet.BlockHeader{Hash: fmt.Sprintf("hash_%d", i), Height: i, Symbol: "BTC"})
+		if err != nil {
+			t.Errorf("SaveCurrentBlockHead unexpected error: %v", err)
+			return
+		}
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM ow_block_head").Scan(&count)
+	current, _ := base.GetCurrentBlockHead("BTC")
+	if count != 1 || current.Height != 3 {
+		t.Errorf("block head rows = %d, height = %d, want 1 and 3", count, current.Height)
+	}
+}
+
+func TestWalletStore_SetAddressExtParamConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlstore")
+	if err != nil {
+		t.Fatalf("TempDir unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	//多个进程同时设置同一地址的不同扩展字段
+	dsn := filepath.Join(dir, "ow.db") + "?_busy_timeout=5000"
+	db, err := Open(DriverSQLite, dsn)
+	if err != nil {
+		t.Fatalf("Open unexpected error: %v", err)
+	}
+	defer db.Close()
+	if err = db.Migrate(); err != nil {
+		t.Fatalf("Migrate unexpected error: %v", err)
+	}
+	NewWalletStore(db, "app", nil, "").SaveAddress(&openwallet.Address{AccountID: "acc1", Address: "addr1", Symbol: "BTC"})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := Open(DriverSQLite, dsn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			errs <- NewWalletStore(conn, "app", nil, "").SetAddressExtParam("addr1", fmt.Sprintf("key%d", i), i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("SetAddressExtParam unexpected error: %v", err)
+		}
+	}
+
+	store := NewWalletStore(db, "app", nil, "")
+	for i := 0; i < 8; i++ {
+		val, err := store.GetAddressExtParam("addr1", fmt.Sprintf("key%d", i))
+		if err != nil || val == nil {
+			t.Errorf("key%d = %v, %v, want %d", i, val, err, i)
+		}
+	}
+}
+
+func TestDB_Rebind(t *testing.T) {
+	db := &DB{driver: DriverPostgres}
+	got := db.rebind("SELECT data FROM t WHERE a = ? AND b = ?")
+	if got != "SELECT data FROM t WHERE a = $1 AND b = $2" {
+		t.Errorf("rebind = %s", got)
+	}
+}
+
+func TestBlockchainStore(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	base := NewBlockchainStore(db)
+	base.SetMaxBlockCache(5, "BTC")
+
+	header := &openwallet.BlockHeader{Hash: "1111", Height: 100, Symbol: "BTC"}
+	if err := base.SaveCurrentBlockHead(header); err != nil {
+		t.Errorf("SaveCurrentBlockHead unexpected error: %v", err)
+		return
+	}
+	header.Hash = "2222"
+	base.SaveCurrentBlockHead(header)
+
+	current, err := base.GetCurrentBlockHead("BTC")
+	if err != nil {
+		t.Errorf("GetCurrentBlockHead unexpected error: %v", err)
+		return
+	}
+	if current.Height != 100 || current.Hash != "2222" {
+		t.Errorf("current block = %d %s, want 100 2222", current.Height, current.Hash)
+	}
+
+	for i := uint64(1); i <= 10; i++ {
+		err = base.SaveLocalBlockHead(&openwallet.BlockHeader{Hash: fmt.Sprintf("hash_%d", i), Height: i, Symbol: "BTC"})
+		if err != nil {
+			t.Errorf("SaveLocalBlockHead unexpected error: %v", err)
+			return
+		}
+	}
+
+	//超出缓存数量的区块已删除
+	if _, err = base.GetLocalBlockHeadByHeight(5, "BTC"); err == nil {
+		t.Errorf("block height: 5 should be removed from cache")
+	}
+	local, err := base.GetLocalBlockHeadByHeight(6, "BTC")
+	if err != nil || local.Hash != "hash_6" {
+		t.Errorf("GetLocalBlockHeadByHeight = %v, %v", local, err)
+	}
+
+	base.SaveUnscanRecord(openwallet.NewUnscanRecord(333, "333", "rpc call error", "BTC"))
+	base.SaveUnscanRecord(openwallet.NewUnscanRecord(333, "334", "rpc call error", "BTC"))
+	base.SaveUnscanRecord(openwallet.NewUnscanRecord(444, "444", "rpc call error", "ETH"))
+
+	list, _ := base.GetUnscanRecords("BTC")
+	if len(list) != 2 {
+		t.Errorf("unscan records = %d, want 2", len(list))
+	}
+
+	base.DeleteUnscanRecordByHeight(333, "BTC")
+	list, _ = base.GetUnscanRecords("")
+	if len(list) != 1 || list[0].TxID != "444" {
+		t.Errorf("unscan records after delete = %v", list)
+	}
+}
+
+func TestWalletStore_SaveBlockExtractData(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	store := NewWalletStore(db, "app", nil, "")
+	other := NewWalletStore(db, "other", nil, "")
+
+	store.SaveWallet(&openwallet.Wallet{WalletID: "w1", Alias: "wallet"})
+	store.SaveAssetsAccount(&openwallet.AssetsAccount{WalletID: "w1", AccountID: "acc1", Symbol: "BTC"})
+	store.SaveAddress(
+		&openwallet.Address{AccountID: "acc1", Address: "addr1", Symbol: "BTC"},
+		&openwallet.Address{AccountID: "acc1", Address: "addr2", Symbol: "BTC"},
+	)
+
+	account, err := store.GetAssetsAccountByAddress("addr2")
+	if err != nil || account.AccountID != "acc1" {
+		t.Errorf("GetAssetsAccountByAddress = %v, %v", account, err)
+		return
+	}
+
+	if _, err = other.GetAddress("addr1"); err == nil {
+		t.Errorf("address should be isolated by appID")
+	}
+
+	coin := openwallet.Coin{Symbol: "BTC"}
+	data := openwallet.NewBlockExtractData()
+	for i, addr := range []string{"addr1", "addr2", "unknown"} {
+		output := &openwallet.TxOutPut{}
+		output.Sid = openwallet.GenTxOutPutSID("tx1", "BTC", "", uint64(i))
+		output.TxID = "tx1"
+		output.Address = addr
+		output.Amount = "1.5"
+		output.Coin = coin
+		output.BlockHeight = 100
+		data.TxOutputs = append(data.TxOutputs, output)
+	}
+	data.Transaction = &openwallet.Transaction{TxID: "tx1", Coin: coin, BlockHeight: 100, Decimal: 8}
+	data.Transaction.WxID = openwallet.GenTransactionWxID(data.Transaction)
+
+	err = store.SaveBlockExtractData("acc1", data)
+	if err != nil {
+		t.Errorf("SaveBlockExtractData unexpected error: %v", err)
+		return
+	}
+
+	txs, err := store.GetTransactionByTxID("tx1", "BTC")
+	if err != nil || len(txs) != 1 || txs[0].Amount != "3.00000000" {
+		t.Errorf("GetTransactionByTxID = %v, %v", txs, err)
+		return
+	}
+
+	unspent, err := store.GetTxUnspent(0, -1, "AccountID", "acc1")
+	if err != nil || len(unspent) != 2 {
+		t.Errorf("GetTxUnspent = %d, %v", len(unspent), err)
+		return
+	}
+
+	//结构体字段过滤与分页
+	unspent[0].Received = true
+	store.SaveTxOutputs(unspent[0])
+	unspent, _ = store.GetTxUnspent(0, 10)
+	if len(unspent) != 1 {
+		t.Errorf("GetTxUnspent after received = %d, want 1", len(unspent))
+	}
+	outputs, _ := store.GetTxOutputs(1, 1, "TxID", "tx1")
+	if len(outputs) != 1 {
+		t.Errorf("GetTxOutputs page = %d, want 1", len(outputs))
+	}
+
+	err = store.DeleteBlockDataByHeight(100)
+	if err != nil {
+		t.Errorf("DeleteBlockDataByHeight unexpected error: %v", err)
+		return
+	}
+	txs, _ = store.GetTransactions(0, -1)
+	outputs, _ = store.GetTxOutputs(0, -1)
+	if len(txs) != 0 || len(outputs) != 0 {
+		t.Errorf("block data not deleted: %d transactions, %d outputs", len(txs), len(outputs))
+	}
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	//结构体字段对应的数据表查询字段
+	transactionColumns = map[string]string{
+		"WxID":        "wxid",
+		"TxID":        "txid",
+		"AccountID":   "account_id",
+		"BlockHeight": "block_height",
+	}
+	rechargeColumns = map[string]string{
+		"Sid":         "sid",
+		"TxID":        "txid",
+		"AccountID":   "account_id",
+		"Address":     "address",
+		"BlockHeight": "block_height",
+	}
+)
+
+//GetTransactionByTxID 获取钱包的交易记录
+func (store *WalletStore) GetTransactionByTxID(txid, symbol string) ([]*openwallet.Transaction, error) {
+	qs := &query{}
+	qs.eq("app_id", store.appID)
+	qs.eq("txid", txid)
+	if len(symbol) > 0 {
+		qs.eq("symbol", symbol)
+	}
+	var txs []*openwallet.Transaction
+	err := store.db.find("ow_transaction", qs, 0, -1, &txs)
+	if err != nil {
+		return nil, fmt.Errorf("can not find transactions")
+	}
+	return txs, nil
+}
+
+//GetTransactions 获取钱包的交易记录
+func (store *WalletStore) GetTransactions(offset, limit int, cols ...interface{}) ([]*openwallet.Transaction, error) {
+	qs, err := newQuery(transactionColumns, cols...)
+	if err != nil {
+		return nil, err
+	}
+	qs.eq("app_id", store.appID)
+
+	var txs []*openwallet.Transaction
+	err = store.db.find("ow_transaction", qs, offset, limit, &txs)
+	if err != nil {
+		return nil, fmt.Errorf("can not find transactions")
+	}
+	return txs, nil
+}
+
+//GetTxInputs 获取钱包的出账记录
+func (store *WalletStore) GetTxInputs(offset, limit int, cols ...interface{}) ([]*openwallet.TxInput, error) {
+	qs, err := newQuery(rechargeColumns, cols...)
+	if err != nil {
+		return nil, err
+	}
+	qs.eq("app_id", store.appID)
+
+	var txs []*openwallet.TxInput
+	err = store.db.find("ow_tx_input", qs, offset, limit, &txs)
+	if err != nil {
+		return nil, fmt.Errorf("can not find txInputs")
+	}
+	return txs, nil
+}
+
+//GetTxOutputs 获取钱包的入账记录
+func (store *WalletStore) GetTxOutputs(offset, limit int, cols ...interface{}) ([]*openwallet.TxOutPut, error) {
+	qs, err := newQuery(rechargeColumns, cols...)
+	if err != nil {
+		return nil, err
+	}
+	qs.eq("app_id", store.appID)
+
+	var txs []*openwallet.TxOutPut
+	err = store.db.find("ow_tx_output", qs, offset, limit, &txs)
+	if err != nil {
+		return nil, fmt.Errorf("can not find txoutputs")
+	}
+	return txs, nil
+}
+
+//GetTxUnspent 获取钱包未花费的入账记录
+func (store *WalletStore) GetTxUnspent(offset, limit int, cols ...interface{}) ([]*openwallet.TxOutPut, error) {
+	return store.GetTxOutputs(offset, limit, append(cols, "Received", false)...)
+}
+
+//SaveBlockExtractData 保存区块提取数据
+func (store *WalletStore) SaveBlockExtractData(accountID string, data *openwallet.TxExtractData) error {
+
+	var (
+		accountSpent    = decimal.Zero
+		accountReceived = decimal.Zero
+	)
+
+	return store.db.inTx(func(tx *sql.Tx) error {
+
+		//保存出账的记录
+		for _, input := range data.TxInputs {
+			a, err := store.getAddress(tx, input.Address)
+			if err != nil {
+				continue
+			}
+			input.AccountID = a.AccountID
+			err = store.saveRecharge(tx, "ow_tx_input", input.Sid, &input.Recharge, input)
+			if err != nil {
+				return fmt.Errorf("wallet save TxInputs failed, unexpected error: %v", err)
+			}
+
+			//统计该交易单下的各个资产账户的支出总数
+			if a.AccountID == accountID {
+				amount, _ := decimal.NewFromString(input.Amount)
+				accountSpent = accountSpent.Add(amount)
+			}
+		}
+
+		//保存入账的记录
+		for _, output := range data.TxOutputs {
+			a, err := store.getAddress(tx, output.Address)
+			if err != nil {
+				continue
+			}
+			output.AccountID = a.AccountID
+			err = store.saveRecharge(tx, "ow_tx_output", output.Sid, &output.Recharge, output)
+			if err != nil {
+				return fmt.Errorf("wallet save TxOutputs failed, unexpected error: %v", err)
+			}
+
+			//统计该交易单下的各个资产账户的收入总数
+			if a.AccountID == accountID {
+				amount, _ := decimal.NewFromString(output.Amount)
+				accountReceived = accountReceived.Add(amount)
+			}
+		}
+
+		//计算该交易单下的各个资产账户实际总收支，记录为账单数据
+		trx := data.Transaction
+		trx.AccountID = accountID
+		trx.Amount = accountReceived.Sub(accountSpent).StringFixed(trx.Decimal)
+
+		err := store.saveTransaction(tx, trx)
+		if err != nil {
+			return fmt.Errorf("wallet save Transactions failed, unexpected error: %v", err)
+		}
+
+		return nil
+	})
+}
+
+//SaveTransaction 保存交易记录
+func (store *WalletStore) SaveTransaction(trx *openwallet.Transaction) error {
+	return store.saveTransaction(store.db, trx)
+}
+
+//SaveTxOutputs 批量保存入账记录
+func (store *WalletStore) SaveTxOutputs(outputs ...*openwallet.TxOutPut) error {
+	return store.db.inTx(func(tx *sql.Tx) error {
+		for _, output := range outputs {
+			err := store.saveRecharge(tx, "ow_tx_output", output.Sid, &output.Recharge, output)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//DeleteBlockDataByHeight 删除钱包中指定区块高度相关的交易记录
+func (store *WalletStore) DeleteBlockDataByHeight(height uint64) error {
+	return store.db.inTx(func(tx *sql.Tx) error {
+		for _, table := range []string{"ow_transaction", "ow_tx_input", "ow_tx_output"} {
+			_, err := tx.Exec(store.db.rebind(fmt.Sprintf("DELETE FROM %s WHERE app_id = ? AND block_height = ?", table)), store.appID, height)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *WalletStore) saveTransaction(e execer, trx *openwallet.Transaction) error {
+	data, err := encode(trx)
+	if err != nil {
+		return err
+	}
+	return store.db.save(e, &record{
+		table:   "ow_transaction",
+		keys:    []string{"app_id", "wxid"},
+		columns: []string{"app_id", "wxid", "txid", "account_id", "symbol", "block_height", "data"},
+		values:  []interface{}{store.appID, trx.WxID, trx.TxID, trx.AccountID, trx.Coin.Symbol, trx.BlockHeight, data},
+	})
+}
+
+func (store *WalletStore) saveRecharge(e execer, table, sid string, r *openwallet.Recharge, obj interface{}) error {
+	data, err := encode(obj)
+	if err != nil {
+		return err
+	}
+	return store.db.save(e, &record{
+		table:   table,
+		keys:    []string{"app_id", "sid"},
+		columns: []string{"app_id", "sid", "txid", "account_id", "address", "symbol", "block_height", "data"},
+		values:  []interface{}{store.appID, sid, r.TxID, r.AccountID, r.Address, r.Coin.Symbol, r.BlockHeight, data},
+	})
+}
@@ -0,0 +1,312 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/hdkeystore"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	//结构体字段对应的数据表查询字段
+	walletColumns = map[string]string{
+		"WalletID": "wallet_id",
+	}
+	accountColumns = map[string]string{
+		"AccountID": "account_id",
+		"WalletID":  "wallet_id",
+		"Symbol":    "symbol",
+	}
+	addressColumns = map[string]string{
+		"Address":   "address",
+		"AccountID": "account_id",
+		"Symbol":    "symbol",
+	}
+)
+
+var _ openwallet.WalletDAI = (*WalletStore)(nil)
+
+//WalletStore 钱包数据访问接口的关系型数据库实现，数据按appID隔离
+type WalletStore struct {
+	db      *DB
+	appID   string
+	wallet  *openwallet.Wallet //需要包装的钱包
+	keyFile string             //钱包密钥文件路径
+	key     *hdkeystore.HDKey
+}
+
+//NewWalletStore 创建钱包数据访问接口
+//@param wallet 当前钱包，可为nil
+//@param keyFile 钱包密钥文件路径，观察钱包可为空
+func NewWalletStore(db *DB, appID string, wallet *openwallet.Wallet, keyFile string) *WalletStore {
+	return &WalletStore{
+		db:      db,
+		appID:   appID,
+		wallet:  wallet,
+		keyFile: keyFile,
+	}
+}
+
+//AppID 应用ID
+func (store *WalletStore) AppID() string {
+	return store.appID
+}
+
+//GetWallet 获取钱包
+func (store *WalletStore) GetWallet() *openwallet.Wallet {
+	return store.wallet
+}
+
+//GetWalletByID 通过钱包ID获取
+func (store *WalletStore) GetWalletByID(walletID string) (*openwallet.Wallet, error) {
+	var wallet openwallet.Wallet
+	err := store.db.one(store.db, "ow_wallet", &wallet, "app_id = ? AND wallet_id = ?", store.appID, walletID)
+	if err != nil {
+		return nil, fmt.Errorf("can not find wallet: %s", walletID)
+	}
+	return &wallet, nil
+}
+
+//GetWalletList 获取应用的钱包列表
+func (store *WalletStore) GetWalletList(offset, limit int) ([]*openwallet.Wallet, error) {
+	var wallets []*openwallet.Wallet
+	qs := &query{}
+	qs.eq("app_id", store.appID)
+	err := store.db.find("ow_wallet", qs, offset, limit, &wallets)
+	if err != nil {
+		return nil, fmt.Errorf("can not find wallets")
+	}
+	return wallets, nil
+}
+
+//SaveWallet 保存钱包
+func (store *WalletStore) SaveWallet(wallet *openwallet.Wallet) error {
+	return store.saveWallet(store.db, wallet)
+}
+
+func (store *WalletStore) saveWallet(e execer, wallet *openwallet.Wallet) error {
+	data, err := encode(wallet)
+	if err != nil {
+		return err
+	}
+	return store.db.save(e, &record{
+		table:   "ow_wallet",
+		keys:    []string{"app_id", "wallet_id"},
+		columns: []string{"app_id", "wallet_id", "data"},
+		values:  []interface{}{store.appID, wallet.WalletID, data},
+	})
+}
+
+//GetAssetsAccountInfo 获取指定账户
+func (store *WalletStore) GetAssetsAccountInfo(accountID string) (*openwallet.AssetsAccount, error) {
+	var account openwallet.AssetsAccount
+	err := store.db.one(store.db, "ow_assets_account", &account, "app_id = ? AND account_id = ?", store.appID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("can not find account: %s", accountID)
+	}
+	return &account, nil
+}
+
+//GetAssetsAccountList 获取资产账户列表
+func (store *WalletStore) GetAssetsAccountList(offset, limit int, cols ...interface{}) ([]*openwallet.AssetsAccount, error) {
+
+	qs, err := newQuery(accountColumns, cols...)
+	if err != nil {
+		return nil, err
+	}
+	qs.eq("app_id", store.appID)
+	if store.wallet != nil {
+		qs.eq("wallet_id", store.wallet.WalletID)
+	}
+
+	var accounts []*openwallet.AssetsAccount
+	err = store.db.find("ow_assets_account", qs, offset, limit, &accounts)
+	if err != nil {
+		return nil, fmt.Errorf("can not find accounts")
+	}
+	return accounts, nil
+}
+
+//GetAssetsAccountByAddress 通过地址获取资产账户对象
+func (store *WalletStore) GetAssetsAccountByAddress(address string) (*openwallet.AssetsAccount, error) {
+	obj, err := store.GetAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	account, err := store.GetAssetsAccountInfo(obj.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("can not find account by address: %s", address)
+	}
+	return account, nil
+}
+
+//SaveAssetsAccount 保存资产账户
+func (store *WalletStore) SaveAssetsAccount(account *openwallet.AssetsAccount) error {
+	return store.saveAssetsAccount(store.db, account)
+}
+
+func (store *WalletStore) saveAssetsAccount(e execer, account *openwallet.AssetsAccount) error {
+	data, err := encode(account)
+	if err != nil {
+		return err
+	}
+	return store.db.save(e, &record{
+		table:   "ow_assets_account",
+		keys:    []string{"app_id", "account_id"},
+		columns: []string{"app_id", "account_id", "wallet_id", "symbol", "data"},
+		values:  []interface{}{store.appID, account.AccountID, account.WalletID, account.Symbol, data},
+	})
+}
+
+//GetAddress 通过地址字符串获取地址对象
+func (store *WalletStore) GetAddress(address string) (*openwallet.Address, error) {
+	return store.getAddress(store.db, address)
+}
+
+func (store *WalletStore) getAddress(e execer, address string) (*openwallet.Address, error) {
+	var obj openwallet.Address
+	err := store.db.one(e, "ow_address", &obj, "app_id = ? AND address = ?", store.appID, address)
+	if err != nil {
+		return nil, fmt.Errorf("can not find address")
+	}
+	return &obj, nil
+}
+
+//GetAddressList 获取地址列表
+func (store *WalletStore) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+
+	qs, err := newQuery(addressColumns, cols...)
+	if err != nil {
+		return nil, err
+	}
+	qs.eq("app_id", store.appID)
+
+	var addrs []*openwallet.Address
+	err = store.db.find("ow_address", qs, offset, limit, &addrs)
+	if err != nil {
+		return nil, fmt.Errorf("can not find addresses")
+	}
+	return addrs, nil
+}
+
+//SaveAddress 批量保存地址
+func (store *WalletStore) SaveAddress(address ...*openwallet.Address) error {
+	return store.db.inTx(func(tx *sql.Tx) error {
+		for _, a := range address {
+			err := store.saveAddress(tx, a)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (store *WalletStore) saveAddress(e execer, a *openwallet.Address) error {
+	data, err := encode(a)
+	if err != nil {
+		return err
+	}
+	return store.db.save(e, &record{
+		table:   "ow_address",
+		keys:    []string{"app_id", "address"},
+		columns: []string{"app_id", "address", "account_id", "symbol", "data"},
+		values:  []interface{}{store.appID, a.Address, a.AccountID, a.Symbol, data},
+	})
+}
+
+//SetAddressExtParam 设置地址的扩展字段，在同一事务中锁定地址记录后读取和修改，并发设置不同字段不会互相覆盖
+func (store *WalletStore) SetAddressExtParam(address string, key string, val interface{}) error {
+	return store.db.inTx(func(tx *sql.Tx) error {
+
+		var obj openwallet.Address
+		err := store.db.oneForUpdate(tx, "ow_address", &obj, "app_id = ? AND address = ?", store.appID, address)
+		if err != nil {
+			return fmt.Errorf("can not find address")
+		}
+
+		var ext map[string]interface{}
+		if len(obj.ExtParam) == 0 {
+			ext = make(map[string]interface{})
+		} else {
+			err = json.Unmarshal([]byte(obj.ExtParam), &ext)
+			if err != nil {
+				return err
+			}
+		}
+
+		ext[key] = val
+
+		extJSON, err := json.Marshal(ext)
+		if err != nil {
+			return err
+		}
+		obj.ExtParam = string(extJSON)
+		return store.saveAddress(tx, &obj)
+	})
+}
+
+//GetAddressExtParam 获取地址的扩展字段
+func (store *WalletStore) GetAddressExtParam(address string, key string) (interface{}, error) {
+	obj, err := store.GetAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return gjson.ParseBytes([]byte(obj.ExtParam)).Get(key).Value(), nil
+}
+
+//UnlockWallet 解锁钱包
+func (store *WalletStore) UnlockWallet(password string, time time.Duration) error {
+	key, err := store.HDKey(password)
+	if err != nil {
+		return err
+	}
+	store.key = key
+	return nil
+}
+
+//HDKey 获取钱包密钥，需要密码
+func (store *WalletStore) HDKey(password ...string) (*hdkeystore.HDKey, error) {
+
+	pw := ""
+
+	if len(password) > 0 {
+		pw = password[0]
+	} else {
+		if store.key != nil {
+			return store.key, nil
+		} else {
+			return nil, fmt.Errorf("the wallet is locked. ")
+		}
+	}
+
+	if len(pw) == 0 {
+		return nil, fmt.Errorf("password is empty")
+	}
+
+	if len(store.keyFile) == 0 {
+		return nil, errors.New("Wallet key is not exist!")
+	}
+
+	return hdkeystore.LoadHDKeyFile(store.keyFile, pw)
+}